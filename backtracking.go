@@ -0,0 +1,148 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dlclark/regexp2"
+)
+
+// backtrackingRegex is a regex compiled by the regexp2 engine, for the rewrites needing its syntax, such as
+// the lookarounds and the backreferences. Unlike the regexp engine, it backtracks, and may take exponential
+// time on some bodies: its matches are bounded by a timeout, past which the rewrite fails.
+type backtrackingRegex struct {
+	regex *regexp2.Regexp
+	// names and numbers are those of the groups, by their index in the matches. The unnamed groups are named
+	// after their number, the named ones being numbered after them.
+	names   []string
+	numbers []int
+}
+
+// compileBacktracking compiles pattern with the regexp2 engine, each search of a body being bounded by
+// timeout.
+func compileBacktracking(pattern string, timeout time.Duration) (*backtrackingRegex, error) {
+	regex, err := regexp2.Compile(pattern, regexp2.None)
+	if err != nil {
+		return nil, err
+	}
+	regex.MatchTimeout = timeout
+	return &backtrackingRegex{regex: regex, names: regex.GetGroupNames(), numbers: regex.GetGroupNumbers()}, nil
+}
+
+// String returns the source text of the regex.
+func (b *backtrackingRegex) String() string {
+	return b.regex.String()
+}
+
+// NumSubexp returns the number of groups of the regex, like regexp.Regexp.NumSubexp.
+func (b *backtrackingRegex) NumSubexp() int {
+	return len(b.names) - 1
+}
+
+// SubexpIndex returns the index of the group of the given name or number, or -1 if there is none, like
+// regexp.Regexp.SubexpIndex does for the names.
+func (b *backtrackingRegex) SubexpIndex(name string) int {
+	if n, err := strconv.Atoi(name); err == nil {
+		for i, number := range b.numbers {
+			if number == n {
+				return i
+			}
+		}
+		return -1
+	}
+	for i, groupName := range b.names {
+		if groupName == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// matchTimeoutError reports a regexp2 regex which didn't complete its search of a body within its
+// matchTimeout.
+type matchTimeoutError struct {
+	pattern string
+	timeout time.Duration
+}
+
+// Error implements the error interface.
+func (e *matchTimeoutError) Error() string {
+	return fmt.Sprintf("regex %q exceeded matchTimeout of %s", e.pattern, e.timeout)
+}
+
+// findAll returns the successive matches of the regex in body, with the byte offsets of their groups as
+// returned by regexp.Regexp.FindAllSubmatchIndex, or a *matchTimeoutError.
+func (b *backtrackingRegex) findAll(body []byte) ([][]int, error) {
+	// regexp2 matches runes, the invalid UTF-8 bytes being decoded one by one as utf8.RuneError.
+	runes := make([]rune, 0, len(body))
+	offsets := make([]int, 0, len(body)+1)
+	for i := 0; i < len(body); {
+		r, size := utf8.DecodeRune(body[i:])
+		runes = append(runes, r)
+		offsets = append(offsets, i)
+		i += size
+	}
+	offsets = append(offsets, len(body))
+
+	var matches [][]int
+	m, err := b.regex.FindRunesMatch(runes)
+	for m != nil && err == nil {
+		groups := m.Groups()
+		match := make([]int, 2*len(groups))
+		for i, group := range groups {
+			match[2*i], match[2*i+1] = -1, -1
+			if len(group.Captures) > 0 {
+				match[2*i], match[2*i+1] = offsets[group.Index], offsets[group.Index+group.Length]
+			}
+		}
+		matches = append(matches, match)
+		m, err = b.regex.FindNextMatch(m)
+	}
+	if err != nil {
+		return nil, &matchTimeoutError{pattern: b.regex.String(), timeout: b.regex.MatchTimeout}
+	}
+	return matches, nil
+}
+
+// expand appends template to dst with its group references replaced by the groups of match in src, like
+// regexp.Regexp.Expand.
+func (b *backtrackingRegex) expand(dst, template, src []byte, match []int) []byte {
+	for len(template) > 0 {
+		i := bytes.IndexByte(template, '$')
+		if i < 0 {
+			break
+		}
+		dst = append(dst, template[:i]...)
+		template = template[i+1:]
+
+		if len(template) > 0 && template[0] == '$' {
+			dst = append(dst, '$')
+			template = template[1:]
+			continue
+		}
+		name, size := groupReference(string(template))
+		if name == "" {
+			// A malformed reference is kept as is.
+			dst = append(dst, '$')
+			continue
+		}
+		template = template[size:]
+		if i := b.SubexpIndex(name); i >= 0 && 2*i+1 < len(match) && match[2*i] >= 0 {
+			dst = append(dst, src[match[2*i]:match[2*i+1]]...)
+		}
+	}
+	return append(dst, template...)
+}
+
+// matchesEmpty reports whether the regex matches the empty string in the middle of a body, where the
+// anchors don't match, like the regexes rejected by checkEmptyMatch.
+func (b *backtrackingRegex) matchesEmpty() (bool, error) {
+	m, err := b.regex.FindRunesMatchStartingAt([]rune{0, 0}, 1)
+	if err != nil {
+		return false, err
+	}
+	return m != nil && m.Index == 1 && m.Length == 0, nil
+}
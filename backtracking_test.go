@@ -0,0 +1,224 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBacktrackingRewrite(t *testing.T) {
+	tests := []struct {
+		desc        string
+		regex       string
+		replacement string
+		body        string
+		expBody     string
+		expReplaced int
+	}{
+		{
+			desc:        "negative lookahead",
+			regex:       "foo(?!bar)",
+			replacement: "baz",
+			body:        "foobar foo foobaz",
+			expBody:     "foobar baz bazbaz",
+			expReplaced: 2,
+		},
+		{
+			desc:        "positive lookahead",
+			regex:       `\d+(?= EUR)`,
+			replacement: "X",
+			body:        "12 EUR, 34 USD",
+			expBody:     "X EUR, 34 USD",
+			expReplaced: 1,
+		},
+		{
+			desc:        "lookbehind",
+			regex:       `(?<=\$)\d+`,
+			replacement: "N",
+			body:        "$12 and 34",
+			expBody:     "$N and 34",
+			expReplaced: 1,
+		},
+		{
+			desc:        "backreference",
+			regex:       `(\w)\1`,
+			replacement: "$1",
+			body:        "bookkeeper",
+			expBody:     "bokeper",
+			expReplaced: 3,
+		},
+		{
+			desc:        "named backreference",
+			regex:       `(?<quote>["'])(?<text>.*?)\k<quote>`,
+			replacement: "<${text}>",
+			body:        `say "hi" and 'it''s'`,
+			expBody:     "say <hi> and <it><s>",
+			expReplaced: 3,
+		},
+		{
+			desc:        "named groups numbered after the unnamed ones",
+			regex:       `(?<word>[a-z]+)-(\d+)`,
+			replacement: "$1:$2:${word}",
+			body:        "abc-12",
+			expBody:     "12:abc:abc",
+			expReplaced: 1,
+		},
+		{
+			desc:        "escaped dollar and unmatched group",
+			regex:       `(a)|(b)`,
+			replacement: "$$[$1$2]",
+			body:        "ab",
+			expBody:     "$[a]$[b]",
+			expReplaced: 2,
+		},
+		{
+			desc:        "multibyte runes",
+			regex:       "é(?=t)",
+			replacement: "e",
+			body:        "été café",
+			expBody:     "eté café",
+			expReplaced: 1,
+		},
+		{
+			desc:        "invalid UTF-8",
+			regex:       "a(?=b)",
+			replacement: "X",
+			body:        "\xffab\xfe",
+			expBody:     "\xffXb\xfe",
+			expReplaced: 1,
+		},
+		{
+			desc:    "no match",
+			regex:   "foo(?!bar)",
+			body:    "foobar",
+			expBody: "foobar",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			rewrite, err := compileRewrite(Rewrite{Regex: test.regex, Replacement: test.replacement, Engine: engineRegexp2, MatchTimeout: "1s"}, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response := parsedResponse{rewrites: []parsedRewrite{rewrite}, passes: optimizePasses([]parsedRewrite{rewrite})}
+
+			replaced := make([]int, 1)
+			body, modified, _, err := response.rewriteUntil([]byte(test.body), time.Time{}, replaced)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != test.expBody || modified != (test.expReplaced > 0) {
+				t.Errorf("got body %q, modified %t, want %q", body, modified, test.expBody)
+			}
+			if replaced[0] != test.expReplaced {
+				t.Errorf("got %d replaced matches, want %d", replaced[0], test.expReplaced)
+			}
+		})
+	}
+}
+
+func TestBacktrackingRewrite_matchTimeout(t *testing.T) {
+	rewrite, err := compileRewrite(Rewrite{Regex: "(a+)+$", Replacement: "b", Engine: engineRegexp2, MatchTimeout: "10ms"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := parsedResponse{rewrites: []parsedRewrite{rewrite}, passes: optimizePasses([]parsedRewrite{rewrite})}
+
+	original := []byte(strings.Repeat("a", 64) + "!")
+	body, modified, _, err := response.rewriteUntil(original, time.Time{}, nil)
+
+	var timeoutErr *matchTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("got error %v, want a matchTimeoutError", err)
+	}
+	if want := `regex "(a+)+$" exceeded matchTimeout of 10ms`; err.Error() != want {
+		t.Errorf("got error %q, want %q", err, want)
+	}
+	if !bytes.Equal(body, original) || modified {
+		t.Errorf("got body %q, modified %t, want the original body", body, modified)
+	}
+}
+
+func TestServeHTTP_backtracking(t *testing.T) {
+	tests := []struct {
+		desc      string
+		rewrites  []Rewrite
+		body      string
+		expBody   string
+		expLog    string
+		expCached bool
+	}{
+		{
+			desc: "rewritten along with regexp rules",
+			rewrites: []Rewrite{
+				{Regex: "foo", Replacement: "qux"},
+				{Regex: "qux(?!bar)", Replacement: "baz", Engine: engineRegexp2, MatchTimeout: "1s"},
+				{Regex: "baz", Replacement: "BAZ"},
+			},
+			body:      "foobar foo",
+			expBody:   "quxbar BAZ",
+			expCached: true,
+		},
+		{
+			desc: "match timeout",
+			rewrites: []Rewrite{
+				{Regex: "foo", Replacement: "bar"},
+				{Regex: "(a+)+$", Replacement: "b", Engine: engineRegexp2, MatchTimeout: "10ms"},
+			},
+			body:    "foo " + strings.Repeat("a", 64) + "!",
+			expBody: "foo " + strings.Repeat("a", 64) + "!",
+			expLog:  `rewrite stage of the rewrite of / by response 0 failed, sending the original body: regex "(a+)+$" exceeded matchTimeout of 10ms`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := Config{
+				MetricsInterval: "1h",
+				CacheSize:       10,
+				Responses:       []Response{{Status: "200", Rewrites: test.rewrites}},
+			}
+			var logs bytes.Buffer
+			handler, err := NewMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", "text/plain")
+				rw.Header().Set("ETag", `"v1"`)
+				_, _ = rw.Write([]byte(test.body))
+			}), WithConfig(&config), WithName("rewriteBody"), WithLogger(log.New(&logs, "", 0)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+			if !strings.Contains(logs.String(), test.expLog) {
+				t.Errorf("got logs %q, want them to contain %q", logs.String(), test.expLog)
+			}
+			if cached := len(handler.(*responsebodyrewrite).cache.entries) > 0; cached != test.expCached {
+				t.Errorf("got body cached %t, want %t", cached, test.expCached)
+			}
+		})
+	}
+}
+
+func TestBacktrackingRegex_SubexpIndex(t *testing.T) {
+	regex, err := compileBacktracking(`(?<word>\w+)(\d)`, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var indexes []int
+	for _, name := range []string{"0", "1", "01", "2", "word", "3", "other"} {
+		indexes = append(indexes, regex.SubexpIndex(name))
+	}
+	if expected := []int{0, 1, 1, 2, 2, -1, -1}; !reflect.DeepEqual(indexes, expected) {
+		t.Errorf("got indexes %v, want %v", indexes, expected)
+	}
+}
@@ -0,0 +1,78 @@
+package traefik_responsebodyrewrite
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// defaultBinaryContentTypes are the media types of the binary responses when binaryContentTypes is not set:
+// generic binary data, protocol buffers, documents, archives, and the images, audio and video.
+var defaultBinaryContentTypes = []string{
+	"application/octet-stream",
+	"application/x-protobuf",
+	"application/protobuf",
+	"application/vnd.google.protobuf",
+	"application/pdf",
+	"application/zip",
+	"application/gzip",
+	"image/*",
+	"audio/*",
+	"video/*",
+}
+
+// binaryContentTypes are the media types of the binary responses, which are passed through.
+type binaryContentTypes struct {
+	mediaTypes map[string]bool
+	// types are the types, such as "image", whose subtypes are all binary.
+	types map[string]bool
+	// rewritten are the media types rewritten despite being binary.
+	rewritten map[string]bool
+}
+
+// parseBinaryContentTypes parses the binaryContentTypes option, or its default if empty, and the
+// rewriteBinaryContentTypes option.
+func parseBinaryContentTypes(contentTypes, rewritten []string) (*binaryContentTypes, error) {
+	if len(contentTypes) == 0 {
+		contentTypes = defaultBinaryContentTypes
+	}
+	binary := &binaryContentTypes{mediaTypes: map[string]bool{}, types: map[string]bool{}, rewritten: map[string]bool{}}
+	for _, contentType := range contentTypes {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil || len(params) > 0 || !strings.Contains(mediaType, "/") || strings.HasPrefix(mediaType, "*/") {
+			return nil, fmt.Errorf("invalid binaryContentTypes %q: must be a media type without parameters, or a type followed by /*", contentType)
+		}
+		if strings.HasSuffix(mediaType, "/*") {
+			binary.types[strings.TrimSuffix(mediaType, "/*")] = true
+		} else {
+			binary.mediaTypes[mediaType] = true
+		}
+	}
+	for _, contentType := range rewritten {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil || len(params) > 0 || !strings.Contains(mediaType, "/") {
+			return nil, fmt.Errorf("invalid rewriteBinaryContentTypes %q: must be a media type without parameters", contentType)
+		}
+		binary.rewritten[mediaType] = true
+	}
+	return binary, nil
+}
+
+// contains reports whether the media type is binary.
+func (b *binaryContentTypes) contains(mediaType string) bool {
+	if b.rewritten[mediaType] {
+		return false
+	}
+	slash := strings.Index(mediaType, "/")
+	return b.mediaTypes[mediaType] || (slash > 0 && b.types[mediaType[:slash]])
+}
+
+// binaryMediaType returns the media type of the response if it is one of the binaryContentTypes, which are
+// passed through without being rewritten, and an empty string otherwise.
+func (rw *responseWriter) binaryMediaType() string {
+	mediaType, _, err := mime.ParseMediaType(rw.ResponseWriter.Header().Get("Content-Type"))
+	if err != nil || !rw.middleware.binaryContentTypes.contains(mediaType) {
+		return ""
+	}
+	return mediaType
+}
@@ -0,0 +1,109 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestParseBinaryContentTypes(t *testing.T) {
+	binary, err := parseBinaryContentTypes(nil, []string{"image/svg+xml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for mediaType, exp := range map[string]bool{
+		"application/octet-stream": true,
+		"application/x-protobuf":   true,
+		"image/png":                true,
+		"video/mp4":                true,
+		"image/svg+xml":            false,
+		"text/html":                false,
+		"application/json":         false,
+		"image":                    false,
+	} {
+		if got := binary.contains(mediaType); got != exp {
+			t.Errorf("got %t for %s, want %t", got, mediaType, exp)
+		}
+	}
+
+	tests := []struct {
+		contentTypes []string
+		rewritten    []string
+		expErr       string
+	}{
+		{contentTypes: []string{"image"}, expErr: `invalid binaryContentTypes "image": must be a media type without parameters, or a type followed by /*`},
+		{contentTypes: []string{"*/*"}, expErr: `invalid binaryContentTypes "*/*": must be a media type without parameters, or a type followed by /*`},
+		{contentTypes: []string{"application/octet-stream; q=1"}, expErr: `invalid binaryContentTypes "application/octet-stream; q=1": must be a media type without parameters, or a type followed by /*`},
+		{rewritten: []string{"svg"}, expErr: `invalid rewriteBinaryContentTypes "svg": must be a media type without parameters`},
+	}
+	for _, test := range tests {
+		_, err := parseBinaryContentTypes(test.contentTypes, test.rewritten)
+		if err == nil || err.Error() != test.expErr {
+			t.Errorf("got error %v, want %q", err, test.expErr)
+		}
+	}
+}
+
+func TestServeHTTP_binaryContentTypes(t *testing.T) {
+	const body = "\x08\x96\x01foo\x00"
+	tests := []struct {
+		desc        string
+		config      Config
+		contentType string
+		expBody     string
+	}{
+		{
+			desc:        "octet stream",
+			contentType: "application/octet-stream",
+			expBody:     body,
+		},
+		{
+			desc:        "image",
+			contentType: "image/png",
+			expBody:     body,
+		},
+		{
+			desc:        "rewritten type",
+			config:      Config{RewriteBinaryContentTypes: []string{"image/png"}},
+			contentType: "image/png",
+			expBody:     "\x08\x96\x01bar\x00",
+		},
+		{
+			desc:        "custom list",
+			config:      Config{BinaryContentTypes: []string{"application/x-custom"}},
+			contentType: "application/octet-stream",
+			expBody:     "\x08\x96\x01bar\x00",
+		},
+		{
+			desc:        "text",
+			contentType: "text/plain",
+			expBody:     "\x08\x96\x01bar\x00",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := test.config
+			config.Responses = []Response{{Status: "200", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}}}
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", test.contentType)
+				rw.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				_, _ = rw.Write([]byte(body))
+			}), &config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+			if test.expBody == body && recorder.Header().Get("Content-Length") != strconv.Itoa(len(body)) {
+				t.Errorf("got Content-Length %q, want the one of the upstream", recorder.Header().Get("Content-Length"))
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package traefik_responsebodyrewrite
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// budgetWarningInterval is the minimum interval between two warnings about the exhausted memory budget.
+const budgetWarningInterval = time.Second
+
+// reserveBudget accounts n more bytes buffered in memory against the maxTotalBufferedBytes budget shared
+// by all the responses handled by the middleware. It returns false if the budget would be exceeded, in
+// which case nothing is accounted.
+func (rw *responseWriter) reserveBudget(n int64) bool {
+	m := rw.middleware
+	if m.maxTotalBufferedBytes == 0 {
+		return true
+	}
+
+	if atomic.AddInt64(&m.bufferedBytes, n) > m.maxTotalBufferedBytes {
+		atomic.AddInt64(&m.bufferedBytes, -n)
+		m.warnBudgetExceeded(rw.request)
+		return false
+	}
+	rw.reserved += n
+	return true
+}
+
+// releaseBudget gives back all the bytes accounted for the response to the shared budget.
+func (rw *responseWriter) releaseBudget() {
+	if rw.reserved == 0 {
+		return
+	}
+	atomic.AddInt64(&rw.middleware.bufferedBytes, -rw.reserved)
+	rw.reserved = 0
+}
+
+// warnBudgetExceeded logs that a response is not rewritten because of the memory budget, at most once
+// per budgetWarningInterval.
+func (r *responsebodyrewrite) warnBudgetExceeded(req *http.Request) {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&r.lastBudgetWarning)
+	if now-last < int64(budgetWarningInterval) || !atomic.CompareAndSwapInt64(&r.lastBudgetWarning, last, now) {
+		return
+	}
+	r.logf(levelWarn, logFields{request: req}, "%s: maxTotalBufferedBytes of %d bytes reached, skipping rewrite of %s", r.name, r.maxTotalBufferedBytes, req.URL)
+}
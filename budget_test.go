@@ -0,0 +1,112 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestServeHTTP_maxTotalBufferedBytes(t *testing.T) {
+	const (
+		concurrency = 20
+		budget      = 100
+		bodySize    = 10
+	)
+
+	// All the handlers write their body before any of them returns, so that the bodies are buffered
+	// at the same time.
+	var written sync.WaitGroup
+	written.Add(concurrency)
+	release := make(chan struct{})
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("foo is bar"))
+		written.Done()
+		<-release
+	}
+
+	config := &Config{
+		MaxTotalBufferedBytes: budget,
+		Responses: []Response{
+			{
+				Status:   "200",
+				Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+			},
+		},
+	}
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+	middleware := handler.(*responsebodyrewrite)
+
+	bodies := make(chan string, concurrency)
+	var served sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		served.Add(1)
+		go func() {
+			defer served.Done()
+			recorder := httptest.NewRecorder()
+			middleware.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+			bodies <- recorder.Body.String()
+		}()
+	}
+
+	written.Wait()
+	if buffered := atomic.LoadInt64(&middleware.bufferedBytes); buffered != budget {
+		t.Errorf("got %d buffered bytes, want %d", buffered, budget)
+	}
+	close(release)
+	served.Wait()
+	close(bodies)
+
+	rewritten := 0
+	for body := range bodies {
+		switch body {
+		case "bar is bar":
+			rewritten++
+		case "foo is bar":
+		default:
+			t.Errorf("unexpected body %q", body)
+		}
+	}
+	if rewritten != budget/bodySize {
+		t.Errorf("got %d rewritten bodies, want %d", rewritten, budget/bodySize)
+	}
+	if buffered := atomic.LoadInt64(&middleware.bufferedBytes); buffered != 0 {
+		t.Errorf("got %d buffered bytes once all the responses are complete, want 0", buffered)
+	}
+}
+
+func TestServeHTTP_maxTotalBufferedBytes_panic(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("foo is bar"))
+		panic(http.ErrAbortHandler)
+	}
+
+	config := &Config{
+		MaxTotalBufferedBytes: 100,
+		Responses: []Response{
+			{
+				Status:   "200",
+				Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+			},
+		},
+	}
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+	middleware := handler.(*responsebodyrewrite)
+
+	func() {
+		defer func() { _ = recover() }()
+		middleware.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+
+	if buffered := atomic.LoadInt64(&middleware.bufferedBytes); buffered != 0 {
+		t.Errorf("got %d buffered bytes after a panic, want 0", buffered)
+	}
+}
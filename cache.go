@@ -0,0 +1,73 @@
+package traefik_responsebodyrewrite
+
+import (
+	"regexp"
+	"sync"
+)
+
+// maxRegexCacheSize is the maximum number of patterns, and of replacements, kept by the regex cache.
+const maxRegexCacheSize = 1024
+
+// regexCache shares the compiled regexes and the replacements of identical rules, across the response
+// blocks of a configuration and across the instances of the middleware, since Traefik creates one per router.
+// Both are only read once created, so they can be used concurrently.
+type regexCache struct {
+	mu           sync.Mutex
+	size         int
+	regexes      map[string]*regexp.Regexp
+	replacements map[string][]byte
+}
+
+// newRegexCache creates a regexCache holding at most size patterns and size replacements.
+func newRegexCache(size int) *regexCache {
+	return &regexCache{
+		size:         size,
+		regexes:      make(map[string]*regexp.Regexp),
+		replacements: make(map[string][]byte),
+	}
+}
+
+// sharedRegexCache is the regex cache used by all the instances of the middleware.
+var sharedRegexCache = newRegexCache(maxRegexCacheSize)
+
+// compile returns the compiled regex of pattern, compiling it if it is not cached yet.
+// The flags are part of the pattern, e.g. (?i), so the pattern alone identifies the regex.
+func (c *regexCache) compile(pattern string) (*regexp.Regexp, error) {
+	c.mu.Lock()
+	regex, ok := c.regexes[pattern]
+	c.mu.Unlock()
+	if ok {
+		return regex, nil
+	}
+
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.regexes[pattern]; ok {
+		return cached, nil
+	}
+	// Once the cache is full, new patterns are not shared anymore.
+	if len(c.regexes) < c.size {
+		c.regexes[pattern] = regex
+	}
+	return regex, nil
+}
+
+// replacement returns the bytes of the given replacement, shared with the identical ones.
+func (c *regexCache) replacement(replacement string) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := c.replacements[replacement]; ok {
+		return cached
+	}
+	bytes := []byte(replacement)
+	if len(c.replacements) < c.size {
+		c.replacements[replacement] = bytes
+	}
+	return bytes
+}
@@ -0,0 +1,73 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestRegexCache(t *testing.T) {
+	cache := newRegexCache(2)
+
+	foo, err := cache.compile("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if regex, _ := cache.compile("foo"); regex != foo {
+		t.Error("expected identical patterns to share the same regex")
+	}
+	if regex, _ := cache.compile("(?i)foo"); regex == foo {
+		t.Error("expected patterns with different flags not to share the same regex")
+	}
+
+	// The cache is full, new patterns are compiled but not cached.
+	bar, _ := cache.compile("bar")
+	if regex, _ := cache.compile("bar"); regex == bar {
+		t.Error("expected a pattern not to be cached once the cache is full")
+	}
+	if len(cache.regexes) != 2 {
+		t.Errorf("got %d cached regexes, want 2", len(cache.regexes))
+	}
+
+	if _, err := cache.compile("*"); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+
+	replacement := cache.replacement("bar")
+	if other := cache.replacement("bar"); &other[0] != &replacement[0] {
+		t.Error("expected identical replacements to share the same bytes")
+	}
+}
+
+func TestNew_sharedRegexes(t *testing.T) {
+	config := &Config{
+		Responses: []Response{
+			{
+				Status:   "200",
+				Rewrites: []Rewrite{{Regex: "shared-pattern", Replacement: "shared-replacement"}},
+			},
+			{
+				Status:   "404",
+				Rewrites: []Rewrite{{Regex: "shared-pattern", Replacement: "shared-replacement"}},
+			},
+		},
+	}
+
+	first, err := New(context.Background(), http.NotFoundHandler(), config, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := New(context.Background(), http.NotFoundHandler(), config, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	regex := first.(*responsebodyrewrite).responses[0].rewrites[0].regex
+	for _, handler := range []http.Handler{first, second} {
+		for _, response := range handler.(*responsebodyrewrite).responses {
+			if response.rewrites[0].regex != regex {
+				t.Error("expected identical rules to share the same regex")
+			}
+		}
+	}
+}
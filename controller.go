@@ -0,0 +1,37 @@
+package traefik_responsebodyrewrite
+
+import (
+	"net/http"
+	"time"
+)
+
+// SetWriteDeadline sets the write deadline of the underlying connection, as done by
+// http.ResponseController. As a buffered body is only written once the upstream handler has returned, the
+// deadline bounds the write of the rewritten body.
+func (rw *responseWriter) SetWriteDeadline(deadline time.Time) error {
+	return http.NewResponseController(rw.ResponseWriter).SetWriteDeadline(deadline)
+}
+
+// SetReadDeadline sets the read deadline of the underlying connection, as done by http.ResponseController.
+func (rw *responseWriter) SetReadDeadline(deadline time.Time) error {
+	return http.NewResponseController(rw.ResponseWriter).SetReadDeadline(deadline)
+}
+
+// EnableFullDuplex enables full duplex on the underlying writer, as done by http.ResponseController.
+// The upstream then expects the client to receive the body while it reads the request body, which is
+// incompatible with buffering: the response is passed through without being rewritten, what has been
+// buffered so far being sent right away.
+func (rw *responseWriter) EnableFullDuplex() error {
+	if err := http.NewResponseController(rw.ResponseWriter).EnableFullDuplex(); err != nil {
+		return err
+	}
+
+	rw.fullDuplex = true
+	if !rw.buffering() || !rw.wroteHeader {
+		return nil
+	}
+
+	rw.infof("full duplex enabled for %s, skipping rewrite", rw.request.URL)
+	rw.passthrough = true
+	return rw.downgradeToPassthrough()
+}
@@ -0,0 +1,88 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServeHTTP_fullDuplex(t *testing.T) {
+	tests := []struct {
+		desc       string
+		enableAt   int
+		expSent    bool
+		expBody    string
+		fullDuplex bool
+	}{
+		{
+			desc:    "should rewrite the body without full duplex",
+			expBody: "bar is the new bar",
+		},
+		{
+			desc:       "should pass the body through when full duplex is enabled before the first write",
+			fullDuplex: true,
+			expSent:    true,
+			expBody:    "foo is the new bar",
+		},
+		{
+			desc:       "should send what has been buffered when full duplex is enabled after the first write",
+			enableAt:   1,
+			fullDuplex: true,
+			expSent:    true,
+			expBody:    "foo is the new bar",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				Responses: []Response{
+					{
+						Status:   "200",
+						Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+					},
+				},
+			}
+
+			deadline := time.Now().Add(time.Minute)
+			recorder := &controlledRecorder{ResponseRecorder: httptest.NewRecorder()}
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				controller := http.NewResponseController(rw)
+				if err := controller.SetWriteDeadline(deadline); err != nil {
+					t.Errorf("unable to set the write deadline: %v", err)
+				}
+				for i, chunk := range []string{"foo is the ", "new bar"} {
+					if test.fullDuplex && i == test.enableAt {
+						if err := controller.EnableFullDuplex(); err != nil {
+							t.Errorf("unable to enable full duplex: %v", err)
+						}
+					}
+					_, _ = rw.Write([]byte(chunk))
+				}
+				if sent := recorder.Body.Len() > 0; sent != test.expSent {
+					t.Errorf("got body sent %t before the end of the handler, want %t", sent, test.expSent)
+				}
+			}
+
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rewriteBody.ServeHTTP(recorder, req)
+
+			if recorder.fullDuplex != test.fullDuplex {
+				t.Errorf("got full duplex %t on the underlying writer, want %t", recorder.fullDuplex, test.fullDuplex)
+			}
+			if !recorder.writeDeadline.Equal(deadline) {
+				t.Errorf("got write deadline %v, want %v", recorder.writeDeadline, deadline)
+			}
+			if recorder.Body.String() != test.expBody {
+				t.Errorf("got body %q, want %q", recorder.Body.String(), test.expBody)
+			}
+		})
+	}
+}
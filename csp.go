@@ -0,0 +1,125 @@
+package traefik_responsebodyrewrite
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// cspHeaders are the headers of the enforced and of the reported Content Security Policies.
+var cspHeaders = []string{"Content-Security-Policy", "Content-Security-Policy-Report-Only"}
+
+// cspScriptDirectives are the directives restricting the script elements, the first one present in a
+// policy applying.
+var cspScriptDirectives = []string{"script-src-elem", "script-src", "default-src"}
+
+// cspNonceRegex matches the value of a nonce source, which is stamped onto the injected scripts.
+var cspNonceRegex = regexp.MustCompile(`^[A-Za-z0-9+/_-]+=*$`)
+
+// allowInjectedScripts adjusts the Content-Security-Policy headers of a response for the injected scripts to
+// run, and returns the nonce to stamp onto them, empty if none.
+func (h *parsedHTML) allowInjectedScripts(header http.Header) string {
+	if h.csp == "" {
+		return ""
+	}
+
+	var nonce string
+	if h.csp == cspNonce {
+		for _, name := range cspHeaders {
+			for _, value := range header.Values(name) {
+				for _, policy := range strings.Split(value, ",") {
+					if nonce == "" {
+						nonce = policyNonce(policy)
+					}
+				}
+			}
+		}
+	}
+
+	for _, name := range cspHeaders {
+		values := header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		header.Del(name)
+		for _, value := range values {
+			policies := strings.Split(value, ",")
+			for i, policy := range policies {
+				policies[i] = allowScriptHashes(policy, h.scriptHashes, nonce)
+			}
+			header.Add(name, strings.Join(policies, ","))
+		}
+	}
+	return nonce
+}
+
+// scriptSources returns the directives of a policy, the index of the one restricting the script elements,
+// -1 if none, and its sources.
+func scriptSources(policy string) ([]string, int, []string) {
+	directives := strings.Split(policy, ";")
+	for _, name := range cspScriptDirectives {
+		for i, directive := range directives {
+			fields := strings.Fields(directive)
+			if len(fields) > 0 && strings.EqualFold(fields[0], name) {
+				return directives, i, fields[1:]
+			}
+		}
+	}
+	return directives, -1, nil
+}
+
+// policyNonce returns the value of the nonce source of the directive of a policy restricting the scripts,
+// empty if it has none.
+func policyNonce(policy string) string {
+	_, _, sources := scriptSources(policy)
+	for _, source := range sources {
+		if len(source) > len("'nonce-'") && strings.HasPrefix(strings.ToLower(source), "'nonce-") && strings.HasSuffix(source, "'") {
+			if value := source[len("'nonce-") : len(source)-1]; cspNonceRegex.MatchString(value) {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// allowScriptHashes returns the policy with the hashes added to the sources of the directive restricting the
+// scripts. A policy which doesn't restrict the scripts, allows the nonce, or allows all the inline scripts,
+// which its hashes would disallow, is returned as is. The other directives are kept byte for byte.
+func allowScriptHashes(policy string, hashes []string, nonce string) string {
+	directives, i, sources := scriptSources(policy)
+	if i < 0 || len(hashes) == 0 {
+		return policy
+	}
+
+	unsafeInline, restricted := false, false
+	kept := make([]string, 0, len(sources)+len(hashes))
+	present := map[string]bool{}
+	for _, source := range sources {
+		lower := strings.ToLower(source)
+		switch {
+		case nonce != "" && strings.HasPrefix(lower, "'nonce-") && source[len("'nonce-"):] == nonce+"'":
+			return policy
+		case lower == "'unsafe-inline'":
+			unsafeInline = true
+		case lower == "'strict-dynamic'" || strings.HasPrefix(lower, "'nonce-") || strings.HasPrefix(lower, "'sha"):
+			restricted = true
+		case lower == "'none'":
+			continue
+		}
+		kept = append(kept, source)
+		present[source] = true
+	}
+	if unsafeInline && !restricted {
+		return policy
+	}
+	for _, hash := range hashes {
+		if !present[hash] {
+			kept = append(kept, hash)
+		}
+	}
+
+	directive := directives[i]
+	indent := directive[:len(directive)-len(strings.TrimLeft(directive, " \t\n\f\r"))]
+	directives[i] = indent + strings.Fields(directive)[0] + " " + strings.Join(kept, " ")
+	return strings.Join(directives, ";")
+}
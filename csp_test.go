@@ -0,0 +1,86 @@
+package traefik_responsebodyrewrite
+
+import "testing"
+
+func TestAllowScriptHashes(t *testing.T) {
+	hashes := []string{"'sha256-abc='"}
+	tests := []struct {
+		desc      string
+		policy    string
+		nonce     string
+		expPolicy string
+	}{
+		{
+			desc:      "script-src",
+			policy:    "default-src 'self'; script-src 'self' https://cdn.example.com; img-src *",
+			expPolicy: "default-src 'self'; script-src 'self' https://cdn.example.com 'sha256-abc='; img-src *",
+		},
+		{
+			desc:      "script-src-elem first",
+			policy:    "script-src 'self';script-src-elem 'self'",
+			expPolicy: "script-src 'self';script-src-elem 'self' 'sha256-abc='",
+		},
+		{
+			desc:      "default-src",
+			policy:    "default-src 'none'; style-src 'self'",
+			expPolicy: "default-src 'sha256-abc='; style-src 'self'",
+		},
+		{
+			desc:      "scripts not restricted",
+			policy:    "img-src *; frame-ancestors 'none'",
+			expPolicy: "img-src *; frame-ancestors 'none'",
+		},
+		{
+			desc:      "unsafe-inline",
+			policy:    "script-src 'self' 'unsafe-inline'",
+			expPolicy: "script-src 'self' 'unsafe-inline'",
+		},
+		{
+			desc:      "unsafe-inline disabled by a hash",
+			policy:    "script-src 'unsafe-inline' 'sha256-def='",
+			expPolicy: "script-src 'unsafe-inline' 'sha256-def=' 'sha256-abc='",
+		},
+		{
+			desc:      "hash already present",
+			policy:    "script-src 'sha256-abc='",
+			expPolicy: "script-src 'sha256-abc='",
+		},
+		{
+			desc:      "nonce allowed",
+			policy:    "script-src 'nonce-r4nd0m' 'strict-dynamic'",
+			nonce:     "r4nd0m",
+			expPolicy: "script-src 'nonce-r4nd0m' 'strict-dynamic'",
+		},
+		{
+			desc:      "other nonce",
+			policy:    "script-src 'nonce-0ther'",
+			nonce:     "r4nd0m",
+			expPolicy: "script-src 'nonce-0ther' 'sha256-abc='",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if policy := allowScriptHashes(test.policy, hashes, test.nonce); policy != test.expPolicy {
+				t.Errorf("got policy %q, want %q", policy, test.expPolicy)
+			}
+		})
+	}
+}
+
+func TestPolicyNonce(t *testing.T) {
+	tests := []struct {
+		policy   string
+		expNonce string
+	}{
+		{policy: "default-src 'self'; script-src 'NONCE-r4nd0m+/=='", expNonce: "r4nd0m+/=="},
+		{policy: "default-src 'nonce-r4nd0m'", expNonce: "r4nd0m"},
+		{policy: "script-src 'self'; default-src 'nonce-r4nd0m'", expNonce: ""},
+		{policy: "script-src 'nonce-\"><script>'", expNonce: ""},
+		{policy: "script-src 'nonce-'", expNonce: ""},
+	}
+	for _, test := range tests {
+		if nonce := policyNonce(test.policy); nonce != test.expNonce {
+			t.Errorf("got nonce %q for policy %q, want %q", nonce, test.policy, test.expNonce)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"time"
+)
+
+// csvContentTypes are the media types of the CSV responses.
+var csvContentTypes = map[string]bool{
+	"text/csv":        true,
+	"application/csv": true,
+}
+
+// CSV restricts the rewrites of a response block to a column of the CSV bodies.
+type CSV struct {
+	// Column is the name of the column in the header row, the first record.
+	Column string `json:"column,omitempty"`
+	// ColumnIndex is the index of the column, starting from 0, in place of Column for the files without
+	// header row.
+	ColumnIndex *int `json:"columnIndex,omitempty"`
+	// HeaderRow tells that the first record is a header row, left as is, when the column is given by
+	// ColumnIndex. It is implied by Column.
+	HeaderRow bool `json:"headerRow,omitempty"`
+	// Mask replaces the non-empty fields of the column, in place of the rewrites of the response block.
+	Mask string `json:"mask,omitempty"`
+}
+
+// parsedCSV is a parsed CSV.
+type parsedCSV struct {
+	// column is the name of the column, empty if it is given by index.
+	column string
+	index  int
+	// headerRow is set when the first record is a header row.
+	headerRow bool
+	mask      string
+}
+
+// parseCSV parses the csv option of a response block, nil if it has none.
+func parseCSV(config *CSV) (*parsedCSV, error) {
+	if config == nil {
+		return nil, nil
+	}
+	switch {
+	case config.Column != "" && config.ColumnIndex != nil:
+		return nil, errors.New("column and columnIndex can't both be set")
+	case config.Column == "" && config.ColumnIndex == nil:
+		return nil, errors.New("column or columnIndex must be set")
+	case config.ColumnIndex != nil && *config.ColumnIndex < 0:
+		return nil, fmt.Errorf("invalid columnIndex %d: must not be negative", *config.ColumnIndex)
+	}
+	parsed := &parsedCSV{column: config.Column, headerRow: config.HeaderRow || config.Column != "", mask: config.Mask}
+	if config.ColumnIndex != nil {
+		parsed.index = *config.ColumnIndex
+	}
+	return parsed, nil
+}
+
+// isCSVResponse reports whether the given Content-Type is the one of a CSV response.
+func isCSVResponse(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && csvContentTypes[mediaType]
+}
+
+// columnIndex returns the index of the column in the header row, -1 if it is missing. A byte order mark
+// before the first name is ignored.
+func (c *parsedCSV) columnIndex(header []string) int {
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		if name == c.column {
+			return i
+		}
+	}
+	return -1
+}
+
+// String describes the column in the debug dump.
+func (c *parsedCSV) String() string {
+	description := fmt.Sprintf("column %d", c.index)
+	if c.column != "" {
+		description = fmt.Sprintf("column %q", c.column)
+	}
+	if c.headerRow {
+		description += " after the header row"
+	}
+	if c.mask != "" {
+		description += fmt.Sprintf(" masked with %q", c.mask)
+	}
+	return description
+}
+
+func (c *parsedCSV) option() string { return "csv" }
+
+// changesBody implements the transformer interface: only a mask changes the column without rewrites.
+func (c *parsedCSV) changesBody() bool { return c.mask != "" }
+
+// matchesContentType implements the contentTypeMatcher interface.
+func (c *parsedCSV) matchesContentType(contentType string) bool {
+	return isCSVResponse(contentType)
+}
+
+// transform implements the bodyTransformer interface, as rewriteBody for a CSV body: the rewrites, or the
+// mask, are only applied to the fields of the column. The records whose field is modified are serialized
+// again, keeping their line ending, the other records being left byte for byte. A body which can't be parsed,
+// or without the column in its header row, fails the csv stage.
+func (c *parsedCSV) transform(rw *responseWriter, body []byte) ([]byte, bool, bool, []int, error) {
+	r, response, req := rw.middleware, rw.response, rw.request
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	deadline := r.rewriteDeadline()
+
+	var out bytes.Buffer
+	modified, complete := false, true
+	var replaced []int
+	if c.mask == "" && (response.logLevel >= levelDebug || r.debugHeader != "" || r.logModifications) {
+		replaced = make([]int, len(response.rewrites))
+	}
+	var sent int64
+	index := c.index
+	for first := true; ; first = false {
+		start := reader.InputOffset()
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return body, false, true, nil, &stageError{stage: stageCSV, err: fmt.Errorf("unable to parse the CSV body: %w", err)}
+		}
+		end := reader.InputOffset()
+
+		if first && c.headerRow {
+			if c.column != "" {
+				if index = c.columnIndex(record); index < 0 {
+					return body, false, true, nil, &stageError{stage: stageCSV, err: fmt.Errorf("no column %q in the CSV body", c.column)}
+				}
+			}
+			continue
+		}
+		if index >= len(record) {
+			continue
+		}
+
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			r.logfUpTo(response.logLevel, levelWarn, logFields{request: req, response: response}, "%s: rewrite of %s by response %s exceeded maxRewriteDuration of %s, skipping the records from offset %d",
+				r.name, req.URL, response.id, r.maxRewriteDuration, start)
+			if r.sendOriginalOnTimeout {
+				return body, false, false, nil, nil
+			}
+			complete = false
+			break
+		}
+
+		field := record[index]
+		if c.mask != "" {
+			if field == "" || field == c.mask {
+				continue
+			}
+			record[index] = c.mask
+		} else {
+			rewritten, changed, _, err := response.rewriteUntil([]byte(field), time.Time{}, replaced)
+			if err != nil {
+				return body, false, true, nil, err
+			}
+			if !changed {
+				continue
+			}
+			record[index] = string(rewritten)
+		}
+
+		// The blank lines skipped before the record are kept.
+		raw := body[start:end]
+		blank := len(raw) - len(bytes.TrimLeft(raw, "\r\n"))
+		out.Write(body[sent : start+int64(blank)])
+		if err := writeCSVRecord(&out, record, raw); err != nil {
+			return body, false, true, nil, err
+		}
+		sent = end
+		modified = true
+	}
+	if replaced != nil {
+		r.logReplacements(req, response, replaced)
+	}
+
+	if !modified {
+		return body, false, complete, replaced, nil
+	}
+	out.Write(body[sent:])
+	return out.Bytes(), true, complete, replaced, nil
+}
+
+// writeCSVRecord writes record to out with the line ending of its raw bytes, none for a last record without
+// one.
+func writeCSVRecord(out *bytes.Buffer, record []string, raw []byte) error {
+	writer := csv.NewWriter(out)
+	writer.UseCRLF = bytes.HasSuffix(raw, []byte("\r\n"))
+	if err := writer.Write(record); err != nil {
+		return err
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	if !bytes.HasSuffix(raw, []byte("\n")) {
+		out.Truncate(out.Len() - len("\n"))
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeHTTP_csv(t *testing.T) {
+	const body = "id,email,comment\r\n" +
+		"1,jane@example.com,\"Contact jane@example.com, or bob@example.com\"\r\n" +
+		"2,,\"no email\"\r\n" +
+		"\r\n" +
+		"3,\"bob@example.com\",\"multi\r\nline\"\r\n"
+	columnIndex, negativeIndex := 1, -1
+
+	tests := []struct {
+		desc        string
+		csv         CSV
+		rewrites    []Rewrite
+		contentType string
+		body        string
+		expBody     string
+		expErr      string
+	}{
+		{
+			desc:        "column",
+			csv:         CSV{Column: "email"},
+			contentType: "text/csv; charset=utf-8",
+			body:        body,
+			expBody: "id,email,comment\r\n" +
+				"1,jane@***,\"Contact jane@example.com, or bob@example.com\"\r\n" +
+				"2,,\"no email\"\r\n" +
+				"\r\n" +
+				"3,bob@***,\"multi\r\nline\"\r\n",
+		},
+		{
+			desc:        "mask",
+			csv:         CSV{Column: "email", Mask: "***"},
+			contentType: "text/csv",
+			body:        body,
+			expBody: "id,email,comment\r\n" +
+				"1,***,\"Contact jane@example.com, or bob@example.com\"\r\n" +
+				"2,,\"no email\"\r\n" +
+				"\r\n" +
+				"3,***,\"multi\r\nline\"\r\n",
+		},
+		{
+			desc:        "column index without header row",
+			csv:         CSV{ColumnIndex: &columnIndex},
+			contentType: "application/csv",
+			body:        "1,jane@example.com\n2,bob@example.com",
+			expBody:     "1,jane@***\n2,bob@***",
+		},
+		{
+			desc:        "column index with header row",
+			csv:         CSV{ColumnIndex: &columnIndex, HeaderRow: true},
+			contentType: "text/csv",
+			body:        "id,admin@example.com\n1,jane@example.com\n",
+			expBody:     "id,admin@example.com\n1,jane@***\n",
+		},
+		{
+			desc:        "quoted field",
+			csv:         CSV{ColumnIndex: &columnIndex},
+			contentType: "text/csv",
+			body:        "1,\"jane@example.com, \"\"Jane\"\"\"\n",
+			expBody:     "1,\"jane@***, \"\"Jane\"\"\"\n",
+		},
+		{
+			desc:        "byte order mark",
+			csv:         CSV{Column: "email"},
+			contentType: "text/csv",
+			body:        "\ufeffemail\njane@example.com\n",
+			expBody:     "\ufeffemail\njane@***\n",
+		},
+		{
+			desc:        "missing column",
+			csv:         CSV{Column: "mail"},
+			contentType: "text/csv",
+			body:        body,
+			expBody:     body,
+		},
+		{
+			desc:        "malformed body",
+			csv:         CSV{Column: "email"},
+			contentType: "text/csv",
+			body:        "id,email\n1,\"jane@example.com\n",
+			expBody:     "id,email\n1,\"jane@example.com\n",
+		},
+		{
+			desc:        "other content type",
+			csv:         CSV{Column: "email"},
+			contentType: "text/plain",
+			body:        body,
+			expBody:     body,
+		},
+		{
+			desc:   "no column",
+			expErr: "responses[0]: csv: column or columnIndex must be set",
+		},
+		{
+			desc:   "column and index",
+			csv:    CSV{Column: "email", ColumnIndex: &columnIndex},
+			expErr: "responses[0]: csv: column and columnIndex can't both be set",
+		},
+		{
+			desc:   "negative index",
+			csv:    CSV{ColumnIndex: &negativeIndex},
+			expErr: "responses[0]: csv: invalid columnIndex -1: must not be negative",
+		},
+		{
+			desc:     "mask with rewrites",
+			csv:      CSV{Column: "email", Mask: "***"},
+			rewrites: []Rewrite{{Regex: "a", Replacement: "b"}},
+			expErr:   "responses[0]: csv: mask can't be used with rewrites",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			csv := test.csv
+			rewrites := test.rewrites
+			if rewrites == nil && csv.Mask == "" {
+				rewrites = []Rewrite{{Regex: `@[\w.]+`, Replacement: "@***"}}
+			}
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", test.contentType)
+				_, _ = rw.Write([]byte(test.body))
+			}), &Config{Responses: []Response{{Status: "200", CSV: &csv, Rewrites: rewrites}}}, "rewriteBody")
+			if test.expErr != "" {
+				if err == nil || err.Error() != test.expErr {
+					t.Errorf("got error %v, want %q", err, test.expErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_csvFailure(t *testing.T) {
+	t.Run("malformed body", func(t *testing.T) {
+		testModeFailure(t, Response{Status: "200", CSV: &CSV{Column: "email"}, Rewrites: []Rewrite{{Regex: "a", Replacement: "b"}}},
+			"text/csv", "id,email\n1,\"jane@example.com\n", stageCSV)
+	})
+	t.Run("missing column", func(t *testing.T) {
+		testModeFailure(t, Response{Status: "200", CSV: &CSV{Column: "email"}, Rewrites: []Rewrite{{Regex: "a", Replacement: "b"}}},
+			"text/csv", "id,name\n1,jane\n", stageCSV)
+	})
+}
@@ -0,0 +1,125 @@
+package traefik_responsebodyrewrite
+
+import (
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+)
+
+// dashNamespace is the namespace of the MPEG-DASH manifests.
+const dashNamespace = "urn:mpeg:dash:schema:mpd:2011"
+
+// segmentTemplateURLOrigins matches the origins of the URLs of the media and initialization attributes of a
+// SegmentTemplate.
+var segmentTemplateURLOrigins = xmlAttributeURLOrigin("media", "initialization")
+
+// DASH rewrites the origin of the URLs of the MPEG-DASH manifests.
+type DASH struct {
+	// Origin is the scheme and the host the URLs are rewritten to, e.g. "https://cdn.example.com". If empty,
+	// it is the public origin of the request, from its X-Forwarded-Proto and X-Forwarded-Host headers, or
+	// else from its own scheme and Host.
+	Origin string `json:"origin,omitempty"`
+}
+
+// parsedDASH is a parsed DASH.
+type parsedDASH struct {
+	// origin is the configured origin, empty if it is derived from the request.
+	origin string
+}
+
+// parseDASH parses the dash option of a response block, nil if it has none.
+func parseDASH(config *DASH) (*parsedDASH, error) {
+	if config == nil {
+		return nil, nil
+	}
+	origin, err := parseOrigin(config.Origin)
+	if err != nil {
+		return nil, err
+	}
+	return &parsedDASH{origin: origin}, nil
+}
+
+// isDASHResponse reports whether the given Content-Type is the one of an MPEG-DASH manifest.
+func isDASHResponse(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/dash+xml"
+}
+
+// publicOrigin returns the origin the URLs of the manifest of req are rewritten to.
+func (d *parsedDASH) publicOrigin(req *http.Request) string {
+	if d.origin != "" {
+		return d.origin
+	}
+	return requestOrigin(req)
+}
+
+// isDASHURL reports whether the element at the end of the path holds URLs of an MPD: a BaseURL at any level,
+// the Location of the manifest, or a SegmentTemplate, with or without the DASH namespace.
+func isDASHURL(path []xml.Name) bool {
+	name := path[len(path)-1]
+	if path[0] != (xml.Name{Space: name.Space, Local: "MPD"}) || (name.Space != dashNamespace && name.Space != "") {
+		return false
+	}
+	switch name.Local {
+	case "BaseURL", "SegmentTemplate":
+		return true
+	case "Location":
+		return len(path) == 2
+	}
+	return false
+}
+
+// dashURLOrigins returns the regex matching the origins of the URLs of a DASH element: those of the media and
+// initialization of a SegmentTemplate, or of the content of the others.
+func dashURLOrigins(element xmlElement) *regexp.Regexp {
+	if element.tag.Name.Local == "SegmentTemplate" {
+		return segmentTemplateURLOrigins
+	}
+	return xmlURLOrigin
+}
+
+// String describes the manifests in the debug dump.
+func (d *parsedDASH) String() string {
+	return "URLs rewritten to " + describeOrigin(d.origin)
+}
+
+func (d *parsedDASH) option() string { return "dash" }
+
+// changesBody implements the transformer interface.
+func (d *parsedDASH) changesBody() bool { return true }
+
+// matchesContentType implements the contentTypeMatcher interface.
+func (d *parsedDASH) matchesContentType(contentType string) bool {
+	return isDASHResponse(contentType)
+}
+
+// cacheKey implements the cacheKeyer interface: the rewritten URLs depend on the public origin of req.
+func (d *parsedDASH) cacheKey(req *http.Request) string {
+	return d.publicOrigin(req)
+}
+
+// transform implements the bodyTransformer interface, as rewriteBody for an MPEG-DASH manifest: once the
+// rewrites are applied, the scheme and the host of the absolute URLs of its BaseURL, Location and
+// SegmentTemplate elements are replaced with the public origin, the template identifiers of their paths and
+// the rest of the body, namespaces and attribute order included, being left byte for byte. A body which can't
+// be parsed fails the dash stage.
+func (d *parsedDASH) transform(rw *responseWriter, body []byte) ([]byte, bool, bool, []int, error) {
+	r, response, req := rw.middleware, rw.response, rw.request
+	rewritten, modified, complete, replaced := body, false, true, []int(nil)
+	if len(response.rewrites) > 0 {
+		var err error
+		if rewritten, modified, complete, replaced, err = r.rewriteBody(response, body, req); err != nil {
+			return body, false, true, nil, err
+		}
+	}
+
+	elements, err := xmlElements(rewritten, isDASHURL)
+	if err != nil {
+		return body, false, true, nil, &stageError{stage: stageDASH, err: fmt.Errorf("unable to parse the DASH manifest: %w", err)}
+	}
+
+	result, changed := rewriteURLOrigins(rewritten, elements, d.publicOrigin(req), dashURLOrigins)
+	return result, modified || changed, complete, replaced, nil
+}
@@ -0,0 +1,103 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeHTTP_dash(t *testing.T) {
+	const mpd = `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" xmlns:cenc="urn:mpeg:cenc:2013" type="dynamic" minimumUpdatePeriod="PT2.000S">
+  <Location>http://origin-1.internal.local/live/manifest.mpd</Location>
+  <BaseURL>http://origin-1.internal.local/live/</BaseURL>
+  <Period id="1" start="PT0S">
+    <AdaptationSet mimeType="video/mp4" segmentAlignment="true">
+      <BaseURL>video/</BaseURL>
+      <SegmentTemplate timescale="90000" media="http://origin-1.internal.local/live/$RepresentationID$/$Number%05d$.m4s" initialization='http://origin-1.internal.local/live/$RepresentationID$/init.mp4' startNumber="1"/>
+      <Representation id="720p" bandwidth="3000000" width="1280" height="720">
+        <BaseURL>https://origin-2.internal.local/live/720p/</BaseURL>
+      </Representation>
+    </AdaptationSet>
+    <AdaptationSet mimeType="audio/mp4">
+      <SegmentTemplate media="http://$RepresentationID$.internal.local/$Number$.m4s" initialization="init-$RepresentationID$.mp4"/>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+	tests := []struct {
+		desc        string
+		contentType string
+		body        string
+		expBody     string
+	}{
+		{
+			desc:        "manifest",
+			contentType: "application/dash+xml",
+			body:        mpd,
+			expBody: strings.NewReplacer(
+				"http://origin-1.internal.local/live", "https://cdn.example.com/live",
+				"https://origin-2.internal.local/live", "https://cdn.example.com/live",
+			).Replace(mpd),
+		},
+		{
+			desc:        "other root element",
+			contentType: "application/dash+xml",
+			body:        `<Playlist><BaseURL>http://origin-1.internal.local/</BaseURL></Playlist>`,
+			expBody:     `<Playlist><BaseURL>http://origin-1.internal.local/</BaseURL></Playlist>`,
+		},
+		{
+			desc:        "without namespace",
+			contentType: "application/dash+xml",
+			body:        `<MPD><Period><BaseURL>http://origin-1.internal.local/</BaseURL><Location>http://origin-1.internal.local/</Location></Period></MPD>`,
+			expBody:     `<MPD><Period><BaseURL>https://cdn.example.com/</BaseURL><Location>http://origin-1.internal.local/</Location></Period></MPD>`,
+		},
+		{
+			desc:        "malformed body",
+			contentType: "application/dash+xml",
+			body:        `<MPD><BaseURL>http://origin-1.internal.local/</MPD>`,
+			expBody:     `<MPD><BaseURL>http://origin-1.internal.local/</MPD>`,
+		},
+		{
+			desc:        "other content type",
+			contentType: "application/xml",
+			body:        `<MPD><BaseURL>http://origin-1.internal.local/</BaseURL></MPD>`,
+			expBody:     `<MPD><BaseURL>http://origin-1.internal.local/</BaseURL></MPD>`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", test.contentType)
+				_, _ = rw.Write([]byte(test.body))
+			}), &Config{Responses: []Response{{Status: "200", DASH: &DASH{Origin: "https://cdn.example.com"}}}}, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/live/manifest.mpd", nil))
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+
+	_, err := New(context.Background(), http.NotFoundHandler(), &Config{Responses: []Response{{
+		Status: "200",
+		DASH:   &DASH{},
+		HLS:    &HLS{},
+	}}}, "rewriteBody")
+	expErr := "responses[0]: hls and dash can't both be set"
+	if err == nil || err.Error() != expErr {
+		t.Errorf("got error %v, want %q", err, expErr)
+	}
+}
+
+func TestServeHTTP_dashFailure(t *testing.T) {
+	testModeFailure(t, Response{Status: "200", DASH: &DASH{Origin: "https://cdn.example.com"}},
+		"application/dash+xml", "<MPD><BaseURL>http://origin-1.internal.local/</MPD>", stageDASH)
+}
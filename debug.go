@@ -0,0 +1,137 @@
+package traefik_responsebodyrewrite
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// debugTokenHeader is the header holding the debugToken of the requests to the debugPath.
+const debugTokenHeader = "X-Rewrite-Debug-Token"
+
+// redacted replaces the patterns and the replacements of the dump with debugRedactPatterns.
+const redacted = "[redacted]"
+
+// debugDump is the JSON dump of the configuration served on the debugPath.
+type debugDump struct {
+	Name      string           `json:"name"`
+	Responses []debugResponse  `json:"responses"`
+	Metrics   map[string]int64 `json:"metrics,omitempty"`
+}
+
+// debugResponse is a response block in the debugDump.
+type debugResponse struct {
+	ID          string         `json:"id"`
+	Index       int            `json:"index"`
+	Name        string         `json:"name,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Status      string         `json:"status"`
+	DryRun      bool           `json:"dryRun,omitempty"`
+	Disabled    bool           `json:"disabled,omitempty"`
+	Variant     string         `json:"variant,omitempty"`
+	Mode        *debugMode     `json:"mode,omitempty"`
+	Stream      bool           `json:"stream,omitempty"`
+	Rewrites    []debugRewrite `json:"rewrites"`
+}
+
+// debugMode is the mode of a response block in the debugDump, described by the option setting it.
+type debugMode struct {
+	Option   string `json:"option"`
+	Settings string `json:"settings"`
+}
+
+// debugRewrite is a rewrite in the debugDump.
+type debugRewrite struct {
+	Regex       string `json:"regex"`
+	Replacement string `json:"replacement"`
+	Description string `json:"description,omitempty"`
+	// Engine is set for the rewrites of the regexp2 engine.
+	Engine string `json:"engine,omitempty"`
+}
+
+// validateDebugPath checks the debugPath and debugToken options, which must be set together.
+func validateDebugPath(path, token string) error {
+	switch {
+	case path == "" && token == "":
+		return nil
+	case path == "" || token == "":
+		return errors.New("invalid debugPath and debugToken: must be set together")
+	case !strings.HasPrefix(path, "/"):
+		return fmt.Errorf("invalid debugPath %q: must start with /", path)
+	default:
+		return nil
+	}
+}
+
+// isDebugRequest reports whether req asks for the debug dump or the debug metrics: its path is the debugPath
+// or that of the metrics under it, and it holds the debugToken. Other requests to these paths go to the
+// upstream as usual.
+func (r *responsebodyrewrite) isDebugRequest(req *http.Request) bool {
+	if r.debugPath == "" || (req.URL.Path != r.debugPath && !r.isDebugMetricsRequest(req)) {
+		return false
+	}
+	token := req.Header.Get(debugTokenHeader)
+	return subtle.ConstantTimeCompare([]byte(token), []byte(r.debugToken)) == 1
+}
+
+// serveDebug sends the debug dump of the parsed configuration and of the metrics.
+func (r *responsebodyrewrite) serveDebug(rw http.ResponseWriter) {
+	responses := r.currentResponses()
+	dump := debugDump{
+		Name:      r.name,
+		Responses: make([]debugResponse, len(responses)),
+	}
+	for i := range responses {
+		response := &responses[i]
+		rewrites := make([]debugRewrite, len(response.rewrites))
+		for j, rewrite := range response.rewrites {
+			// The descriptions tell the intent of the rewrites, they are not redacted with their patterns.
+			rewrites[j] = debugRewrite{Regex: redacted, Replacement: redacted, Description: rewrite.description}
+			if !r.debugRedactPatterns {
+				rewrites[j] = debugRewrite{Regex: rewrite.pattern(), Replacement: string(rewrite.replacement), Description: rewrite.description}
+			}
+			if rewrite.backtracking != nil {
+				rewrites[j].Engine = engineRegexp2
+			}
+		}
+		dump.Responses[i] = debugResponse{
+			ID:          response.id,
+			Index:       response.index,
+			Name:        response.name,
+			Description: response.description,
+			Status:      response.status.String(),
+			DryRun:      response.dryRun,
+			Disabled:    response.disabled,
+			Stream:      response.stream,
+			Rewrites:    rewrites,
+		}
+		if response.variant != nil {
+			dump.Responses[i].Variant = response.variant.String()
+		}
+		if response.mode != nil {
+			dump.Responses[i].Mode = &debugMode{Option: response.mode.option(), Settings: response.mode.String()}
+		}
+	}
+	if r.metrics != nil {
+		dump.Metrics = map[string]int64{}
+		for _, value := range r.metrics.values(responses) {
+			dump.Metrics[value.name] = value.value
+		}
+	}
+
+	body, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		r.errorf("%s: unable to encode the debug dump: %v", r.name, err)
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(http.StatusOK)
+	if err := writeBody(rw, body); err != nil {
+		r.warnf("%s: unable to write the debug dump: %v", r.name, err)
+	}
+}
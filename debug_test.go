@@ -0,0 +1,246 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestServeHTTP_debugPath(t *testing.T) {
+	config := &Config{
+		DebugPath:  "/_rewrite",
+		DebugToken: "secret",
+		Always:     true,
+		Rewrites:   []Rewrite{{Regex: "baz", Replacement: "qux"}},
+		Responses: []Response{
+			{Name: "ok", Status: "200-204", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}},
+		},
+	}
+
+	tests := []struct {
+		desc      string
+		path      string
+		token     string
+		redact    bool
+		expDump   *debugDump
+		expStatus int
+	}{
+		{
+			desc:  "dump",
+			path:  "/_rewrite",
+			token: "secret",
+			expDump: &debugDump{
+				Name: "rewriteBody",
+				Responses: []debugResponse{
+					{ID: "ok", Index: 0, Name: "ok", Status: "200-204", Rewrites: []debugRewrite{{Regex: "baz", Replacement: "qux"}, {Regex: "foo", Replacement: "bar"}}},
+					{ID: "global", Index: 1, Status: "100-599", Rewrites: []debugRewrite{{Regex: "baz", Replacement: "qux"}}},
+				},
+				Metrics: map[string]int64{
+					"responses": 0, "passthrough.maxBodySize": 0, "passthrough.encoded": 0,
+					"failures.rewrite": 0, "failures.json": 0, "failures.csv": 0, "failures.yaml": 0, "failures.sitemap": 0, "failures.feed": 0, "failures.dash": 0, "failures.vast": 0, "failures.openapi": 0, "failures.oidc": 0, "failures.soap": 0, "failures.multipart": 0,
+					"response.ok.matched": 0, "response.ok.modified": 0, "response.ok.replacements": 0,
+					"response.ok.bytesIn": 0, "response.ok.bytesOut": 0,
+					"response.global.matched": 0, "response.global.modified": 0, "response.global.replacements": 0,
+					"response.global.bytesIn": 0, "response.global.bytesOut": 0,
+				},
+			},
+		},
+		{
+			desc:   "redacted",
+			path:   "/_rewrite",
+			token:  "secret",
+			redact: true,
+			expDump: &debugDump{
+				Name: "rewriteBody",
+				Responses: []debugResponse{
+					{ID: "ok", Index: 0, Name: "ok", Status: "200-204", Rewrites: []debugRewrite{{Regex: redacted, Replacement: redacted}, {Regex: redacted, Replacement: redacted}}},
+					{ID: "global", Index: 1, Status: "100-599", Rewrites: []debugRewrite{{Regex: redacted, Replacement: redacted}}},
+				},
+			},
+		},
+		{desc: "wrong token", path: "/_rewrite", token: "guess", expStatus: http.StatusTeapot},
+		{desc: "no token", path: "/_rewrite", expStatus: http.StatusTeapot},
+		{desc: "other path", path: "/_rewrite/", token: "secret", expStatus: http.StatusTeapot},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := *config
+			config.DebugRedactPatterns = test.redact
+			if test.redact {
+				config.MetricsInterval = "0"
+			}
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.WriteHeader(http.StatusTeapot)
+			}), &config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.token != "" {
+				req.Header.Set(debugTokenHeader, test.token)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if test.expDump == nil {
+				if recorder.Code != test.expStatus {
+					t.Errorf("got status %d, want %d from the upstream", recorder.Code, test.expStatus)
+				}
+				return
+			}
+			if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("got status %d and Content-Type %q, want a JSON dump", recorder.Code, recorder.Header().Get("Content-Type"))
+			}
+			var dump debugDump
+			if err := json.Unmarshal(recorder.Body.Bytes(), &dump); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(&dump, test.expDump) {
+				t.Errorf("got dump %+v, want %+v", dump, *test.expDump)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_debugPathMode(t *testing.T) {
+	rewrites := []Rewrite{{Regex: "foo", Replacement: "bar"}}
+	columnIndex := 2
+
+	tests := []struct {
+		desc     string
+		response Response
+		expMode  *debugMode
+	}{
+		{
+			desc:     "no mode",
+			response: Response{Rewrites: rewrites},
+		},
+		{
+			desc:     "graphql",
+			response: Response{GraphQL: &GraphQL{Code: "INTERNAL"}, Rewrites: rewrites},
+			expMode:  &debugMode{Option: "graphql", Settings: "errors with code INTERNAL"},
+		},
+		{
+			desc:     "jsonapiErrors",
+			response: Response{JSONAPIErrors: &JSONAPIErrors{Detail: `"error":"([^"]*)"`}},
+			expMode:  &debugMode{Option: "jsonapiErrors", Settings: `errors titled the status text, with the detail matching "error":"([^"]*)"`},
+		},
+		{
+			desc:     "soapFaults",
+			response: Response{SOAPFaults: true, Rewrites: rewrites},
+			expMode:  &debugMode{Option: "soapFaults", Settings: "fault messages"},
+		},
+		{
+			desc:     "csv column",
+			response: Response{CSV: &CSV{Column: "email", Mask: "***"}},
+			expMode:  &debugMode{Option: "csv", Settings: `column "email" after the header row masked with "***"`},
+		},
+		{
+			desc:     "csv index",
+			response: Response{CSV: &CSV{ColumnIndex: &columnIndex}, Rewrites: rewrites},
+			expMode:  &debugMode{Option: "csv", Settings: "column 2"},
+		},
+		{
+			desc:     "yamlOps",
+			response: Response{YAMLOps: []YAMLOp{{Path: "service.endpoint", Set: "https://example.com"}, {Path: "debug", Remove: true}}},
+			expMode:  &debugMode{Option: "yamlOps", Settings: `set service.endpoint to "https://example.com", remove debug`},
+		},
+		{
+			desc: "html",
+			response: Response{HTML: &HTML{
+				Inject:     []HTMLInjection{{Position: "bodyEnd", Content: "<footer></footer>"}},
+				Attributes: []HTMLAttribute{{Element: "a", Name: "href", Regex: "^http:", Replacement: "https:"}},
+			}},
+			expMode: &debugMode{Option: "html", Settings: "inject at bodyEnd, rewrite a[href] matching ^http:"},
+		},
+		{
+			desc:     "sitemap",
+			response: Response{Sitemap: &Sitemap{Origin: "https://www.example.com"}},
+			expMode:  &debugMode{Option: "sitemap", Settings: "paths /sitemap*.xml rewritten to https://www.example.com"},
+		},
+		{
+			desc:     "feed",
+			response: Response{Feed: &Feed{}},
+			expMode:  &debugMode{Option: "feed", Settings: "links rewritten to the public origin of the request"},
+		},
+		{
+			desc:     "hls",
+			response: Response{HLS: &HLS{Origin: "https://cdn.example.com"}},
+			expMode:  &debugMode{Option: "hls", Settings: "URIs rewritten to https://cdn.example.com"},
+		},
+		{
+			desc:     "dash",
+			response: Response{DASH: &DASH{}},
+			expMode:  &debugMode{Option: "dash", Settings: "URLs rewritten to the public origin of the request"},
+		},
+		{
+			desc:     "vast",
+			response: Response{VAST: &VAST{}},
+			expMode:  &debugMode{Option: "vast", Settings: "URLs rewritten to the public origin of the request"},
+		},
+		{
+			desc:     "openapi",
+			response: Response{OpenAPI: &OpenAPI{Paths: []string{"/openapi.json"}}},
+			expMode:  &debugMode{Option: "openapi", Settings: "server URLs of paths /openapi.json rewritten to the public origin of the request, with the forwarded prefix"},
+		},
+		{
+			desc:     "oidc",
+			response: Response{OIDC: &OIDC{Fields: []string{"jwks_uri", "issuer"}}},
+			expMode:  &debugMode{Option: "oidc", Settings: "fields issuer, jwks_uri of paths ending with /.well-known/openid-configuration rewritten to the public origin of the request"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			response := test.response
+			response.Status = "200"
+			handler, err := New(context.Background(), http.NotFoundHandler(), &Config{
+				DebugPath:       "/_rewrite",
+				DebugToken:      "secret",
+				MetricsInterval: "0",
+				Responses:       []Response{response},
+			}, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/_rewrite", nil)
+			req.Header.Set(debugTokenHeader, "secret")
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			var dump debugDump
+			if err := json.Unmarshal(recorder.Body.Bytes(), &dump); err != nil {
+				t.Fatal(err)
+			}
+			if len(dump.Responses) != 1 {
+				t.Fatalf("got %d response blocks, want 1", len(dump.Responses))
+			}
+			if mode := dump.Responses[0].Mode; !reflect.DeepEqual(mode, test.expMode) {
+				t.Errorf("got mode %+v, want %+v", mode, test.expMode)
+			}
+		})
+	}
+}
+
+func TestNew_debugPath(t *testing.T) {
+	tests := []struct {
+		desc   string
+		config Config
+		expErr string
+	}{
+		{desc: "path without token", config: Config{DebugPath: "/_rewrite"}, expErr: "invalid debugPath and debugToken: must be set together"},
+		{desc: "token without path", config: Config{DebugToken: "secret"}, expErr: "invalid debugPath and debugToken: must be set together"},
+		{desc: "relative path", config: Config{DebugPath: "_rewrite", DebugToken: "secret"}, expErr: `invalid debugPath "_rewrite": must start with /`},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if _, err := New(context.Background(), http.NotFoundHandler(), &test.config, "rewriteBody"); err == nil || err.Error() != test.expErr {
+				t.Errorf("got error %v, want %q", err, test.expErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// DecodeConfig decodes a JSON configuration of the middleware, as found under its name in the Traefik dynamic
+// configuration, and validates it. Unlike Traefik, which silently drops them before the plugin sees the
+// configuration, it rejects the unknown fields, e.g. a misspelled "replacment", telling their path.
+func DecodeConfig(data []byte) (*Config, error) {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if unknown := unknownFields(raw, reflect.TypeOf(Config{}), ""); len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown fields: %s", strings.Join(unknown, ", "))
+	}
+
+	config := CreateConfig()
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Validate checks the configuration as New does, without creating a middleware. The rulesURLs are not
+// fetched, only their syntax is checked.
+func (c *Config) Validate() error {
+	config := *c
+	_, err := newMiddleware(context.Background(), http.NotFoundHandler(), &config, defaultMiddlewareName, log.New(io.Discard, "", 0), true)
+	return err
+}
+
+// unknownFields returns the paths of the fields of value, as decoded from JSON, which are not fields of typ.
+// Values which don't fit typ are left to the JSON decoder.
+func unknownFields(value interface{}, typ reflect.Type, path string) []string {
+	switch typ.Kind() {
+	case reflect.Ptr:
+		return unknownFields(value, typ.Elem(), path)
+	case reflect.Slice:
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil
+		}
+		var unknown []string
+		for i, item := range items {
+			unknown = append(unknown, unknownFields(item, typ.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return unknown
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		var unknown []string
+		for _, key := range keys {
+			fieldPath := key
+			if path != "" {
+				fieldPath = path + "." + key
+			}
+			field, ok := jsonField(typ, key)
+			if !ok {
+				unknown = append(unknown, fieldPath)
+				continue
+			}
+			unknown = append(unknown, unknownFields(object[key], field.Type, fieldPath)...)
+		}
+		return unknown
+	default:
+		return nil
+	}
+}
+
+// jsonField returns the field of typ decoded from the given JSON key, matched case-insensitively like the
+// JSON decoder does.
+func jsonField(typ reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
@@ -0,0 +1,82 @@
+package traefik_responsebodyrewrite
+
+import (
+	"testing"
+)
+
+func TestDecodeConfig(t *testing.T) {
+	tests := []struct {
+		desc   string
+		data   string
+		expErr string
+	}{
+		{
+			desc: "valid",
+			data: `{"logLevel": "warn", "responses": [{"status": ["200", 404], "rewrites": [{"regex": "foo", "replacement": "bar"}]}]}`,
+		},
+		{
+			desc: "case insensitive keys",
+			data: `{"Responses": [{"Status": "200", "Rewrites": [{"Regex": "foo", "Replacement": "bar"}]}]}`,
+		},
+		{
+			desc: "rulesURL not fetched",
+			data: `{"rulesRefreshInterval": "1m", "responses": [{"status": "200", "rulesURL": "http://rules.invalid/rules.json"}]}`,
+		},
+		{
+			desc:   "invalid rulesURL",
+			data:   `{"responses": [{"status": "200", "rulesURL": "ftp://rules.invalid/rules.json"}]}`,
+			expErr: `responses[0]: invalid rulesURL "ftp://rules.invalid/rules.json": must be an http or https URL`,
+		},
+		{
+			desc:   "unknown fields",
+			data:   `{"loglevel": "warn", "retries": 3, "responses": [{"status": "200", "rewrites": [{"regex": "foo", "replacement": "bar"}, {"regex": "bar", "replacment": "foo"}]}]}`,
+			expErr: "unknown fields: responses[0].rewrites[1].replacment, retries",
+		},
+		{
+			desc:   "invalid JSON",
+			data:   `{"responses": [}`,
+			expErr: "invalid configuration: invalid character '}' looking for beginning of value",
+		},
+		{
+			desc:   "invalid type",
+			data:   `{"responses": {"status": "200"}}`,
+			expErr: "invalid configuration: json: cannot unmarshal object into Go struct field Config.responses of type []traefik_responsebodyrewrite.Response",
+		},
+		{
+			desc:   "invalid value",
+			data:   `{"logLevel": "verbose"}`,
+			expErr: `invalid logLevel "verbose": must be error, warn, info or debug`,
+		},
+		{
+			desc:   "invalid response",
+			data:   `{"responses": [{"status": "200", "rewrites": [{"regex": "ba(r"}]}]}`,
+			expErr: "responses[0]: rewrites[0].regex: error compiling regex \"ba(r\" with the regexp engine: error parsing regexp: missing closing ): `ba(r`",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config, err := DecodeConfig([]byte(test.data))
+			if test.expErr == "" {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				if len(config.Responses) == 0 || (len(config.Responses[0].Rewrites) != 1 && config.Responses[0].RulesURL == "") {
+					t.Errorf("got config %+v, want the decoded responses", config)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.expErr {
+				t.Errorf("got error %v, want %q", err, test.expErr)
+			}
+		})
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	if err := (&Config{}).Validate(); err != nil {
+		t.Errorf("got error %v, want none", err)
+	}
+	if err := (&Config{MaxBodySize: -1}).Validate(); err == nil {
+		t.Error("expected an error for a negative maxBodySize")
+	}
+}
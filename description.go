@@ -0,0 +1,36 @@
+package traefik_responsebodyrewrite
+
+import (
+	"fmt"
+	"strings"
+)
+
+// withDescription returns the label of a rewrite or of a response block in the diagnostics, followed by its
+// description if any, e.g. "rewrites[2] (mask legacy order IDs)".
+func withDescription(label, description string) string {
+	if description == "" {
+		return label
+	}
+	return label + " (" + description + ")"
+}
+
+// rewriteLabel returns the label of the rewrite at index i in the configuration errors.
+func rewriteLabel(i int, rewrite Rewrite) string {
+	return withDescription(fmt.Sprintf("rewrites[%d]", i), rewrite.Description)
+}
+
+// describeReplacements returns the descriptions of the rewrites which replaced matches, with their number of
+// matches, e.g. " (mask legacy order IDs: 2)", to follow the replacements in the debug logs. It is empty when
+// none of them has a description.
+func describeReplacements(rewrites []parsedRewrite, replaced []int) string {
+	var described []string
+	for i, n := range replaced {
+		if n > 0 && i < len(rewrites) && rewrites[i].description != "" {
+			described = append(described, fmt.Sprintf("%s: %d", rewrites[i].description, n))
+		}
+	}
+	if len(described) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(described, ", ") + ")"
+}
@@ -0,0 +1,129 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNew_descriptions(t *testing.T) {
+	tests := []struct {
+		desc   string
+		config Config
+		expErr string
+	}{
+		{
+			desc:   "rewrite",
+			config: Config{Responses: []Response{{Status: "200", Rewrites: []Rewrite{{Regex: "ACME-(", Replacement: "x", Description: "mask legacy order IDs"}}}}},
+			expErr: "responses[0]: rewrites[0] (mask legacy order IDs).regex: error compiling regex \"ACME-(\" with the regexp engine: error parsing regexp: missing closing ): `ACME-(`",
+		},
+		{
+			desc:   "response",
+			config: Config{Responses: []Response{{Name: "orders", Description: "legacy orders API", Status: "200"}}},
+			expErr: `responses[0] "orders" (legacy orders API): rewrites: must not be empty unless trailers is "strip", or jsonapiErrors, yamlOps, html, sitemap, feed, hls, dash, vast, openapi, oidc, linkHeader or a csv mask is set`,
+		},
+		{
+			desc:   "global rewrite",
+			config: Config{MaxPatternLength: 4, Rewrites: []Rewrite{{Regex: "ACME-\\d", Replacement: "x", Description: "mask IDs"}}},
+			expErr: "rewrites[0] (mask IDs).regex: pattern of 7 bytes exceeds maxPatternLength of 4",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := New(context.Background(), http.NotFoundHandler(), &test.config, "rewriteBody")
+			if err == nil || err.Error() != test.expErr {
+				t.Errorf("got error %v, want %q", err, test.expErr)
+			}
+		})
+	}
+
+	var logs bytes.Buffer
+	_, err := NewMiddleware(http.NotFoundHandler(), WithConfig(&Config{
+		Responses: []Response{{Description: "quote lines", Status: "200", Rewrites: []Rewrite{{Regex: "(?m)^", Replacement: "> ", Description: "quote"}}}},
+	}), WithName("rewriteBody"), WithLogger(log.New(&logs, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expLog := `WARN: rewriteBody: regex "(?m)^" (quote) of responses[0] (quote lines): its minimum match length is zero`
+	if !strings.HasPrefix(withoutCompileLog(logs.String()), expLog) {
+		t.Errorf("got logs %q, want them to start with %q", logs.String(), expLog)
+	}
+}
+
+func TestServeHTTP_descriptions(t *testing.T) {
+	rewrites := []Rewrite{
+		{Regex: `\bACME-\d{4}\b`, Replacement: "ACME-XXXX", Description: "mask legacy order IDs"},
+		{Regex: "internal", Replacement: "example"},
+		{Regex: "secret", Replacement: "[redacted]", Description: "hide secrets"},
+	}
+	tests := []struct {
+		desc    string
+		config  Config
+		expLogs string
+	}{
+		{
+			desc:    "debug log",
+			config:  Config{LogLevel: "debug", Responses: []Response{{Status: "200", Rewrites: rewrites}}},
+			expLogs: "DEBUG: rewriteBody: rewrite of / by response 0 replaced [2 1 0] matches (mask legacy order IDs: 2)\n",
+		},
+		{
+			desc:    "dry run",
+			config:  Config{DryRun: true, Responses: []Response{{Status: "200", Rewrites: rewrites}}},
+			expLogs: "INFO: rewriteBody: dry run: rewrite of / by response 0 would replace [2 1 0] matches (mask legacy order IDs: 2)\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var logs bytes.Buffer
+			handler, err := NewMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				_, _ = rw.Write([]byte("ACME-1234 and ACME-5678 on internal"))
+			}), WithConfig(&test.config), WithName("rewriteBody"), WithLogger(log.New(&logs, "", 0)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			logs.Reset()
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if !strings.HasSuffix(logs.String(), test.expLogs) {
+				t.Errorf("got logs %q, want them to end with %q", logs.String(), test.expLogs)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_debugPathDescriptions(t *testing.T) {
+	handler, err := New(context.Background(), http.NotFoundHandler(), &Config{
+		DebugPath:           "/_rewrite",
+		DebugToken:          "secret",
+		DebugRedactPatterns: true,
+		Responses: []Response{{
+			Name:        "orders",
+			Description: "legacy orders API",
+			Status:      "200",
+			Rewrites:    []Rewrite{{Regex: `\bACME-\d{4}\b`, Replacement: "ACME-XXXX", Description: "mask legacy order IDs"}},
+		}},
+	}, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/_rewrite", nil)
+	req.Header.Set(debugTokenHeader, "secret")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	var dump debugDump
+	if err := json.Unmarshal(recorder.Body.Bytes(), &dump); err != nil {
+		t.Fatal(err)
+	}
+	expRewrite := debugRewrite{Regex: redacted, Replacement: redacted, Description: "mask legacy order IDs"}
+	if len(dump.Responses) != 1 || dump.Responses[0].Description != "legacy orders API" || len(dump.Responses[0].Rewrites) != 1 || dump.Responses[0].Rewrites[0] != expRewrite {
+		t.Errorf("got responses %+v, want the descriptions of the response block and of its rewrite", dump.Responses)
+	}
+}
@@ -0,0 +1,67 @@
+package traefik_responsebodyrewrite
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// dryRunLogInterval is the minimum interval between two logs of the matches found in dry-run mode.
+const dryRunLogInterval = time.Second
+
+// captureDryRun copies p, already passed through to the client, to the buffer, so that the matches of the
+// rewrites of the dry-run response are counted once the body is complete. The body is no longer captured
+// once it exceeds maxBodySize or maxRewriteBytes, or the memory budget.
+func (rw *responseWriter) captureDryRun(p []byte) {
+	if rw.dryRun == nil {
+		return
+	}
+
+	size := int64(rw.buffer.Len() + len(p))
+	if rw.exceedsMaxBodySize(size) || rw.middleware.exceedsMaxRewriteBytes(size) || !rw.reserveBudget(int64(len(p))) {
+		if rw.debugEnabled() {
+			rw.debugf("%s: dry run: body of %s is too big to be captured, matches not counted", rw.middleware.name, rw.request.URL)
+		}
+		rw.dryRun = nil
+		rw.buffer.Reset()
+		rw.releaseBudget()
+		return
+	}
+	rw.buffer.Write(p)
+}
+
+// reportDryRun logs the number of matches the rules of the dry-run response would have replaced in the
+// captured body, if any. Bodies with matches are logged at most once per dryRunLogInterval, along with the
+// number of such bodies not logged in between.
+func (rw *responseWriter) reportDryRun() {
+	if rw.dryRun == nil {
+		return
+	}
+
+	replaced := make([]int, len(rw.dryRun.rewrites))
+	_, _, _, _ = rw.dryRun.rewriteUntil(rw.buffer.Bytes(), time.Time{}, replaced)
+	total := 0
+	for _, n := range replaced {
+		total += n
+	}
+	if total == 0 {
+		return
+	}
+
+	m := rw.middleware
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&m.lastDryRunLog)
+	if now-last < int64(dryRunLogInterval) || !atomic.CompareAndSwapInt64(&m.lastDryRunLog, last, now) {
+		atomic.AddInt64(&m.suppressedDryRunLogs, 1)
+		return
+	}
+	suppressed := atomic.SwapInt64(&m.suppressedDryRunLogs, 0)
+
+	message := fmt.Sprintf("%s: dry run: rewrite of %s by response %s would replace %v matches%s",
+		m.name, rw.request.URL, rw.dryRun.id, replaced, describeReplacements(rw.dryRun.rewrites, replaced))
+	if suppressed > 0 {
+		message += fmt.Sprintf(" (%d similar rewrites not logged)", suppressed)
+	}
+	fields := logFields{request: rw.request, status: rw.code, response: rw.dryRun, replacements: replaced}
+	m.logfUpTo(rw.dryRun.logLevel, levelInfo, fields, "%s", message)
+}
@@ -0,0 +1,133 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeHTTP_dryRun(t *testing.T) {
+	rewrites := []Rewrite{
+		{Regex: "foo", Replacement: "bar"},
+		{Regex: "qux", Replacement: "baz"},
+		{Regex: "bar bar", Replacement: "foo"},
+	}
+	tests := []struct {
+		desc     string
+		config   *Config
+		readFrom bool
+		expLogs  []string
+	}{
+		{
+			desc: "response dry run",
+			config: &Config{
+				Responses: []Response{{Status: "200", DryRun: true, Rewrites: rewrites}},
+			},
+			expLogs: []string{"rewriteBody: dry run: rewrite of /foo by response 0 would replace [2 0 1] matches"},
+		},
+		{
+			desc: "middleware dry run",
+			config: &Config{
+				DryRun:    true,
+				Responses: []Response{{Status: "200", Trailers: trailersStrip, Rewrites: rewrites}},
+			},
+			expLogs: []string{"rewriteBody: dry run: rewrite of /foo by response 0 would replace [2 0 1] matches"},
+		},
+		{
+			desc: "read from",
+			config: &Config{
+				Responses: []Response{{Status: "200", DryRun: true, Rewrites: rewrites}},
+			},
+			readFrom: true,
+			expLogs:  []string{"rewriteBody: dry run: rewrite of /foo by response 0 would replace [2 0 1] matches"},
+		},
+		{
+			desc: "no matches",
+			config: &Config{
+				Responses: []Response{{Status: "200", DryRun: true, Rewrites: []Rewrite{{Regex: "qux", Replacement: "baz"}}}},
+			},
+		},
+		{
+			desc: "body too big",
+			config: &Config{
+				MaxBodySize: 4,
+				Responses:   []Response{{Status: "200", DryRun: true, Rewrites: rewrites}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Content-Length", "7")
+				rw.Header().Set("ETag", `"foo"`)
+				rw.Header().Set("Trailer", "X-Foo")
+				if test.readFrom {
+					_, _ = io.Copy(rw, strings.NewReader("foo foo"))
+				} else {
+					_, _ = rw.Write([]byte("foo "))
+					_, _ = rw.Write([]byte("foo"))
+				}
+			}
+			handler, err := New(context.Background(), http.HandlerFunc(next), test.config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var logs bytes.Buffer
+			handler.(*responsebodyrewrite).infoLogger = log.New(&logs, "", 0)
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/foo", nil))
+
+			if body := recorder.Body.String(); body != "foo foo" {
+				t.Errorf("got body %q, want %q", body, "foo foo")
+			}
+			for name, value := range map[string]string{"Content-Length": "7", "ETag": `"foo"`, "Trailer": "X-Foo"} {
+				if got := recorder.Header().Get(name); got != value {
+					t.Errorf("got %s %q, want %q", name, got, value)
+				}
+			}
+			var expLogs string
+			for _, line := range test.expLogs {
+				expLogs += line + "\n"
+			}
+			if logs.String() != expLogs {
+				t.Errorf("got logs %q, want %q", logs.String(), expLogs)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_dryRunRateLimit(t *testing.T) {
+	config := &Config{
+		DryRun:    true,
+		Responses: []Response{{Status: "200", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}}},
+	}
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("foo"))
+	}
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := handler.(*responsebodyrewrite)
+	var logs bytes.Buffer
+	r.infoLogger = log.New(&logs, "", 0)
+
+	for i := 0; i < 3; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/foo", nil))
+	}
+	// Once the interval has elapsed, the next body with matches is logged.
+	r.lastDryRunLog = 0
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/bar", nil))
+
+	expected := "rewriteBody: dry run: rewrite of /foo by response 0 would replace [1] matches\n" +
+		"rewriteBody: dry run: rewrite of /bar by response 0 would replace [1] matches (2 similar rewrites not logged)\n"
+	if logs.String() != expected {
+		t.Errorf("got logs %q, want %q", logs.String(), expected)
+	}
+}
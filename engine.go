@@ -0,0 +1,653 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// rewritePass applies one or several rewrites in a single scan of the body.
+type rewritePass interface {
+	// apply returns the rewritten body, and whether it has been changed.
+	// When nothing changed, the returned slice is body itself. Unless nil, replaced holds a counter for
+	// each rewrite of the pass, incremented by the number of matches it replaced. A regexp2 rewrite whose
+	// search exceeds its matchTimeout returns a *matchTimeoutError.
+	apply(body []byte, replaced []int) ([]byte, bool, error)
+	// size returns the number of rewrites applied by the pass.
+	size() int
+}
+
+// apply implements the rewritePass interface for a single rewrite.
+func (r parsedRewrite) apply(body []byte, replaced []int) ([]byte, bool, error) {
+	if replaced != nil || r.backtracking != nil {
+		matches, err := r.findAll(body)
+		if err != nil {
+			return body, false, err
+		}
+		if replaced != nil {
+			replaced[0] += len(matches)
+		}
+		body, changed := r.expand(body, matches)
+		return body, changed, nil
+	}
+	// ReplaceAll copies the whole body even without any match, check there is one first.
+	if !r.regex.Match(body) {
+		return body, false, nil
+	}
+	return r.regex.ReplaceAll(body, r.replacement), true, nil
+}
+
+// findAll returns the matches of the rewrite in body, as regexp.Regexp.FindAllSubmatchIndex does.
+func (r parsedRewrite) findAll(body []byte) ([][]int, error) {
+	if r.backtracking != nil {
+		return r.backtracking.findAll(body)
+	}
+	return r.regex.FindAllSubmatchIndex(body, -1), nil
+}
+
+// expandMatch appends to dst the replacement of a match of the rewrite in body.
+func (r parsedRewrite) expandMatch(dst, body []byte, match []int) []byte {
+	if r.backtracking != nil {
+		return r.backtracking.expand(dst, r.replacement, body, match)
+	}
+	return r.regex.Expand(dst, r.replacement, body, match)
+}
+
+// expand returns body with the given matches of the rewrite replaced, and whether there was any.
+func (r parsedRewrite) expand(body []byte, matches [][]int) ([]byte, bool) {
+	if matches == nil {
+		return body, false
+	}
+	out := make([]byte, 0, len(body))
+	last := 0
+	for _, match := range matches {
+		out = append(out, body[last:match[0]]...)
+		out = r.expandMatch(out, body, match)
+		last = match[1]
+	}
+	return append(out, body[last:]...), true
+}
+
+// size implements the rewritePass interface.
+func (r parsedRewrite) size() int {
+	return 1
+}
+
+// pattern returns the source text of the regex of the rewrite, whatever its engine.
+func (r parsedRewrite) pattern() string {
+	if r.backtracking != nil {
+		return r.backtracking.String()
+	}
+	return r.regex.String()
+}
+
+// outputLimitError reports a rewrite which made the body bigger than the maxOutputBytes of its response.
+type outputLimitError struct {
+	// rule is the index of the rewrite in the response.
+	rule int
+	// replaced is the number of matches replaced when the limit was exceeded, out of matches.
+	replaced int
+	matches  int
+	limit    int64
+}
+
+// Error implements the error interface.
+func (e *outputLimitError) Error() string {
+	return fmt.Sprintf("rule %d exceeded maxOutputBytes of %d after replacing %d of %d matches", e.rule, e.limit, e.replaced, e.matches)
+}
+
+// applyLimited applies the rewrite like apply, but stops as soon as the rewritten body gets bigger than limit
+// bytes, in which case an *outputLimitError is returned. It also returns the number of matches replaced.
+func (r parsedRewrite) applyLimited(body []byte, limit int64) ([]byte, bool, int, error) {
+	matches, err := r.findAll(body)
+	if err != nil {
+		return nil, false, 0, err
+	}
+	if matches == nil {
+		return body, false, 0, nil
+	}
+
+	out := make([]byte, 0, len(body))
+	last := 0
+	for i, match := range matches {
+		out = append(out, body[last:match[0]]...)
+		out = r.expandMatch(out, body, match)
+		last = match[1]
+		// The rest of the body is going to be appended to the output anyway.
+		if int64(len(out)+len(body)-last) > limit {
+			return nil, false, 0, &outputLimitError{replaced: i + 1, matches: len(matches), limit: limit}
+		}
+	}
+	return append(out, body[last:]...), true, len(matches), nil
+}
+
+// literalPass replaces several literal patterns in a single scan of the body.
+type literalPass struct {
+	replacer *strings.Replacer
+	// literals and replacements are those of the rewrites, in order, for the matches to be counted.
+	literals     [][]byte
+	replacements [][]byte
+}
+
+// apply implements the rewritePass interface.
+func (p literalPass) apply(body []byte, replaced []int) ([]byte, bool, error) {
+	if replaced != nil {
+		body, changed := p.applyCounting(body, replaced)
+		return body, changed, nil
+	}
+	out := p.replacer.Replace(string(body))
+	if out == string(body) {
+		return body, false, nil
+	}
+	return []byte(out), true, nil
+}
+
+// applyCounting is apply counting the matches of each literal. The literals of a pass can't overlap, so
+// their leftmost matches, found by scanning forward the body once for each of them, are those the replacer
+// would replace.
+func (p literalPass) applyCounting(body []byte, replaced []int) ([]byte, bool) {
+	// next holds the position of the next match of each literal, -1 once there are no more.
+	next := make([]int, len(p.literals))
+	for i, literal := range p.literals {
+		next[i] = bytes.Index(body, literal)
+	}
+
+	var out []byte
+	last := 0
+	for {
+		first := -1
+		for i, position := range next {
+			if position >= 0 && (first < 0 || position < next[first]) {
+				first = i
+			}
+		}
+		if first < 0 {
+			break
+		}
+		if out == nil {
+			out = make([]byte, 0, len(body))
+		}
+		out = append(out, body[last:next[first]]...)
+		out = append(out, p.replacements[first]...)
+		last = next[first] + len(p.literals[first])
+		replaced[first]++
+		if position := bytes.Index(body[last:], p.literals[first]); position >= 0 {
+			next[first] = last + position
+		} else {
+			next[first] = -1
+		}
+	}
+	if out == nil {
+		return body, false
+	}
+	out = append(out, body[last:]...)
+	// A literal replaced by itself leaves the body unchanged, as told by the replacer.
+	if bytes.Equal(out, body) {
+		return body, false
+	}
+	return out, true
+}
+
+// size implements the rewritePass interface.
+func (p literalPass) size() int {
+	return len(p.literals)
+}
+
+// alternative is one of the rewrites merged in an alternationPass.
+type alternative struct {
+	rewrite parsedRewrite
+	// group is the index of the capture group wrapping the rewrite pattern in the merged regex.
+	group int
+}
+
+// alternationPass applies several rewrites in a single scan of the body, using a regex made of the
+// alternation of their patterns, each wrapped in a capture group telling which rewrite matched.
+type alternationPass struct {
+	regex        *regexp.Regexp
+	alternatives []alternative
+}
+
+// apply implements the rewritePass interface.
+func (p alternationPass) apply(body []byte, replaced []int) ([]byte, bool, error) {
+	matches := p.regex.FindAllSubmatchIndex(body, -1)
+	if matches == nil {
+		return body, false, nil
+	}
+
+	out := make([]byte, 0, len(body))
+	last := 0
+	for _, match := range matches {
+		out = append(out, body[last:match[0]]...)
+		for i, alt := range p.alternatives {
+			if match[2*alt.group] < 0 {
+				continue
+			}
+			if replaced != nil {
+				replaced[i]++
+			}
+			// The submatches of the rewrite pattern follow its wrapping group.
+			submatches := match[2*alt.group : 2*(alt.group+alt.rewrite.regex.NumSubexp()+1)]
+			out = alt.rewrite.regex.Expand(out, alt.rewrite.replacement, body, submatches)
+			break
+		}
+		last = match[1]
+	}
+	return append(out, body[last:]...), true, nil
+}
+
+// size implements the rewritePass interface.
+func (p alternationPass) size() int {
+	return len(p.alternatives)
+}
+
+// newAlternationPass merges the given rewrites in a single alternationPass.
+func newAlternationPass(rewrites []parsedRewrite) (alternationPass, error) {
+	patterns := make([]string, len(rewrites))
+	alternatives := make([]alternative, len(rewrites))
+	group := 1
+	for i, rewrite := range rewrites {
+		re, err := syntax.Parse(rewrite.regex.String(), syntax.Perl)
+		if err != nil {
+			return alternationPass{}, err
+		}
+		// Capture group names may collide between patterns, they are only needed by each rewrite own regex.
+		clearCaptureNames(re)
+
+		patterns[i] = "(" + re.String() + ")"
+		alternatives[i] = alternative{rewrite: rewrite, group: group}
+		group += rewrite.regex.NumSubexp() + 1
+	}
+
+	regex, err := regexp.Compile(strings.Join(patterns, "|"))
+	if err != nil {
+		return alternationPass{}, err
+	}
+	return alternationPass{regex: regex, alternatives: alternatives}, nil
+}
+
+// clearCaptureNames removes the names of all the capture groups of re.
+func clearCaptureNames(re *syntax.Regexp) {
+	re.Name = ""
+	for _, sub := range re.Sub {
+		clearCaptureNames(sub)
+	}
+}
+
+// ruleInfo holds what the optimizer knows about a rewrite.
+type ruleInfo struct {
+	rewrite parsedRewrite
+	// literal is the pattern when it is a plain literal, with a replacement without any group reference.
+	literal    string
+	isLiteral  bool
+	mergeable  bool
+	matchBytes *[256]bool
+	// replBytes are the bytes of the replacement written as is, i.e. not coming from a group reference.
+	replBytes []byte
+}
+
+// newRuleInfo analyzes a rewrite for the optimizer.
+func newRuleInfo(rewrite parsedRewrite) ruleInfo {
+	info := ruleInfo{rewrite: rewrite}
+	// The regexp2 patterns are neither analyzed nor merged, their syntax not being that of the regexp engine.
+	if rewrite.backtracking != nil {
+		return info
+	}
+
+	prefix, complete := rewrite.regex.LiteralPrefix()
+	if complete && prefix != "" && !bytes.ContainsRune(rewrite.replacement, '$') {
+		info.literal = prefix
+		info.isLiteral = true
+	}
+
+	// Patterns matching the empty string or depending on the surrounding text can't be merged.
+	if rewrite.regex.Match(nil) {
+		return info
+	}
+	re, err := syntax.Parse(rewrite.regex.String(), syntax.Perl)
+	if err != nil {
+		return info
+	}
+	var set [256]bool
+	if !collectMatchBytes(re, &set) {
+		return info
+	}
+	info.matchBytes = &set
+	info.replBytes = templateLiteralBytes(rewrite.replacement)
+	info.mergeable = len(info.replBytes) > 0
+	return info
+}
+
+// templateLiteralBytes returns the bytes of a replacement template which are not group references.
+func templateLiteralBytes(template []byte) []byte {
+	var out []byte
+	for len(template) > 0 {
+		i := bytes.IndexByte(template, '$')
+		if i < 0 {
+			return append(out, template...)
+		}
+		out = append(out, template[:i]...)
+		template = template[i+1:]
+
+		switch {
+		case len(template) > 0 && template[0] == '$':
+			out = append(out, '$')
+			template = template[1:]
+		case len(template) > 0 && template[0] == '{':
+			if end := bytes.IndexByte(template, '}'); end >= 0 {
+				template = template[end+1:]
+			}
+		default:
+			end := 0
+			for end < len(template) && (template[end] == '_' || isAlnum(template[end])) {
+				end++
+			}
+			template = template[end:]
+		}
+	}
+	return out
+}
+
+// groupRegex is a compiled regex with capture groups, of either engine.
+type groupRegex interface {
+	NumSubexp() int
+	SubexpIndex(name string) int
+}
+
+// missingGroup returns the first group referred to by a replacement template which regex doesn't have, if
+// any. Such references are replaced by an empty string, which is seldom intended: "$1x" refers to a group
+// named "1x", not to the group 1 followed by "x".
+func missingGroup(regex groupRegex, template string) string {
+	for len(template) > 0 {
+		i := strings.IndexByte(template, '$')
+		if i < 0 {
+			return ""
+		}
+		template = template[i+1:]
+		if len(template) > 0 && template[0] == '$' {
+			template = template[1:]
+			continue
+		}
+
+		name, size := groupReference(template)
+		template = template[size:]
+		if name == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(name); err == nil {
+			if n > regex.NumSubexp() {
+				return name
+			}
+			continue
+		}
+		if regex.SubexpIndex(name) < 0 {
+			return name
+		}
+	}
+	return ""
+}
+
+// groupReference parses the group reference at the start of template, following a '$', as regexp.Expand does.
+// It returns the name of the group, empty if there is no reference, and the length of the reference.
+func groupReference(template string) (string, int) {
+	if len(template) > 0 && template[0] == '{' {
+		end := strings.IndexByte(template, '}')
+		// Unterminated references, and braces around something else than a name, are kept as is.
+		if end < 0 || strings.TrimFunc(template[1:end], isNameRune) != "" {
+			return "", 0
+		}
+		return template[1:end], end + 1
+	}
+	end := 0
+	for end < len(template) && (template[end] == '_' || isAlnum(template[end])) {
+		end++
+	}
+	return template[:end], end
+}
+
+// isNameRune reports whether r can be part of a group name in a replacement template.
+func isNameRune(r rune) bool {
+	return r == '_' || r < utf8.RuneSelf && isAlnum(byte(r))
+}
+
+// isAlnum reports whether c is an ASCII letter or digit.
+func isAlnum(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// collectMatchBytes adds to set all the bytes that can be part of a match of re.
+// It returns false when re contains an assertion, whose result depends on the text around the match.
+func collectMatchBytes(re *syntax.Regexp, set *[256]bool) bool {
+	switch re.Op {
+	case syntax.OpNoMatch, syntax.OpEmptyMatch:
+		return true
+
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			addRuneBytes(r, set)
+			if re.Flags&syntax.FoldCase != 0 {
+				for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+					addRuneBytes(f, set)
+				}
+			}
+		}
+		return true
+
+	case syntax.OpCharClass:
+		for i := 0; i < len(re.Rune); i += 2 {
+			lo, hi := re.Rune[i], re.Rune[i+1]
+			for r := lo; r <= hi && r < utf8.RuneSelf; r++ {
+				set[r] = true
+			}
+			// Conservatively consider that any multi-byte rune can match.
+			if hi >= utf8.RuneSelf {
+				for b := utf8.RuneSelf; b < 256; b++ {
+					set[b] = true
+				}
+			}
+		}
+		return true
+
+	case syntax.OpAnyCharNotNL, syntax.OpAnyChar:
+		for b := range set {
+			if b != '\n' || re.Op == syntax.OpAnyChar {
+				set[b] = true
+			}
+		}
+		return true
+
+	case syntax.OpCapture, syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat,
+		syntax.OpConcat, syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if !collectMatchBytes(sub, set) {
+				return false
+			}
+		}
+		return true
+
+	default:
+		return false
+	}
+}
+
+// addRuneBytes adds the UTF-8 encoding of r to set.
+func addRuneBytes(r rune, set *[256]bool) {
+	var buf [utf8.UTFMax]byte
+	n := utf8.EncodeRune(buf[:], r)
+	for _, b := range buf[:n] {
+		set[b] = true
+	}
+}
+
+// overlaps reports whether a and b can overlap when written next to each other or inside each other.
+func overlaps(a, b string) bool {
+	if strings.Contains(a, b) || strings.Contains(b, a) {
+		return true
+	}
+	for i := 1; i < len(a) && i < len(b); i++ {
+		if a[len(a)-i:] == b[:i] || b[len(b)-i:] == a[:i] {
+			return true
+		}
+	}
+	return false
+}
+
+// independent reports whether applying the rewrites before then after in a single scan of the body gives
+// the same result as applying them one after the other.
+// This is the case when their matches can't overlap, and when the rewrite of before can neither create nor
+// remove matches of after.
+func independent(before, after ruleInfo) bool {
+	if before.isLiteral && after.isLiteral {
+		// Replacing before by an empty string could join two parts of a match of after.
+		return len(before.rewrite.replacement) > 0 &&
+			!overlaps(before.literal, after.literal) &&
+			!overlaps(string(before.rewrite.replacement), after.literal)
+	}
+
+	if !before.mergeable || !after.mergeable {
+		return false
+	}
+	// The matches of after are made of bytes that neither the matches nor the replacement of before contain:
+	// the runs of such bytes in the body, and so the matches of after, are left untouched by before.
+	for b := range after.matchBytes {
+		if after.matchBytes[b] && before.matchBytes[b] {
+			return false
+		}
+	}
+	for _, b := range before.replBytes {
+		if after.matchBytes[b] {
+			return false
+		}
+	}
+	return true
+}
+
+// optimizePasses groups consecutive independent rewrites to apply them in a single scan of the body.
+// Rewrites that can't be proven independent from the previous ones are applied one after the other.
+func optimizePasses(rewrites []parsedRewrite) []rewritePass {
+	var passes []rewritePass
+	var group []ruleInfo
+
+	flush := func() {
+		passes = append(passes, newPass(group))
+		group = nil
+	}
+
+	for _, rewrite := range rewrites {
+		info := newRuleInfo(rewrite)
+		for _, member := range group {
+			if !independent(member, info) {
+				flush()
+				break
+			}
+		}
+		group = append(group, info)
+	}
+	if len(group) > 0 {
+		flush()
+	}
+	return passes
+}
+
+// newPass creates the pass applying a group of independent rewrites.
+func newPass(group []ruleInfo) rewritePass {
+	if len(group) == 1 {
+		return group[0].rewrite
+	}
+
+	allLiterals := true
+	rewrites := make([]parsedRewrite, len(group))
+	for i, info := range group {
+		rewrites[i] = info.rewrite
+		allLiterals = allLiterals && info.isLiteral
+	}
+
+	if allLiterals {
+		pass := literalPass{literals: make([][]byte, len(group)), replacements: make([][]byte, len(group))}
+		oldnew := make([]string, 0, 2*len(group))
+		for i, info := range group {
+			oldnew = append(oldnew, info.literal, string(info.rewrite.replacement))
+			pass.literals[i] = []byte(info.literal)
+			pass.replacements[i] = info.rewrite.replacement
+		}
+		pass.replacer = strings.NewReplacer(oldnew...)
+		return pass
+	}
+
+	pass, err := newAlternationPass(rewrites)
+	if err != nil {
+		// Fall back to applying the rewrites one after the other.
+		return sequentialPass(rewrites)
+	}
+	return pass
+}
+
+// sequentialPass applies rewrites one after the other.
+type sequentialPass []parsedRewrite
+
+// apply implements the rewritePass interface.
+func (p sequentialPass) apply(body []byte, replaced []int) ([]byte, bool, error) {
+	modified := false
+	for i, rewrite := range p {
+		var changed bool
+		var err error
+		if replaced != nil {
+			body, changed, err = rewrite.apply(body, replaced[i:i+1])
+		} else {
+			body, changed, err = rewrite.apply(body, nil)
+		}
+		if err != nil {
+			return body, false, err
+		}
+		modified = modified || changed
+	}
+	return body, modified, nil
+}
+
+// size implements the rewritePass interface.
+func (p sequentialPass) size() int {
+	return len(p)
+}
+
+// The regex engines of the rewrites.
+const (
+	engineRegexp  = "regexp"
+	engineRegexp2 = "regexp2"
+)
+
+// checkEngine checks the engine of a rewrite.
+func checkEngine(engine string) error {
+	switch engine {
+	case "", engineRegexp, engineRegexp2:
+		return nil
+	}
+	return fmt.Errorf("invalid engine %q: must be %q or %q", engine, engineRegexp, engineRegexp2)
+}
+
+// backtrackingSyntaxHint returns a hint for an error compiling a regex with the syntax of the backtracking
+// engines, such as regexp2, which RE2 doesn't support, empty for other errors.
+func backtrackingSyntaxHint(err error) string {
+	var syntaxErr *syntax.Error
+	if !errors.As(err, &syntaxErr) {
+		return ""
+	}
+	var construct, workaround string
+	switch {
+	case syntaxErr.Code == syntax.ErrInvalidPerlOp && (syntaxErr.Expr == "(?=" || syntaxErr.Expr == "(?!"),
+		syntaxErr.Code == syntax.ErrInvalidNamedCapture && (strings.HasPrefix(syntaxErr.Expr, "(?<=") || strings.HasPrefix(syntaxErr.Expr, "(?<!")):
+		construct, workaround = "lookarounds", "match the surrounding text in a group, and put it back with the group in the replacement"
+	case syntaxErr.Code == syntax.ErrInvalidEscape && (syntaxErr.Expr == `\k` || syntaxErr.Expr == `\g` || len(syntaxErr.Expr) == 2 && syntaxErr.Expr[1] >= '1' && syntaxErr.Expr[1] <= '9'):
+		construct, workaround = "backreferences", "use one rewrite per repeated value"
+	case syntaxErr.Code == syntax.ErrInvalidPerlOp && syntaxErr.Expr == "(?>",
+		syntaxErr.Code == syntax.ErrInvalidRepeatOp && strings.HasSuffix(syntaxErr.Expr, "+"):
+		construct, workaround = "atomic groups and possessive quantifiers", "use a non-capturing group or a plain quantifier, the regexp engine never backtracking"
+	default:
+		return ""
+	}
+	return fmt.Sprintf("%s are %s syntax: set engine to %s, along with a matchTimeout, or %s", construct, engineRegexp2, engineRegexp2, workaround)
+}
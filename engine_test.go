@@ -0,0 +1,402 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// naiveRewrite applies the rewrites one after the other, as the reference for the optimized passes.
+func naiveRewrite(rewrites []parsedRewrite, body []byte) []byte {
+	for _, rewrite := range rewrites {
+		body = rewrite.regex.ReplaceAll(body, rewrite.replacement)
+	}
+	return body
+}
+
+// naiveReplacements counts the matches of the rewrites applied one after the other, as the reference for the
+// matches counted by the optimized passes.
+func naiveReplacements(rewrites []parsedRewrite, body []byte) []int {
+	replaced := make([]int, len(rewrites))
+	for i, rewrite := range rewrites {
+		replaced[i] = len(rewrite.regex.FindAllIndex(body, -1))
+		body = rewrite.regex.ReplaceAll(body, rewrite.replacement)
+	}
+	return replaced
+}
+
+func parseRewrites(rules ...string) []parsedRewrite {
+	rewrites := make([]parsedRewrite, 0, len(rules)/2)
+	for i := 0; i < len(rules); i += 2 {
+		rewrites = append(rewrites, parsedRewrite{
+			regex:       regexp.MustCompile(rules[i]),
+			replacement: []byte(rules[i+1]),
+		})
+	}
+	return rewrites
+}
+
+func applyPasses(passes []rewritePass, body []byte) []byte {
+	for _, pass := range passes {
+		body, _, _ = pass.apply(body, nil)
+	}
+	return body
+}
+
+// countPasses applies the passes like applyPasses, counting the matches of each rewrite.
+func countPasses(passes []rewritePass, body []byte) ([]byte, []int) {
+	var replaced []int
+	for _, pass := range passes {
+		counts := make([]int, pass.size())
+		body, _, _ = pass.apply(body, counts)
+		replaced = append(replaced, counts...)
+	}
+	return body, replaced
+}
+
+func TestOptimizePasses(t *testing.T) {
+	tests := []struct {
+		desc      string
+		rules     []string
+		body      string
+		expPasses int
+	}{
+		{
+			desc:      "should merge independent literals",
+			rules:     []string{"foo", "bar", "baz", "qux"},
+			body:      "foo baz foobaz",
+			expPasses: 1,
+		},
+		{
+			desc:      "should not merge a literal replaced by the pattern of the next one",
+			rules:     []string{"foo", "bar", "bar", "baz"},
+			body:      "foo bar",
+			expPasses: 2,
+		},
+		{
+			desc:      "should not merge a replacement which can form the next pattern with the text around it",
+			rules:     []string{"X", "ab", "bc", "Y"},
+			body:      "Xc",
+			expPasses: 2,
+		},
+		{
+			desc:      "should not merge overlapping literals",
+			rules:     []string{"foo", "X", "oof", "Y"},
+			body:      "oofoo",
+			expPasses: 2,
+		},
+		{
+			desc:      "should not merge a literal removed by an empty replacement",
+			rules:     []string{"X", "", "ab", "Y"},
+			body:      "aXb",
+			expPasses: 2,
+		},
+		{
+			desc:      "should merge regexes made of disjoint bytes",
+			rules:     []string{`[0-9]+`, "N", `[a-z]+@[a-z]+`, "<${0}>"},
+			body:      "call 123 or mail to foo@bar",
+			expPasses: 1,
+		},
+		{
+			desc:      "should not merge regexes sharing bytes",
+			rules:     []string{`[a-z]+`, "W", `o+`, "0"},
+			body:      "foo boo",
+			expPasses: 2,
+		},
+		{
+			desc:      "should not merge regexes with assertions",
+			rules:     []string{`\bfoo\b`, "X", `[0-9]`, "N"},
+			body:      "foo 1",
+			expPasses: 2,
+		},
+		{
+			desc:      "should not merge a regex whose replacement contains bytes of the next one",
+			rules:     []string{`[0-9]+`, "x", `x+`, "Y"},
+			body:      "1x",
+			expPasses: 2,
+		},
+		{
+			desc:      "should merge regexes with colliding group names",
+			rules:     []string{`(?P<n>[0-9]+)`, "<$n>", `(?P<n>[a-z]+)`, "[$n]"},
+			body:      "abc 123",
+			expPasses: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			rewrites := parseRewrites(test.rules...)
+			passes := optimizePasses(rewrites)
+
+			if len(passes) != test.expPasses {
+				t.Errorf("got %d passes, want %d", len(passes), test.expPasses)
+			}
+
+			expected := naiveRewrite(rewrites, []byte(test.body))
+			if res := applyPasses(passes, []byte(test.body)); !bytes.Equal(res, expected) {
+				t.Errorf("got body %q, want %q", res, expected)
+			}
+			res, replaced := countPasses(passes, []byte(test.body))
+			if !bytes.Equal(res, expected) {
+				t.Errorf("got body %q counting the matches, want %q", res, expected)
+			}
+			if expReplaced := naiveReplacements(rewrites, []byte(test.body)); !reflect.DeepEqual(replaced, expReplaced) {
+				t.Errorf("got replacements %v, want %v", replaced, expReplaced)
+			}
+		})
+	}
+}
+
+func TestOptimizePasses_differential(t *testing.T) {
+	patterns := []string{
+		"a", "ab", "ba", "abc", "b", "c-", "x", "--", "1", "12",
+		`[0-9]+`, `[a-c]{2}`, `x+`, `(a)(b)?`, `-[0-9]`, `(?i)ab`,
+	}
+	replacements := []string{"", "x", "ab", "-", "1", "$1", "[$0]", "zz", "b-"}
+
+	random := rand.New(rand.NewSource(42))
+	for i := 0; i < 2000; i++ {
+		var rules []string
+		for n := random.Intn(5) + 1; n > 0; n-- {
+			rules = append(rules, patterns[random.Intn(len(patterns))], replacements[random.Intn(len(replacements))])
+		}
+		rewrites := parseRewrites(rules...)
+		passes := optimizePasses(rewrites)
+
+		var body strings.Builder
+		for n := random.Intn(30); n > 0; n-- {
+			body.WriteByte("abcxAB-12 "[random.Intn(10)])
+		}
+
+		expected := naiveRewrite(rewrites, []byte(body.String()))
+		if res := applyPasses(passes, []byte(body.String())); !bytes.Equal(res, expected) {
+			t.Fatalf("rules %q on body %q: got %q, want %q", rules, body.String(), res, expected)
+		}
+		res, replaced := countPasses(passes, []byte(body.String()))
+		if expReplaced := naiveReplacements(rewrites, []byte(body.String())); !bytes.Equal(res, expected) || !reflect.DeepEqual(replaced, expReplaced) {
+			t.Fatalf("rules %q on body %q: got %q with replacements %v counting, want %q with %v", rules, body.String(), res, replaced, expected, expReplaced)
+		}
+	}
+}
+
+func BenchmarkRewrite(b *testing.B) {
+	var rules []string
+	for i := 0; i < 30; i++ {
+		rules = append(rules, fmt.Sprintf("internal-host-%02d", i), fmt.Sprintf("public-host-%02d", i))
+	}
+	rewrites := parseRewrites(rules...)
+
+	body := bytes.Repeat([]byte("<a href=\"https://internal-host-07/page\">some text to scan</a>\n"), 16*1024)
+
+	b.Run("sequential", func(b *testing.B) {
+		passes := []rewritePass{sequentialPass(rewrites)}
+		b.SetBytes(int64(len(body)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			applyPasses(passes, body)
+		}
+	})
+
+	b.Run("optimized", func(b *testing.B) {
+		passes := optimizePasses(rewrites)
+		b.SetBytes(int64(len(body)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			applyPasses(passes, body)
+		}
+	})
+}
+
+func TestParsedRewrite_applyLimited(t *testing.T) {
+	tests := []struct {
+		desc        string
+		rules       []string
+		body        string
+		limit       int64
+		expReplaced int
+	}{
+		{
+			desc:  "should rewrite like ReplaceAll within the limit",
+			rules: []string{`(\w+)@example\.com`, "${1} at example.com"},
+			body:  "foo@example.com, bar@example.com",
+			limit: 100,
+		},
+		{
+			desc:  "should handle empty matches like ReplaceAll",
+			rules: []string{"x*", "-"},
+			body:  "abxxcé",
+			limit: 100,
+		},
+		{
+			desc:        "should stop at the match exceeding the limit",
+			rules:       []string{"a", "aaaa"},
+			body:        "aaaaaaaaaa",
+			limit:       20,
+			expReplaced: 4,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			rewrite := parseRewrites(test.rules...)[0]
+			out, _, _, err := rewrite.applyLimited([]byte(test.body), test.limit)
+
+			if test.expReplaced == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected := naiveRewrite([]parsedRewrite{rewrite}, []byte(test.body))
+				if !bytes.Equal(out, expected) {
+					t.Errorf("got body %q, want %q", out, expected)
+				}
+				return
+			}
+
+			var limitErr *outputLimitError
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("expected the limit to be exceeded, got body %q and error %v", out, err)
+			}
+			if limitErr.replaced != test.expReplaced || limitErr.matches != len(test.body) {
+				t.Errorf("got %d replaced matches out of %d, want %d out of %d", limitErr.replaced, limitErr.matches, test.expReplaced, len(test.body))
+			}
+		})
+	}
+}
+
+func TestMissingGroup(t *testing.T) {
+	tests := []struct {
+		regex       string
+		replacement string
+		expGroup    string
+	}{
+		{regex: "foo", replacement: "bar"},
+		{regex: "f(o)o", replacement: "$1"},
+		{regex: "f(o)o", replacement: "${1}x"},
+		{regex: "f(o)o", replacement: "$1x", expGroup: "1x"},
+		{regex: "f(o)o", replacement: "$2", expGroup: "2"},
+		{regex: "f(?P<o>o)o", replacement: "${o}"},
+		{regex: "f(?P<o>o)o", replacement: "$p", expGroup: "p"},
+		{regex: "foo", replacement: "$$1"},
+		{regex: "foo", replacement: "${1"},
+		{regex: "foo", replacement: "${a-b}"},
+		{regex: "foo", replacement: "$ $"},
+	}
+	for _, test := range tests {
+		t.Run(test.replacement, func(t *testing.T) {
+			group := missingGroup(regexp.MustCompile(test.regex), test.replacement)
+			if group != test.expGroup {
+				t.Errorf("got group %q, want %q", group, test.expGroup)
+			}
+		})
+	}
+}
+
+func TestCompileRewrite_engine(t *testing.T) {
+	tests := []struct {
+		desc    string
+		rewrite Rewrite
+		expErr  string
+	}{
+		{
+			desc:    "explicit regexp engine",
+			rewrite: Rewrite{Regex: "foo", Replacement: "bar", Engine: "regexp"},
+		},
+		{
+			desc:    "regexp2 engine",
+			rewrite: Rewrite{Regex: "foo(?!bar)", Replacement: "baz", Engine: "regexp2", MatchTimeout: "100ms"},
+		},
+		{
+			desc:    "regexp2 engine with a backreference",
+			rewrite: Rewrite{Regex: `(\w)\1`, Replacement: "$1", Engine: "regexp2", MatchTimeout: "100ms"},
+		},
+		{
+			desc:    "regexp2 engine without matchTimeout",
+			rewrite: Rewrite{Regex: "foo(?!bar)", Replacement: "baz", Engine: "regexp2"},
+			expErr:  "matchTimeout: must be set with the regexp2 engine",
+		},
+		{
+			desc:    "invalid matchTimeout",
+			rewrite: Rewrite{Regex: "foo(?!bar)", Replacement: "baz", Engine: "regexp2", MatchTimeout: "soon"},
+			expErr:  `matchTimeout: time: invalid duration "soon"`,
+		},
+		{
+			desc:    "negative matchTimeout",
+			rewrite: Rewrite{Regex: "foo(?!bar)", Replacement: "baz", Engine: "regexp2", MatchTimeout: "-1s"},
+			expErr:  `invalid matchTimeout "-1s": must be positive`,
+		},
+		{
+			desc:    "matchTimeout with the regexp engine",
+			rewrite: Rewrite{Regex: "foo", Replacement: "bar", MatchTimeout: "100ms"},
+			expErr:  "matchTimeout: can only be used with the regexp2 engine",
+		},
+		{
+			desc:    "regexp2 syntax error",
+			rewrite: Rewrite{Regex: "foo(", Replacement: "bar", Engine: "regexp2", MatchTimeout: "100ms"},
+			expErr:  "regex: error compiling regex \"foo(\" with the regexp2 engine: error parsing regexp: missing closing ) in `foo(`",
+		},
+		{
+			desc:    "regexp2 empty match",
+			rewrite: Rewrite{Regex: "(?!bar)", Replacement: "baz", Engine: "regexp2", MatchTimeout: "100ms"},
+			expErr:  `regex: "(?!bar)" matches the empty string, which inserts the replacement between every byte of the body`,
+		},
+		{
+			desc:    "regexp2 missing group",
+			rewrite: Rewrite{Regex: "f(?<o>o)o", Replacement: "${p}", Engine: "regexp2", MatchTimeout: "100ms"},
+			expErr:  `replacement: "${p}" refers to group "p", which regex "f(?<o>o)o" doesn't have`,
+		},
+		{
+			desc:    "regexp2 engine for a compiled regex",
+			rewrite: Rewrite{Regex: "foo", Replacement: "bar", Engine: "regexp2", MatchTimeout: "100ms", regex: regexp.MustCompile("foo")},
+			expErr:  "engine: can't be regexp2 for a regex compiled by the regexp engine",
+		},
+		{
+			desc:    "unknown engine",
+			rewrite: Rewrite{Regex: "foo", Replacement: "bar", Engine: "pcre"},
+			expErr:  `engine: invalid engine "pcre": must be "regexp" or "regexp2"`,
+		},
+		{
+			desc:    "negative lookahead",
+			rewrite: Rewrite{Regex: "foo(?!bar)", Replacement: "baz"},
+			expErr:  "regex: error compiling regex \"foo(?!bar)\" with the regexp engine: error parsing regexp: invalid or unsupported Perl syntax: `(?!` (lookarounds are regexp2 syntax: set engine to regexp2, along with a matchTimeout, or match the surrounding text in a group, and put it back with the group in the replacement)",
+		},
+		{
+			desc:    "lookbehind",
+			rewrite: Rewrite{Regex: "(?<=foo)bar", Replacement: "baz"},
+			expErr:  "regex: error compiling regex \"(?<=foo)bar\" with the regexp engine: error parsing regexp: invalid named capture: `(?<=foo)bar` (lookarounds are regexp2 syntax: set engine to regexp2, along with a matchTimeout, or match the surrounding text in a group, and put it back with the group in the replacement)",
+		},
+		{
+			desc:    "backreference",
+			rewrite: Rewrite{Regex: `(a)\1`, Replacement: "b"},
+			expErr:  "regex: error compiling regex \"(a)\\\\1\" with the regexp engine: error parsing regexp: invalid escape sequence: `\\1` (backreferences are regexp2 syntax: set engine to regexp2, along with a matchTimeout, or use one rewrite per repeated value)",
+		},
+		{
+			desc:    "possessive quantifier",
+			rewrite: Rewrite{Regex: `a*+`, Replacement: "b"},
+			expErr:  "regex: error compiling regex \"a*+\" with the regexp engine: error parsing regexp: invalid nested repetition operator: `*+` (atomic groups and possessive quantifiers are regexp2 syntax: set engine to regexp2, along with a matchTimeout, or use a non-capturing group or a plain quantifier, the regexp engine never backtracking)",
+		},
+		{
+			desc:    "other syntax error",
+			rewrite: Rewrite{Regex: "foo(", Replacement: "bar"},
+			expErr:  "regex: error compiling regex \"foo(\" with the regexp engine: error parsing regexp: missing closing ): `foo(`",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := compileRewrite(test.rewrite, false)
+			if test.expErr == "" {
+				if err != nil {
+					t.Errorf("got error %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.expErr {
+				t.Errorf("got error %v, want %q", err, test.expErr)
+			}
+		})
+	}
+}
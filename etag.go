@@ -0,0 +1,50 @@
+package traefik_responsebodyrewrite
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// computeETag returns a strong ETag identifying body.
+func computeETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether the If-None-Match header value ifNoneMatch matches etag, using the weak
+// comparison required for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// setRewrittenETag replaces the upstream ETag of a response to rewrite by the ETag of the rewritten body
+// when it is already known from the cache, and removes it otherwise. It returns the status code to send,
+// which is 304 Not Modified when the ETag matches the If-None-Match of the request.
+func (rw *responseWriter) setRewrittenETag(statusCode int) int {
+	header := rw.ResponseWriter.Header()
+	if rw.cachedETag == "" {
+		header.Del("ETag")
+		return statusCode
+	}
+
+	header.Set("ETag", rw.cachedETag)
+	ifNoneMatch := rw.request.Header.Get("If-None-Match")
+	if rw.request.Method != http.MethodGet || ifNoneMatch == "" || !etagMatches(ifNoneMatch, rw.cachedETag) {
+		return statusCode
+	}
+
+	rw.notModified = true
+	// A 304 response has no body, and doesn't describe the representation it would have had.
+	for _, name := range []string{"Content-Length", "Content-Type", "Content-Encoding", "Transfer-Encoding"} {
+		header.Del(name)
+	}
+	return http.StatusNotModified
+}
@@ -0,0 +1,92 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEtagMatches(t *testing.T) {
+	etag := computeETag([]byte("bar"))
+
+	tests := []struct {
+		desc        string
+		ifNoneMatch string
+		expected    bool
+	}{
+		{desc: "should match the same ETag", ifNoneMatch: etag, expected: true},
+		{desc: "should match the ETag in a list", ifNoneMatch: `"other", ` + etag, expected: true},
+		{desc: "should match a weak ETag", ifNoneMatch: "W/" + etag, expected: true},
+		{desc: "should match any ETag", ifNoneMatch: "*", expected: true},
+		{desc: "should not match another ETag", ifNoneMatch: computeETag([]byte("foo"))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if matches := etagMatches(test.ifNoneMatch, etag); matches != test.expected {
+				t.Errorf("got %t, want %t", matches, test.expected)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_recomputeETag(t *testing.T) {
+	config := &Config{
+		CacheSize:     10,
+		RecomputeETag: true,
+		Responses: []Response{
+			{
+				Status:   "200",
+				Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+			},
+		},
+	}
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("ETag", `"upstream"`)
+		rw.Header().Set("Content-Type", "text/plain")
+		_, _ = rw.Write([]byte("foo is the new bar"))
+	}
+
+	rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		recorder := httptest.NewRecorder()
+		rewriteBody.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// The rewritten body is not known yet when the headers are sent: the upstream ETag is removed.
+	recorder := serve("")
+	if etag := recorder.Header().Get("ETag"); etag != "" {
+		t.Errorf("got ETag %q, want none", etag)
+	}
+
+	etag := computeETag([]byte("bar is the new bar"))
+	recorder = serve(`"upstream"`)
+	if recorder.Header().Get("ETag") != etag || recorder.Code != http.StatusOK {
+		t.Errorf("got ETag %q and status %d, want %q and %d", recorder.Header().Get("ETag"), recorder.Code, etag, http.StatusOK)
+	}
+	if recorder.Body.String() != "bar is the new bar" {
+		t.Errorf("got body %q, want %q", recorder.Body.String(), "bar is the new bar")
+	}
+
+	recorder = serve(etag)
+	if recorder.Code != http.StatusNotModified {
+		t.Errorf("got status %d, want %d", recorder.Code, http.StatusNotModified)
+	}
+	if recorder.Header().Get("ETag") != etag || recorder.Header().Get("Content-Type") != "" {
+		t.Errorf("got headers %v", recorder.Header())
+	}
+	if recorder.Body.Len() != 0 {
+		t.Errorf("got body %q, want none", recorder.Body.String())
+	}
+}
@@ -0,0 +1,149 @@
+package traefik_responsebodyrewrite
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// The values of the failureMode option.
+const (
+	failureModePassthrough = "passthrough"
+	failureModeError       = "error"
+)
+
+// failureStage is a stage of the rewrite of a body which can fail, named in the logs and the metrics.
+type failureStage int
+
+const (
+	// stageRewrite is the application of the rewrites, failing when the body exceeds maxOutputBytes.
+	stageRewrite failureStage = iota
+	// stageJSON is the decoding of the body in JSON streaming mode.
+	stageJSON
+	// stageCSV is the parsing of the body by the csv mode.
+	stageCSV
+	// stageYAML is the parsing of the body by the yamlOps mode.
+	stageYAML
+	// stageSitemap is the parsing of the body by the sitemap mode.
+	stageSitemap
+	// stageFeed is the parsing of the body by the feed mode.
+	stageFeed
+	// stageDASH is the parsing of the body by the dash mode.
+	stageDASH
+	// stageVAST is the parsing of the body by the vast mode.
+	stageVAST
+	// stageOpenAPI is the parsing of the body by the openapi mode.
+	stageOpenAPI
+	// stageOIDC is the parsing of the body by the oidc mode.
+	stageOIDC
+	// stageSOAP is the parsing of the body by the soapFaults mode.
+	stageSOAP
+	// stageMultipart is the parsing of a multipart body rewritten part by part.
+	stageMultipart
+
+	numFailureStages
+)
+
+// String returns the name of the stage.
+func (s failureStage) String() string {
+	switch s {
+	case stageRewrite:
+		return "rewrite"
+	case stageJSON:
+		return "json"
+	case stageCSV:
+		return "csv"
+	case stageYAML:
+		return "yaml"
+	case stageSitemap:
+		return "sitemap"
+	case stageFeed:
+		return "feed"
+	case stageDASH:
+		return "dash"
+	case stageVAST:
+		return "vast"
+	case stageOpenAPI:
+		return "openapi"
+	case stageOIDC:
+		return "oidc"
+	case stageSOAP:
+		return "soap"
+	case stageMultipart:
+		return "multipart"
+	default:
+		return "unknown"
+	}
+}
+
+// stageError is the error of a stage of the rewrite other than the rewrites themselves, such as the parsing of
+// the body by the mode of its response block.
+type stageError struct {
+	stage failureStage
+	err   error
+}
+
+// Error implements the error interface.
+func (e *stageError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *stageError) Unwrap() error {
+	return e.err
+}
+
+// failureStageOf returns the stage of the rewrite which failed with err, the rewrites themselves unless it is
+// a *stageError.
+func failureStageOf(err error) failureStage {
+	var stageErr *stageError
+	if errors.As(err, &stageErr) {
+		return stageErr.stage
+	}
+	return stageRewrite
+}
+
+// validateFailureMode checks the failureMode and failureStatus options, the status only being used with the
+// error mode.
+func validateFailureMode(mode string, status int) error {
+	switch mode {
+	case "", failureModePassthrough, failureModeError:
+	default:
+		return fmt.Errorf("invalid failureMode %q: must be %q or %q", mode, failureModePassthrough, failureModeError)
+	}
+	if status != 0 && (status < 400 || status > 599) {
+		return fmt.Errorf("invalid failureStatus %d: must be between 400 and 599", status)
+	}
+	return nil
+}
+
+// logFailure logs and counts the failure of a stage of the rewrite of the response by response, followed by
+// what is sent instead.
+func (rw *responseWriter) logFailure(response *parsedResponse, stage failureStage, err error, sending string) {
+	m := rw.middleware
+	m.metrics.countFailure(stage)
+	m.logfUpTo(response.logLevel, levelWarn, logFields{request: rw.request, status: rw.code, response: response},
+		"%s: %s stage of the rewrite of %s by response %s failed, %s: %v", m.name, stage, rw.request.URL, response.id, sending, err)
+}
+
+// handleFailure logs and counts the failure of a stage of the rewrite of the response, and reports whether
+// the failure response must be sent with sendFailureResponse, rather than the original body.
+func (rw *responseWriter) handleFailure(stage failureStage, err error) bool {
+	if rw.middleware.failureMode != failureModeError {
+		rw.logFailure(rw.response, stage, err, "sending the original body")
+		return false
+	}
+	rw.logFailure(rw.response, stage, err, fmt.Sprintf("sending status %d", rw.middleware.failureStatus))
+	return true
+}
+
+// sendFailureResponse sends the failureStatus and failureBody in place of the response whose rewrite failed,
+// the rest of the body written by the upstream being discarded. If the headers have already been sent, a
+// valid response can't be sent anymore and the connection is aborted.
+func (rw *responseWriter) sendFailureResponse() {
+	if rw.headersSent {
+		panic(http.ErrAbortHandler)
+	}
+	rw.failed = true
+	rw.replaceResponse(rw.middleware.failureStatus, rw.middleware.failureBody)
+}
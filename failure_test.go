@@ -0,0 +1,229 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateFailureMode(t *testing.T) {
+	tests := []struct {
+		desc   string
+		mode   string
+		status int
+		expErr string
+	}{
+		{desc: "default"},
+		{desc: "passthrough", mode: failureModePassthrough},
+		{desc: "error with a status", mode: failureModeError, status: http.StatusServiceUnavailable},
+		{desc: "unknown mode", mode: "drop", expErr: `invalid failureMode "drop": must be "passthrough" or "error"`},
+		{desc: "success status", mode: failureModeError, status: http.StatusOK, expErr: "invalid failureStatus 200: must be between 400 and 599"},
+		{desc: "out of range status", mode: failureModeError, status: 600, expErr: "invalid failureStatus 600: must be between 400 and 599"},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			err := validateFailureMode(test.mode, test.status)
+			if test.expErr == "" && err != nil {
+				t.Errorf("got error %v, want none", err)
+			}
+			if test.expErr != "" && (err == nil || err.Error() != test.expErr) {
+				t.Errorf("got error %v, want %q", err, test.expErr)
+			}
+		})
+	}
+}
+
+func TestFailureStage_String(t *testing.T) {
+	names := map[string]failureStage{}
+	for stage := failureStage(0); stage < numFailureStages; stage++ {
+		name := stage.String()
+		if name == "unknown" {
+			t.Errorf("stage %d has no name", stage)
+		}
+		if other, ok := names[name]; ok {
+			t.Errorf("stages %d and %d are both named %q", other, stage, name)
+		}
+		names[name] = stage
+	}
+	if name := numFailureStages.String(); name != "unknown" {
+		t.Errorf("got name %q for an unknown stage, want %q", name, "unknown")
+	}
+}
+
+func TestServeHTTP_failureMode(t *testing.T) {
+	tests := []struct {
+		desc              string
+		config            Config
+		rewriteFirstBytes int
+		expStatus         int
+		expBody           string
+		expLog            string
+	}{
+		{
+			desc:      "passthrough",
+			expStatus: http.StatusOK,
+			expBody:   "foo is the new bar",
+			expLog:    "rewrite stage of the rewrite of / by response 0 failed, sending the original body: rule 1 exceeded maxOutputBytes of 20",
+		},
+		{
+			desc:      "error",
+			config:    Config{FailureMode: failureModeError},
+			expStatus: http.StatusBadGateway,
+			expBody:   "Bad Gateway",
+			expLog:    "rewrite stage of the rewrite of / by response 0 failed, sending status 502: rule 1 exceeded maxOutputBytes of 20",
+		},
+		{
+			desc:      "error with a status and a body",
+			config:    Config{FailureMode: failureModeError, FailureStatus: http.StatusServiceUnavailable, FailureBody: "try again later"},
+			expStatus: http.StatusServiceUnavailable,
+			expBody:   "try again later",
+			expLog:    "sending status 503",
+		},
+		{
+			desc:              "error in the head of the body",
+			config:            Config{FailureMode: failureModeError},
+			rewriteFirstBytes: 5,
+			expStatus:         http.StatusBadGateway,
+			expBody:           "Bad Gateway",
+			expLog:            "sending status 502",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := test.config
+			config.MetricsInterval = "1h"
+			config.Responses = []Response{{
+				Status:            "200",
+				MaxOutputBytes:    20,
+				RewriteFirstBytes: test.rewriteFirstBytes,
+				Rewrites:          []Rewrite{{Regex: "foo", Replacement: "bar"}, {Regex: "bar", Replacement: "barbar"}},
+			}}
+			var logs bytes.Buffer
+			handler, err := NewMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", "text/html")
+				_, _ = rw.Write([]byte("foo is the new bar"))
+			}), WithConfig(&config), WithName("rewriteBody"), WithLogger(log.New(&logs, "", 0)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if body := recorder.Body.String(); recorder.Code != test.expStatus || body != test.expBody {
+				t.Errorf("got status %d and body %q, want %d and %q", recorder.Code, body, test.expStatus, test.expBody)
+			}
+			if test.expStatus != http.StatusOK {
+				if contentType := recorder.Header().Get("Content-Type"); contentType != "text/plain; charset=utf-8" {
+					t.Errorf("got Content-Type %q, want the one of the failure body", contentType)
+				}
+			}
+			if !strings.Contains(logs.String(), test.expLog) {
+				t.Errorf("got logs %q, want them to contain %q", logs.String(), test.expLog)
+			}
+			if failures := handler.(*responsebodyrewrite).metrics.failures[stageRewrite]; failures != 1 {
+				t.Errorf("got %d failures of the rewrite stage, want 1", failures)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_failureModeJSON(t *testing.T) {
+	tests := []struct {
+		desc     string
+		mode     string
+		expBody  string
+		expAbort bool
+	}{
+		{desc: "passthrough", expBody: `{"url": "bar", "x": foo, "url": "foo"}`},
+		{desc: "error", mode: failureModeError, expBody: `{"url": "bar", "x"`, expAbort: true},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var logs bytes.Buffer
+			handler, err := NewMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				_, _ = rw.Write([]byte(`{"url": "foo", "x": foo, "url": "foo"}`))
+			}), WithConfig(&Config{
+				FailureMode: test.mode,
+				Responses:   []Response{{Status: "200", JSONPaths: []string{"url"}, Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}}},
+			}), WithName("rewriteBody"), WithLogger(log.New(&logs, "", 0)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			aborted := func() (aborted bool) {
+				// The headers are sent before the body is decoded, the connection can only be aborted.
+				defer func() {
+					aborted = recover() == http.ErrAbortHandler
+				}()
+				handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+				return false
+			}()
+
+			if aborted != test.expAbort {
+				t.Errorf("got aborted %t, want %t", aborted, test.expAbort)
+			}
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+			if expLog := "json stage of the rewrite of / by response 0 failed"; !strings.Contains(logs.String(), expLog) {
+				t.Errorf("got logs %q, want them to contain %q", logs.String(), expLog)
+			}
+		})
+	}
+
+	_, err := New(context.Background(), http.NotFoundHandler(), &Config{FailureMode: failureModeError, FailureStatus: 302}, "rewriteBody")
+	if expErr := "invalid failureStatus 302: must be between 400 and 599"; err == nil || err.Error() != expErr {
+		t.Errorf("got error %v, want %q", err, expErr)
+	}
+}
+
+// testModeFailure checks that a response block failing to parse body in the given stage applies the
+// failureMode, and counts the failure of the stage.
+func testModeFailure(t *testing.T, response Response, contentType, body string, stage failureStage) {
+	t.Helper()
+
+	tests := []struct {
+		desc      string
+		mode      string
+		expStatus int
+		expBody   string
+		expLog    string
+	}{
+		{desc: "passthrough", expStatus: http.StatusOK, expBody: body, expLog: "sending the original body"},
+		{desc: "error", mode: failureModeError, expStatus: http.StatusBadGateway, expBody: "Bad Gateway", expLog: "sending status 502"},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var logs bytes.Buffer
+			config := Config{MetricsInterval: "1h", FailureMode: test.mode, Responses: []Response{response}}
+			handler, err := NewMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", contentType)
+				_, _ = rw.Write([]byte(body))
+			}), WithConfig(&config), WithName("rewriteBody"), WithLogger(log.New(&logs, "", 0)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); recorder.Code != test.expStatus || got != test.expBody {
+				t.Errorf("got status %d and body %q, want %d and %q", recorder.Code, got, test.expStatus, test.expBody)
+			}
+			expLog := fmt.Sprintf("%s stage of the rewrite of / by response 0 failed, %s", stage, test.expLog)
+			if !strings.Contains(logs.String(), expLog) {
+				t.Errorf("got logs %q, want them to contain %q", logs.String(), expLog)
+			}
+			if failures := handler.(*responsebodyrewrite).metrics.failures[stage]; failures != 1 {
+				t.Errorf("got %d failures of the %s stage, want 1", failures, stage)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package traefik_responsebodyrewrite
+
+import (
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// atomNamespace is the namespace of the Atom feeds.
+const atomNamespace = "http://www.w3.org/2005/Atom"
+
+// feedContentTypes are the media types of the RSS and Atom feeds, the generic XML ones being told by their
+// root element.
+var feedContentTypes = map[string]bool{
+	"application/rss+xml":  true,
+	"application/atom+xml": true,
+	"application/xml":      true,
+	"text/xml":             true,
+}
+
+// enclosureURLOrigin matches the origin of the URL of an enclosure.
+var enclosureURLOrigin = xmlAttributeURLOrigin("url")
+
+// Feed rewrites the origin of the links of the RSS 2.0 and Atom feeds.
+type Feed struct {
+	// Origin is the scheme and the host the links are rewritten to, e.g. "https://www.example.com". If empty,
+	// it is the public origin of the request, from its X-Forwarded-Proto and X-Forwarded-Host headers, or
+	// else from its own scheme and Host.
+	Origin string `json:"origin,omitempty"`
+}
+
+// parsedFeed is a parsed Feed.
+type parsedFeed struct {
+	// origin is the configured origin, empty if it is derived from the request.
+	origin string
+}
+
+// parseFeed parses the feed option of a response block, nil if it has none.
+func parseFeed(config *Feed) (*parsedFeed, error) {
+	if config == nil {
+		return nil, nil
+	}
+	origin, err := parseOrigin(config.Origin)
+	if err != nil {
+		return nil, err
+	}
+	return &parsedFeed{origin: origin}, nil
+}
+
+// isFeedResponse reports whether the given Content-Type is the one of a feed.
+func isFeedResponse(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && feedContentTypes[mediaType]
+}
+
+// publicOrigin returns the origin the links of the feed of req are rewritten to.
+func (f *parsedFeed) publicOrigin(req *http.Request) string {
+	if f.origin != "" {
+		return f.origin
+	}
+	return requestOrigin(req)
+}
+
+// isFeedLink reports whether the element at the end of the path holds a link of a feed: the link of the
+// channel and the link, guid and enclosure of its items in an RSS 2.0 feed, and the Atom links, in an Atom
+// feed or in an RSS one. Any other document has none.
+func isFeedLink(path []xml.Name) bool {
+	n := len(path)
+	switch {
+	case n >= 2 && path[n-1] == xml.Name{Space: atomNamespace, Local: "link"}:
+		return path[0] == xml.Name{Local: "rss"} || path[0] == xml.Name{Space: atomNamespace, Local: "feed"}
+	case path[0] != xml.Name{Local: "rss"} || n < 3 || path[1] != xml.Name{Local: "channel"}:
+		return false
+	case n == 3:
+		return path[2] == xml.Name{Local: "link"}
+	case n == 4 && path[2] == xml.Name{Local: "item"}:
+		return path[3] == xml.Name{Local: "link"} || path[3] == xml.Name{Local: "guid"} || path[3] == xml.Name{Local: "enclosure"}
+	}
+	return false
+}
+
+// feedURLOrigins returns the regex matching the origin of the URL of a feed element: that of the url of an
+// enclosure, of the href of an Atom link, or of the content of the others. A guid which is not a permalink is
+// not an URL to rewrite: feed readers tell the items apart by their guid.
+func feedURLOrigins(element xmlElement) *regexp.Regexp {
+	switch element.tag.Name.Local {
+	case "enclosure":
+		return enclosureURLOrigin
+	case "guid":
+		for _, attr := range element.tag.Attr {
+			if attr.Name.Local == "isPermaLink" && strings.TrimSpace(attr.Value) == "false" {
+				return nil
+			}
+		}
+	}
+	if element.tag.Name.Space == atomNamespace {
+		return hrefURLOrigin
+	}
+	return xmlURLOrigin
+}
+
+// String describes the feed in the debug dump.
+func (f *parsedFeed) String() string {
+	return "links rewritten to " + describeOrigin(f.origin)
+}
+
+func (f *parsedFeed) option() string { return "feed" }
+
+// changesBody implements the transformer interface.
+func (f *parsedFeed) changesBody() bool { return true }
+
+// matchesContentType implements the contentTypeMatcher interface.
+func (f *parsedFeed) matchesContentType(contentType string) bool {
+	return isFeedResponse(contentType)
+}
+
+// cacheKey implements the cacheKeyer interface: the rewritten URLs depend on the public origin of req.
+func (f *parsedFeed) cacheKey(req *http.Request) string {
+	return f.publicOrigin(req)
+}
+
+// transform implements the bodyTransformer interface, as rewriteBody for a feed: once the rewrites are
+// applied, the scheme and the host of the absolute URLs of its links are replaced with the public origin, the
+// rest of the body being left byte for byte. A body which can't be parsed fails the feed stage.
+func (f *parsedFeed) transform(rw *responseWriter, body []byte) ([]byte, bool, bool, []int, error) {
+	r, response, req := rw.middleware, rw.response, rw.request
+	rewritten, modified, complete, replaced := body, false, true, []int(nil)
+	if len(response.rewrites) > 0 {
+		var err error
+		if rewritten, modified, complete, replaced, err = r.rewriteBody(response, body, req); err != nil {
+			return body, false, true, nil, err
+		}
+	}
+
+	elements, err := xmlElements(rewritten, isFeedLink)
+	if err != nil {
+		return body, false, true, nil, &stageError{stage: stageFeed, err: fmt.Errorf("unable to parse the feed: %w", err)}
+	}
+
+	result, changed := rewriteURLOrigins(rewritten, elements, f.publicOrigin(req), feedURLOrigins)
+	return result, modified || changed, complete, replaced, nil
+}
@@ -0,0 +1,136 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeHTTP_feed(t *testing.T) {
+	const rss = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
+  <channel>
+    <title>News of cms.internal.local</title>
+    <link>http://cms.internal.local/news</link>
+    <atom:link href="http://cms.internal.local/feed.xml" rel="self" type="application/rss+xml"/>
+    <item>
+      <link>http://cms.internal.local/news/1</link>
+      <guid>http://cms.internal.local/news/1</guid>
+      <enclosure url="http://cms.internal.local/media/1.mp3" length="1024" type="audio/mpeg"/>
+    </item>
+    <item>
+      <link>http://cms.internal.local/news/2</link>
+      <guid isPermaLink="false">http://cms.internal.local/news/2</guid>
+    </item>
+  </channel>
+</rss>`
+	const atom = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <id>http://cms.internal.local/</id>
+  <link href="http://cms.internal.local/"/>
+  <entry>
+    <id>http://cms.internal.local/news/1</id>
+    <link rel="alternate" href="http://cms.internal.local/news/1"/>
+    <link rel="enclosure" href='http://cms.internal.local/media/1.mp3'/>
+  </entry>
+</feed>`
+
+	tests := []struct {
+		desc        string
+		config      Feed
+		header      http.Header
+		contentType string
+		body        string
+		expBody     string
+	}{
+		{
+			desc:        "RSS",
+			config:      Feed{Origin: "https://www.example.com"},
+			contentType: "application/rss+xml",
+			body:        rss,
+			expBody: strings.NewReplacer(
+				"<link>http://cms.internal.local", "<link>https://www.example.com",
+				`href="http://cms.internal.local`, `href="https://www.example.com`,
+				"<guid>http://cms.internal.local", "<guid>https://www.example.com",
+				`url="http://cms.internal.local`, `url="https://www.example.com`,
+			).Replace(rss),
+		},
+		{
+			desc:        "Atom",
+			header:      http.Header{"X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"www.example.com"}},
+			contentType: "application/atom+xml",
+			body:        atom,
+			expBody: strings.NewReplacer(
+				`href="http://cms.internal.local`, `href="https://www.example.com`,
+				`href='http://cms.internal.local`, `href='https://www.example.com`,
+			).Replace(atom),
+		},
+		{
+			desc:        "generic XML content type",
+			config:      Feed{Origin: "https://www.example.com"},
+			contentType: "text/xml",
+			body:        `<rss><channel><link>http://cms.internal.local/</link></channel></rss>`,
+			expBody:     `<rss><channel><link>https://www.example.com/</link></channel></rss>`,
+		},
+		{
+			desc:        "other root element",
+			config:      Feed{Origin: "https://www.example.com"},
+			contentType: "application/xml",
+			body:        `<page><channel><link>http://cms.internal.local/</link></channel></page>`,
+			expBody:     `<page><channel><link>http://cms.internal.local/</link></channel></page>`,
+		},
+		{
+			desc:        "malformed body",
+			config:      Feed{Origin: "https://www.example.com"},
+			contentType: "application/rss+xml",
+			body:        `<rss><channel><link>http://cms.internal.local/</channel>`,
+			expBody:     `<rss><channel><link>http://cms.internal.local/</channel>`,
+		},
+		{
+			desc:        "other content type",
+			config:      Feed{Origin: "https://www.example.com"},
+			contentType: "text/html",
+			body:        `<rss><channel><link>http://cms.internal.local/</link></channel></rss>`,
+			expBody:     `<rss><channel><link>http://cms.internal.local/</link></channel></rss>`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := test.config
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", test.contentType)
+				_, _ = rw.Write([]byte(test.body))
+			}), &Config{Responses: []Response{{Status: "200", Feed: &config}}}, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/feed.xml", nil)
+			for name, values := range test.header {
+				req.Header[name] = values
+			}
+			handler.ServeHTTP(recorder, req)
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+
+	_, err := New(context.Background(), http.NotFoundHandler(), &Config{Responses: []Response{{
+		Status: "200",
+		Feed:   &Feed{Origin: "www.example.com"},
+	}}}, "rewriteBody")
+	expErr := `responses[0]: feed: invalid origin "www.example.com": must be a scheme and a host, e.g. https://www.example.com`
+	if err == nil || err.Error() != expErr {
+		t.Errorf("got error %v, want %q", err, expErr)
+	}
+}
+
+func TestServeHTTP_feedFailure(t *testing.T) {
+	testModeFailure(t, Response{Status: "200", Feed: &Feed{Origin: "https://www.example.com"}},
+		"application/rss+xml", "<rss><channel><link>http://cms.internal.local/</channel>", stageFeed)
+}
@@ -0,0 +1,88 @@
+package traefik_responsebodyrewrite
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Values of the rewritesPosition option.
+const (
+	rewritesBefore = "before"
+	rewritesAfter  = "after"
+)
+
+// globalResponseID identifies the response applying the global rewrites to the responses no response block
+// matches, with the always option.
+const globalResponseID = "global"
+
+// globalRewrites are the rewrites applied to the responses of all the response blocks.
+type globalRewrites struct {
+	rewrites []parsedRewrite
+	// after is set when they are applied after the rewrites of the response blocks.
+	after bool
+}
+
+// parseGlobalRewrites parses the global rewrites of the configuration, within the limits of a response block.
+func parseGlobalRewrites(config *Config, limits ruleLimits) (globalRewrites, error) {
+	if err := limits.checkRewrites(config.Rewrites); err != nil {
+		return globalRewrites{}, err
+	}
+	rewrites, err := compileRewrites(config.Rewrites, config.AllowEmptyReplacement)
+	if err != nil {
+		return globalRewrites{}, err
+	}
+
+	switch config.RewritesPosition {
+	case "", rewritesBefore, rewritesAfter:
+	default:
+		return globalRewrites{}, fmt.Errorf("invalid rewritesPosition %q: must be %q or %q", config.RewritesPosition, rewritesBefore, rewritesAfter)
+	}
+	if config.Always && len(rewrites) == 0 {
+		return globalRewrites{}, errors.New("always: rewrites must not be empty")
+	}
+
+	return globalRewrites{rewrites: rewrites, after: config.RewritesPosition == rewritesAfter}, nil
+}
+
+// around returns the rewrites of a response block preceded or followed by the global rewrites.
+func (g globalRewrites) around(rewrites []parsedRewrite) []parsedRewrite {
+	if len(g.rewrites) == 0 {
+		return rewrites
+	}
+	all := make([]parsedRewrite, 0, len(g.rewrites)+len(rewrites))
+	if g.after {
+		return append(append(all, rewrites...), g.rewrites...)
+	}
+	return append(append(all, g.rewrites...), rewrites...)
+}
+
+// aroundWindows returns the stream windows of the rewrites returned by around, given those of the rewrites
+// of the response block and of the global rewrites.
+func (g globalRewrites) aroundWindows(windows, globalWindows []int) []int {
+	all := make([]int, 0, len(globalWindows)+len(windows))
+	if g.after {
+		return append(append(all, windows...), globalWindows...)
+	}
+	return append(append(all, globalWindows...), windows...)
+}
+
+// newGlobalResponse returns the response applying the global rewrites alone, to the responses of any status
+// code, used after the response blocks with the always option. Its messages are logged up to level, the
+// logLevel of the middleware.
+func newGlobalResponse(index int, global globalRewrites, dryRun bool, level logLevel) parsedResponse {
+	// The response switches to the streaming mode on flushes only if all the rewrites allow it.
+	windows, err := streamWindows(global.rewrites, 0)
+	if err != nil {
+		windows = nil
+	}
+	return parsedResponse{
+		index:    index,
+		id:       globalResponseID,
+		rewrites: global.rewrites,
+		passes:   optimizePasses(global.rewrites),
+		status:   HTTPCodeRanges{{100, 599}},
+		windows:  windows,
+		dryRun:   dryRun,
+		logLevel: level,
+	}
+}
@@ -0,0 +1,161 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeHTTP_globalRewrites(t *testing.T) {
+	tests := []struct {
+		desc      string
+		position  string
+		always    bool
+		status    int
+		expBody   string
+		expHeader string
+	}{
+		{
+			desc:      "before",
+			status:    http.StatusOK,
+			expBody:   "baz baz",
+			expHeader: "0 r0:2,r1:2",
+		},
+		{
+			desc:      "explicitly before",
+			position:  "before",
+			status:    http.StatusOK,
+			expBody:   "baz baz",
+			expHeader: "0 r0:2,r1:2",
+		},
+		{
+			desc:      "after",
+			position:  "after",
+			status:    http.StatusOK,
+			expBody:   "bar bar",
+			expHeader: "0 r0:0,r1:2",
+		},
+		{
+			desc:    "no matching response",
+			status:  http.StatusNotFound,
+			expBody: "foo foo",
+		},
+		{
+			desc:      "always",
+			always:    true,
+			status:    http.StatusNotFound,
+			expBody:   "bar bar",
+			expHeader: "global r0:2",
+		},
+		{
+			desc:      "always with a matching response",
+			always:    true,
+			status:    http.StatusOK,
+			expBody:   "baz baz",
+			expHeader: "0 r0:2,r1:2",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				Rewrites:         []Rewrite{{Regex: "foo", Replacement: "bar"}},
+				RewritesPosition: test.position,
+				Always:           test.always,
+				DebugHeader:      "X-Rewritten",
+				Responses: []Response{
+					{Status: "200", Rewrites: []Rewrite{{Regex: "bar", Replacement: "baz"}}},
+				},
+			}
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(test.status)
+				_, _ = rw.Write([]byte("foo foo"))
+			}
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+			if header := recorder.Header().Get("X-Rewritten"); header != test.expHeader {
+				t.Errorf("got header %q, want %q", header, test.expHeader)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_globalRewritesOnly(t *testing.T) {
+	config := &Config{
+		Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+		// A response block without rewrites applies the global ones.
+		Responses: []Response{{Status: "200", Stream: true}},
+	}
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("foo "))
+		_, _ = rw.Write([]byte("foo"))
+	}
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if body := recorder.Body.String(); body != "bar bar" {
+		t.Errorf("got body %q, want %q", body, "bar bar")
+	}
+}
+
+func TestNew_globalRewritesErrors(t *testing.T) {
+	tests := []struct {
+		desc   string
+		config *Config
+		expErr string
+	}{
+		{
+			desc:   "invalid regex",
+			config: &Config{Rewrites: []Rewrite{{Regex: "foo"}, {Regex: "ba(r"}}},
+			expErr: `rewrites[1].regex: error compiling regex "ba(r" with the regexp engine: `,
+		},
+		{
+			desc:   "invalid position",
+			config: &Config{Rewrites: []Rewrite{{Regex: "foo"}}, RewritesPosition: "first"},
+			expErr: `invalid rewritesPosition "first": must be "before" or "after"`,
+		},
+		{
+			desc:   "always without rewrites",
+			config: &Config{Always: true},
+			expErr: "always: rewrites must not be empty",
+		},
+		{
+			desc: "unbounded regex in streaming mode",
+			config: &Config{
+				Rewrites:  []Rewrite{{Regex: "fo+"}},
+				Responses: []Response{{Status: "200", Stream: true, Rewrites: []Rewrite{{Regex: "foo"}}}},
+			},
+			expErr: `responses[0]: global rewrites[0].regex: "fo+" can't be used in streaming mode: `,
+		},
+		{
+			desc: "reserved name",
+			config: &Config{
+				Responses: []Response{{Name: "global", Status: "200", Rewrites: []Rewrite{{Regex: "foo"}}}},
+			},
+			expErr: `responses[0] "global": invalid name "global": reserved for the global rewrites`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := New(context.Background(), nil, test.config, "rewriteBody")
+			if err == nil || !strings.HasPrefix(err.Error(), test.expErr) {
+				t.Errorf("got error %v, want %q", err, test.expErr)
+			}
+		})
+	}
+}
@@ -3,3 +3,5 @@ module github.com/quortex/traefik-responsebodyrewrite
 go 1.22
 
 toolchain go1.22.3
+
+require github.com/dlclark/regexp2 v1.11.5
@@ -0,0 +1,117 @@
+package traefik_responsebodyrewrite
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"regexp"
+	"strings"
+)
+
+// graphQLContentTypes are the media types of the GraphQL responses.
+var graphQLContentTypes = map[string]bool{
+	"application/json":                  true,
+	"application/graphql-response+json": true,
+}
+
+// GraphQL restricts a response block to the GraphQL responses reporting errors, which are sent with a 200
+// status code like the successful ones.
+type GraphQL struct {
+	// Message is a regex which must match the message of one of the errors, any error matching if empty.
+	Message string `json:"message,omitempty"`
+	// Code is the value the extensions.code of one of the errors must have, any error matching if empty.
+	Code string `json:"code,omitempty"`
+}
+
+// parsedGraphQL is a parsed GraphQL.
+type parsedGraphQL struct {
+	message *regexp.Regexp
+	code    string
+}
+
+// graphQLError is an error of a GraphQL response, as far as it is matched.
+type graphQLError struct {
+	Message    string `json:"message"`
+	Extensions struct {
+		Code interface{} `json:"code"`
+	} `json:"extensions"`
+}
+
+// parseGraphQL parses the GraphQL condition of a response block, nil if it has none.
+func parseGraphQL(graphQL *GraphQL) (*parsedGraphQL, error) {
+	if graphQL == nil {
+		return nil, nil
+	}
+	parsed := &parsedGraphQL{code: graphQL.Code}
+	if graphQL.Message != "" {
+		message, err := regexp.Compile(graphQL.Message)
+		if err != nil {
+			return nil, fmt.Errorf("invalid message %q: %w", graphQL.Message, err)
+		}
+		parsed.message = message
+	}
+	return parsed, nil
+}
+
+// isGraphQLResponse reports whether the given Content-Type is the one of a GraphQL response.
+func isGraphQLResponse(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && graphQLContentTypes[mediaType]
+}
+
+// selects reports whether body is a GraphQL response whose top-level errors array has an error matching the
+// condition. A body which isn't a JSON object is not selected.
+func (g *parsedGraphQL) selects(body []byte) bool {
+	var response struct {
+		Errors []graphQLError `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return false
+	}
+	for _, graphQLError := range response.Errors {
+		if g.message != nil && !g.message.MatchString(graphQLError.Message) {
+			continue
+		}
+		if g.code != "" && fmt.Sprint(graphQLError.Extensions.Code) != g.code {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// String describes the condition in the debug dump.
+func (g *parsedGraphQL) String() string {
+	var conditions []string
+	if g.message != nil {
+		conditions = append(conditions, fmt.Sprintf("message matching %s", g.message))
+	}
+	if g.code != "" {
+		conditions = append(conditions, fmt.Sprintf("code %s", g.code))
+	}
+	if len(conditions) == 0 {
+		return "errors"
+	}
+	return "errors with " + strings.Join(conditions, " and ")
+}
+
+func (g *parsedGraphQL) option() string { return "graphql" }
+
+// changesBody implements the transformer interface: the GraphQL condition only selects the bodies.
+func (g *parsedGraphQL) changesBody() bool { return false }
+
+// matchesContentType implements the contentTypeMatcher interface.
+func (g *parsedGraphQL) matchesContentType(contentType string) bool {
+	return isGraphQLResponse(contentType)
+}
+
+// transform implements the bodyTransformer interface: the bodies without matching errors are passed through.
+func (g *parsedGraphQL) transform(rw *responseWriter, body []byte) ([]byte, bool, bool, []int, error) {
+	r, response, req := rw.middleware, rw.response, rw.request
+	if !g.selects(body) {
+		rw.debugf("%s: response %s matches %s, but it has no matching GraphQL errors, passing it through",
+			r.name, response.id, req.URL)
+		return body, false, true, nil, nil
+	}
+	return r.rewriteBody(response, body, req)
+}
@@ -0,0 +1,141 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeHTTP_graphQL(t *testing.T) {
+	const body = `{"data":null,"errors":[{"message":"connection to db.internal.local refused","extensions":{"code":"INTERNAL_SERVER_ERROR"}}]}`
+	const rewritten = `{"data":null,"errors":[{"message":"internal error","extensions":{"code":"INTERNAL_SERVER_ERROR"}}]}`
+
+	tests := []struct {
+		desc        string
+		graphQL     GraphQL
+		contentType string
+		body        string
+		expBody     string
+		expErr      string
+	}{
+		{
+			desc:        "errors",
+			contentType: "application/json",
+			body:        body,
+			expBody:     rewritten,
+		},
+		{
+			desc:        "GraphQL response content type",
+			contentType: "application/graphql-response+json; charset=utf-8",
+			body:        body,
+			expBody:     rewritten,
+		},
+		{
+			desc:        "no errors",
+			contentType: "application/json",
+			body:        `{"data":{"message":"connection to db.internal.local refused"}}`,
+			expBody:     `{"data":{"message":"connection to db.internal.local refused"}}`,
+		},
+		{
+			desc:        "empty errors",
+			contentType: "application/json",
+			body:        `{"data":{"message":"connection to db.internal.local refused"},"errors":[]}`,
+			expBody:     `{"data":{"message":"connection to db.internal.local refused"},"errors":[]}`,
+		},
+		{
+			desc:        "matching message",
+			graphQL:     GraphQL{Message: `\.internal\.local\b`},
+			contentType: "application/json",
+			body:        body,
+			expBody:     rewritten,
+		},
+		{
+			desc:        "other message",
+			graphQL:     GraphQL{Message: "^timeout"},
+			contentType: "application/json",
+			body:        body,
+			expBody:     body,
+		},
+		{
+			desc:        "matching code",
+			graphQL:     GraphQL{Code: "INTERNAL_SERVER_ERROR"},
+			contentType: "application/json",
+			body:        body,
+			expBody:     rewritten,
+		},
+		{
+			desc:        "other code",
+			graphQL:     GraphQL{Message: "refused", Code: "BAD_USER_INPUT"},
+			contentType: "application/json",
+			body:        body,
+			expBody:     body,
+		},
+		{
+			desc:        "other content type",
+			contentType: "text/plain",
+			body:        body,
+			expBody:     body,
+		},
+		{
+			desc:        "not JSON",
+			contentType: "application/json",
+			body:        "connection to db.internal.local refused",
+			expBody:     "connection to db.internal.local refused",
+		},
+		{
+			desc:    "invalid message",
+			graphQL: GraphQL{Message: "("},
+			expErr:  "responses[0]: graphql: invalid message \"(\": error parsing regexp: missing closing ): `(`",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			graphQL := test.graphQL
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", test.contentType)
+				_, _ = rw.Write([]byte(test.body))
+			}), &Config{Responses: []Response{{
+				Status:   "200",
+				GraphQL:  &graphQL,
+				Rewrites: []Rewrite{{Regex: `"message":"[^"]*"`, Replacement: `"message":"internal error"`}},
+			}}}, "rewriteBody")
+			if test.expErr != "" {
+				if err == nil || err.Error() != test.expErr {
+					t.Errorf("got error %v, want %q", err, test.expErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_graphQLNextResponse(t *testing.T) {
+	handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "text/html")
+		_, _ = rw.Write([]byte("internal.local"))
+	}), &Config{Responses: []Response{
+		{Status: "200", GraphQL: &GraphQL{}, Rewrites: []Rewrite{{Regex: "internal.local", Replacement: "graphql"}}},
+		{Status: "200", Rewrites: []Rewrite{{Regex: "internal.local", Replacement: "example.com"}}},
+	}}, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if body := recorder.Body.String(); body != "example.com" {
+		t.Errorf("got body %q, want the rewrite of the next response block", body)
+	}
+}
@@ -0,0 +1,236 @@
+package traefik_responsebodyrewrite
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// prepareRewriteHeaders adjusts the response headers of a response whose body is going to be rewritten.
+// All header bookkeeping related to body framing and encoding must go through the responseWriter helpers
+// declared in this file, so that they stay consistent with each other.
+func (rw *responseWriter) prepareRewriteHeaders() {
+	// The body size is going to change, the upstream Content-Length can't be trusted anymore.
+	rw.ResponseWriter.Header().Del("Content-Length")
+	// Byte ranges of the upstream body don't match the rewritten body.
+	rw.ResponseWriter.Header().Del("Accept-Ranges")
+}
+
+// withoutRange returns req without its Range and If-Range headers, leaving req untouched.
+func withoutRange(req *http.Request) *http.Request {
+	if req.Header.Get("Range") == "" && req.Header.Get("If-Range") == "" {
+		return req
+	}
+
+	stripped := new(http.Request)
+	*stripped = *req
+	stripped.Header = req.Header.Clone()
+	stripped.Header.Del("Range")
+	stripped.Header.Del("If-Range")
+	return stripped
+}
+
+// Header implements the http.ResponseWriter interface.
+// With freezeHeaders, the upstream gets a detached copy of the header map once it has written the status code
+// of a buffered body, so that its later changes are ignored as they would be by net/http.
+func (rw *responseWriter) Header() http.Header {
+	if rw.frozenHeader != nil {
+		return rw.frozenHeader
+	}
+	if rw.middleware.freezeHeaders && rw.wroteHeader && !rw.headersSent {
+		rw.frozenHeader = rw.ResponseWriter.Header().Clone()
+		return rw.frozenHeader
+	}
+	return rw.ResponseWriter.Header()
+}
+
+// copyFrozenTrailers copies the trailers set by the upstream in the detached header map of freezeHeaders to
+// the response, trailers being the only header changes allowed once the status code has been written.
+func (rw *responseWriter) copyFrozenTrailers() {
+	if rw.frozenHeader == nil || rw.hijacked {
+		return
+	}
+
+	header := rw.ResponseWriter.Header()
+	declared := map[string]bool{}
+	for _, name := range declaredTrailers(header) {
+		declared[name] = true
+	}
+	for name, values := range rw.frozenHeader {
+		if strings.HasPrefix(name, http.TrailerPrefix) || declared[name] {
+			header[name] = values
+		}
+	}
+}
+
+// isEncoded reports whether a body with the given Content-Encoding is encoded, e.g. compressed, in which case
+// its bytes can't be matched by the rewrites.
+func isEncoded(encoding string) bool {
+	return encoding != "" && !strings.EqualFold(encoding, "identity")
+}
+
+// skipEncodedBody reports whether the body must not be rewritten because it is encoded and skipEncodedBodies
+// is set, restoring the upstream Content-Length for the body to be sent as is. It is checked when the status
+// code is written, and again before the body is rewritten, as the upstream may set the encoding once it has
+// started to write the body.
+func (rw *responseWriter) skipEncodedBody() bool {
+	encoding := rw.ResponseWriter.Header().Get("Content-Encoding")
+	// With skipEncodedBodies, whether the body is rewritten depends on the encoding the upstream negotiated with
+	// the client.
+	if !rw.headersSent {
+		rw.setContentEncoding(encoding, rw.middleware.skipEncodedBodies)
+	}
+	if !rw.middleware.skipEncodedBodies || !isEncoded(encoding) {
+		return false
+	}
+	rw.infof("response body of %s is encoded with %s, skipping rewrite", rw.request.URL, encoding)
+	rw.middleware.metrics.countEncoded()
+	rw.restoreContentLength()
+	return true
+}
+
+// restoreContentLength puts back the Content-Length announced by the upstream, removed by
+// prepareRewriteHeaders, when the body turns out to be sent unmodified, so that the response isn't needlessly
+// sent with chunked encoding. It must be called before the headers are sent.
+func (rw *responseWriter) restoreContentLength() {
+	// Trailers can only be sent with chunked encoding.
+	if rw.forwardsTrailers() {
+		return
+	}
+	if rw.contentLength > 0 && !rw.headersSent {
+		rw.ResponseWriter.Header().Set("Content-Length", strconv.FormatInt(rw.contentLength, 10))
+	}
+}
+
+// setContentEncoding sets the Content-Encoding of the response sent to the client and keeps the Vary header
+// consistent with it. negotiated must be true when what is sent depends on the encoding negotiated with the
+// client, e.g. from its Accept-Encoding header, in which case Accept-Encoding is added to Vary so that shared
+// caches don't serve the representation to a client that can't decode it. Otherwise, Vary is left untouched.
+// It must be called before the headers are sent.
+func (rw *responseWriter) setContentEncoding(encoding string, negotiated bool) {
+	header := rw.ResponseWriter.Header()
+	if isEncoded(encoding) {
+		header.Set("Content-Encoding", encoding)
+	} else {
+		header.Del("Content-Encoding")
+	}
+
+	if negotiated {
+		addVary(header, "Accept-Encoding")
+	}
+}
+
+// addVary adds the given field name to the Vary header unless it is already listed, either explicitly or
+// through the "*" wildcard.
+func addVary(header http.Header, field string) {
+	for _, value := range header.Values("Vary") {
+		for _, token := range strings.Split(value, ",") {
+			token = strings.TrimSpace(token)
+			if token == "*" || strings.EqualFold(token, field) {
+				return
+			}
+		}
+	}
+
+	header.Add("Vary", field)
+}
+
+// hasNoBody reports whether a response can't have a body to rewrite: responses to HEAD requests,
+// informational, 204 No Content and 304 Not Modified responses, and responses announcing an empty body.
+func hasNoBody(method string, statusCode int, contentLength int64) bool {
+	return method == http.MethodHead || !bodyAllowed(statusCode) || contentLength == 0
+}
+
+// bodyAllowed reports whether a response with the given status code may have a body, as defined by RFC 9110.
+func bodyAllowed(statusCode int) bool {
+	return statusCode >= http.StatusOK && statusCode != http.StatusNoContent && statusCode != http.StatusNotModified
+}
+
+// duplicateWriteHeader handles a status code written again by the upstream. A different code is logged, and
+// with lastStatusWins it replaces the previous one unless the headers have already been sent.
+func (rw *responseWriter) duplicateWriteHeader(statusCode int) {
+	if statusCode == rw.code || isInformational(statusCode) {
+		return
+	}
+
+	m := rw.middleware
+	count := atomic.AddInt64(&m.duplicateWriteHeaders, 1)
+	if !m.lastStatusWins || !rw.buffering() {
+		rw.warnf("%s: status %d written again with status %d for %s, keeping %d (%d duplicate status codes so far)",
+			m.name, rw.code, statusCode, rw.request.URL, rw.code, count)
+		return
+	}
+	rw.warnf("%s: status %d written again with status %d for %s, using %d (%d duplicate status codes so far)",
+		m.name, rw.code, statusCode, rw.request.URL, statusCode, count)
+
+	rw.code = statusCode
+	for i := range rw.responses {
+		if rw.responses[i].matches(statusCode, rw.request) {
+			rw.response = &rw.responses[i]
+			return
+		}
+	}
+
+	// No response block matches the new status code, what has been buffered is sent as is.
+	rw.passthrough = true
+	if err := rw.downgradeToPassthrough(); err != nil {
+		rw.logWriteError(-1, err)
+	}
+}
+
+// validStatusCode reports whether statusCode is in the 100-599 range of the status codes defined by RFC 9110.
+func validStatusCode(statusCode int) bool {
+	return statusCode >= 100 && statusCode <= 599
+}
+
+// isInformational reports whether statusCode is an interim 1xx response, which is followed by the final
+// response of the request.
+func isInformational(statusCode int) bool {
+	return statusCode >= 100 && statusCode < 200 && statusCode != http.StatusSwitchingProtocols
+}
+
+// contentLength returns the value of the Content-Length header, or -1 if it is missing or invalid.
+func contentLength(header http.Header) int64 {
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return -1
+	}
+	return length
+}
+
+// transformationWarning returns the value of the Warning header added to the modified responses with
+// addWarningHeader: the 214 "Transformation Applied" code of RFC 7234, with the name of the middleware as
+// warn-agent. The characters of the name which are not allowed in a token, such as the "@" of the Traefik
+// providers, are replaced with "-".
+func transformationWarning(name string) string {
+	agent := []byte(name)
+	for i, c := range agent {
+		if !isTokenChar(c) {
+			agent[i] = '-'
+		}
+	}
+	if len(agent) == 0 {
+		agent = []byte(defaultMiddlewareName)
+	}
+	return "214 " + string(agent) + ` "Transformation Applied"`
+}
+
+// isTokenChar reports whether c is allowed in an HTTP token.
+func isTokenChar(c byte) bool {
+	return isAlnum(c) || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+// addModificationHeaders adds the headers telling that the body has been modified, if enabled and the headers
+// have not been sent yet: the Warning header, keeping those of the upstream, and the matchedHeader.
+func (rw *responseWriter) addModificationHeaders() {
+	if rw.headersSent {
+		return
+	}
+	if rw.middleware.transformationWarning != "" {
+		rw.ResponseWriter.Header().Add("Warning", rw.middleware.transformationWarning)
+	}
+	if rw.middleware.matchedHeader != "" {
+		rw.ResponseWriter.Header().Set(rw.middleware.matchedHeader, rw.response.id)
+	}
+}
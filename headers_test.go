@@ -0,0 +1,662 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestResponseWriter_prepareRewriteHeaders(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	recorder.Header().Set("Content-Length", "42")
+	recorder.Header().Set("Content-Encoding", "gzip")
+	recorder.Header().Set("Vary", "Accept-Encoding")
+	recorder.Header().Set("Accept-Ranges", "bytes")
+
+	rw := &responseWriter{ResponseWriter: recorder}
+	rw.prepareRewriteHeaders()
+
+	expected := http.Header{
+		"Content-Encoding": {"gzip"},
+		"Vary":             {"Accept-Encoding"},
+	}
+	if !reflect.DeepEqual(recorder.Header(), expected) {
+		t.Errorf("got headers %v, want %v", recorder.Header(), expected)
+	}
+}
+
+func TestResponseWriter_setContentEncoding(t *testing.T) {
+	tests := []struct {
+		desc       string
+		header     http.Header
+		encoding   string
+		negotiated bool
+		expected   http.Header
+	}{
+		{
+			desc:       "should remove the encoding and add Vary when the body depends on the client",
+			header:     http.Header{"Content-Encoding": {"gzip"}},
+			encoding:   "",
+			negotiated: true,
+			expected:   http.Header{"Vary": {"Accept-Encoding"}},
+		},
+		{
+			desc:       "should treat identity as no encoding",
+			header:     http.Header{"Content-Encoding": {"identity"}},
+			encoding:   "identity",
+			negotiated: false,
+			expected:   http.Header{},
+		},
+		{
+			desc:       "should set the encoding and leave Vary untouched when not negotiated",
+			header:     http.Header{"Vary": {"Origin"}},
+			encoding:   "gzip",
+			negotiated: false,
+			expected:   http.Header{"Content-Encoding": {"gzip"}, "Vary": {"Origin"}},
+		},
+		{
+			desc:       "should append Accept-Encoding to an existing Vary",
+			header:     http.Header{"Vary": {"Origin"}},
+			encoding:   "gzip",
+			negotiated: true,
+			expected:   http.Header{"Content-Encoding": {"gzip"}, "Vary": {"Origin", "Accept-Encoding"}},
+		},
+		{
+			desc:       "should not duplicate Accept-Encoding in Vary",
+			header:     http.Header{"Vary": {"Origin, accept-encoding"}},
+			encoding:   "br",
+			negotiated: true,
+			expected:   http.Header{"Content-Encoding": {"br"}, "Vary": {"Origin, accept-encoding"}},
+		},
+		{
+			desc:       "should not add Accept-Encoding when Vary is a wildcard",
+			header:     http.Header{"Vary": {"*"}},
+			encoding:   "",
+			negotiated: true,
+			expected:   http.Header{"Vary": {"*"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			for key, values := range test.header {
+				recorder.Header()[key] = values
+			}
+
+			rw := &responseWriter{ResponseWriter: recorder}
+			rw.setContentEncoding(test.encoding, test.negotiated)
+
+			if !reflect.DeepEqual(recorder.Header(), test.expected) {
+				t.Errorf("got headers %v, want %v", recorder.Header(), test.expected)
+			}
+		})
+	}
+}
+
+func TestAddVary(t *testing.T) {
+	tests := []struct {
+		desc     string
+		header   http.Header
+		field    string
+		expected http.Header
+	}{
+		{
+			desc:     "should add the field to a missing Vary",
+			header:   http.Header{},
+			field:    "Accept-Language",
+			expected: http.Header{"Vary": {"Accept-Language"}},
+		},
+		{
+			desc:     "should append the field to an existing Vary",
+			header:   http.Header{"Vary": {"Origin"}},
+			field:    "Accept-Language",
+			expected: http.Header{"Vary": {"Origin", "Accept-Language"}},
+		},
+		{
+			desc:     "should not duplicate the field in Vary",
+			header:   http.Header{"Vary": {"Origin, accept-language"}},
+			field:    "Accept-Language",
+			expected: http.Header{"Vary": {"Origin, accept-language"}},
+		},
+		{
+			desc:     "should not add the field when Vary is a wildcard",
+			header:   http.Header{"Vary": {"*"}},
+			field:    "Accept-Language",
+			expected: http.Header{"Vary": {"*"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			addVary(test.header, test.field)
+
+			if !reflect.DeepEqual(test.header, test.expected) {
+				t.Errorf("got headers %v, want %v", test.header, test.expected)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_lateHeaders(t *testing.T) {
+	tests := []struct {
+		desc           string
+		freezeHeaders  bool
+		expContentType string
+	}{
+		{
+			desc:           "should send the headers set after the first write",
+			expContentType: "application/json",
+		},
+		{
+			desc:          "should ignore the headers set after the first write with freezeHeaders",
+			freezeHeaders: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				FreezeHeaders: test.freezeHeaders,
+				Responses: []Response{
+					{
+						Status:   "200",
+						Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+					},
+				},
+			}
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Trailer", "X-Checksum")
+				_, _ = rw.Write([]byte(`{"foo": "is the new bar"}`))
+				rw.Header().Set("Content-Type", "application/json")
+				rw.Header().Set("X-Checksum", "abc")
+			}
+
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rewriteBody.ServeHTTP(recorder, req)
+
+			result := recorder.Result()
+			if header := result.Header.Get("Content-Type"); header != test.expContentType {
+				t.Errorf("got Content-Type %q, want %q", header, test.expContentType)
+			}
+			if trailer := result.Trailer.Get("X-Checksum"); trailer != "abc" {
+				t.Errorf("got X-Checksum trailer %q, want %q", trailer, "abc")
+			}
+			if recorder.Body.String() != `{"bar": "is the new bar"}` {
+				t.Errorf("got body %q, want %q", recorder.Body.String(), `{"bar": "is the new bar"}`)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_disableByteRanges(t *testing.T) {
+	tests := []struct {
+		desc              string
+		disableByteRanges bool
+		alwaysPartial     bool
+		expStatus         int
+		expAcceptRanges   string
+		expBody           string
+	}{
+		{
+			desc:      "should rewrite a partial response by default",
+			expStatus: http.StatusPartialContent,
+			expBody:   "bar",
+		},
+		{
+			desc:              "should request the whole body with disableByteRanges",
+			disableByteRanges: true,
+			expStatus:         http.StatusOK,
+			expBody:           "bar is the new bar",
+		},
+		{
+			desc:              "should pass a partial response through with disableByteRanges",
+			disableByteRanges: true,
+			alwaysPartial:     true,
+			expStatus:         http.StatusPartialContent,
+			expAcceptRanges:   "bytes",
+			expBody:           "foo",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				DisableByteRanges: test.disableByteRanges,
+				Responses: []Response{
+					{
+						Status:   "200-299",
+						Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+					},
+				},
+			}
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Accept-Ranges", "bytes")
+				if req.Header.Get("Range") != "" || test.alwaysPartial {
+					rw.Header().Set("Content-Range", "bytes 0-2/18")
+					rw.WriteHeader(http.StatusPartialContent)
+					_, _ = rw.Write([]byte("foo"))
+					return
+				}
+				_, _ = rw.Write([]byte("foo is the new bar"))
+			}
+
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Range", "bytes=0-2")
+			rewriteBody.ServeHTTP(recorder, req)
+
+			if req.Header.Get("Range") != "bytes=0-2" {
+				t.Error("expected the Range header of the original request to be left untouched")
+			}
+			if recorder.Code != test.expStatus {
+				t.Errorf("got status %d, want %d", recorder.Code, test.expStatus)
+			}
+			if header := recorder.Header().Get("Accept-Ranges"); header != test.expAcceptRanges {
+				t.Errorf("got Accept-Ranges %q, want %q", header, test.expAcceptRanges)
+			}
+			if recorder.Body.String() != test.expBody {
+				t.Errorf("got body %q, want %q", recorder.Body.String(), test.expBody)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_duplicateWriteHeader(t *testing.T) {
+	tests := []struct {
+		desc           string
+		lastStatusWins bool
+		secondStatus   int
+		expStatus      int
+		expBody        string
+	}{
+		{
+			desc:         "should keep the first status code",
+			secondStatus: http.StatusInternalServerError,
+			expStatus:    http.StatusOK,
+			expBody:      "bar is the new bar",
+		},
+		{
+			desc:           "should use the last status code with lastStatusWins",
+			lastStatusWins: true,
+			secondStatus:   http.StatusInternalServerError,
+			expStatus:      http.StatusInternalServerError,
+			expBody:        "baz is the new bar",
+		},
+		{
+			desc:           "should pass the body through when the last status code matches no response",
+			lastStatusWins: true,
+			secondStatus:   http.StatusNotFound,
+			expStatus:      http.StatusNotFound,
+			expBody:        "foo is the new bar",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				LastStatusWins: test.lastStatusWins,
+				Responses: []Response{
+					{
+						Status:   "200",
+						Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+					},
+					{
+						Status:   "500",
+						Rewrites: []Rewrite{{Regex: "foo", Replacement: "baz"}},
+					},
+				},
+			}
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusOK)
+				_, _ = rw.Write([]byte("foo is the "))
+				rw.WriteHeader(http.StatusOK)
+				rw.WriteHeader(test.secondStatus)
+				_, _ = rw.Write([]byte("new bar"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != test.expStatus {
+				t.Errorf("got status %d, want %d", recorder.Code, test.expStatus)
+			}
+			if recorder.Body.String() != test.expBody {
+				t.Errorf("got body %q, want %q", recorder.Body.String(), test.expBody)
+			}
+			if count := handler.(*responsebodyrewrite).duplicateWriteHeaders; count != 1 {
+				t.Errorf("got %d duplicate status codes, want 1", count)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_skipEncodedBodies(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, _ = gz.Write([]byte("foo is the new bar"))
+	_ = gz.Close()
+
+	tests := []struct {
+		desc          string
+		lateEncoding  bool
+		contentLength bool
+		flush         bool
+	}{
+		{
+			desc:          "should skip a body encoded before the status code",
+			contentLength: true,
+		},
+		{
+			desc:         "should skip a body encoded after the first write",
+			lateEncoding: true,
+		},
+		{
+			desc:         "should skip a flushed body encoded after the first write",
+			lateEncoding: true,
+			flush:        true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				SkipEncodedBodies: true,
+				Responses: []Response{
+					{
+						Status:   "200",
+						Rewrites: []Rewrite{{Regex: "[\x00-\x7f]", Replacement: "x"}},
+					},
+				},
+			}
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				if test.contentLength {
+					rw.Header().Set("Content-Length", strconv.Itoa(compressed.Len()))
+				}
+				if !test.lateEncoding {
+					rw.Header().Set("Content-Encoding", "gzip")
+				}
+				// The status code is written implicitly by the first write, before the encoding is set.
+				_, _ = rw.Write(compressed.Bytes()[:1])
+				rw.Header().Set("Content-Encoding", "gzip")
+				if test.flush {
+					rw.(http.Flusher).Flush()
+				}
+				_, _ = rw.Write(compressed.Bytes()[1:])
+			}
+
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rewriteBody.ServeHTTP(recorder, req)
+
+			if !bytes.Equal(recorder.Body.Bytes(), compressed.Bytes()) {
+				t.Errorf("got body %q, want the upstream compressed body %q", recorder.Body.Bytes(), compressed.Bytes())
+			}
+			if header := recorder.Header().Get("Content-Encoding"); header != "gzip" {
+				t.Errorf("got Content-Encoding %q, want %q", header, "gzip")
+			}
+			if _, exists := recorder.Header()["Content-Length"]; exists != test.contentLength {
+				t.Errorf("got Content-Length %t, want %t", exists, test.contentLength)
+			}
+			if vary := recorder.Header().Get("Vary"); vary != "Accept-Encoding" {
+				t.Errorf("got Vary %q, want %q", vary, "Accept-Encoding")
+			}
+		})
+	}
+}
+
+func TestServeHTTP_skipEncodedBodiesVary(t *testing.T) {
+	tests := []struct {
+		desc              string
+		skipEncodedBodies bool
+		expVary           string
+	}{
+		{
+			desc:              "should vary on Accept-Encoding when the rewrite depends on the encoding",
+			skipEncodedBodies: true,
+			expVary:           "Accept-Encoding",
+		},
+		{
+			desc: "should not vary on Accept-Encoding when every encoding is rewritten",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				SkipEncodedBodies: test.skipEncodedBodies,
+				Responses:         []Response{{Status: "200", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}}},
+			}
+			next := func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Encoding", "identity")
+				_, _ = rw.Write([]byte("foo"))
+			}
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			rewriteBody.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if body := recorder.Body.String(); body != "bar" {
+				t.Errorf("got body %q, want %q", body, "bar")
+			}
+			if vary := recorder.Header().Get("Vary"); vary != test.expVary {
+				t.Errorf("got Vary %q, want %q", vary, test.expVary)
+			}
+			if encoding, exists := recorder.Header()["Content-Encoding"]; exists {
+				t.Errorf("got Content-Encoding %q, want none for the identity encoding", encoding)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_invalidStatusCode(t *testing.T) {
+	tests := []struct {
+		desc              string
+		statusCode        int
+		invalidStatusCode int
+		expStatus         int
+		expBody           string
+	}{
+		{
+			desc:       "should send a 500 in place of a zero status code",
+			statusCode: 0,
+			expStatus:  http.StatusInternalServerError,
+			expBody:    "bar is the new bar",
+		},
+		{
+			desc:       "should send a 500 in place of a status code out of range",
+			statusCode: 999,
+			expStatus:  http.StatusInternalServerError,
+			expBody:    "bar is the new bar",
+		},
+		{
+			desc:              "should send the configured status code",
+			statusCode:        42,
+			invalidStatusCode: http.StatusBadGateway,
+			expStatus:         http.StatusBadGateway,
+			expBody:           "foo is the new bar",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				InvalidStatusCode: test.invalidStatusCode,
+				Responses: []Response{
+					{
+						Status:   "500",
+						Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+					},
+				},
+			}
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(test.statusCode)
+				_, _ = rw.Write([]byte("foo is the new bar"))
+			}
+
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rewriteBody.ServeHTTP(recorder, req)
+
+			if recorder.Code != test.expStatus {
+				t.Errorf("got status %d, want %d", recorder.Code, test.expStatus)
+			}
+			if recorder.Body.String() != test.expBody {
+				t.Errorf("got body %q, want %q", recorder.Body.String(), test.expBody)
+			}
+		})
+	}
+}
+
+func TestNew_invalidStatusCode(t *testing.T) {
+	for _, statusCode := range []int{-1, 101, 600} {
+		config := &Config{InvalidStatusCode: statusCode}
+		if _, err := New(context.Background(), http.NotFoundHandler(), config, "rewriteBody"); err == nil {
+			t.Errorf("expected an error for invalidStatusCode %d", statusCode)
+		}
+	}
+}
+
+func TestTransformationWarning(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{name: "rewriteBody", expected: `214 rewriteBody "Transformation Applied"`},
+		{name: "rewrite-body@file", expected: `214 rewrite-body-file "Transformation Applied"`},
+		{name: "", expected: `214 responsebodyrewrite "Transformation Applied"`},
+	}
+	for _, test := range tests {
+		if value := transformationWarning(test.name); value != test.expected {
+			t.Errorf("%q: got %q, want %q", test.name, value, test.expected)
+		}
+	}
+}
+
+func TestServeHTTP_addWarningHeader(t *testing.T) {
+	config := &Config{
+		AddWarningHeader: true,
+		CacheSize:        10,
+		Responses: []Response{
+			{Status: "200", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}},
+			{Status: "201", RewriteFirstBytes: 4, Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}},
+		},
+	}
+	handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Add("Warning", `199 upstream "Miscellaneous Warning"`)
+		rw.Header().Set("ETag", `"v1"`)
+		if req.URL.Query().Get("status") == "201" {
+			rw.WriteHeader(http.StatusCreated)
+		}
+		_, _ = rw.Write([]byte(req.URL.Query().Get("body")))
+		_, _ = rw.Write([]byte(" and more"))
+	}), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upstream := `199 upstream "Miscellaneous Warning"`
+	added := `214 rewriteBody "Transformation Applied"`
+	tests := []struct {
+		desc       string
+		target     string
+		expWarning []string
+	}{
+		{desc: "modified", target: "/?body=foo", expWarning: []string{upstream, added}},
+		{desc: "cached", target: "/?body=foo", expWarning: []string{upstream, added}},
+		{desc: "unmodified", target: "/?body=baz", expWarning: []string{upstream}},
+		{desc: "head of the body", target: "/?status=201&body=foo", expWarning: []string{upstream, added}},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.target, nil))
+			if warning := recorder.Result().Header.Values("Warning"); !reflect.DeepEqual(warning, test.expWarning) {
+				t.Errorf("got Warning %q, want %q", warning, test.expWarning)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_matchedHeader(t *testing.T) {
+	config := &Config{
+		MatchedHeader: "X-Rewrite-Matched",
+		CacheSize:     10,
+		Always:        true,
+		Rewrites:      []Rewrite{{Regex: "baz", Replacement: "qux"}},
+		Responses: []Response{
+			{Name: "pages", Status: "200", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}},
+			{Status: "404", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}},
+		},
+	}
+	handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("ETag", `"v1"`)
+		status, _ := strconv.Atoi(req.URL.Query().Get("status"))
+		rw.WriteHeader(status)
+		_, _ = rw.Write([]byte(req.URL.Query().Get("body")))
+	}), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc       string
+		target     string
+		expMatched string
+	}{
+		{desc: "named", target: "/?status=200&body=foo", expMatched: "pages"},
+		{desc: "cached", target: "/?status=200&body=foo", expMatched: "pages"},
+		{desc: "unnamed", target: "/?status=404&body=foo", expMatched: "1"},
+		{desc: "global", target: "/?status=500&body=baz", expMatched: "global"},
+		{desc: "unmodified", target: "/?status=200&body=quux"},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.target, nil))
+			if matched := recorder.Result().Header.Get("X-Rewrite-Matched"); matched != test.expMatched {
+				t.Errorf("got X-Rewrite-Matched %q, want %q", matched, test.expMatched)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+)
+
+// hlsContentTypes are the media types of the HLS playlists.
+var hlsContentTypes = map[string]bool{
+	"application/vnd.apple.mpegurl": true,
+	"application/x-mpegurl":         true,
+	"audio/mpegurl":                 true,
+	"audio/x-mpegurl":               true,
+}
+
+// HLS rewrites the origin of the URIs of the HLS playlists.
+type HLS struct {
+	// Origin is the scheme and the host the URIs are rewritten to, e.g. "https://cdn.example.com". If empty,
+	// it is the public origin of the request, from its X-Forwarded-Proto and X-Forwarded-Host headers, or
+	// else from its own scheme and Host.
+	Origin string `json:"origin,omitempty"`
+}
+
+// parsedHLS is a parsed HLS.
+type parsedHLS struct {
+	// origin is the configured origin, empty if it is derived from the request.
+	origin string
+}
+
+// parseHLS parses the hls option of a response block, nil if it has none.
+func parseHLS(config *HLS) (*parsedHLS, error) {
+	if config == nil {
+		return nil, nil
+	}
+	origin, err := parseOrigin(config.Origin)
+	if err != nil {
+		return nil, err
+	}
+	return &parsedHLS{origin: origin}, nil
+}
+
+// isHLSResponse reports whether the given Content-Type is the one of an HLS playlist.
+func isHLSResponse(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && hlsContentTypes[mediaType]
+}
+
+// publicOrigin returns the origin the URIs of the playlist of req are rewritten to.
+func (h *parsedHLS) publicOrigin(req *http.Request) string {
+	if h.origin != "" {
+		return h.origin
+	}
+	return requestOrigin(req)
+}
+
+// hlsPlaylistRewriter replaces the origin of the absolute http and https URIs of a playlist, building the
+// result only once a URI is changed.
+type hlsPlaylistRewriter struct {
+	body   []byte
+	origin string
+	// out is the rewritten playlist up to sent, nil while nothing has been changed.
+	out  []byte
+	sent int
+}
+
+// rewriteHLSPlaylist replaces the scheme and the host of the absolute http and https URIs of an HLS
+// playlist, master or media, with origin, and reports whether the playlist was modified. The URIs are the
+// lines which are neither blank nor comments nor tags, and the quoted URI attributes of the tags, such as
+// those of EXT-X-MEDIA, EXT-X-KEY or EXT-X-MAP. The rest of the playlist is left byte for byte, and nothing
+// is allocated when no URI is changed.
+func rewriteHLSPlaylist(body []byte, origin string) ([]byte, bool) {
+	rewriter := hlsPlaylistRewriter{body: body, origin: origin}
+	for start := 0; start < len(body); {
+		end := len(body)
+		if i := bytes.IndexByte(body[start:], '\n'); i >= 0 {
+			end = start + i
+		}
+		lineEnd := end
+		if lineEnd > start && body[lineEnd-1] == '\r' {
+			lineEnd--
+		}
+
+		switch line := body[start:lineEnd]; {
+		case len(bytes.TrimSpace(line)) == 0:
+		case line[0] != '#':
+			rewriter.replaceOrigin(start, lineEnd)
+		case bytes.HasPrefix(line, []byte("#EXT")) && !bytes.HasPrefix(line, []byte("#EXTINF:")):
+			if colon := bytes.IndexByte(line, ':'); colon >= 0 {
+				rewriter.rewriteAttributes(start+colon+1, lineEnd)
+			}
+		}
+		start = end + 1
+	}
+
+	if rewriter.out == nil {
+		return body, false
+	}
+	return append(rewriter.out, body[rewriter.sent:]...), true
+}
+
+// rewriteAttributes replaces the origin of the quoted URI attribute in the attribute list of a tag between
+// start and end. A list which is not made of AttributeName=value pairs is left as it is.
+func (h *hlsPlaylistRewriter) rewriteAttributes(start, end int) {
+	for i := start; i < end; {
+		nameStart := i
+		for i < end && isHLSAttributeNameByte(h.body[i]) {
+			i++
+		}
+		if i == nameStart || i == end || h.body[i] != '=' {
+			return
+		}
+		name := h.body[nameStart:i]
+		i++
+
+		if i < end && h.body[i] == '"' {
+			valueEnd := bytes.IndexByte(h.body[i+1:end], '"')
+			if valueEnd < 0 {
+				return
+			}
+			if string(name) == "URI" {
+				h.replaceOrigin(i+1, i+1+valueEnd)
+			}
+			i += valueEnd + 2
+		} else {
+			for i < end && h.body[i] != ',' {
+				i++
+			}
+		}
+		if i < end && h.body[i] != ',' {
+			return
+		}
+		i++
+	}
+}
+
+// isHLSAttributeNameByte reports whether b can be part of the name of an attribute.
+func isHLSAttributeNameByte(b byte) bool {
+	return 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' || b == '-'
+}
+
+// replaceOrigin replaces the scheme and the host of the URI between start and end, if absolute with the http
+// or https scheme.
+func (h *hlsPlaylistRewriter) replaceOrigin(start, end int) {
+	uri := h.body[start:end]
+	var schemeLength int
+	switch {
+	case len(uri) >= 7 && bytes.EqualFold(uri[:7], []byte("http://")):
+		schemeLength = 7
+	case len(uri) >= 8 && bytes.EqualFold(uri[:8], []byte("https://")):
+		schemeLength = 8
+	default:
+		return
+	}
+	originEnd := schemeLength
+	for originEnd < len(uri) && uri[originEnd] != '/' && uri[originEnd] != '?' && uri[originEnd] != '#' {
+		originEnd++
+	}
+	if string(uri[:originEnd]) == h.origin {
+		return
+	}
+
+	if h.out == nil {
+		h.out = make([]byte, 0, len(h.body)+len(h.body)/8)
+	}
+	h.out = append(h.out, h.body[h.sent:start]...)
+	h.out = append(h.out, h.origin...)
+	h.sent = start + originEnd
+}
+
+// String describes the playlists in the debug dump.
+func (h *parsedHLS) String() string {
+	return "URIs rewritten to " + describeOrigin(h.origin)
+}
+
+func (h *parsedHLS) option() string { return "hls" }
+
+// changesBody implements the transformer interface.
+func (h *parsedHLS) changesBody() bool { return true }
+
+// matchesContentType implements the contentTypeMatcher interface.
+func (h *parsedHLS) matchesContentType(contentType string) bool {
+	return isHLSResponse(contentType)
+}
+
+// cacheKey implements the cacheKeyer interface: the rewritten URLs depend on the public origin of req.
+func (h *parsedHLS) cacheKey(req *http.Request) string {
+	return h.publicOrigin(req)
+}
+
+// transform implements the bodyTransformer interface, as rewriteBody for an HLS playlist: once the rewrites
+// are applied, the scheme and the host of the absolute URIs of its segments, variants and tags are replaced
+// with the public origin.
+func (h *parsedHLS) transform(rw *responseWriter, body []byte) ([]byte, bool, bool, []int, error) {
+	r, response, req := rw.middleware, rw.response, rw.request
+	rewritten, modified, complete, replaced := body, false, true, []int(nil)
+	if len(response.rewrites) > 0 {
+		var err error
+		if rewritten, modified, complete, replaced, err = r.rewriteBody(response, body, req); err != nil {
+			return body, false, true, nil, err
+		}
+	}
+
+	result, changed := rewriteHLSPlaylist(rewritten, h.publicOrigin(req))
+	return result, modified || changed, complete, replaced, nil
+}
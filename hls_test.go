@@ -0,0 +1,164 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const hlsMasterPlaylist = "#EXTM3U\r\n" +
+	"#EXT-X-VERSION:6\r\n" +
+	"# generated by origin-1.internal.local\r\n" +
+	`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",DEFAULT=YES,URI="http://origin-1.internal.local/audio/en.m3u8"` + "\r\n" +
+	`#EXT-X-STREAM-INF:BANDWIDTH=1280000,CODECS="mp4a.40.2,avc1.4d401f",AUDIO="aac"` + "\r\n" +
+	"http://origin-1.internal.local/video/720p.m3u8\r\n" +
+	`#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=86000,URI="https://origin-1.internal.local/video/720p-iframes.m3u8"` + "\r\n" +
+	"low/index.m3u8\r\n"
+
+const hlsMediaPlaylist = "#EXTM3U\n" +
+	"#EXT-X-TARGETDURATION:10\n" +
+	"#EXT-X-MEDIA-SEQUENCE:2680\n" +
+	`#EXT-X-KEY:METHOD=AES-128,URI="https://keys.internal.local/key?id=1",IV=0x9c7db8778570d05c3177c349fd9236aa` + "\n" +
+	`#EXT-X-MAP:URI="http://origin-1.internal.local/init.mp4"` + "\n" +
+	"#EXTINF:9.009,URI=\"http://origin-1.internal.local/title\"\n" +
+	"http://origin-1.internal.local/segments/2680.ts\n" +
+	"#EXTINF:10.010000,\n" +
+	"segments/2681.ts\n" +
+	`#EXT-X-SESSION-KEY:METHOD=SAMPLE-AES,URI="skd://origin-1.internal.local/key"` + "\n" +
+	"#EXTINF:9.976,\n" +
+	"http://origin-1.internal.local?segment=2682"
+
+func TestRewriteHLSPlaylist(t *testing.T) {
+	tests := []struct {
+		desc    string
+		body    string
+		expBody string
+	}{
+		{
+			desc: "master playlist",
+			body: hlsMasterPlaylist,
+			expBody: strings.NewReplacer(
+				"http://origin-1.internal.local/audio", "https://cdn.example.com/audio",
+				"http://origin-1.internal.local/video", "https://cdn.example.com/video",
+				"https://origin-1.internal.local/video", "https://cdn.example.com/video",
+			).Replace(hlsMasterPlaylist),
+		},
+		{
+			desc: "media playlist",
+			body: hlsMediaPlaylist,
+			expBody: strings.NewReplacer(
+				"https://keys.internal.local/key", "https://cdn.example.com/key",
+				`"http://origin-1.internal.local/init.mp4"`, `"https://cdn.example.com/init.mp4"`,
+				"\nhttp://origin-1.internal.local/segments", "\nhttps://cdn.example.com/segments",
+				"\nhttp://origin-1.internal.local?", "\nhttps://cdn.example.com?",
+			).Replace(hlsMediaPlaylist),
+		},
+		{
+			desc:    "already rewritten",
+			body:    "#EXTM3U\nhttps://cdn.example.com/segments/1.ts\n",
+			expBody: "#EXTM3U\nhttps://cdn.example.com/segments/1.ts\n",
+		},
+		{
+			desc:    "unterminated attribute",
+			body:    "#EXT-X-MAP:URI=\"http://origin-1.internal.local/init.mp4\n",
+			expBody: "#EXT-X-MAP:URI=\"http://origin-1.internal.local/init.mp4\n",
+		},
+		{
+			desc:    "empty",
+			body:    "",
+			expBody: "",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			body, modified := rewriteHLSPlaylist([]byte(test.body), "https://cdn.example.com")
+			if string(body) != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+			if expModified := test.expBody != test.body; modified != expModified {
+				t.Errorf("got modified %t, want %t", modified, expModified)
+			}
+		})
+	}
+}
+
+func TestRewriteHLSPlaylist_allocations(t *testing.T) {
+	changed := []byte(hlsMediaPlaylist)
+	unchanged, _ := rewriteHLSPlaylist(changed, "https://cdn.example.com")
+	if allocs := testing.AllocsPerRun(100, func() { rewriteHLSPlaylist(unchanged, "https://cdn.example.com") }); allocs != 0 {
+		t.Errorf("got %v allocations for an unchanged playlist, want none", allocs)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { rewriteHLSPlaylist(changed, "https://cdn.example.com") }); allocs > 2 {
+		t.Errorf("got %v allocations for a rewritten playlist, want at most 2", allocs)
+	}
+}
+
+func TestServeHTTP_hls(t *testing.T) {
+	tests := []struct {
+		desc        string
+		config      HLS
+		header      http.Header
+		contentType string
+		expBody     string
+	}{
+		{
+			desc:        "configured origin",
+			config:      HLS{Origin: "https://cdn.example.com"},
+			contentType: "application/vnd.apple.mpegurl",
+			expBody:     "#EXTM3U\n#EXTINF:10.0,\nhttps://cdn.example.com/segments/1.ts\n",
+		},
+		{
+			desc:        "forwarded origin",
+			header:      http.Header{"X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"cdn.example.com"}},
+			contentType: "application/x-mpegURL",
+			expBody:     "#EXTM3U\n#EXTINF:10.0,\nhttps://cdn.example.com/segments/1.ts\n",
+		},
+		{
+			desc:        "invalid forwarded origin",
+			header:      http.Header{"X-Forwarded-Proto": {"javascript"}, "X-Forwarded-Host": {`cdn.example.com"`}},
+			contentType: "application/x-mpegURL",
+			expBody:     "#EXTM3U\n#EXTINF:10.0,\nhttp://example.com/segments/1.ts\n",
+		},
+		{
+			desc:        "other content type",
+			config:      HLS{Origin: "https://cdn.example.com"},
+			contentType: "text/plain",
+			expBody:     "#EXTM3U\n#EXTINF:10.0,\nhttp://origin-1.internal.local/segments/1.ts\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := test.config
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", test.contentType)
+				_, _ = rw.Write([]byte("#EXTM3U\n#EXTINF:10.0,\nhttp://origin-1.internal.local/segments/1.ts\n"))
+			}), &Config{Responses: []Response{{Status: "200", HLS: &config}}}, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/live/index.m3u8", nil)
+			for name, values := range test.header {
+				req.Header[name] = values
+			}
+			handler.ServeHTTP(recorder, req)
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+
+	_, err := New(context.Background(), http.NotFoundHandler(), &Config{Responses: []Response{{
+		Status: "200",
+		HLS:    &HLS{},
+		Feed:   &Feed{},
+	}}}, "rewriteBody")
+	expErr := "responses[0]: feed and hls can't both be set"
+	if err == nil || err.Error() != expErr {
+		t.Errorf("got error %v, want %q", err, expErr)
+	}
+}
@@ -0,0 +1,728 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"regexp"
+	"strings"
+)
+
+// The positions of the HTML injections.
+const (
+	htmlHeadEnd   = "headEnd"
+	htmlBodyStart = "bodyStart"
+	htmlBodyEnd   = "bodyEnd"
+)
+
+// The ways the Content-Security-Policy headers are adjusted for the injected scripts.
+const (
+	cspHash  = "hash"
+	cspNonce = "nonce"
+)
+
+// htmlRawTextElements are the elements whose content is not parsed as HTML: it holds no tags, and is left
+// untouched by the rewrites.
+var htmlRawTextElements = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"textarea": true,
+	"title":    true,
+	"xmp":      true,
+	"iframe":   true,
+	"noembed":  true,
+	"noframes": true,
+}
+
+// HTML holds the operations of the HTML mode of a response block.
+type HTML struct {
+	// Inject are the snippets inserted in the documents.
+	Inject []HTMLInjection `json:"inject,omitempty"`
+	// Attributes are the rewrites of the attribute values.
+	Attributes []HTMLAttribute `json:"attributes,omitempty"`
+	// Integrity handles the Subresource Integrity attributes of the elements whose src or href has been
+	// rewritten, the first rule matching the rewritten URL applying. The elements no rule matches, and those
+	// whose URL has been left as is, keep their attributes.
+	Integrity []HTMLIntegrity `json:"integrity,omitempty"`
+	// CSP adjusts the Content-Security-Policy headers for the injected scripts to run: "hash" adds the hashes
+	// of the inline scripts to the sources of the scripts, "nonce" stamps the nonce of the policy onto the
+	// scripts, the hashes being added to the policies without it. The headers are left as is if empty.
+	CSP string `json:"csp,omitempty"`
+}
+
+// HTMLInjection is a snippet inserted in the HTML documents.
+type HTMLInjection struct {
+	// Position is where the snippet is inserted: "headEnd" before the end tag of the head, "bodyStart" after
+	// the start tag of the body, "bodyEnd" before the end tag of the body.
+	Position string `json:"position,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// HTMLAttribute rewrites the values of an attribute of the HTML elements.
+type HTMLAttribute struct {
+	// Element is the name of the elements, "*" or empty for all of them.
+	Element     string `json:"element,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Regex       string `json:"regex,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// HTMLIntegrity handles the integrity and crossorigin attributes of the elements whose URL has been rewritten.
+type HTMLIntegrity struct {
+	// URL is a regex which must match the rewritten URL, any URL matching if empty.
+	URL string `json:"url,omitempty"`
+	// Remove removes the integrity and crossorigin attributes.
+	Remove bool `json:"remove,omitempty"`
+	// Value replaces the value of the integrity attribute, and CrossOrigin the one of the crossorigin
+	// attribute, which is added if missing, if not empty.
+	Value       string `json:"value,omitempty"`
+	CrossOrigin string `json:"crossOrigin,omitempty"`
+}
+
+// parsedHTML is a parsed HTML.
+type parsedHTML struct {
+	// injections are the snippets to insert by position, those at the same position being concatenated.
+	injections map[string]string
+	attributes []parsedHTMLAttribute
+	integrity  []parsedHTMLIntegrity
+	// csp is the way the Content-Security-Policy headers are adjusted, empty if they are left as is.
+	csp string
+	// scriptHashes are the hash sources of the injected inline scripts.
+	scriptHashes []string
+	// nonceOffsets are the offsets of the snippets, by position, where a nonce attribute is inserted in the
+	// start tags of their scripts.
+	nonceOffsets map[string][]int
+}
+
+// parsedHTMLAttribute is a parsed HTMLAttribute.
+type parsedHTMLAttribute struct {
+	element     string
+	name        string
+	regex       *regexp.Regexp
+	replacement []byte
+}
+
+// parsedHTMLIntegrity is a parsed HTMLIntegrity.
+type parsedHTMLIntegrity struct {
+	// url is the regex of the rewritten URLs, nil if any URL matches.
+	url         *regexp.Regexp
+	remove      bool
+	value       string
+	crossOrigin string
+}
+
+// parseHTML parses the html option of a response block, nil if it has none.
+func parseHTML(config *HTML) (*parsedHTML, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if len(config.Inject) == 0 && len(config.Attributes) == 0 {
+		return nil, errors.New("inject or attributes must be set")
+	}
+	parsed := &parsedHTML{injections: map[string]string{}, csp: config.CSP, nonceOffsets: map[string][]int{}}
+	for i, injection := range config.Inject {
+		switch injection.Position {
+		case htmlHeadEnd, htmlBodyStart, htmlBodyEnd:
+		default:
+			return nil, fmt.Errorf("inject[%d]: invalid position %q: must be %q, %q or %q", i, injection.Position, htmlHeadEnd, htmlBodyStart, htmlBodyEnd)
+		}
+		parsed.injections[injection.Position] += injection.Content
+	}
+	if err := parsed.parseScripts(); err != nil {
+		return nil, err
+	}
+	for i, attribute := range config.Attributes {
+		if attribute.Name == "" {
+			return nil, fmt.Errorf("attributes[%d]: name must be set", i)
+		}
+		regex, err := regexp.Compile(attribute.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("attributes[%d]: invalid regex %q: %w", i, attribute.Regex, err)
+		}
+		element := strings.ToLower(attribute.Element)
+		if element == "" {
+			element = "*"
+		}
+		parsed.attributes = append(parsed.attributes, parsedHTMLAttribute{
+			element:     element,
+			name:        strings.ToLower(attribute.Name),
+			regex:       regex,
+			replacement: []byte(attribute.Replacement),
+		})
+	}
+	if len(config.Integrity) > 0 && len(config.Attributes) == 0 {
+		return nil, errors.New("integrity can only be used with attributes")
+	}
+	for i, integrity := range config.Integrity {
+		switch {
+		case integrity.Remove && (integrity.Value != "" || integrity.CrossOrigin != ""):
+			return nil, fmt.Errorf("integrity[%d]: remove can't be used with value or crossOrigin", i)
+		case !integrity.Remove && integrity.Value == "":
+			return nil, fmt.Errorf("integrity[%d]: remove or value must be set", i)
+		}
+		parsedIntegrity := parsedHTMLIntegrity{remove: integrity.Remove, value: integrity.Value, crossOrigin: integrity.CrossOrigin}
+		if integrity.URL != "" {
+			url, err := regexp.Compile(integrity.URL)
+			if err != nil {
+				return nil, fmt.Errorf("integrity[%d]: invalid url %q: %w", i, integrity.URL, err)
+			}
+			parsedIntegrity.url = url
+		}
+		parsed.integrity = append(parsed.integrity, parsedIntegrity)
+	}
+	return parsed, nil
+}
+
+// parseScripts computes the hashes of the injected inline scripts and the offsets of the nonces, if the
+// Content-Security-Policy headers are adjusted.
+func (h *parsedHTML) parseScripts() error {
+	switch h.csp {
+	case "":
+		return nil
+	case cspHash, cspNonce:
+	default:
+		return fmt.Errorf("invalid csp %q: must be %q or %q", h.csp, cspHash, cspNonce)
+	}
+
+	scripts := 0
+	for _, position := range []string{htmlHeadEnd, htmlBodyStart, htmlBodyEnd} {
+		content, ok := h.injections[position]
+		if !ok {
+			continue
+		}
+		offsets, inline, n := injectedScripts(content)
+		for _, script := range inline {
+			sum := sha256.Sum256([]byte(script))
+			h.scriptHashes = append(h.scriptHashes, "'sha256-"+base64.StdEncoding.EncodeToString(sum[:])+"'")
+		}
+		if len(offsets) > 0 {
+			h.nonceOffsets[position] = offsets
+		}
+		scripts += n
+	}
+	if scripts == 0 {
+		return fmt.Errorf("csp %q requires a script in inject", h.csp)
+	}
+	if h.csp == cspHash && len(h.scriptHashes) == 0 {
+		return fmt.Errorf("csp %q requires an inline script in inject", h.csp)
+	}
+	return nil
+}
+
+// injectedScripts returns the offsets following the names of the start tags of the scripts of a snippet
+// which have no nonce attribute, the contents of the inline scripts, and the number of scripts.
+func injectedScripts(content string) ([]int, []string, int) {
+	data := []byte(content)
+	var offsets []int
+	var inline []string
+	scripts := 0
+	for i := 0; i < len(data); i++ {
+		if data[i] != '<' || i+1 == len(data) || !isASCIILetter(data[i+1]) {
+			continue
+		}
+		tag, ok := parseHTMLStartTag(data[i:])
+		if !ok {
+			break
+		}
+		if tag.name != "script" {
+			i += tag.end - 1
+			continue
+		}
+
+		scripts++
+		src, nonce := false, false
+		for _, attribute := range tag.attributes {
+			src = src || attribute.name == "src"
+			nonce = nonce || attribute.name == "nonce"
+		}
+		if !nonce {
+			offsets = append(offsets, i+len("<script"))
+		}
+		start := i + tag.end
+		end, _ := rawTextEnd(data[start:], "script")
+		if end < 0 {
+			break
+		}
+		// The content of an external script is ignored, only the nonce allowing it.
+		if !src {
+			inline = append(inline, content[start:start+end])
+		}
+		i = start + end - 1
+	}
+	return offsets, inline, scripts
+}
+
+// isHTMLResponse reports whether the given Content-Type is the one of an HTML document.
+func isHTMLResponse(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "text/html" || mediaType == "application/xhtml+xml")
+}
+
+// String describes the HTML mode in the debug dump.
+func (h *parsedHTML) String() string {
+	var descriptions []string
+	for _, position := range []string{htmlHeadEnd, htmlBodyStart, htmlBodyEnd} {
+		if _, ok := h.injections[position]; ok {
+			descriptions = append(descriptions, "inject at "+position)
+		}
+	}
+	for _, attribute := range h.attributes {
+		descriptions = append(descriptions, fmt.Sprintf("rewrite %s[%s] matching %s", attribute.element, attribute.name, attribute.regex))
+	}
+	if len(h.integrity) > 0 {
+		descriptions = append(descriptions, fmt.Sprintf("%d integrity rules", len(h.integrity)))
+	}
+	if h.csp != "" {
+		descriptions = append(descriptions, "csp "+h.csp)
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+func (h *parsedHTML) option() string { return "html" }
+
+// changesBody implements the transformer interface.
+func (h *parsedHTML) changesBody() bool { return true }
+
+// matchesContentType implements the contentTypeMatcher interface.
+func (h *parsedHTML) matchesContentType(contentType string) bool {
+	return isHTMLResponse(contentType)
+}
+
+// newStream implements the streamTransformer interface: the policy of the document is adjusted along with
+// the scripts injected in its body.
+func (h *parsedHTML) newStream(rw *responseWriter) io.WriteCloser {
+	nonce := h.allowInjectedScripts(rw.ResponseWriter.Header())
+	return newHTMLRewriter(rw.ResponseWriter, rw.response, h, nonce)
+}
+
+// htmlRewriter rewrites an HTML document token by token as it is written: the rewrites of the response are
+// applied to the text between the tags, the attributes to the values of the start tags, and the snippets
+// are injected around the tags of the head and the body. The comments and the content of the raw text
+// elements, such as scripts, are sent untouched. Only the token being written is held back.
+type htmlRewriter struct {
+	response *parsedResponse
+	html     *parsedHTML
+	writer   io.Writer
+	pending  []byte
+	// rawText is the name of the raw text element the data is in, empty outside of one.
+	rawText string
+	// comment is set while the data is in a comment.
+	comment bool
+	// injected are the positions whose snippets have been inserted.
+	injected map[string]bool
+	// nonce is the nonce stamped onto the injected scripts, empty if none.
+	nonce string
+}
+
+// newHTMLRewriter creates a htmlRewriter writing to w, stamping nonce onto the injected scripts if not empty.
+func newHTMLRewriter(w io.Writer, response *parsedResponse, html *parsedHTML, nonce string) *htmlRewriter {
+	return &htmlRewriter{
+		response: response,
+		html:     html,
+		writer:   w,
+		injected: map[string]bool{},
+		nonce:    nonce,
+	}
+}
+
+// Write implements the io.Writer interface.
+func (h *htmlRewriter) Write(p []byte) (int, error) {
+	h.pending = append(h.pending, p...)
+	if err := h.process(false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close rewrites and sends the last token, even if incomplete.
+func (h *htmlRewriter) Close() error {
+	return h.process(true)
+}
+
+// process sends all the complete tokens of the pending data, and all the pending data when final is true.
+func (h *htmlRewriter) process(final bool) error {
+	var out []byte
+	start := 0
+	for start < len(h.pending) {
+		n, token := h.next(h.pending[start:], final)
+		if n == 0 {
+			break
+		}
+		out = append(out, token...)
+		start += n
+	}
+	h.pending = append(h.pending[:0], h.pending[start:]...)
+
+	if len(out) == 0 {
+		return nil
+	}
+	_, err := h.writer.Write(out)
+	return err
+}
+
+// next returns the number of bytes of the next token of data and its rewritten bytes, or zero if the token
+// is not complete yet. An incomplete token is returned as is when final is true.
+func (h *htmlRewriter) next(data []byte, final bool) (int, []byte) {
+	if h.comment {
+		if end := bytes.Index(data, []byte("-->")); end >= 0 {
+			h.comment = false
+			return end + len("-->"), data[:end+len("-->")]
+		}
+		// The end of the comment may be split.
+		return h.partial(data, len("--"), final)
+	}
+
+	if h.rawText != "" {
+		end, complete := rawTextEnd(data, h.rawText)
+		switch {
+		case end < 0:
+			return h.partial(data, len("</")+len(h.rawText), final)
+		case !complete && !final:
+			return end, data[:end]
+		case end > 0:
+			return end, data[:end]
+		}
+		h.rawText = ""
+	}
+
+	if data[0] != '<' {
+		end := bytes.IndexByte(data, '<')
+		if end < 0 {
+			if !final {
+				return 0, nil
+			}
+			end = len(data)
+		}
+		if len(h.response.rewrites) == 0 {
+			return end, data[:end]
+		}
+		text, _ := h.response.rewrite(data[:end])
+		return end, text
+	}
+
+	if len(data) < 2 {
+		return h.partial(data, len(data), final)
+	}
+	switch {
+	case bytes.HasPrefix(data, []byte("<!--")):
+		h.comment = true
+		return len("<!--"), data[:len("<!--")]
+	case data[1] == '!' || data[1] == '?':
+		end := bytes.IndexByte(data, '>')
+		if end < 0 {
+			return h.partial(data, len(data), final)
+		}
+		return end + 1, data[:end+1]
+	case data[1] == '/':
+		end := bytes.IndexByte(data, '>')
+		if end < 0 {
+			return h.partial(data, len(data), final)
+		}
+		name := htmlTagName(data[2:end])
+		var token []byte
+		switch name {
+		case "head":
+			token = h.inject(token, htmlHeadEnd)
+		case "body":
+			token = h.inject(token, htmlBodyEnd)
+		}
+		return end + 1, append(token, data[:end+1]...)
+	case isASCIILetter(data[1]):
+		tag, ok := parseHTMLStartTag(data)
+		if !ok {
+			return h.partial(data, len(data), final)
+		}
+		token := h.rewriteAttributes(data[:tag.end], tag)
+		if htmlRawTextElements[tag.name] {
+			h.rawText = tag.name
+		}
+		if tag.name == "body" {
+			token = h.inject(token, htmlBodyStart)
+		}
+		return tag.end, token
+	}
+	// A "<" which doesn't start a tag is text.
+	return 1, data[:1]
+}
+
+// partial returns the bytes of an incomplete token which can be sent right away, all but the last keep bytes
+// which may be the beginning of the end of the token, or all of them when final is true.
+func (h *htmlRewriter) partial(data []byte, keep int, final bool) (int, []byte) {
+	if final {
+		return len(data), data
+	}
+	if len(data) <= keep {
+		return 0, nil
+	}
+	return len(data) - keep, data[:len(data)-keep]
+}
+
+// inject appends to token the snippets of the position, the first time only.
+func (h *htmlRewriter) inject(token []byte, position string) []byte {
+	content, ok := h.html.injections[position]
+	if !ok || h.injected[position] {
+		return token
+	}
+	h.injected[position] = true
+	// The token may be a slice of the pending data, which must not be overwritten.
+	token = append([]byte(nil), token...)
+	if h.nonce == "" {
+		return append(token, content...)
+	}
+	sent := 0
+	for _, offset := range h.html.nonceOffsets[position] {
+		token = append(token, content[sent:offset]...)
+		token = append(token, ` nonce="`+h.nonce+`"`...)
+		sent = offset
+	}
+	return append(token, content[sent:]...)
+}
+
+// rawTextEnd returns the offset of the end tag of the raw text element name in data, -1 if there is none,
+// and whether it is complete, i.e. whether the character following its name is known.
+func rawTextEnd(data []byte, name string) (int, bool) {
+	lower := bytes.ToLower(data)
+	endTag := []byte("</" + name)
+	for from := 0; ; {
+		i := bytes.Index(lower[from:], endTag)
+		if i < 0 {
+			return -1, false
+		}
+		end := from + i
+		after := end + len(endTag)
+		if after == len(data) {
+			return end, false
+		}
+		if isHTMLSpace(data[after]) || data[after] == '/' || data[after] == '>' {
+			return end, true
+		}
+		from = after
+	}
+}
+
+// htmlStartTag is a parsed start tag.
+type htmlStartTag struct {
+	name string
+	// end is the offset following the tag.
+	end        int
+	attributes []htmlAttribute
+}
+
+// htmlAttribute is an attribute of a start tag, with the offsets of its value.
+type htmlAttribute struct {
+	name string
+	// start is the offset of the whitespace preceding the attribute, and end the one following it.
+	start      int
+	end        int
+	valueStart int
+	valueEnd   int
+	// quote is the quote of the value, zero if it is not quoted, and hasValue is false for an attribute
+	// without value.
+	quote    byte
+	hasValue bool
+}
+
+// parseHTMLStartTag parses the start tag at the beginning of data. It returns false if it is not complete.
+func parseHTMLStartTag(data []byte) (htmlStartTag, bool) {
+	i := 1
+	for i < len(data) && !isHTMLSpace(data[i]) && data[i] != '/' && data[i] != '>' {
+		i++
+	}
+	tag := htmlStartTag{name: strings.ToLower(string(data[1:i]))}
+	for {
+		space := i
+		for i < len(data) && (isHTMLSpace(data[i]) || data[i] == '/') {
+			if data[i] == '/' {
+				space = i + 1
+			}
+			i++
+		}
+		if i >= len(data) {
+			return htmlStartTag{}, false
+		}
+		if data[i] == '>' {
+			tag.end = i + 1
+			return tag, true
+		}
+
+		start := i
+		for i < len(data) && !isHTMLSpace(data[i]) && data[i] != '/' && data[i] != '>' && (data[i] != '=' || i == start) {
+			i++
+		}
+		attribute := htmlAttribute{name: strings.ToLower(string(data[start:i])), start: space, end: i}
+		j := i
+		for j < len(data) && isHTMLSpace(data[j]) {
+			j++
+		}
+		if j < len(data) && data[j] == '=' {
+			i = j + 1
+			for i < len(data) && isHTMLSpace(data[i]) {
+				i++
+			}
+			if i >= len(data) {
+				return htmlStartTag{}, false
+			}
+			attribute.hasValue = true
+			if data[i] == '"' || data[i] == '\'' {
+				end := bytes.IndexByte(data[i+1:], data[i])
+				if end < 0 {
+					return htmlStartTag{}, false
+				}
+				attribute.quote = data[i]
+				attribute.valueStart, attribute.valueEnd = i+1, i+1+end
+				i += end + 2
+			} else {
+				attribute.valueStart = i
+				for i < len(data) && !isHTMLSpace(data[i]) && data[i] != '>' {
+					i++
+				}
+				attribute.valueEnd = i
+			}
+			attribute.end = i
+		}
+		tag.attributes = append(tag.attributes, attribute)
+	}
+}
+
+// rewriteAttributes returns the start tag with the values of its attributes rewritten, keeping their quotes.
+// If its src or href has been rewritten, its integrity and crossorigin attributes are handled by the first
+// integrity rule matching the rewritten URL.
+func (h *htmlRewriter) rewriteAttributes(data []byte, tag htmlStartTag) []byte {
+	html := h.html
+	if len(html.attributes) == 0 {
+		return data
+	}
+	// rewritten are the rewritten values of the attributes, nil for those left as is.
+	rewritten := make([][]byte, len(tag.attributes))
+	changed := false
+	var url []byte
+	for i, attribute := range tag.attributes {
+		if !attribute.hasValue {
+			continue
+		}
+		value := data[attribute.valueStart:attribute.valueEnd]
+		result := value
+		for _, rule := range html.attributes {
+			if (rule.element == "*" || rule.element == tag.name) && rule.name == attribute.name {
+				result = rule.regex.ReplaceAll(result, rule.replacement)
+			}
+		}
+		if bytes.Equal(result, value) {
+			continue
+		}
+		rewritten[i] = result
+		changed = true
+		if attribute.name == "src" || attribute.name == "href" {
+			url = result
+		}
+	}
+	if !changed {
+		return data
+	}
+
+	integrity := html.integrityRule(url)
+	var out []byte
+	sent := 0
+	hasIntegrity, hasCrossOrigin := false, false
+	for i, attribute := range tag.attributes {
+		value := rewritten[i]
+		if integrity != nil && (attribute.name == "integrity" || attribute.name == "crossorigin") {
+			hasIntegrity = hasIntegrity || attribute.name == "integrity"
+			hasCrossOrigin = hasCrossOrigin || attribute.name == "crossorigin"
+			switch {
+			case integrity.remove:
+				// The whitespace is kept before a "/" which would be appended to an unquoted value.
+				start := attribute.start
+				for attribute.end < len(data) && data[attribute.end] == '/' && isHTMLSpace(data[start]) {
+					start++
+				}
+				out = append(out, data[sent:start]...)
+				sent = attribute.end
+				continue
+			case attribute.name == "integrity":
+				value = []byte(integrity.value)
+			case integrity.crossOrigin != "":
+				value = []byte(integrity.crossOrigin)
+			}
+		}
+		if value == nil {
+			continue
+		}
+		if !attribute.hasValue {
+			// An attribute without value is given one, double-quoted.
+			out = append(out, data[sent:attribute.end]...)
+			out = append(out, `="`...)
+			out = append(out, quoteHTMLAttribute(value, '"')...)
+			out = append(out, '"')
+			sent = attribute.end
+			continue
+		}
+		out = append(out, data[sent:attribute.valueStart]...)
+		out = append(out, quoteHTMLAttribute(value, attribute.quote)...)
+		sent = attribute.valueEnd
+	}
+	// A resource fetched with a new integrity from another origin needs a CORS request.
+	if integrity != nil && !integrity.remove && hasIntegrity && !hasCrossOrigin && integrity.crossOrigin != "" {
+		end := len("<") + len(tag.name)
+		if len(tag.attributes) > 0 {
+			end = tag.attributes[len(tag.attributes)-1].end
+		}
+		out = append(out, data[sent:end]...)
+		out = append(out, ` crossorigin="`...)
+		out = append(out, quoteHTMLAttribute([]byte(integrity.crossOrigin), '"')...)
+		out = append(out, '"')
+		sent = end
+	}
+	return append(out, data[sent:]...)
+}
+
+// integrityRule returns the first integrity rule matching a rewritten URL, nil if none or if url is nil.
+func (h *parsedHTML) integrityRule(url []byte) *parsedHTMLIntegrity {
+	if url == nil {
+		return nil
+	}
+	for i := range h.integrity {
+		if h.integrity[i].url == nil || h.integrity[i].url.Match(url) {
+			return &h.integrity[i]
+		}
+	}
+	return nil
+}
+
+// quoteHTMLAttribute escapes an attribute value for its quote. An unquoted value which can't stay unquoted is
+// double-quoted.
+func quoteHTMLAttribute(value []byte, quote byte) []byte {
+	switch quote {
+	case '"':
+		return bytes.ReplaceAll(value, []byte(`"`), []byte("&quot;"))
+	case '\'':
+		return bytes.ReplaceAll(value, []byte("'"), []byte("&#39;"))
+	}
+	if len(value) > 0 && bytes.IndexAny(value, " \t\n\f\r\"'=<>`") < 0 {
+		return value
+	}
+	return append(append([]byte(`"`), bytes.ReplaceAll(value, []byte(`"`), []byte("&quot;"))...), '"')
+}
+
+// htmlTagName returns the lowercase name at the beginning of the content of a tag.
+func htmlTagName(content []byte) string {
+	end := 0
+	for end < len(content) && !isHTMLSpace(content[end]) && content[end] != '/' {
+		end++
+	}
+	return strings.ToLower(string(content[:end]))
+}
+
+// isHTMLSpace reports whether c is an HTML whitespace.
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+// isASCIILetter reports whether c is an ASCII letter.
+func isASCIILetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
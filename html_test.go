@@ -0,0 +1,386 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestServeHTTP_html(t *testing.T) {
+	const page = `<!DOCTYPE html>
+<html>
+<head>
+<title>internal.local</title>
+<script>const tpl = ` + "`</head><p>internal.local</p>`" + `;</script>
+<!-- </head> internal.local -->
+<link rel="stylesheet" href="http://internal.local/app.css">
+</head>
+<body class=main>
+<p>Served by internal.local</p>
+<a href='http://internal.local/?q="x"' data-x>link</a>
+<img src=http://internal.local/logo.png alt=logo>
+</body>
+</html>
+`
+
+	tests := []struct {
+		desc     string
+		html     HTML
+		rewrites []Rewrite
+		expBody  string
+		expErr   string
+	}{
+		{
+			desc: "injections",
+			html: HTML{Inject: []HTMLInjection{
+				{Position: "headEnd", Content: `<script src="/rum.js"></script>`},
+				{Position: "bodyStart", Content: `<div class="banner">Maintenance</div>`},
+				{Position: "bodyEnd", Content: `<footer></footer>`},
+			}},
+			expBody: `<!DOCTYPE html>
+<html>
+<head>
+<title>internal.local</title>
+<script>const tpl = ` + "`</head><p>internal.local</p>`" + `;</script>
+<!-- </head> internal.local -->
+<link rel="stylesheet" href="http://internal.local/app.css">
+<script src="/rum.js"></script></head>
+<body class=main><div class="banner">Maintenance</div>
+<p>Served by internal.local</p>
+<a href='http://internal.local/?q="x"' data-x>link</a>
+<img src=http://internal.local/logo.png alt=logo>
+<footer></footer></body>
+</html>
+`,
+		},
+		{
+			desc: "attributes and text",
+			html: HTML{Attributes: []HTMLAttribute{
+				{Name: "href", Regex: `http://internal\.local`, Replacement: "https://example.com"},
+				{Element: "img", Name: "SRC", Regex: `http://internal\.local/`, Replacement: "/static/"},
+				{Element: "a", Name: "href", Regex: `"x"`, Replacement: `'y'`},
+			}},
+			rewrites: []Rewrite{{Regex: `internal\.local`, Replacement: "example.com"}},
+			expBody: `<!DOCTYPE html>
+<html>
+<head>
+<title>internal.local</title>
+<script>const tpl = ` + "`</head><p>internal.local</p>`" + `;</script>
+<!-- </head> internal.local -->
+<link rel="stylesheet" href="https://example.com/app.css">
+</head>
+<body class=main>
+<p>Served by example.com</p>
+<a href='https://example.com/?q=&#39;y&#39;' data-x>link</a>
+<img src=/static/logo.png alt=logo>
+</body>
+</html>
+`,
+		},
+		{
+			desc: "unquoted value needing quotes",
+			html: HTML{Attributes: []HTMLAttribute{{Name: "alt", Regex: "logo", Replacement: "our logo"}}},
+			expBody: `<!DOCTYPE html>
+<html>
+<head>
+<title>internal.local</title>
+<script>const tpl = ` + "`</head><p>internal.local</p>`" + `;</script>
+<!-- </head> internal.local -->
+<link rel="stylesheet" href="http://internal.local/app.css">
+</head>
+<body class=main>
+<p>Served by internal.local</p>
+<a href='http://internal.local/?q="x"' data-x>link</a>
+<img src=http://internal.local/logo.png alt="our logo">
+</body>
+</html>
+`,
+		},
+		{
+			desc:   "no operation",
+			expErr: "responses[0]: html: inject or attributes must be set",
+		},
+		{
+			desc:   "invalid position",
+			html:   HTML{Inject: []HTMLInjection{{Position: "head", Content: "x"}}},
+			expErr: `responses[0]: html: inject[0]: invalid position "head": must be "headEnd", "bodyStart" or "bodyEnd"`,
+		},
+		{
+			desc:   "invalid regex",
+			html:   HTML{Attributes: []HTMLAttribute{{Name: "href", Regex: "("}}},
+			expErr: "responses[0]: html: attributes[0]: invalid regex \"(\": error parsing regexp: missing closing ): `(`",
+		},
+		{
+			desc:   "attribute without name",
+			html:   HTML{Attributes: []HTMLAttribute{{Regex: "x"}}},
+			expErr: "responses[0]: html: attributes[0]: name must be set",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			html := test.html
+			config := &Config{Responses: []Response{{Status: "200", HTML: &html, Rewrites: test.rewrites}}}
+			for _, chunkSize := range []int{len(page), 7, 1} {
+				handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+					rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+					for rest := page; rest != ""; {
+						n := chunkSize
+						if n > len(rest) {
+							n = len(rest)
+						}
+						_, _ = rw.Write([]byte(rest[:n]))
+						rest = rest[n:]
+					}
+				}), config, "rewriteBody")
+				if test.expErr != "" {
+					if err == nil || err.Error() != test.expErr {
+						t.Errorf("got error %v, want %q", err, test.expErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+				if body := recorder.Body.String(); body != test.expBody {
+					t.Errorf("got body %q with writes of %d bytes, want %q", body, chunkSize, test.expBody)
+				}
+			}
+		})
+	}
+}
+
+func TestServeHTTP_htmlOtherContentType(t *testing.T) {
+	handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`{"html": "</head>"}`))
+	}), &Config{Responses: []Response{{
+		Status: "200",
+		HTML:   &HTML{Inject: []HTMLInjection{{Position: "headEnd", Content: "<script></script>"}}},
+	}}}, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if body := recorder.Body.String(); body != `{"html": "</head>"}` {
+		t.Errorf("got body %q, want it unmodified", body)
+	}
+}
+
+func TestServeHTTP_htmlCSP(t *testing.T) {
+	const page = `<html><head><script nonce="r4nd0m">app()</script></head><body></body></html>`
+	inject := []HTMLInjection{
+		{Position: "headEnd", Content: `<script>console.log("rum")</script>`},
+		{Position: "bodyEnd", Content: `<script src="/rum.js"></script><script nonce="x">x()</script>`},
+	}
+	const hash = "'sha256-mXCp1GC0WgEIJx6IP8O2TekRbounlmTy5dvZ5rv0oM0='"
+
+	tests := []struct {
+		desc        string
+		csp         string
+		policies    []string
+		expBody     string
+		expPolicies []string
+		expErr      string
+	}{
+		{
+			desc:        "hash",
+			csp:         "hash",
+			policies:    []string{"default-src 'self'; script-src 'self'", "script-src 'nonce-r4nd0m'"},
+			expBody:     `<html><head><script nonce="r4nd0m">app()</script><script>console.log("rum")</script></head><body><script src="/rum.js"></script><script nonce="x">x()</script></body></html>`,
+			expPolicies: []string{"default-src 'self'; script-src 'self' " + hash + " 'sha256-D6IGS8VMvCoyaR/l0h9tERrBTATY01CoPS7l6xDv0kI='", "script-src 'nonce-r4nd0m' " + hash + " 'sha256-D6IGS8VMvCoyaR/l0h9tERrBTATY01CoPS7l6xDv0kI='"},
+		},
+		{
+			desc:        "nonce",
+			csp:         "nonce",
+			policies:    []string{"script-src 'nonce-r4nd0m' 'strict-dynamic', img-src 'self'", "default-src 'self'"},
+			expBody:     `<html><head><script nonce="r4nd0m">app()</script><script nonce="r4nd0m">console.log("rum")</script></head><body><script nonce="r4nd0m" src="/rum.js"></script><script nonce="x">x()</script></body></html>`,
+			expPolicies: []string{"script-src 'nonce-r4nd0m' 'strict-dynamic', img-src 'self'", "default-src 'self' " + hash + " 'sha256-D6IGS8VMvCoyaR/l0h9tERrBTATY01CoPS7l6xDv0kI='"},
+		},
+		{
+			desc:    "nonce without policy",
+			csp:     "nonce",
+			expBody: `<html><head><script nonce="r4nd0m">app()</script><script>console.log("rum")</script></head><body><script src="/rum.js"></script><script nonce="x">x()</script></body></html>`,
+		},
+		{
+			desc:   "invalid csp",
+			csp:    "sha256",
+			expErr: `responses[0]: html: invalid csp "sha256": must be "hash" or "nonce"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				for _, policy := range test.policies {
+					rw.Header().Add("Content-Security-Policy", policy)
+				}
+				rw.Header().Set("Content-Type", "text/html")
+				_, _ = rw.Write([]byte(page))
+			}), &Config{Responses: []Response{{Status: "200", HTML: &HTML{Inject: inject, CSP: test.csp}}}}, "rewriteBody")
+			if test.expErr != "" {
+				if err == nil || err.Error() != test.expErr {
+					t.Errorf("got error %v, want %q", err, test.expErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+			if policies := recorder.Header().Values("Content-Security-Policy"); !reflect.DeepEqual(policies, test.expPolicies) {
+				t.Errorf("got policies %q, want %q", policies, test.expPolicies)
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		inject []HTMLInjection
+		csp    string
+		expErr string
+	}{
+		{
+			inject: []HTMLInjection{{Position: "headEnd", Content: `<link rel="stylesheet" href="/rum.css">`}},
+			csp:    "nonce",
+			expErr: `responses[0]: html: csp "nonce" requires a script in inject`,
+		},
+		{
+			inject: []HTMLInjection{{Position: "headEnd", Content: `<script src="/rum.js"></script>`}},
+			csp:    "hash",
+			expErr: `responses[0]: html: csp "hash" requires an inline script in inject`,
+		},
+	} {
+		_, err := New(context.Background(), http.NotFoundHandler(), &Config{Responses: []Response{{
+			Status: "200",
+			HTML:   &HTML{Inject: test.inject, CSP: test.csp},
+		}}}, "rewriteBody")
+		if err == nil || err.Error() != test.expErr {
+			t.Errorf("got error %v, want %q", err, test.expErr)
+		}
+	}
+}
+
+func TestServeHTTP_htmlIntegrity(t *testing.T) {
+	const page = `<head>
+<script src="https://cdn.internal.local/app.js" integrity="sha384-old" crossorigin="anonymous"></script>
+<link rel=stylesheet integrity=sha384-old href=https://cdn.internal.local/app.css crossorigin>
+<script src="https://other.example.com/lib.js" integrity="sha384-lib" crossorigin="anonymous"></script>
+<script integrity="sha384-inline"></script>
+<script src=https://cdn.internal.local/vendor.js integrity="sha384-vendor"/>
+</head>`
+	attributes := []HTMLAttribute{{Regex: `^https://cdn\.internal\.local/`, Name: "src"}, {Regex: `^https://cdn\.internal\.local/`, Name: "href"}}
+	for i := range attributes {
+		attributes[i].Replacement = "https://mirror.example.com/"
+	}
+
+	tests := []struct {
+		desc      string
+		integrity []HTMLIntegrity
+		expBody   string
+		expErr    string
+	}{
+		{
+			desc:      "remove",
+			integrity: []HTMLIntegrity{{Remove: true}},
+			expBody: `<head>
+<script src="https://mirror.example.com/app.js"></script>
+<link rel=stylesheet href=https://mirror.example.com/app.css>
+<script src="https://other.example.com/lib.js" integrity="sha384-lib" crossorigin="anonymous"></script>
+<script integrity="sha384-inline"></script>
+<script src=https://mirror.example.com/vendor.js />
+</head>`,
+		},
+		{
+			desc: "values by URL",
+			integrity: []HTMLIntegrity{
+				{URL: `\.js$`, Value: `sha384-new"js`},
+				{URL: `\.css$`, Value: "sha384-newcss", CrossOrigin: "use-credentials"},
+			},
+			expBody: `<head>
+<script src="https://mirror.example.com/app.js" integrity="sha384-new&quot;js" crossorigin="anonymous"></script>
+<link rel=stylesheet integrity=sha384-newcss href=https://mirror.example.com/app.css crossorigin="use-credentials">
+<script src="https://other.example.com/lib.js" integrity="sha384-lib" crossorigin="anonymous"></script>
+<script integrity="sha384-inline"></script>
+<script src=https://mirror.example.com/vendor.js integrity="sha384-new&quot;js"/>
+</head>`,
+		},
+		{
+			desc:      "crossorigin added",
+			integrity: []HTMLIntegrity{{URL: `\.js$`, Value: "sha384-newjs", CrossOrigin: "anonymous"}},
+			expBody: `<head>
+<script src="https://mirror.example.com/app.js" integrity="sha384-newjs" crossorigin="anonymous"></script>
+<link rel=stylesheet integrity=sha384-old href=https://mirror.example.com/app.css crossorigin>
+<script src="https://other.example.com/lib.js" integrity="sha384-lib" crossorigin="anonymous"></script>
+<script integrity="sha384-inline"></script>
+<script src=https://mirror.example.com/vendor.js integrity="sha384-newjs" crossorigin="anonymous"/>
+</head>`,
+		},
+		{
+			desc:      "no operation",
+			integrity: []HTMLIntegrity{{URL: "x"}},
+			expErr:    "responses[0]: html: integrity[0]: remove or value must be set",
+		},
+		{
+			desc:      "remove and value",
+			integrity: []HTMLIntegrity{{Remove: true, CrossOrigin: "anonymous"}},
+			expErr:    "responses[0]: html: integrity[0]: remove can't be used with value or crossOrigin",
+		},
+		{
+			desc:      "invalid url",
+			integrity: []HTMLIntegrity{{URL: "(", Remove: true}},
+			expErr:    "responses[0]: html: integrity[0]: invalid url \"(\": error parsing regexp: missing closing ): `(`",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", "text/html")
+				_, _ = rw.Write([]byte(page))
+			}), &Config{Responses: []Response{{
+				Status: "200",
+				HTML:   &HTML{Attributes: attributes, Integrity: test.integrity},
+			}}}, "rewriteBody")
+			if test.expErr != "" {
+				if err == nil || err.Error() != test.expErr {
+					t.Errorf("got error %v, want %q", err, test.expErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+
+	_, err := New(context.Background(), http.NotFoundHandler(), &Config{Responses: []Response{{
+		Status: "200",
+		HTML: &HTML{
+			Inject:    []HTMLInjection{{Position: "headEnd", Content: "x"}},
+			Integrity: []HTMLIntegrity{{Remove: true}},
+		},
+	}}}, "rewriteBody")
+	expErr := "responses[0]: html: integrity can only be used with attributes"
+	if err == nil || err.Error() != expErr {
+		t.Errorf("got error %v, want %q", err, expErr)
+	}
+}
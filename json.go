@@ -0,0 +1,342 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// errJSONAborted is returned to the JSON decoder when the response is released before its body is complete.
+var errJSONAborted = errors.New("json rewrite aborted")
+
+// parseJSONPaths parses the field paths of the JSON streaming mode.
+// A path is a list of object keys separated by dots, "*" matching any key. Arrays are traversed
+// transparently: "items.name" matches the name of every object of the items array.
+func parseJSONPaths(paths []string) ([][]string, error) {
+	parsed := make([][]string, len(paths))
+	for i, path := range paths {
+		segments := strings.Split(path, ".")
+		for _, segment := range segments {
+			if segment == "" {
+				return nil, fmt.Errorf("invalid JSON path %q: empty key", path)
+			}
+		}
+		parsed[i] = segments
+	}
+	return parsed, nil
+}
+
+// jsonFrame is an object or an array the JSON decoder is in.
+type jsonFrame struct {
+	object bool
+	// expectKey is true when the next token of an object is a key.
+	expectKey bool
+	// key is the key of the value being decoded in an object.
+	key string
+}
+
+// jsonChunkReader feeds the JSON decoder with the chunks of body written to a jsonRewriter, and keeps the
+// bytes read by the decoder which haven't been sent yet.
+// Reading and writing strictly alternate: the decoder only runs while a Write waits for it to ask for the
+// next chunk, so that the underlying writer is never used by two goroutines at the same time.
+type jsonChunkReader struct {
+	chunks chan []byte
+	need   chan struct{}
+	abort  chan struct{}
+	chunk  []byte
+	eof    bool
+	// raw[start:] are the bytes read from the input offset which haven't been sent yet.
+	raw    []byte
+	start  int
+	offset int64
+}
+
+// Read implements the io.Reader interface.
+func (r *jsonChunkReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		select {
+		case r.need <- struct{}{}:
+		case <-r.abort:
+			return 0, errJSONAborted
+		}
+		select {
+		case chunk, ok := <-r.chunks:
+			if !ok {
+				r.eof = true
+				return 0, io.EOF
+			}
+			r.chunk = chunk
+		case <-r.abort:
+			return 0, errJSONAborted
+		}
+	}
+
+	// The bytes taken so far have been sent, their space can be reused.
+	if r.start > 0 {
+		r.raw = r.raw[:copy(r.raw, r.raw[r.start:])]
+		r.start = 0
+	}
+
+	n := copy(p, r.chunk)
+	r.raw = append(r.raw, r.chunk[:n]...)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// take removes and returns the raw bytes read up to the given input offset.
+// The returned slice is only valid until the next Read.
+func (r *jsonChunkReader) take(offset int64) []byte {
+	end := r.start + int(offset-r.offset)
+	out := r.raw[r.start:end]
+	r.start = end
+	r.offset = offset
+	return out
+}
+
+// takeAll removes and returns all the raw bytes read so far.
+// The returned slice is only valid until the next Read.
+func (r *jsonChunkReader) takeAll() []byte {
+	return r.take(r.offset + int64(len(r.raw)-r.start))
+}
+
+// jsonRewriter applies the rewrites of a response to the string values found at the configured paths of
+// a JSON body, and sends the body to the client as it is decoded.
+// Everything but the rewritten values is sent byte for byte, and a body which fails to decode is sent
+// unmodified from the point of failure.
+type jsonRewriter struct {
+	response *parsedResponse
+	writer   io.Writer
+	reader   *jsonChunkReader
+	// waiting is true when the decoder waits for the next chunk.
+	waiting bool
+	done    chan struct{}
+	err     error
+	// decodeErr is the error of a body which failed to decode, read once done is closed. The rest of the body
+	// is sent unmodified, unless stopOnError is set.
+	decodeErr   error
+	stopOnError bool
+	once        sync.Once
+}
+
+// newJSONRewriter creates a jsonRewriter writing to w, and starts decoding the body. With stopOnError, nothing
+// more is sent once the body fails to decode.
+func newJSONRewriter(w io.Writer, response *parsedResponse, stopOnError bool) *jsonRewriter {
+	j := &jsonRewriter{
+		response:    response,
+		writer:      w,
+		stopOnError: stopOnError,
+		reader: &jsonChunkReader{
+			chunks: make(chan []byte),
+			need:   make(chan struct{}),
+			abort:  make(chan struct{}),
+		},
+		done: make(chan struct{}),
+	}
+	go j.run()
+	return j
+}
+
+// Write implements the io.Writer interface.
+// It returns once the decoder has processed all of p.
+func (j *jsonRewriter) Write(p []byte) (int, error) {
+	if j.wait() {
+		j.reader.chunks <- p
+		j.waiting = false
+		if j.wait() {
+			return len(p), nil
+		}
+	}
+
+	// The decoder has stopped, err can be read.
+	if j.err != nil {
+		return 0, j.err
+	}
+	return len(p), nil
+}
+
+// Close signals the end of the body, and returns once all of it has been sent.
+func (j *jsonRewriter) Close() error {
+	if j.wait() {
+		close(j.reader.chunks)
+	}
+	<-j.done
+	return j.err
+}
+
+// abort stops the decoder without sending anything more, when the body is not going to be completed.
+func (j *jsonRewriter) abort() {
+	j.once.Do(func() { close(j.reader.abort) })
+}
+
+// wait waits for the decoder to ask for the next chunk. It returns false if the decoder has stopped.
+func (j *jsonRewriter) wait() bool {
+	if j.waiting {
+		return true
+	}
+	select {
+	case <-j.reader.need:
+		j.waiting = true
+		return true
+	case <-j.done:
+		return false
+	}
+}
+
+// run decodes the body and sends it to the underlying writer.
+func (j *jsonRewriter) run() {
+	defer close(j.done)
+
+	err := j.rewrite()
+	if werr, ok := err.(writeError); ok {
+		j.err = werr.err
+		return
+	}
+	if err == errJSONAborted {
+		return
+	}
+	if err != nil {
+		j.decodeErr = err
+		if j.stopOnError {
+			return
+		}
+	}
+
+	// Send what follows the last value, such as a trailing newline, or the rest of a body which failed
+	// to decode as is.
+	buf := make([]byte, 32<<10)
+	for {
+		if _, err := j.writer.Write(j.reader.takeAll()); err != nil {
+			j.err = err
+			return
+		}
+		if _, err := j.reader.Read(buf); err != nil {
+			return
+		}
+	}
+}
+
+// writeError wraps an error of the underlying writer, to tell it apart from a decoding error.
+type writeError struct {
+	err error
+}
+
+// Error implements the error interface.
+func (e writeError) Error() string {
+	return e.err.Error()
+}
+
+// rewrite decodes the body token by token, rewrites the string values found at the configured paths, and
+// sends the body up to the last decoded token.
+func (j *jsonRewriter) rewrite() error {
+	decoder := json.NewDecoder(j.reader)
+	decoder.UseNumber()
+
+	var frames []jsonFrame
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		raw := j.reader.take(decoder.InputOffset())
+
+		var top *jsonFrame
+		if len(frames) > 0 {
+			top = &frames[len(frames)-1]
+		}
+
+		switch value := token.(type) {
+		case json.Delim:
+			switch value {
+			case '{':
+				frames = append(frames, jsonFrame{object: true, expectKey: true})
+			case '[':
+				frames = append(frames, jsonFrame{})
+			default:
+				frames = frames[:len(frames)-1]
+				if len(frames) > 0 {
+					frames[len(frames)-1].expectKey = true
+				}
+			}
+
+		case string:
+			if top != nil && top.object && top.expectKey {
+				top.key = value
+				top.expectKey = false
+				break
+			}
+			if top != nil {
+				top.expectKey = true
+			}
+			if j.matches(frames) {
+				raw = j.rewriteString(raw, value)
+			}
+
+		default:
+			if top != nil {
+				top.expectKey = true
+			}
+		}
+
+		if _, err := j.writer.Write(raw); err != nil {
+			return writeError{err: err}
+		}
+	}
+}
+
+// matches reports whether the value being decoded is at one of the configured paths.
+func (j *jsonRewriter) matches(frames []jsonFrame) bool {
+	var keys []string
+	for _, frame := range frames {
+		if frame.object {
+			keys = append(keys, frame.key)
+		}
+	}
+
+	for _, path := range j.response.jsonPaths {
+		if len(path) != len(keys) {
+			continue
+		}
+		matched := true
+		for i, segment := range path {
+			if segment != "*" && segment != keys[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteString applies the rewrites to a string value, raw being the bytes of its token including the
+// separators and spaces preceding it. The token is kept as is when the value is not modified.
+func (j *jsonRewriter) rewriteString(raw []byte, value string) []byte {
+	rewritten, modified := j.response.rewrite([]byte(value))
+	if !modified {
+		return raw
+	}
+
+	var encoded bytes.Buffer
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(string(rewritten)); err != nil {
+		return raw
+	}
+
+	start := bytes.IndexByte(raw, '"')
+	out := make([]byte, 0, start+encoded.Len())
+	out = append(out, raw[:start]...)
+	return append(out, bytes.TrimSuffix(encoded.Bytes(), []byte("\n"))...)
+}
@@ -0,0 +1,130 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+)
+
+func TestJSONRewriter(t *testing.T) {
+	tests := []struct {
+		desc    string
+		paths   []string
+		body    string
+		expBody string
+	}{
+		{
+			desc:    "should rewrite the values at the given path only",
+			paths:   []string{"data.url"},
+			body:    `{"url": "http://foo", "data": {"url": "http://foo", "other": "http://foo"}}`,
+			expBody: `{"url": "http://foo", "data": {"url": "http://bar", "other": "http://foo"}}`,
+		},
+		{
+			desc:    "should traverse arrays transparently",
+			paths:   []string{"items.url"},
+			body:    `{"items": [{"url": "foo"}, {"url": "foo", "n": 1}], "url": "foo"}`,
+			expBody: `{"items": [{"url": "bar"}, {"url": "bar", "n": 1}], "url": "foo"}`,
+		},
+		{
+			desc:    "should match any key with a wildcard",
+			paths:   []string{"*.name"},
+			body:    `{"a": {"name": "foo"}, "b": {"name": "foo"}, "name": "foo"}`,
+			expBody: `{"a": {"name": "bar"}, "b": {"name": "bar"}, "name": "foo"}`,
+		},
+		{
+			desc:    "should not rewrite keys",
+			paths:   []string{"foo"},
+			body:    `{"foo": {"foo": "foo"}}`,
+			expBody: `{"foo": {"foo": "foo"}}`,
+		},
+		{
+			desc:    "should keep numbers, spaces and escapes as they are",
+			paths:   []string{"url"},
+			body:    "{\n  \"n\": 1.50e+10,\n  \"s\": \"\\u00e9\\/\",\n  \"url\" : \"foo\\u00e9\"\n}\n",
+			expBody: "{\n  \"n\": 1.50e+10,\n  \"s\": \"\\u00e9\\/\",\n  \"url\" : \"baré\"\n}\n",
+		},
+		{
+			desc:    "should rewrite each value of a stream of documents",
+			paths:   []string{"url"},
+			body:    "{\"url\": \"foo\"}\n{\"url\": \"foo\"}\n",
+			expBody: "{\"url\": \"bar\"}\n{\"url\": \"bar\"}\n",
+		},
+		{
+			desc:    "should send the rest of an invalid document as is",
+			paths:   []string{"url"},
+			body:    `{"url": "foo", "x": foo, "url": "foo"}`,
+			expBody: `{"url": "bar", "x": foo, "url": "foo"}`,
+		},
+		{
+			desc:    "should send a truncated document as is",
+			paths:   []string{"url"},
+			body:    `{"url": "foo", "other": "fo`,
+			expBody: `{"url": "bar", "other": "fo`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			paths, err := parseJSONPaths(test.paths)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rewrites := []parsedRewrite{{regex: regexp.MustCompile("foo"), replacement: []byte("bar")}}
+			response := &parsedResponse{rewrites: rewrites, passes: optimizePasses(rewrites), jsonPaths: paths}
+
+			// The result must not depend on how the body is split in writes.
+			for size := 1; size <= len(test.body); size++ {
+				var out bytes.Buffer
+				rewriter := newJSONRewriter(&out, response, false)
+				for i := 0; i < len(test.body); i += size {
+					end := i + size
+					if end > len(test.body) {
+						end = len(test.body)
+					}
+					if _, err := rewriter.Write([]byte(test.body[i:end])); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := rewriter.Close(); err != nil {
+					t.Fatal(err)
+				}
+
+				if out.String() != test.expBody {
+					t.Fatalf("with writes of %d bytes, got body %q, want %q", size, out.String(), test.expBody)
+				}
+			}
+		})
+	}
+}
+
+func TestJSONRewriter_abort(t *testing.T) {
+	rewrites := []parsedRewrite{{regex: regexp.MustCompile("foo"), replacement: []byte("bar")}}
+	response := &parsedResponse{rewrites: rewrites, passes: optimizePasses(rewrites), jsonPaths: [][]string{{"url"}}}
+
+	var out bytes.Buffer
+	rewriter := newJSONRewriter(&out, response, false)
+	if _, err := rewriter.Write([]byte(`{"url": "foo", "other": `)); err != nil {
+		t.Fatal(err)
+	}
+	rewriter.abort()
+	<-rewriter.done
+
+	// Nothing is sent after the last complete token.
+	if out.String() != `{"url": "bar", "other"` {
+		t.Errorf("got body %q, want %q", out.String(), `{"url": "bar", "other"`)
+	}
+}
+
+func TestParseJSONPaths(t *testing.T) {
+	paths, err := parseJSONPaths([]string{"a.b", "*.c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 || len(paths[0]) != 2 || paths[1][0] != "*" {
+		t.Errorf("got paths %q", paths)
+	}
+
+	if _, err := parseJSONPaths([]string{"a..b"}); err == nil {
+		t.Error("expected an error for an empty key")
+	}
+}
@@ -0,0 +1,122 @@
+package traefik_responsebodyrewrite
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// jsonAPIContentType is the media type of the JSON:API documents.
+const jsonAPIContentType = "application/vnd.api+json"
+
+// JSONAPIErrors reshapes the bodies of a response block into JSON:API error documents.
+type JSONAPIErrors struct {
+	// Title is the title of the error, the status text of the response if empty.
+	Title string `json:"title,omitempty"`
+	// Detail is a regex extracting the detail of the error from the body: the text of its first capture group,
+	// or of the whole match without group. The error has no detail if it is empty or doesn't match.
+	Detail string `json:"detail,omitempty"`
+}
+
+// parsedJSONAPIErrors is a parsed JSONAPIErrors.
+type parsedJSONAPIErrors struct {
+	title  string
+	detail *regexp.Regexp
+}
+
+// jsonAPIError is an error object of a JSON:API error document.
+type jsonAPIError struct {
+	Status string `json:"status"`
+	Title  string `json:"title,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// parseJSONAPIErrors parses the jsonapiErrors option of a response block, nil if it has none.
+func parseJSONAPIErrors(jsonAPIErrors *JSONAPIErrors) (*parsedJSONAPIErrors, error) {
+	if jsonAPIErrors == nil {
+		return nil, nil
+	}
+	parsed := &parsedJSONAPIErrors{title: jsonAPIErrors.Title}
+	if jsonAPIErrors.Detail != "" {
+		detail, err := regexp.Compile(jsonAPIErrors.Detail)
+		if err != nil {
+			return nil, fmt.Errorf("invalid detail %q: %w", jsonAPIErrors.Detail, err)
+		}
+		parsed.detail = detail
+	}
+	return parsed, nil
+}
+
+// isJSONAPIErrorDocument reports whether body is already a JSON:API error document: an object without data,
+// whose errors member is a non-empty array of objects.
+func isJSONAPIErrorDocument(body []byte) bool {
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(body, &document); err != nil {
+		return false
+	}
+	if _, ok := document["data"]; ok {
+		return false
+	}
+	var errors []map[string]json.RawMessage
+	if err := json.Unmarshal(document["errors"], &errors); err != nil || len(errors) == 0 {
+		return false
+	}
+	for _, object := range errors {
+		if object == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// document returns the JSON:API error document of a response with the given status code and body.
+func (j *parsedJSONAPIErrors) document(statusCode int, body []byte) []byte {
+	object := jsonAPIError{Status: strconv.Itoa(statusCode), Title: j.title}
+	if object.Title == "" {
+		object.Title = http.StatusText(statusCode)
+	}
+	if j.detail != nil {
+		if match := j.detail.FindSubmatch(body); match != nil {
+			object.Detail = string(match[0])
+			if len(match) > 1 {
+				object.Detail = string(match[1])
+			}
+		}
+	}
+	// Marshaling strings can't fail.
+	document, _ := json.Marshal(struct {
+		Errors []jsonAPIError `json:"errors"`
+	}{Errors: []jsonAPIError{object}})
+	return document
+}
+
+// String describes the error documents in the debug dump.
+func (j *parsedJSONAPIErrors) String() string {
+	title := j.title
+	if title == "" {
+		title = "the status text"
+	}
+	if j.detail == nil {
+		return "errors titled " + title
+	}
+	return fmt.Sprintf("errors titled %s, with the detail matching %s", title, j.detail)
+}
+
+func (j *parsedJSONAPIErrors) option() string { return "jsonapiErrors" }
+
+// changesBody implements the transformer interface.
+func (j *parsedJSONAPIErrors) changesBody() bool { return true }
+
+// transform implements the bodyTransformer interface: once rewritten, the bodies which are not already
+// JSON:API error documents are replaced with one, its Content-Type being set along.
+func (j *parsedJSONAPIErrors) transform(rw *responseWriter, body []byte) ([]byte, bool, bool, []int, error) {
+	body, modified, complete, replaced, err := rw.middleware.rewriteBody(rw.response, body, rw.request)
+	if !isJSONAPIErrorDocument(body) {
+		body = j.document(rw.code, body)
+		modified = true
+		rw.ResponseWriter.Header().Set("Content-Type", jsonAPIContentType)
+	}
+	return body, modified, complete, replaced, err
+}
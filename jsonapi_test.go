@@ -0,0 +1,126 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsJSONAPIErrorDocument(t *testing.T) {
+	tests := []struct {
+		body string
+		exp  bool
+	}{
+		{body: `{"errors":[{"status":"404","title":"Not Found"}]}`, exp: true},
+		{body: `{"errors":[{"status":"404"}],"meta":{"requestId":"42"}}`, exp: true},
+		{body: `{"errors":[]}`},
+		{body: `{"errors":["not found"]}`},
+		{body: `{"errors":[null]}`},
+		{body: `{"errors":"not found"}`},
+		{body: `{"data":null,"errors":[{"status":"404"}]}`},
+		{body: `{"error":"not found"}`},
+		{body: `not found`},
+	}
+	for _, test := range tests {
+		if got := isJSONAPIErrorDocument([]byte(test.body)); got != test.exp {
+			t.Errorf("got %t for %s, want %t", got, test.body, test.exp)
+		}
+	}
+}
+
+func TestServeHTTP_jsonAPIErrors(t *testing.T) {
+	tests := []struct {
+		desc           string
+		jsonAPIErrors  JSONAPIErrors
+		rewrites       []Rewrite
+		stream         bool
+		body           string
+		expBody        string
+		expContentType string
+		expErr         string
+	}{
+		{
+			desc:           "status text",
+			body:           "Internal Server Error",
+			expBody:        `{"errors":[{"status":"500","title":"Internal Server Error"}]}`,
+			expContentType: "application/vnd.api+json",
+		},
+		{
+			desc:           "detail capture",
+			jsonAPIErrors:  JSONAPIErrors{Title: "Upstream failure", Detail: `"error":\s*"([^"]*)"`},
+			body:           `{"error": "order service unavailable", "trace": "..."}`,
+			expBody:        `{"errors":[{"status":"500","title":"Upstream failure","detail":"order service unavailable"}]}`,
+			expContentType: "application/vnd.api+json",
+		},
+		{
+			desc:           "detail match",
+			jsonAPIErrors:  JSONAPIErrors{Detail: `order service \w+`},
+			body:           `{"error": "order service unavailable"}`,
+			expBody:        `{"errors":[{"status":"500","title":"Internal Server Error","detail":"order service unavailable"}]}`,
+			expContentType: "application/vnd.api+json",
+		},
+		{
+			desc:           "detail of the rewritten body",
+			jsonAPIErrors:  JSONAPIErrors{Detail: `"error":\s*"([^"]*)"`},
+			rewrites:       []Rewrite{{Regex: `db\.internal\.local`, Replacement: "the database"}},
+			body:           `{"error": "can't reach db.internal.local"}`,
+			expBody:        `{"errors":[{"status":"500","title":"Internal Server Error","detail":"can't reach the database"}]}`,
+			expContentType: "application/vnd.api+json",
+		},
+		{
+			desc:           "no detail match",
+			jsonAPIErrors:  JSONAPIErrors{Detail: `"message":"([^"]*)"`},
+			body:           `{"error": "order service unavailable"}`,
+			expBody:        `{"errors":[{"status":"500","title":"Internal Server Error"}]}`,
+			expContentType: "application/vnd.api+json",
+		},
+		{
+			desc:           "already JSON:API",
+			body:           `{"errors":[{"status":"500","title":"Order service unavailable"}]}`,
+			expBody:        `{"errors":[{"status":"500","title":"Order service unavailable"}]}`,
+			expContentType: "application/json",
+		},
+		{
+			desc:          "invalid detail",
+			jsonAPIErrors: JSONAPIErrors{Detail: "("},
+			expErr:        "responses[0]: jsonapiErrors: invalid detail \"(\": error parsing regexp: missing closing ): `(`",
+		},
+		{
+			desc:     "streaming mode",
+			rewrites: []Rewrite{{Regex: "a", Replacement: "b"}},
+			stream:   true,
+			expErr:   "responses[0]: jsonapiErrors can't be used with stream, jsonPaths, rewriteFirstBytes or rewriteMultipart",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			jsonAPIErrors := test.jsonAPIErrors
+			response := Response{Status: "500-599", Rewrites: test.rewrites, Stream: test.stream, JSONAPIErrors: &jsonAPIErrors}
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", "application/json")
+				rw.WriteHeader(http.StatusInternalServerError)
+				_, _ = rw.Write([]byte(test.body))
+			}), &Config{Responses: []Response{response}}, "rewriteBody")
+			if test.expErr != "" {
+				if err == nil || err.Error() != test.expErr {
+					t.Errorf("got error %v, want %q", err, test.expErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+			if contentType := recorder.Header().Get("Content-Type"); contentType != test.expContentType {
+				t.Errorf("got Content-Type %q, want %q", contentType, test.expContentType)
+			}
+		})
+	}
+}
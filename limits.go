@@ -0,0 +1,89 @@
+package traefik_responsebodyrewrite
+
+import (
+	"fmt"
+)
+
+// The limits on the size of the configuration when they are not set. They leave room for the configurations
+// written by hand, while keeping a generated one from blocking the application of the configuration for
+// minutes while its patterns are compiled.
+const (
+	defaultMaxResponses           = 200
+	defaultMaxRewritesPerResponse = 1000
+	defaultMaxPatternLength       = 16384
+)
+
+// ruleLimits are the limits on the number of response blocks and rules, and on the length of the patterns,
+// checked before the patterns are compiled.
+type ruleLimits struct {
+	maxResponses     int
+	maxRewrites      int
+	maxPatternLength int
+}
+
+// parseRuleLimits parses the maxResponses, maxRewritesPerResponse and maxPatternLength options, zero meaning
+// their default.
+func parseRuleLimits(config *Config) (ruleLimits, error) {
+	limits := ruleLimits{
+		maxResponses:     defaultMaxResponses,
+		maxRewrites:      defaultMaxRewritesPerResponse,
+		maxPatternLength: defaultMaxPatternLength,
+	}
+	for _, option := range []struct {
+		name  string
+		value int
+		limit *int
+	}{
+		{name: "maxResponses", value: config.MaxResponses, limit: &limits.maxResponses},
+		{name: "maxRewritesPerResponse", value: config.MaxRewritesPerResponse, limit: &limits.maxRewrites},
+		{name: "maxPatternLength", value: config.MaxPatternLength, limit: &limits.maxPatternLength},
+	} {
+		if option.value < 0 {
+			return ruleLimits{}, fmt.Errorf("invalid %s %d: must not be negative", option.name, option.value)
+		}
+		if option.value > 0 {
+			*option.limit = option.value
+		}
+	}
+	return limits, nil
+}
+
+// checkResponses checks the number of response blocks against maxResponses.
+func (l ruleLimits) checkResponses(count int) error {
+	if count > l.maxResponses {
+		return fmt.Errorf("responses: %d response blocks exceed maxResponses of %d", count, l.maxResponses)
+	}
+	return nil
+}
+
+// checkRewrites checks the number of rewrites against maxRewritesPerResponse, and the length of their patterns
+// against maxPatternLength. Its errors start with the name of the offending field, as those of compileRewrites.
+func (l ruleLimits) checkRewrites(rewrites []Rewrite) error {
+	if len(rewrites) > l.maxRewrites {
+		return fmt.Errorf("rewrites: %d rules exceed maxRewritesPerResponse of %d", len(rewrites), l.maxRewrites)
+	}
+	for i, rewrite := range rewrites {
+		if err := l.checkPattern(rewrite.Regex); err != nil {
+			return fmt.Errorf("%s.%w", rewriteLabel(i, rewrite), err)
+		}
+	}
+	return nil
+}
+
+// checkPattern checks the length of a pattern against maxPatternLength.
+func (l ruleLimits) checkPattern(pattern string) error {
+	if len(pattern) > l.maxPatternLength {
+		return fmt.Errorf("regex: pattern of %d bytes exceeds maxPatternLength of %d", len(pattern), l.maxPatternLength)
+	}
+	return nil
+}
+
+// compiledRules returns the number of rules compiled for the response blocks, the global rewrites, applied by
+// all of them, being counted once.
+func compiledRules(global globalRewrites, responses []parsedResponse) int {
+	count := len(global.rewrites)
+	for i := range responses {
+		count += len(responses[i].rewrites) - len(global.rewrites)
+	}
+	return count
+}
@@ -0,0 +1,115 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// compileLogPattern matches the line logged with the number of compiled rules, whose duration varies.
+var compileLogPattern = regexp.MustCompile(`(?m)^INFO: [^ ]+: compiled \d+ rules of \d+ response blocks in [^ ]+\n`)
+
+// withoutCompileLog returns the logs without the line logged with the number of compiled rules.
+func withoutCompileLog(logs string) string {
+	return compileLogPattern.ReplaceAllString(logs, "")
+}
+
+func TestNew_ruleLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yml")
+	if err := os.WriteFile(path, []byte("- regex: foo\n  replacement: bar\n- regex: fooo\n  replacement: bar\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rewrite := Rewrite{Regex: "foo", Replacement: "bar"}
+
+	tests := []struct {
+		desc   string
+		config Config
+		expErr string
+	}{
+		{
+			desc:   "within the limits",
+			config: Config{MaxResponses: 1, MaxRewritesPerResponse: 2, MaxPatternLength: 3, Responses: []Response{{Status: "200", Rewrites: []Rewrite{rewrite, rewrite}}}},
+		},
+		{
+			desc:   "negative limit",
+			config: Config{MaxPatternLength: -1},
+			expErr: "invalid maxPatternLength -1: must not be negative",
+		},
+		{
+			desc:   "too many responses",
+			config: Config{MaxResponses: 1, Responses: []Response{{Status: "200", Rewrites: []Rewrite{rewrite}}, {Status: "404", Rewrites: []Rewrite{rewrite}}}},
+			expErr: "responses: 2 response blocks exceed maxResponses of 1",
+		},
+		{
+			desc:   "too many rewrites",
+			config: Config{MaxRewritesPerResponse: 1, Responses: []Response{{Status: "200", Rewrites: []Rewrite{rewrite, rewrite}}}},
+			expErr: "responses[0]: rewrites: 2 rules exceed maxRewritesPerResponse of 1",
+		},
+		{
+			desc:   "too many global rewrites",
+			config: Config{MaxRewritesPerResponse: 1, Rewrites: []Rewrite{rewrite, rewrite}},
+			expErr: "rewrites: 2 rules exceed maxRewritesPerResponse of 1",
+		},
+		{
+			desc:   "too long pattern",
+			config: Config{MaxPatternLength: 2, Responses: []Response{{Name: "ok", Status: "200", Rewrites: []Rewrite{rewrite}}}},
+			expErr: `responses[0] "ok": rewrites[0].regex: pattern of 3 bytes exceeds maxPatternLength of 2`,
+		},
+		{
+			desc:   "too many rewrites in the rulesFile",
+			config: Config{MaxRewritesPerResponse: 1, Responses: []Response{{Status: "200", RulesFile: path}}},
+			expErr: fmt.Sprintf("responses[0]: rulesFile %q: 2 rules exceed maxRewritesPerResponse of 1", path),
+		},
+		{
+			desc:   "too many rewrites with the rulesFile",
+			config: Config{MaxRewritesPerResponse: 2, Responses: []Response{{Status: "200", RulesFile: path, Rewrites: []Rewrite{rewrite}}}},
+			expErr: "responses[0]: rewrites: 3 rules with those of the rulesFile exceed maxRewritesPerResponse of 2",
+		},
+		{
+			desc:   "too long pattern in the rulesFile",
+			config: Config{MaxPatternLength: 3, Responses: []Response{{Status: "200", RulesFile: path}}},
+			expErr: fmt.Sprintf("responses[0]: rulesFile %q: line 3: rewrites[1].regex: pattern of 4 bytes exceeds maxPatternLength of 3", path),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := New(context.Background(), http.NotFoundHandler(), &test.config, "rewriteBody")
+			if test.expErr == "" && err != nil {
+				t.Errorf("got error %v, want none", err)
+			}
+			if test.expErr != "" && (err == nil || err.Error() != test.expErr) {
+				t.Errorf("got error %v, want %q", err, test.expErr)
+			}
+		})
+	}
+}
+
+func TestNew_compileLog(t *testing.T) {
+	var logs bytes.Buffer
+	_, err := NewMiddleware(http.NotFoundHandler(), WithConfig(&Config{
+		Always:   true,
+		Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+		Responses: []Response{
+			{Status: "200", Rewrites: []Rewrite{{Regex: "bar", Replacement: "baz"}, {Regex: "baz", Replacement: "qux"}}},
+			{Status: "404", Rewrites: []Rewrite{{Regex: "qux", Replacement: "foo"}}},
+		},
+	}), WithName("rewriteBody"), WithLogger(log.New(&logs, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The global rewrites are counted once, although they are applied by every response block.
+	if expected := "INFO: rewriteBody: compiled 4 rules of 2 response blocks in "; !strings.HasPrefix(logs.String(), expected) {
+		t.Errorf("got logs %q, want them to start with %q", logs.String(), expected)
+	}
+	if withoutCompileLog(logs.String()) != "" {
+		t.Errorf("got logs %q, want the line with the number of compiled rules only", logs.String())
+	}
+}
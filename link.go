@@ -0,0 +1,129 @@
+package traefik_responsebodyrewrite
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// LinkHeader rewrites the URI-references of the Link header of the responses, as defined by RFC 8288.
+type LinkHeader struct {
+	// Regex matches the URI-references to rewrite, each match being replaced with Replacement, which can
+	// refer to its capture groups as $1 or ${name}.
+	Regex       string `json:"regex,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+	// Origin is the scheme and the host the absolute http and https URI-references are rewritten to, in place
+	// of Regex, e.g. "https://api.example.com". If both are empty, it is the public origin of the request, from
+	// its X-Forwarded-Proto and X-Forwarded-Host headers, or else from its own scheme and Host.
+	Origin string `json:"origin,omitempty"`
+}
+
+// parsedLinkHeader is a parsed LinkHeader.
+type parsedLinkHeader struct {
+	// regex is the regex of the URI-references to rewrite, nil if their origin is rewritten instead.
+	regex       *regexp.Regexp
+	replacement string
+	// origin is the configured origin, empty if it is derived from the request.
+	origin string
+}
+
+// parseLinkHeader parses the linkHeader option of a response block, nil if it has none.
+func parseLinkHeader(config *LinkHeader) (*parsedLinkHeader, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if config.Regex != "" && config.Origin != "" {
+		return nil, errors.New("regex and origin can't both be set")
+	}
+	if config.Regex == "" && config.Replacement != "" {
+		return nil, errors.New("replacement can only be used with regex")
+	}
+	var regex *regexp.Regexp
+	if config.Regex != "" {
+		var err error
+		if regex, err = regexp.Compile(config.Regex); err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %w", config.Regex, err)
+		}
+	}
+	origin, err := parseOrigin(config.Origin)
+	if err != nil {
+		return nil, err
+	}
+	return &parsedLinkHeader{regex: regex, replacement: config.Replacement, origin: origin}, nil
+}
+
+// rewriteHeader rewrites the URI-references of the Link header values of a response to req.
+func (l *parsedLinkHeader) rewriteHeader(header http.Header, req *http.Request) {
+	values := header.Values("Link")
+	if len(values) == 0 {
+		return
+	}
+
+	origin := l.origin
+	if l.regex == nil && origin == "" {
+		origin = requestOrigin(req)
+	}
+	rewritten := make([]string, len(values))
+	for i, value := range values {
+		rewritten[i] = rewriteLinkValue(value, func(uri string) string {
+			if l.regex != nil {
+				return l.regex.ReplaceAllString(uri, l.replacement)
+			}
+			if urlPath, absolute := httpURLPath(uri); absolute {
+				return origin + urlPath
+			}
+			return uri
+		})
+	}
+	header["Link"] = rewritten
+}
+
+// rewriteLinkValue applies rewrite to the URI-references of the comma-separated links of a Link header value,
+// the rest of the value, the parameters of the links included, being left byte for byte. The value is left
+// as is from the first link which is not made of a URI-reference between angle brackets followed by
+// parameters.
+func rewriteLinkValue(value string, rewrite func(uri string) string) string {
+	var out strings.Builder
+	sent := 0
+	for i := 0; i < len(value); {
+		// Each link starts with its URI-reference, after the separators of the previous one.
+		for i < len(value) && (value[i] == ' ' || value[i] == '\t' || value[i] == ',') {
+			i++
+		}
+		if i == len(value) {
+			break
+		}
+		if value[i] != '<' {
+			break
+		}
+		end := strings.IndexByte(value[i:], '>')
+		if end < 0 {
+			break
+		}
+		uriStart, uriEnd := i+1, i+end
+		if uri, rewritten := value[uriStart:uriEnd], rewrite(value[uriStart:uriEnd]); rewritten != uri {
+			out.WriteString(value[sent:uriStart])
+			out.WriteString(rewritten)
+			sent = uriEnd
+		}
+
+		// The parameters end at the first comma out of a quoted string.
+		i = uriEnd + 1
+		for quoted := false; i < len(value) && (quoted || value[i] != ','); i++ {
+			switch {
+			case quoted && value[i] == '\\':
+				i++
+			case value[i] == '"':
+				quoted = !quoted
+			}
+		}
+	}
+
+	if sent == 0 {
+		return value
+	}
+	out.WriteString(value[sent:])
+	return out.String()
+}
@@ -0,0 +1,133 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRewriteLinkValue(t *testing.T) {
+	rewrite := func(uri string) string {
+		return strings.Replace(uri, "internal.local", "example.com", 1)
+	}
+	tests := []struct {
+		desc     string
+		value    string
+		expValue string
+	}{
+		{
+			desc:     "single link",
+			value:    `<http://internal.local/items?page=2>; rel="next"`,
+			expValue: `<http://example.com/items?page=2>; rel="next"`,
+		},
+		{
+			desc:     "several links",
+			value:    `<http://internal.local/items?page=2>; rel="next", <http://internal.local/items?page=9>;rel=last ;title*=UTF-8'de'n%c3%a4chstes`,
+			expValue: `<http://example.com/items?page=2>; rel="next", <http://example.com/items?page=9>;rel=last ;title*=UTF-8'de'n%c3%a4chstes`,
+		},
+		{
+			desc:     "comma in a quoted parameter",
+			value:    `<http://internal.local/a>; title="a, <b> \"c, d\""; rel=prev,<http://internal.local/b>; rel=next`,
+			expValue: `<http://example.com/a>; title="a, <b> \"c, d\""; rel=prev,<http://example.com/b>; rel=next`,
+		},
+		{
+			desc:     "comma in the URI-reference",
+			value:    `<http://internal.local/a,b>; rel=next`,
+			expValue: `<http://example.com/a,b>; rel=next`,
+		},
+		{
+			desc:     "malformed link",
+			value:    `<http://internal.local/a>; rel=prev, http://internal.local/b; rel=next`,
+			expValue: `<http://example.com/a>; rel=prev, http://internal.local/b; rel=next`,
+		},
+		{
+			desc:     "unterminated URI-reference",
+			value:    `<http://internal.local/a; rel=next`,
+			expValue: `<http://internal.local/a; rel=next`,
+		},
+		{
+			desc:     "unchanged",
+			value:    `</items?page=2>; rel="next"`,
+			expValue: `</items?page=2>; rel="next"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if value := rewriteLinkValue(test.value, rewrite); value != test.expValue {
+				t.Errorf("got value %q, want %q", value, test.expValue)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_linkHeader(t *testing.T) {
+	tests := []struct {
+		desc     string
+		config   LinkHeader
+		dryRun   bool
+		expLinks []string
+	}{
+		{
+			desc: "public origin",
+			expLinks: []string{
+				`<https://api.example.com/items?page=2>; rel="next"`,
+				`</items?page=1>; rel="first", <https://api.example.com/items?page=9>; rel="last"`,
+			},
+		},
+		{
+			desc:   "regex",
+			config: LinkHeader{Regex: `^http://internal\.local(:\d+)?/items`, Replacement: "/api/items"},
+			expLinks: []string{
+				`</api/items?page=2>; rel="next"`,
+				`</items?page=1>; rel="first", </api/items?page=9>; rel="last"`,
+			},
+		},
+		{
+			desc:   "dry-run mode",
+			dryRun: true,
+			expLinks: []string{
+				`<http://internal.local:8080/items?page=2>; rel="next"`,
+				`</items?page=1>; rel="first", <http://internal.local:8080/items?page=9>; rel="last"`,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := test.config
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Add("Link", `<http://internal.local:8080/items?page=2>; rel="next"`)
+				rw.Header().Add("Link", `</items?page=1>; rel="first", <http://internal.local:8080/items?page=9>; rel="last"`)
+				rw.Header().Set("Content-Type", "application/octet-stream")
+				_, _ = rw.Write([]byte("internal.local"))
+			}), &Config{Responses: []Response{{Status: "200", LinkHeader: &config, DryRun: test.dryRun}}}, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/items", nil)
+			req.Header.Set("X-Forwarded-Proto", "https")
+			req.Header.Set("X-Forwarded-Host", "api.example.com")
+			handler.ServeHTTP(recorder, req)
+
+			if links := recorder.Header().Values("Link"); !reflect.DeepEqual(links, test.expLinks) {
+				t.Errorf("got Link headers %q, want %q", links, test.expLinks)
+			}
+			if body := recorder.Body.String(); body != "internal.local" {
+				t.Errorf("got body %q, want it unmodified", body)
+			}
+		})
+	}
+
+	_, err := New(context.Background(), http.NotFoundHandler(), &Config{Responses: []Response{{
+		Status:     "200",
+		LinkHeader: &LinkHeader{Regex: "internal", Origin: "https://api.example.com"},
+	}}}, "rewriteBody")
+	expErr := "responses[0]: linkHeader: regex and origin can't both be set"
+	if err == nil || err.Error() != expErr {
+		t.Errorf("got error %v, want %q", err, expErr)
+	}
+}
@@ -0,0 +1,432 @@
+package traefik_responsebodyrewrite
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevel is the severity of a message, a middleware only logging the messages up to its configured level.
+type logLevel int
+
+const (
+	levelError logLevel = iota
+	levelWarn
+	levelInfo
+	levelDebug
+)
+
+// logLevels are the values of the logLevel option.
+var logLevels = map[string]logLevel{
+	"error": levelError,
+	"warn":  levelWarn,
+	"info":  levelInfo,
+	"debug": levelDebug,
+}
+
+// String returns the name of the level.
+func (l logLevel) String() string {
+	switch l {
+	case levelError:
+		return "error"
+	case levelWarn:
+		return "warn"
+	case levelInfo:
+		return "info"
+	default:
+		return "debug"
+	}
+}
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// parseLogLevel parses the logLevel option, an empty value meaning info.
+func parseLogLevel(level string) (logLevel, error) {
+	if level == "" {
+		return levelInfo, nil
+	}
+	parsed, ok := logLevels[level]
+	if !ok {
+		return 0, fmt.Errorf("invalid logLevel %q: must be error, warn, info or debug", level)
+	}
+	return parsed, nil
+}
+
+// responseLogLevel parses the logLevel of a response block, the logLevel of the middleware applying when it
+// is empty.
+func responseLogLevel(level, middlewareLevel string) (logLevel, error) {
+	if level == "" {
+		level = middlewareLevel
+	}
+	return parseLogLevel(level)
+}
+
+// validateLogFormat checks the logFormat option, an empty value meaning text.
+func validateLogFormat(format string) error {
+	switch format {
+	case "", logFormatText, logFormatJSON:
+		return nil
+	default:
+		return fmt.Errorf("invalid logFormat %q: must be %q or %q", format, logFormatText, logFormatJSON)
+	}
+}
+
+// LogRateLimit is the maximum number of messages logged per second at each level, the messages above the
+// limit being dropped, after a burst of up to 10 messages or the limit if higher. 0 means the default of the
+// level: 10 for warn, 1 for debug, and no limit for error and info. A negative value removes the limit.
+type LogRateLimit struct {
+	Error int `json:"error,omitempty"`
+	Warn  int `json:"warn,omitempty"`
+	Info  int `json:"info,omitempty"`
+	Debug int `json:"debug,omitempty"`
+}
+
+// Default rates of LogRateLimit, in messages per second.
+const (
+	defaultWarnLogRate  = 10
+	defaultDebugLogRate = 1
+)
+
+// minLogBurst is the minimum number of messages of a level logged at once, so that the messages about a
+// single response are not cut by a low rate.
+const minLogBurst = 10
+
+// logLimiter is the token bucket limiting the messages of a level, refilled with rate tokens per second up to
+// burst tokens. It doesn't allocate, so that dropping a message costs next to nothing.
+type logLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	// suppressed is the number of messages dropped since the last one logged.
+	suppressed int64
+	// report logs the number of messages dropped, once a token is back for them to be summarized, unless a
+	// message is logged before. timer is scheduled at that time after the first message dropped.
+	report func(suppressed int64)
+	timer  *time.Timer
+}
+
+// newLogLimiters returns the limiters of each level, nil for the levels without limit, which report the
+// messages they dropped to report.
+func newLogLimiters(limits LogRateLimit, report func(level logLevel, suppressed int64)) [levelDebug + 1]*logLimiter {
+	rates := [levelDebug + 1]int{
+		levelError: limits.Error,
+		levelWarn:  limits.Warn,
+		levelInfo:  limits.Info,
+		levelDebug: limits.Debug,
+	}
+	if rates[levelWarn] == 0 {
+		rates[levelWarn] = defaultWarnLogRate
+	}
+	if rates[levelDebug] == 0 {
+		rates[levelDebug] = defaultDebugLogRate
+	}
+
+	var limiters [levelDebug + 1]*logLimiter
+	now := time.Now()
+	for level, rate := range rates {
+		if rate <= 0 {
+			continue
+		}
+		burst := rate
+		if burst < minLogBurst {
+			burst = minLogBurst
+		}
+		level := logLevel(level)
+		limiters[level] = &logLimiter{
+			rate:   float64(rate),
+			burst:  float64(burst),
+			tokens: float64(burst),
+			last:   now,
+			report: func(suppressed int64) { report(level, suppressed) },
+		}
+	}
+	return limiters
+}
+
+// allow takes a token for a message at the given time. It returns whether the message can be logged, and
+// if so, the number of messages dropped since the previous one and not reported yet.
+func (l *logLimiter) allow(now time.Time) (bool, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens += elapsed.Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+	}
+	if l.tokens < 1 {
+		if l.suppressed == 0 && l.report != nil {
+			wait := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+			l.timer = time.AfterFunc(wait, l.flush)
+		}
+		l.suppressed++
+		return false, 0
+	}
+	l.tokens--
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	suppressed := l.suppressed
+	l.suppressed = 0
+	return true, suppressed
+}
+
+// flush reports the messages dropped since the last one logged, when no message has been logged since the
+// token to summarize them is back.
+func (l *logLimiter) flush() {
+	l.mu.Lock()
+	suppressed := l.suppressed
+	l.suppressed = 0
+	l.timer = nil
+	l.mu.Unlock()
+
+	if suppressed > 0 {
+		l.report(suppressed)
+	}
+}
+
+// newLogger returns a logger of the messages of a level, identified by the given prefix. The messages are
+// written to the standard output, or to output if not nil.
+func newLogger(output *log.Logger, prefix string) *log.Logger {
+	if output != nil {
+		return log.New(loggerWriter{logger: output, prefix: prefix + ": "}, "", 0)
+	}
+	return log.New(os.Stdout, prefix+": responsebodyrewrite: ", log.Ldate|log.Ltime)
+}
+
+// newJSONLogger returns the logger of the messages in the JSON format, written to the standard output, or
+// to output if not nil.
+func newJSONLogger(output *log.Logger) *log.Logger {
+	if output != nil {
+		return log.New(loggerWriter{logger: output}, "", 0)
+	}
+	return log.New(os.Stdout, "", 0)
+}
+
+// loggerWriter writes the messages of a level to a logger supplied with WithLogger, which adds its own
+// prefix and flags.
+type loggerWriter struct {
+	logger *log.Logger
+	prefix string
+}
+
+// Write implements the io.Writer interface, p being a single message.
+func (w loggerWriter) Write(p []byte) (int, error) {
+	if err := w.logger.Output(2, w.prefix+string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// defaultRequestIDHeader is the request header holding the ID of the requests when requestIdHeader is not set.
+const defaultRequestIDHeader = "X-Request-Id"
+
+// logFields are the fields of a message about a response, only logged separately in the JSON format.
+type logFields struct {
+	request      *http.Request
+	status       int
+	response     *parsedResponse
+	replacements []int
+	// modification is set for the lines of logModifications.
+	modification *bodyModification
+}
+
+// logEntry is a message logged in the JSON format.
+type logEntry struct {
+	Time            string `json:"time"`
+	Level           string `json:"level"`
+	Middleware      string `json:"middleware"`
+	Message         string `json:"msg"`
+	Method          string `json:"method,omitempty"`
+	Path            string `json:"path,omitempty"`
+	Status          int    `json:"status,omitempty"`
+	MatchedResponse *int   `json:"matched_response,omitempty"`
+	ResponseName    string `json:"response_name,omitempty"`
+	Replacements    []int  `json:"replacements,omitempty"`
+	RequestID       string `json:"request_id,omitempty"`
+	TraceID         string `json:"trace_id,omitempty"`
+	SpanID          string `json:"span_id,omitempty"`
+	// The fields of the lines of logModifications, which are always set.
+	TotalReplacements *int   `json:"total_replacements,omitempty"`
+	OriginalSize      *int64 `json:"original_size,omitempty"`
+	Size              *int64 `json:"size,omitempty"`
+}
+
+// logf logs a message of the given level, if not above the configured level.
+func (r *responsebodyrewrite) logf(level logLevel, fields logFields, format string, v ...interface{}) {
+	r.logfUpTo(r.logLevel, level, fields, format, v...)
+}
+
+// logfUpTo logs a message of the given level, if not above maxLevel.
+func (r *responsebodyrewrite) logfUpTo(maxLevel, level logLevel, fields logFields, format string, v ...interface{}) {
+	if level > maxLevel {
+		return
+	}
+	if limiter := r.logLimiters[level]; limiter != nil {
+		allowed, suppressed := limiter.allow(time.Now())
+		if !allowed {
+			return
+		}
+		if suppressed > 0 {
+			r.reportSuppressed(level, suppressed)
+		}
+	}
+	r.output(level, fields, format, v...)
+}
+
+// reportSuppressed logs the number of messages of a level dropped because of logRateLimit.
+func (r *responsebodyrewrite) reportSuppressed(level logLevel, suppressed int64) {
+	r.output(level, logFields{}, "%s: %d %s messages not logged because of logRateLimit", r.name, suppressed, level)
+}
+
+// output logs a message of the given level, whatever the configured level.
+func (r *responsebodyrewrite) output(level logLevel, fields logFields, format string, v ...interface{}) {
+	requestID := r.requestID(fields.request)
+	traceID, spanID := r.traceIDs(fields.request)
+	if r.jsonLogger == nil {
+		if requestID == "" && traceID == "" {
+			r.textLogger(level).Printf(format, v...)
+			return
+		}
+		message := fmt.Sprintf(format, v...)
+		if requestID != "" {
+			// The ID comes from the client, it is quoted so that it can't forge log lines.
+			message += fmt.Sprintf(" request_id=%q", requestID)
+		}
+		if traceID != "" {
+			// The trace IDs are validated hexadecimal strings.
+			message += " trace_id=" + traceID + " span_id=" + spanID
+		}
+		r.textLogger(level).Print(message)
+		return
+	}
+
+	entry := logEntry{
+		Time:       time.Now().Format(time.RFC3339Nano),
+		Level:      level.String(),
+		Middleware: r.name,
+		// The name of the middleware is a field of its own.
+		Message:      strings.TrimPrefix(fmt.Sprintf(format, v...), r.name+": "),
+		Status:       fields.status,
+		Replacements: fields.replacements,
+		RequestID:    requestID,
+		TraceID:      traceID,
+		SpanID:       spanID,
+	}
+	if fields.request != nil {
+		entry.Method = fields.request.Method
+		entry.Path = fields.request.URL.Path
+	}
+	if fields.response != nil {
+		index := fields.response.index
+		entry.MatchedResponse = &index
+		entry.ResponseName = fields.response.name
+	}
+	if m := fields.modification; m != nil {
+		entry.TotalReplacements = &m.replacements
+		entry.OriginalSize = &m.originalSize
+		entry.Size = &m.size
+	}
+	// The entry only holds strings and integers, it can't fail to be encoded.
+	line, _ := json.Marshal(entry)
+	r.jsonLogger.Print(string(line))
+}
+
+// requestID returns the ID of req in the requestIDHeader, empty if none.
+func (r *responsebodyrewrite) requestID(req *http.Request) string {
+	if req == nil || r.requestIDHeader == "" {
+		return ""
+	}
+	return req.Header.Get(r.requestIDHeader)
+}
+
+// textLogger returns the logger of the messages of the given level in the text format.
+func (r *responsebodyrewrite) textLogger(level logLevel) *log.Logger {
+	switch level {
+	case levelError:
+		return r.errorLogger
+	case levelWarn:
+		return r.warnLogger
+	case levelInfo:
+		return r.infoLogger
+	default:
+		return r.debugLogger
+	}
+}
+
+// errorf logs a message at the error level.
+func (r *responsebodyrewrite) errorf(format string, v ...interface{}) {
+	r.logf(levelError, logFields{}, format, v...)
+}
+
+// warnf logs a message at the warn level.
+func (r *responsebodyrewrite) warnf(format string, v ...interface{}) {
+	r.logf(levelWarn, logFields{}, format, v...)
+}
+
+// infof logs a message at the info level.
+func (r *responsebodyrewrite) infof(format string, v ...interface{}) {
+	r.logf(levelInfo, logFields{}, format, v...)
+}
+
+// debugf logs a message at the debug level. Hot paths should check debugEnabled first, the arguments being
+// allocated even if the message is not logged.
+func (r *responsebodyrewrite) debugf(format string, v ...interface{}) {
+	r.logf(levelDebug, logFields{}, format, v...)
+}
+
+// debugEnabled reports whether debug messages are logged, to skip computing them otherwise.
+func (r *responsebodyrewrite) debugEnabled() bool {
+	return r.logLevel >= levelDebug
+}
+
+// logFields returns the fields of the messages about the response.
+func (rw *responseWriter) logFields() logFields {
+	return logFields{request: rw.request, status: rw.code, response: rw.response}
+}
+
+// logLevel returns the level of the messages about the response: the logLevel of the response block which
+// matched it, if any, or else that of the middleware.
+func (rw *responseWriter) logLevel() logLevel {
+	switch {
+	case rw.response != nil:
+		return rw.response.logLevel
+	case rw.dryRun != nil:
+		return rw.dryRun.logLevel
+	default:
+		return rw.middleware.logLevel
+	}
+}
+
+// debugEnabled reports whether debug messages about the response are logged.
+func (rw *responseWriter) debugEnabled() bool {
+	return rw.logLevel() >= levelDebug
+}
+
+// warnf logs a message about the response at the warn level.
+func (rw *responseWriter) warnf(format string, v ...interface{}) {
+	rw.middleware.logfUpTo(rw.logLevel(), levelWarn, rw.logFields(), format, v...)
+}
+
+// infof logs a message about the response at the info level.
+func (rw *responseWriter) infof(format string, v ...interface{}) {
+	rw.middleware.logfUpTo(rw.logLevel(), levelInfo, rw.logFields(), format, v...)
+}
+
+// debugf logs a message about the response at the debug level.
+func (rw *responseWriter) debugf(format string, v ...interface{}) {
+	rw.middleware.logfUpTo(rw.logLevel(), levelDebug, rw.logFields(), format, v...)
+}
@@ -0,0 +1,458 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		level    string
+		expLevel logLevel
+		expErr   bool
+	}{
+		{level: "", expLevel: levelInfo},
+		{level: "error", expLevel: levelError},
+		{level: "warn", expLevel: levelWarn},
+		{level: "info", expLevel: levelInfo},
+		{level: "debug", expLevel: levelDebug},
+		{level: "DEBUG", expErr: true},
+		{level: "trace", expErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.level, func(t *testing.T) {
+			level, err := parseLogLevel(test.level)
+			if (err != nil) != test.expErr {
+				t.Fatalf("got error %v, want error %t", err, test.expErr)
+			}
+			if !test.expErr && level != test.expLevel {
+				t.Errorf("got level %d, want %d", level, test.expLevel)
+			}
+		})
+	}
+}
+
+func TestNew_invalidLogLevel(t *testing.T) {
+	_, err := New(context.Background(), http.NotFoundHandler(), &Config{LogLevel: "verbose"}, "rewriteBody")
+	if err == nil || !strings.Contains(err.Error(), "invalid logLevel") {
+		t.Errorf("got error %v, want invalid logLevel", err)
+	}
+}
+
+func TestNew_invalidLogFormat(t *testing.T) {
+	_, err := New(context.Background(), http.NotFoundHandler(), &Config{LogFormat: "logfmt"}, "rewriteBody")
+	if err == nil || !strings.Contains(err.Error(), "invalid logFormat") {
+		t.Errorf("got error %v, want invalid logFormat", err)
+	}
+}
+
+func TestServeHTTP_logLevel(t *testing.T) {
+	tests := []struct {
+		desc    string
+		level   string
+		status  int
+		expLogs []string
+	}{
+		{
+			desc:   "debug",
+			level:  "debug",
+			status: http.StatusOK,
+			expLogs: []string{
+				"rewriteBody: response 0 matches status 200 of /",
+				"rewriteBody: rewrite of / by response 0 replaced [2 0 1] matches",
+			},
+		},
+		{
+			desc:   "debug passthrough",
+			level:  "debug",
+			status: http.StatusNotFound,
+			expLogs: []string{
+				"rewriteBody: passing / through with status 404: no matching response",
+			},
+		},
+		{
+			desc:   "info",
+			level:  "info",
+			status: http.StatusOK,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				LogLevel: test.level,
+				Responses: []Response{{
+					Status: "200",
+					Rewrites: []Rewrite{
+						{Regex: "foo", Replacement: "bar"},
+						{Regex: "baz", Replacement: "qux"},
+						{Regex: "bar bar", Replacement: "foo"},
+					},
+				}},
+			}
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(test.status)
+				_, _ = rw.Write([]byte("foo foo"))
+			}
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var logs bytes.Buffer
+			handler.(*responsebodyrewrite).debugLogger = log.New(&logs, "", 0)
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			var expLogs string
+			for _, line := range test.expLogs {
+				expLogs += line + "\n"
+			}
+			if logs.String() != expLogs {
+				t.Errorf("got logs %q, want %q", logs.String(), expLogs)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_responseLogLevel(t *testing.T) {
+	tests := []struct {
+		desc    string
+		level   string
+		status  int
+		expLogs []string
+	}{
+		{
+			desc:   "debug response",
+			level:  "info",
+			status: http.StatusOK,
+			expLogs: []string{
+				"rewriteBody: response new matches status 200 of /",
+				"rewriteBody: rewrite of / by response new replaced [1] matches",
+			},
+		},
+		{desc: "other response", level: "info", status: http.StatusNotFound},
+		{desc: "no matching response", level: "info", status: http.StatusInternalServerError},
+		{desc: "error response", level: "debug", status: http.StatusNotFound},
+		{
+			desc:   "middleware level",
+			level:  "debug",
+			status: http.StatusInternalServerError,
+			expLogs: []string{
+				"rewriteBody: passing / through with status 500: no matching response",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				LogLevel: test.level,
+				Responses: []Response{
+					{Name: "new", Status: "200", LogLevel: "debug", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}},
+					{Name: "mature", Status: "404", LogLevel: "error", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}},
+				},
+			}
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(test.status)
+				_, _ = rw.Write([]byte("foo"))
+			}
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var logs bytes.Buffer
+			handler.(*responsebodyrewrite).debugLogger = log.New(&logs, "", 0)
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			var expLogs string
+			for _, line := range test.expLogs {
+				expLogs += line + "\n"
+			}
+			if logs.String() != expLogs {
+				t.Errorf("got logs %q, want %q", logs.String(), expLogs)
+			}
+		})
+	}
+
+	_, err := New(context.Background(), http.NotFoundHandler(), &Config{Responses: []Response{{Status: "200", LogLevel: "trace", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}}}}, "rewriteBody")
+	if expErr := `responses[0]: invalid logLevel "trace": must be error, warn, info or debug`; err == nil || err.Error() != expErr {
+		t.Errorf("got error %v, want %q", err, expErr)
+	}
+}
+
+func TestResponsebodyrewrite_warnf(t *testing.T) {
+	var logs bytes.Buffer
+	r := &responsebodyrewrite{
+		logLevel:    levelError,
+		errorLogger: log.New(&logs, "ERROR: ", 0),
+		warnLogger:  log.New(&logs, "WARN: ", 0),
+		infoLogger:  log.New(&logs, "INFO: ", 0),
+		debugLogger: log.New(&logs, "DEBUG: ", 0),
+	}
+	r.errorf("foo")
+	r.warnf("bar")
+	r.infof("baz")
+	r.debugf("qux")
+
+	if expected := "ERROR: foo\n"; logs.String() != expected {
+		t.Errorf("got logs %q, want %q", logs.String(), expected)
+	}
+}
+
+func TestServeHTTP_jsonLogFormat(t *testing.T) {
+	config := &Config{
+		LogLevel:  "debug",
+		LogFormat: "json",
+		Responses: []Response{{
+			Name:     "api",
+			Status:   "200",
+			Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+		}},
+	}
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("foo foo"))
+	}
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	handler.(*responsebodyrewrite).jsonLogger = log.New(&logs, "", 0)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/foo?bar", nil))
+
+	expected := []map[string]interface{}{
+		{
+			"level":            "debug",
+			"middleware":       "rewriteBody",
+			"msg":              "response api matches status 200 of /foo?bar",
+			"method":           "GET",
+			"path":             "/foo",
+			"status":           float64(200),
+			"matched_response": float64(0),
+			"response_name":    "api",
+		},
+		{
+			"level":            "debug",
+			"middleware":       "rewriteBody",
+			"msg":              "rewrite of /foo?bar by response api replaced [2] matches",
+			"method":           "GET",
+			"path":             "/foo",
+			"matched_response": float64(0),
+			"response_name":    "api",
+			"replacements":     []interface{}{float64(2)},
+		},
+	}
+	lines := strings.Split(strings.TrimSuffix(logs.String(), "\n"), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("got %d lines, want %d: %q", len(lines), len(expected), logs.String())
+	}
+	for i, line := range lines {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		if _, ok := entry["time"]; !ok {
+			t.Errorf("line %d: no time", i)
+		}
+		delete(entry, "time")
+		if !reflect.DeepEqual(entry, expected[i]) {
+			t.Errorf("line %d: got %v, want %v", i, entry, expected[i])
+		}
+	}
+}
+
+func TestResponseWriter_logMatchAllocs(t *testing.T) {
+	rw := &responseWriter{
+		code:       http.StatusOK,
+		middleware: &responsebodyrewrite{name: "rewriteBody", logLevel: levelInfo},
+		request:    httptest.NewRequest(http.MethodGet, "/", nil),
+		response:   &parsedResponse{},
+	}
+	if allocs := testing.AllocsPerRun(100, func() { rw.logMatch("no matching response") }); allocs != 0 {
+		t.Errorf("got %v allocations, want none", allocs)
+	}
+}
+
+func TestLogLimiter(t *testing.T) {
+	start := time.Now()
+	limiter := &logLimiter{rate: 2, burst: 3, tokens: 3, last: start}
+
+	for i := 0; i < 3; i++ {
+		if allowed, suppressed := limiter.allow(start); !allowed || suppressed != 0 {
+			t.Fatalf("message %d: got %t, %d, want it allowed within the burst", i, allowed, suppressed)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		if allowed, _ := limiter.allow(start.Add(100 * time.Millisecond)); allowed {
+			t.Fatalf("message %d: got it allowed once the burst is exhausted", i)
+		}
+	}
+	// Half a second refills a token at 2 messages per second.
+	if allowed, suppressed := limiter.allow(start.Add(500 * time.Millisecond)); !allowed || suppressed != 4 {
+		t.Errorf("got %t, %d, want the message allowed after 4 dropped", allowed, suppressed)
+	}
+	// The bucket doesn't hold more than the burst.
+	later := start.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		limiter.allow(later)
+	}
+	if allowed, _ := limiter.allow(later); allowed {
+		t.Error("got more messages than the burst allowed at once")
+	}
+}
+
+func TestNewLogLimiters(t *testing.T) {
+	limiters := newLogLimiters(LogRateLimit{Error: 20, Info: -1}, func(logLevel, int64) {})
+	expected := [levelDebug + 1][2]float64{
+		levelError: {20, 20},
+		levelWarn:  {defaultWarnLogRate, minLogBurst},
+		levelDebug: {defaultDebugLogRate, minLogBurst},
+	}
+	for level, limiter := range limiters {
+		if limiter == nil {
+			if expected[level] != [2]float64{} {
+				t.Errorf("%s: got no limiter, want %v", logLevel(level), expected[level])
+			}
+			continue
+		}
+		if got := [2]float64{limiter.rate, limiter.burst}; got != expected[level] {
+			t.Errorf("%s: got rate and burst %v, want %v", logLevel(level), got, expected[level])
+		}
+	}
+}
+
+func TestResponsebodyrewrite_logRateLimit(t *testing.T) {
+	var logs bytes.Buffer
+	r := &responsebodyrewrite{
+		name:       "rewriteBody",
+		logLevel:   levelWarn,
+		warnLogger: log.New(&logs, "WARN: ", 0),
+	}
+	r.logLimiters[levelWarn] = &logLimiter{rate: 1, burst: 1, tokens: 1, last: time.Now()}
+
+	r.warnf("%s: first", r.name)
+	allocs := testing.AllocsPerRun(100, func() {
+		r.warnf("dropped")
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocations per dropped message, want 0", allocs)
+	}
+	r.logLimiters[levelWarn].tokens = 1
+	r.warnf("%s: second", r.name)
+
+	expected := "WARN: rewriteBody: first\nWARN: rewriteBody: 101 warn messages not logged because of logRateLimit\nWARN: rewriteBody: second\n"
+	if logs.String() != expected {
+		t.Errorf("got logs %q, want %q", logs.String(), expected)
+	}
+}
+
+func TestResponsebodyrewrite_logRateLimitSummary(t *testing.T) {
+	logs := &syncBuffer{}
+	r := &responsebodyrewrite{
+		name:       "rewriteBody",
+		logLevel:   levelWarn,
+		warnLogger: log.New(logs, "WARN: ", 0),
+	}
+	r.logLimiters = newLogLimiters(LogRateLimit{Warn: 10}, r.reportSuppressed)
+
+	// The burst is exhausted, and no message follows those dropped.
+	for i := 0; i < 10+3; i++ {
+		r.warnf("%s: message", r.name)
+	}
+
+	expected := "WARN: rewriteBody: 3 warn messages not logged because of logRateLimit\n"
+	deadline := time.Now().Add(time.Second)
+	for !strings.HasSuffix(logs.String(), expected) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !strings.HasSuffix(logs.String(), expected) {
+		t.Fatalf("got logs %q, want them to end with the summary %q", logs.String(), expected)
+	}
+	if count := strings.Count(logs.String(), "\n"); count != 11 {
+		t.Errorf("got %d lines, want the burst and the summary", count)
+	}
+}
+
+func TestServeHTTP_requestID(t *testing.T) {
+	tests := []struct {
+		desc     string
+		config   Config
+		header   http.Header
+		expLines []string
+	}{
+		{
+			desc:   "default header",
+			header: http.Header{"X-Request-Id": {"abc\ndef"}},
+			expLines: []string{
+				`DEBUG: rewriteBody: response 0 matches status 200 of / request_id="abc\ndef"`,
+				`DEBUG: rewriteBody: rewrite of / by response 0 replaced [1] matches request_id="abc\ndef"`,
+			},
+		},
+		{
+			desc:   "custom header",
+			config: Config{RequestIDHeader: "X-Trace"},
+			header: http.Header{"X-Request-Id": {"abc"}, "X-Trace": {"123"}},
+			expLines: []string{
+				`DEBUG: rewriteBody: response 0 matches status 200 of / request_id="123"`,
+				`DEBUG: rewriteBody: rewrite of / by response 0 replaced [1] matches request_id="123"`,
+			},
+		},
+		{
+			desc: "no ID",
+			expLines: []string{
+				`DEBUG: rewriteBody: response 0 matches status 200 of /`,
+				`DEBUG: rewriteBody: rewrite of / by response 0 replaced [1] matches`,
+			},
+		},
+		{
+			desc:   "JSON format",
+			config: Config{LogFormat: logFormatJSON},
+			header: http.Header{"X-Request-Id": {"abc"}},
+			expLines: []string{
+				`"msg":"response 0 matches status 200 of /","method":"GET","path":"/","status":200,"matched_response":0,"request_id":"abc"}`,
+				`"msg":"rewrite of / by response 0 replaced [1] matches","method":"GET","path":"/","matched_response":0,"replacements":[1],"request_id":"abc"}`,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := test.config
+			config.LogLevel = "debug"
+			config.Responses = []Response{{Status: "200", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}}}
+			var logs bytes.Buffer
+			handler, err := NewMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				_, _ = rw.Write([]byte("foo"))
+			}), WithConfig(&config), WithName("rewriteBody"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := handler.(*responsebodyrewrite)
+			r.debugLogger = log.New(&logs, "DEBUG: ", 0)
+			if r.jsonLogger != nil {
+				r.jsonLogger = log.New(&logs, "", 0)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header = test.header
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			lines := strings.Split(strings.TrimSuffix(logs.String(), "\n"), "\n")
+			if len(lines) != len(test.expLines) {
+				t.Fatalf("got logs %q, want %d lines", logs.String(), len(test.expLines))
+			}
+			for i, line := range lines {
+				if !strings.HasSuffix(line, test.expLines[i]) {
+					t.Errorf("got line %q, want it to end with %q", line, test.expLines[i])
+				}
+			}
+		})
+	}
+}
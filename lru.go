@@ -0,0 +1,184 @@
+package traefik_responsebodyrewrite
+
+import (
+	"container/list"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bodyCacheEntry is a rewritten body, valid as long as the upstream sends the same status and validator.
+type bodyCacheEntry struct {
+	key       string
+	validator string
+	status    int
+	body      []byte
+	// etag is the ETag computed from the rewritten body, empty if ETags are not recomputed.
+	etag string
+	// contentType is the Content-Type set along with the body, empty if it was left as is.
+	contentType string
+	// debugHeader is the value of the debugHeader sent with the body, empty if none.
+	debugHeader string
+	// modification describes how the body was modified, nil if it was not.
+	modification *bodyModification
+	expires      time.Time
+}
+
+// bodyCache is a LRU cache of the rewritten bodies, keyed by request method and URL.
+type bodyCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// newBodyCache creates a bodyCache holding at most size bodies, each for at most ttl if not zero.
+func newBodyCache(size int, ttl time.Duration) *bodyCache {
+	return &bodyCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the entry cached for key, if it was rewritten from a response with the same status and
+// validator, nil otherwise. A stale entry is removed.
+func (c *bodyCache) get(key, validator string, status int, now time.Time) *bodyCacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := element.Value.(*bodyCacheEntry)
+	if entry.validator != validator || entry.status != status || (!entry.expires.IsZero() && now.After(entry.expires)) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil
+	}
+
+	c.order.MoveToFront(element)
+	return entry
+}
+
+// clear removes all the entries, rewritten by rules which are not used anymore.
+func (c *bodyCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+// put caches a rewritten body, evicting the least recently used one if the cache is full.
+// The entry must not be modified afterwards.
+func (c *bodyCache) put(entry *bodyCacheEntry, now time.Time) {
+	key := entry.key
+	if c.ttl > 0 {
+		entry.expires = now.Add(c.ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*bodyCacheEntry).key)
+	}
+}
+
+// lookupCache looks for the rewritten body of the response in the cache. On a miss, the response is
+// prepared to have its body cached once rewritten.
+func (rw *responseWriter) lookupCache(statusCode int) {
+	validator, ok := cacheValidator(rw.ResponseWriter.Header())
+	if !ok {
+		return
+	}
+
+	key := bodyCacheKey(rw.request, rw.response, rw.ResponseWriter.Header())
+	if entry := rw.middleware.cache.get(key, validator, statusCode, time.Now()); entry != nil {
+		rw.cacheHit = true
+		rw.cachedBody = entry.body
+		rw.cachedETag = entry.etag
+		rw.cachedModification = entry.modification
+		if entry.modification != nil {
+			rw.addModificationHeaders()
+		}
+		if entry.contentType != "" {
+			rw.ResponseWriter.Header().Set("Content-Type", entry.contentType)
+		}
+		if entry.debugHeader != "" {
+			rw.ResponseWriter.Header().Set(rw.middleware.debugHeader, entry.debugHeader)
+		}
+		return
+	}
+	rw.cacheKey = key
+	rw.validator = validator
+}
+
+// bodyCacheKey returns the cache key of the body of a response to req rewritten by response, the requests to
+// the same URL being rewritten by different response blocks with variants. The URL includes the scheme and the
+// Host of req, and the key the values of the request headers listed in the Vary header of the response.
+func bodyCacheKey(req *http.Request, response *parsedResponse, header http.Header) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	key := response.id + " " + req.Method + " " + scheme + "://" + req.Host + req.URL.RequestURI()
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				key += " " + http.CanonicalHeaderKey(field) + "=" + strings.Join(req.Header.Values(field), ",")
+			}
+		}
+	}
+	// The bodies rewritten by some modes depend on more of the request, such as its public origin.
+	if keyer, ok := response.mode.(cacheKeyer); ok {
+		key += " " + keyer.cacheKey(req)
+	}
+	return key
+}
+
+// cacheValidator returns the validator identifying the upstream representation, and whether its rewritten
+// body can be cached at all: responses without a validator, setting cookies, private or varying on anything
+// but request headers aren't cached.
+func cacheValidator(header http.Header) (string, bool) {
+	if len(header.Values("Set-Cookie")) > 0 {
+		return "", false
+	}
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if strings.TrimSpace(field) == "*" {
+				return "", false
+			}
+		}
+	}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.ToLower(strings.TrimSpace(directive))
+			if strings.HasPrefix(directive, "private") || directive == "no-store" {
+				return "", false
+			}
+		}
+	}
+
+	if etag := header.Get("ETag"); etag != "" {
+		return "etag " + etag, true
+	}
+	if lastModified := header.Get("Last-Modified"); lastModified != "" {
+		return "last-modified " + lastModified, true
+	}
+	return "", false
+}
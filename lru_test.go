@@ -0,0 +1,215 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBodyCache(t *testing.T) {
+	now := time.Now()
+	cache := newBodyCache(2, time.Minute)
+
+	cache.put(&bodyCacheEntry{key: "a", validator: "etag 1", status: http.StatusOK, body: []byte("A")}, now)
+	cache.put(&bodyCacheEntry{key: "b", validator: "etag 1", status: http.StatusOK, body: []byte("B")}, now)
+
+	if entry := cache.get("a", "etag 1", http.StatusOK, now); entry == nil || string(entry.body) != "A" {
+		t.Errorf("got entry %+v, want body %q", entry, "A")
+	}
+
+	// b is the least recently used body.
+	cache.put(&bodyCacheEntry{key: "c", validator: "etag 1", status: http.StatusOK, body: []byte("C")}, now)
+	if cache.get("b", "etag 1", http.StatusOK, now) != nil {
+		t.Error("expected the least recently used body to be evicted")
+	}
+
+	if cache.get("a", "etag 2", http.StatusOK, now) != nil {
+		t.Error("expected a body with another validator to be a miss")
+	}
+	if cache.get("a", "etag 1", http.StatusOK, now) != nil {
+		t.Error("expected a body with another validator to be invalidated")
+	}
+
+	if cache.get("c", "etag 1", http.StatusNotFound, now) != nil {
+		t.Error("expected a body with another status to be a miss")
+	}
+
+	cache.put(&bodyCacheEntry{key: "d", validator: "etag 1", status: http.StatusOK, body: []byte("D")}, now)
+	if cache.get("d", "etag 1", http.StatusOK, now.Add(2*time.Minute)) != nil {
+		t.Error("expected an expired body to be a miss")
+	}
+}
+
+func TestCacheValidator(t *testing.T) {
+	tests := []struct {
+		desc      string
+		header    http.Header
+		validator string
+		cacheable bool
+	}{
+		{
+			desc:      "should use the ETag",
+			header:    http.Header{"Etag": {`"v1"`}, "Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"}},
+			validator: `etag "v1"`,
+			cacheable: true,
+		},
+		{
+			desc:      "should use Last-Modified without ETag",
+			header:    http.Header{"Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"}},
+			validator: "last-modified Mon, 02 Jan 2006 15:04:05 GMT",
+			cacheable: true,
+		},
+		{
+			desc:   "should not cache a response without validator",
+			header: http.Header{},
+		},
+		{
+			desc:   "should not cache a response setting a cookie",
+			header: http.Header{"Etag": {`"v1"`}, "Set-Cookie": {"id=1"}},
+		},
+		{
+			desc:   "should not cache a private response",
+			header: http.Header{"Etag": {`"v1"`}, "Cache-Control": {"max-age=60, Private"}},
+		},
+		{
+			desc:   "should not cache a response not to be stored",
+			header: http.Header{"Etag": {`"v1"`}, "Cache-Control": {"no-store"}},
+		},
+		{
+			desc:   "should not cache a response varying on anything",
+			header: http.Header{"Etag": {`"v1"`}, "Vary": {"Accept-Encoding, *"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			validator, cacheable := cacheValidator(test.header)
+			if validator != test.validator || cacheable != test.cacheable {
+				t.Errorf("got %q (%t), want %q (%t)", validator, cacheable, test.validator, test.cacheable)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_cache(t *testing.T) {
+	config := &Config{
+		CacheSize: 10,
+		Responses: []Response{
+			{
+				Status:   "200",
+				Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+			},
+		},
+	}
+
+	// The upstream body changes on each request, so that a body served from the cache can be told apart.
+	var etag, cookie string
+	requests := 0
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		requests++
+		rw.Header().Set("ETag", etag)
+		if cookie != "" {
+			rw.Header().Set("Set-Cookie", cookie)
+		}
+		_, _ = rw.Write([]byte("foo " + string(rune('0'+requests))))
+	}
+
+	rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func() string {
+		recorder := httptest.NewRecorder()
+		rewriteBody.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/page", nil))
+		return recorder.Body.String()
+	}
+
+	etag = `"v1"`
+	if body := serve(); body != "bar 1" {
+		t.Errorf("got body %q, want %q", body, "bar 1")
+	}
+	if body := serve(); body != "bar 1" {
+		t.Errorf("got body %q, want the cached body %q", body, "bar 1")
+	}
+
+	etag = `"v2"`
+	if body := serve(); body != "bar 3" {
+		t.Errorf("got body %q, want %q once the ETag changed", body, "bar 3")
+	}
+
+	cookie = "id=1"
+	etag = `"v3"`
+	serve()
+	if body := serve(); body != "bar 5" {
+		t.Errorf("got body %q, want %q for a response setting a cookie", body, "bar 5")
+	}
+}
+
+func TestServeHTTP_cacheKey(t *testing.T) {
+	tests := []struct {
+		desc   string
+		vary   string
+		first  func(req *http.Request)
+		second func(req *http.Request)
+	}{
+		{
+			desc:   "other host",
+			first:  func(req *http.Request) { req.Host = "a.example" },
+			second: func(req *http.Request) { req.Host = "b.example" },
+		},
+		{
+			desc:   "other scheme",
+			first:  func(req *http.Request) {},
+			second: func(req *http.Request) { req.TLS = &tls.ConnectionState{} },
+		},
+		{
+			desc:   "other varied header",
+			vary:   "Accept-Language",
+			first:  func(req *http.Request) { req.Header.Set("Accept-Language", "en") },
+			second: func(req *http.Request) { req.Header.Set("Accept-Language", "fr") },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				CacheSize: 10,
+				Responses: []Response{{Status: "200", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}}},
+			}
+			// The upstream body changes on each request under the same ETag, so that a body served from the cache
+			// can be told apart.
+			requests := 0
+			next := func(rw http.ResponseWriter, _ *http.Request) {
+				requests++
+				rw.Header().Set("ETag", `"v1"`)
+				if test.vary != "" {
+					rw.Header().Set("Vary", test.vary)
+				}
+				_, _ = rw.Write([]byte("foo " + string(rune('0'+requests))))
+			}
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var bodies []string
+			for _, prepare := range []func(req *http.Request){test.first, test.second, test.second} {
+				req := httptest.NewRequest(http.MethodGet, "/page", nil)
+				prepare(req)
+				recorder := httptest.NewRecorder()
+				rewriteBody.ServeHTTP(recorder, req)
+				bodies = append(bodies, recorder.Body.String())
+			}
+			// The second request must not be served the body cached for the first one, but the third one is served
+			// that of the second one.
+			if want := []string{"bar 1", "bar 2", "bar 2"}; strings.Join(bodies, ", ") != strings.Join(want, ", ") {
+				t.Errorf("got bodies %q, want %q", bodies, want)
+			}
+		})
+	}
+}
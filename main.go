@@ -5,42 +5,580 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
-	"os"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // parsedRewrite holds one rewrite body configuration with parsed values.
 type parsedRewrite struct {
-	regex       *regexp.Regexp
-	replacement []byte
+	regex *regexp.Regexp
+	// backtracking is the regex compiled by the regexp2 engine, in place of regex, nil with the regexp engine.
+	backtracking *backtrackingRegex
+	replacement  []byte
+	// description is the intent of the rewrite, only read by the diagnostics.
+	description string
 }
 
 // parsedResponse holds one response configuration with parsed values.
 type parsedResponse struct {
-	rewrites []parsedRewrite
-	status   HTTPCodeRanges
+	// index is the position of the response in the configuration.
+	index int
+	// name is the name of the response, empty if unnamed, and id its name or else its index, identifying
+	// the response in the logs and the debugHeader.
+	name string
+	id   string
+	// description is the intent of the response, only read by the diagnostics.
+	description string
+	rewrites    []parsedRewrite
+	// passes apply the rewrites, grouping the independent ones to apply them in a single scan of the body.
+	passes  []rewritePass
+	status  HTTPCodeRanges
+	stream  bool
+	windows []int
+	// jsonPaths are the parsed paths of the JSON values to rewrite in JSON streaming mode.
+	jsonPaths [][]string
+	// firstBytes is the size of the head of the body to rewrite, zero meaning the whole body.
+	firstBytes int64
+	// maxOutputBytes is the maximum size of a rewritten body, zero meaning no limit.
+	maxOutputBytes int64
+	// stripTrailers is set when the trailers of the upstream are removed.
+	stripTrailers bool
+	// dryRun is set when the body is sent unmodified, the matches of the rewrites being only logged.
+	dryRun bool
+	// disabled is set when the response block is skipped at runtime.
+	disabled bool
+	// variant selects the requests whose responses are rewritten, all of them if nil.
+	variant *parsedVariant
+	// mode changes the way the bodies are rewritten, nil if they are rewritten as a whole by rewriteBody.
+	mode transformer
+	// linkHeader rewrites the URI-references of the Link header of the responses, if not nil.
+	linkHeader *parsedLinkHeader
+	// multipart is set when the multipart bodies are rewritten part by part, the parts of the multipartTypes
+	// only if not nil.
+	multipart      bool
+	multipartTypes map[string]bool
+	// logLevel is the level of the messages about the responses matched by the response block, resolved
+	// from its logLevel or else that of the middleware.
+	logLevel logLevel
+}
+
+// matches reports whether the response block rewrites the bodies of the responses to req with the given
+// status code.
+func (p *parsedResponse) matches(statusCode int, req *http.Request) bool {
+	return p.status.Contains(statusCode) && p.appliesTo(req) && (p.variant == nil || p.variant.selects(req))
+}
+
+// appliesTo reports whether the response block may rewrite the responses to req, whatever their status code
+// and their variant.
+func (p *parsedResponse) appliesTo(req *http.Request) bool {
+	if p.disabled {
+		return false
+	}
+	matcher, ok := p.mode.(pathMatcher)
+	return !ok || matcher.matchesPath(req.URL.Path)
+}
+
+// bypasses reports whether none of the response blocks can apply to the response to req, which can then be
+// sent untouched: the responses to HEAD requests have no body, and the disabled response blocks and those
+// restricted to other paths don't apply. The response blocks with a variant apply even when req is not part
+// of it, since they add to the Vary header of the responses.
+func bypasses(responses []parsedResponse, req *http.Request) bool {
+	if req.Method == http.MethodHead {
+		return true
+	}
+	for i := range responses {
+		if responses[i].appliesTo(req) {
+			return false
+		}
+	}
+	return true
+}
+
+// needsWholeBody reports whether the bodies of the response block must be complete to be rewritten, i.e.
+// whether its mode transforms them as a whole, or whether one of its rewrites uses the regexp2 engine, which
+// can't search a spilled body.
+func (p *parsedResponse) needsWholeBody() bool {
+	if _, ok := p.mode.(bodyTransformer); ok {
+		return true
+	}
+	for _, rewrite := range p.rewrites {
+		if rewrite.backtracking != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesContentType reports whether the response block matches the responses with the given Content-Type.
+func (p *parsedResponse) matchesContentType(contentType string) bool {
+	matcher, ok := p.mode.(contentTypeMatcher)
+	return !ok || matcher.matchesContentType(contentType)
+}
+
+// matchesAllContentTypes reports whether the response block matches the responses whatever their content
+// type, i.e. whether its mode doesn't restrict them.
+func (p *parsedResponse) matchesAllContentTypes() bool {
+	_, ok := p.mode.(contentTypeMatcher)
+	return !ok
+}
+
+// rewrite applies the rewrites of the response to body, in order.
+// It returns the rewritten body, and whether any rewrite changed it. When nothing changed, the returned
+// slice is body itself.
+// A body whose rewrite would exceed maxOutputBytes, or a regexp2 matchTimeout, is returned as is.
+func (p *parsedResponse) rewrite(body []byte) ([]byte, bool) {
+	body, modified, _, _ := p.rewriteUntil(body, time.Time{}, nil)
+	return body, modified
+}
+
+// rewriteUntil applies the rewrites of the response to body, in order, until the deadline is reached.
+// The deadline is checked between the rewrite passes, a zero deadline meaning no deadline. It returns the
+// body rewritten so far, whether any rewrite changed it, and the index of the first rewrite skipped because
+// of the deadline, -1 if all the rewrites were applied.
+// Unless nil, replaced holds a counter for each rewrite, incremented by the number of matches it replaced,
+// counted while the rewrites are applied.
+// When a rewrite makes the body bigger than maxOutputBytes, the original body is returned with an
+// *outputLimitError, and with a *matchTimeoutError when a regexp2 rewrite exceeds its matchTimeout.
+func (p *parsedResponse) rewriteUntil(original []byte, deadline time.Time, replaced []int) ([]byte, bool, int, error) {
+	if p.maxOutputBytes > 0 {
+		return p.rewriteLimited(original, deadline, replaced)
+	}
+
+	body := original
+	modified := false
+	rule := 0
+	for _, pass := range p.passes {
+		if rule > 0 && !deadline.IsZero() && time.Now().After(deadline) {
+			return body, modified, rule, nil
+		}
+
+		var changed bool
+		var err error
+		if replaced != nil {
+			body, changed, err = pass.apply(body, replaced[rule:rule+pass.size()])
+		} else {
+			body, changed, err = pass.apply(body, nil)
+		}
+		if err != nil {
+			return original, false, -1, err
+		}
+		modified = modified || changed
+		rule += pass.size()
+	}
+	return body, modified, -1, nil
+}
+
+// rewriteLimited is rewriteUntil for a response with a maxOutputBytes limit. The rewrites are applied
+// one after the other, so that the limit is checked while each of them replaces its matches.
+func (p *parsedResponse) rewriteLimited(original []byte, deadline time.Time, replaced []int) ([]byte, bool, int, error) {
+	body := original
+	modified := false
+	for i, rewrite := range p.rewrites {
+		if i > 0 && !deadline.IsZero() && time.Now().After(deadline) {
+			return body, modified, i, nil
+		}
+
+		var changed bool
+		var matches int
+		var err error
+		body, changed, matches, err = rewrite.applyLimited(body, p.maxOutputBytes)
+		if err != nil {
+			var limitErr *outputLimitError
+			if errors.As(err, &limitErr) {
+				limitErr.rule = i
+			}
+			return original, false, -1, err
+		}
+		if replaced != nil {
+			replaced[i] += matches
+		}
+		modified = modified || changed
+	}
+	return body, modified, -1, nil
 }
 
 // Rewrite holds one rewrite body configuration.
 type Rewrite struct {
 	Regex       string `json:"regex,omitempty"`
 	Replacement string `json:"replacement,omitempty"`
+	// Remove removes the matches of Regex, the Replacement being empty. An empty Replacement is rejected
+	// otherwise, unless the configuration sets AllowEmptyReplacement, so that removals are intentional.
+	Remove bool `json:"remove,omitempty"`
+	// Description is a free-form text telling the intent of the rewrite, e.g. "mask legacy order IDs". It
+	// names the rewrite in the configuration errors, the debug logs, the dry-run reports and the debug dump.
+	Description string `json:"description,omitempty"`
+	// Engine is the regex engine compiling Regex, "regexp" if empty: the RE2 engine of the standard library,
+	// which runs in linear time. "regexp2" is a backtracking engine, supporting the lookarounds and the
+	// backreferences, whose rewrites only apply to buffered bodies, neither streamed nor spilled.
+	Engine string `json:"engine,omitempty"`
+	// MatchTimeout is the maximum duration of the search of a body by a regexp2 regex, e.g. "100ms", past which
+	// the rewrite fails. It must be set with the regexp2 engine, whose patterns may backtrack exponentially.
+	MatchTimeout string `json:"matchTimeout,omitempty"`
+
+	// regex is the compiled Regex of the rewrites created by NewRewrite.
+	regex *regexp.Regexp
 }
 
 // Response holds one response configuration.
 type Response struct {
-	Rewrites []Rewrite `json:"rewrites,omitempty"`
-	Status   string    `json:"status,omitempty"`
+	// Name identifies the response in the logs, the debugHeader and the configuration errors, instead of its
+	// index in the configuration. Names must be unique, and made of letters, digits, "-", "_" and ".".
+	Name string `json:"name,omitempty"`
+	// Description is a free-form text telling the intent of the response block, shown along with its name
+	// or index in the configuration errors and warnings, and in the debug dump.
+	Description string    `json:"description,omitempty"`
+	Rewrites    []Rewrite `json:"rewrites,omitempty"`
+	// RulesFile is the path of a JSON or YAML file holding a list of rewrites, applied after Rewrites. It is
+	// loaded when the middleware is created, and reloaded when it changes with WatchRulesFile.
+	RulesFile string `json:"rulesFile,omitempty"`
+	// RulesURL is the http or https URL of a JSON or YAML list of rewrites, told by its Content-Type or else
+	// by the extension of its path, applied after those of RulesFile. It is fetched when the middleware is
+	// created, and refreshed every RulesRefreshInterval.
+	RulesURL string      `json:"rulesURL,omitempty"`
+	Status   StatusCodes `json:"status,omitempty"`
+	// StatusCodes is the list of the status codes of the response block, in place of Status, for the
+	// configurations generated as JSON numbers. Status and StatusCodes can't both be set.
+	StatusCodes []int `json:"statusCodes,omitempty"`
+	// Stream enables the streaming mode: the body is rewritten and sent as it is written by the upstream,
+	// instead of being fully buffered. Only patterns with a bounded match width are allowed in this mode.
+	Stream bool `json:"stream,omitempty"`
+	// WindowBytes is the number of bytes held back in streaming mode to find matches spanning several writes.
+	// It defaults to the longest possible match of each pattern, and must not be smaller than it.
+	WindowBytes int `json:"windowBytes,omitempty"`
+	// MaxOutputBytes is the maximum size of a rewritten body. A rewrite which would make the body bigger is
+	// aborted, and the original body is sent, unless the failureMode is "error". Zero means no limit.
+	MaxOutputBytes int64 `json:"maxOutputBytes,omitempty"`
+	// RewriteFirstBytes limits the rewrites to the first bytes of the body, the rest of it being sent as is.
+	// The head is extended to the end of the write reaching the limit, matches spanning past that point
+	// are not rewritten. Zero means the whole body is rewritten.
+	RewriteFirstBytes int `json:"rewriteFirstBytes,omitempty"`
+	// JSONPaths enables the JSON streaming mode: the body is decoded as a stream of JSON tokens, and the
+	// rewrites are only applied to the string values found at these paths. A path is a list of object keys
+	// separated by dots, "*" matching any key, arrays being traversed transparently.
+	JSONPaths []string `json:"jsonPaths,omitempty"`
+	// Trailers tells what to do with the trailers of the upstream: "forward" (default) sends them after the
+	// rewritten body, "strip" removes them along with their announcement in the Trailer header, for clients
+	// which can't handle trailers.
+	Trailers string `json:"trailers,omitempty"`
+	// DryRun sends the original body and headers, the number of matches each rewrite would have replaced
+	// being logged instead. It is enabled for all the responses by the dryRun option of the middleware.
+	DryRun bool `json:"dryRun,omitempty"`
+	// Enabled can be set to false to switch the response block off without removing it from the
+	// configuration: it is still parsed and validated, but skipped at runtime, the next response block
+	// matching the status code being used instead. It defaults to true.
+	Enabled *bool `json:"enabled,omitempty"`
+	// Variant restricts the response block to the requests of an experiment group, the other requests being
+	// matched against the next response blocks.
+	Variant *Variant `json:"variant,omitempty"`
+	// GraphQL restricts the response block to the JSON GraphQL responses with errors, optionally those with a
+	// message or an extensions.code. The responses of other content types are matched against the next
+	// response blocks, and the bodies without matching errors are sent unmodified. It can't be used with
+	// stream, jsonPaths, rewriteFirstBytes or rewriteMultipart.
+	GraphQL *GraphQL `json:"graphql,omitempty"`
+	// JSONAPIErrors replaces the bodies, once rewritten, with JSON:API error documents holding a single error
+	// of the status code of the response, its Content-Type being set to application/vnd.api+json. The bodies
+	// which are already JSON:API error documents are left as they are. It can't be used with stream,
+	// jsonPaths, rewriteFirstBytes, rewriteMultipart or another mode.
+	JSONAPIErrors *JSONAPIErrors `json:"jsonapiErrors,omitempty"`
+	// SOAPFaults restricts the response block to the SOAP responses, of content type text/xml or
+	// application/soap+xml, and its rewrites to the messages of their faults, the faultstring of SOAP 1.1 and
+	// the Reason Text of SOAP 1.2, the rest of the envelope being left as is. The responses of other content
+	// types are matched against the next response blocks. It can't be used with stream, jsonPaths,
+	// rewriteFirstBytes, rewriteMultipart or another mode.
+	SOAPFaults bool `json:"soapFaults,omitempty"`
+	// CSV restricts the response block to the CSV responses, of content type text/csv or application/csv, and
+	// its rewrites to the fields of a column, or replaces them with a mask. The responses of other content
+	// types are matched against the next response blocks. It can't be used with stream, jsonPaths,
+	// rewriteFirstBytes, rewriteMultipart or another mode.
+	CSV *CSV `json:"csv,omitempty"`
+	// YAMLOps restricts the response block to the YAML responses, of content type application/yaml or
+	// text/yaml, and sets or removes the values at their paths once the body has been rewritten, the other
+	// lines being left as they are. The responses of other content types are matched against the next response
+	// blocks. It can't be used with stream, jsonPaths, rewriteFirstBytes, rewriteMultipart or another mode.
+	YAMLOps []YAMLOp `json:"yamlOps,omitempty"`
+	// HTML enables the HTML mode: the HTML documents, of content type text/html or application/xhtml+xml, are
+	// tokenized as they are written, the rewrites being only applied to the text between the tags, out of the
+	// comments, scripts and styles, along with the attribute rewrites and the injections. The responses of
+	// other content types are matched against the next response blocks. It can't be used with stream,
+	// jsonPaths, rewriteFirstBytes, rewriteMultipart or another mode.
+	HTML *HTML `json:"html,omitempty"`
+	// Sitemap restricts the response block to the sitemaps and sitemap index files, of content type
+	// application/xml or text/xml, on its paths, and rewrites the scheme and the host of the URLs of their
+	// locs and alternate links to the public origin once the body has been rewritten. The responses of other
+	// content types or paths are matched against the next response blocks. It can't be used with stream,
+	// jsonPaths, rewriteFirstBytes, rewriteMultipart or another mode.
+	Sitemap *Sitemap `json:"sitemap,omitempty"`
+	// Feed restricts the response block to the RSS 2.0 and Atom feeds, of content type application/rss+xml,
+	// application/atom+xml, or application/xml and text/xml with an rss or Atom feed root element, and
+	// rewrites the scheme and the host of the URLs of their links, guids and enclosures to the public origin
+	// once the body has been rewritten, the guids which are not permalinks being left as they are. The
+	// responses of other content types are matched against the next response blocks. It can't be used with
+	// stream, jsonPaths, rewriteFirstBytes, rewriteMultipart or another mode.
+	Feed *Feed `json:"feed,omitempty"`
+	// HLS restricts the response block to the HLS playlists, of content type application/vnd.apple.mpegurl or
+	// application/x-mpegurl, and rewrites the scheme and the host of their absolute http and https URIs, those
+	// of the segments and variants as well as the URI attributes of the tags, to the public origin once the
+	// body has been rewritten. The responses of other content types are matched against the next response
+	// blocks. It can't be used with stream, jsonPaths, rewriteFirstBytes, rewriteMultipart or another mode.
+	HLS *HLS `json:"hls,omitempty"`
+	// DASH restricts the response block to the MPEG-DASH manifests, of content type application/dash+xml, and
+	// rewrites the scheme and the host of the absolute URLs of their BaseURL and Location elements and of the
+	// media and initialization of their SegmentTemplate elements to the public origin once the body has been
+	// rewritten. The responses of other content types are matched against the next response blocks. It can't
+	// be used with stream, jsonPaths, rewriteFirstBytes, rewriteMultipart or another mode.
+	DASH *DASH `json:"dash,omitempty"`
+	// VAST restricts the response block to the VAST and VMAP ad documents, of content type application/xml or
+	// text/xml with a VAST or VMAP root element, and rewrites the scheme and the host of the absolute URLs of
+	// their MediaFile, Impression, ClickThrough, ClickTracking, Tracking and AdTagURI elements to the public
+	// origin once the body has been rewritten, within their CDATA sections. The responses of other content
+	// types are matched against the next response blocks. It can't be used with stream, jsonPaths,
+	// rewriteFirstBytes, rewriteMultipart or another mode.
+	VAST *VAST `json:"vast,omitempty"`
+	// OpenAPI restricts the response block to the OpenAPI 3 and Swagger 2.0 documents, of content type
+	// application/json or application/vnd.oai.openapi+json, on its paths, and rewrites their server URLs, the
+	// url of the top-level servers of OpenAPI and the host, basePath and schemes of Swagger, to the public
+	// origin once the body has been rewritten, with the X-Forwarded-Prefix of the request prepended to their
+	// paths. The responses of other content types or paths are matched against the next response blocks. It
+	// can't be used with stream, jsonPaths, rewriteFirstBytes, rewriteMultipart or another mode.
+	OpenAPI *OpenAPI `json:"openapi,omitempty"`
+	// OIDC restricts the response block to the OpenID Connect discovery documents, of content type
+	// application/json, on its paths, and rewrites the scheme and the host of the URLs of their issuer and
+	// endpoints, or of the configured top-level fields, to the public origin once the body has been rewritten.
+	// The responses of other content types or paths are matched against the next response blocks. It can't be
+	// used with stream, jsonPaths, rewriteFirstBytes, rewriteMultipart or another mode.
+	OIDC *OIDC `json:"oidc,omitempty"`
+	// LinkHeader rewrites the URI-references of the Link header of the responses matched by the response
+	// block, whatever their content type and whatever happens to their body, their parameters being left as
+	// they are.
+	LinkHeader *LinkHeader `json:"linkHeader,omitempty"`
+	// RewriteMultipart rewrites the multipart bodies part by part, serializing them again with the same
+	// boundary, instead of sending them unmodified. The nested multiparts and the parts with a
+	// Content-Transfer-Encoding are sent as is. It can't be used with stream, jsonPaths or rewriteFirstBytes.
+	RewriteMultipart bool `json:"rewriteMultipart,omitempty"`
+	// MultipartContentTypes are the media types of the parts rewritten with RewriteMultipart, all of them if
+	// empty.
+	MultipartContentTypes []string `json:"multipartContentTypes,omitempty"`
+	// LogLevel overrides the logLevel of the middleware for the messages about the responses matched by the
+	// response block, such as the number of matches replaced, e.g. "debug" for a response block being rolled
+	// out. The other messages keep the logLevel of the middleware.
+	LogLevel string `json:"logLevel,omitempty"`
 }
 
 // Config the plugin configuration.
 type Config struct {
+	// Version is the version of the configuration, whose defaults may change from one version to the next.
+	// The configurations of previous versions are migrated to the latest one keeping their defaults. A
+	// configuration without version, such as the one emitted by CreateConfig onto which Traefik decodes the
+	// configurations, is a version 1 configuration: the latest defaults must be opted in to.
+	Version   int        `json:"version,omitempty"`
 	Responses []Response `json:"responses,omitempty"`
+	// MaxBodySize is the maximum number of bytes buffered for a rewrite. Bigger bodies are sent unmodified.
+	// Zero means no limit.
+	MaxBodySize int64 `json:"maxBodySize,omitempty"`
+	// MaxBodySizeHeader is the name of a header added to responses whose rewrite was skipped because
+	// of MaxBodySize. No header is added when empty.
+	MaxBodySizeHeader string `json:"maxBodySizeHeader,omitempty"`
+	// SpillThresholdBytes is the number of bytes above which a body to rewrite is moved from memory to a
+	// temporary file. Zero disables spilling.
+	SpillThresholdBytes int64 `json:"spillThresholdBytes,omitempty"`
+	// SpillDir is the directory of the temporary files, the default temporary directory if empty.
+	SpillDir string `json:"spillDir,omitempty"`
+	// MaxTotalBufferedBytes is the maximum number of body bytes buffered in memory at the same time by all the
+	// responses handled by the middleware. Responses which would exceed it are sent without being rewritten.
+	// Zero means no limit.
+	MaxTotalBufferedBytes int64 `json:"maxTotalBufferedBytes,omitempty"`
+	// MaxRewriteBytes is the size above which a body is sent without being rewritten. Zero means no limit.
+	MaxRewriteBytes int64 `json:"maxRewriteBytes,omitempty"`
+	// MaxRewriteDuration is a soft deadline for the rewrite of a body (e.g. "50ms"), checked between rules.
+	// Once it has expired, the remaining rules are skipped.
+	MaxRewriteDuration string `json:"maxRewriteDuration,omitempty"`
+	// MaxRewriteDurationPolicy tells which body is sent when MaxRewriteDuration expires: "partial" (default)
+	// sends the body rewritten by the rules applied so far, "original" sends the body unmodified.
+	MaxRewriteDurationPolicy string `json:"maxRewriteDurationPolicy,omitempty"`
+	// SlowRewriteThreshold is the duration (e.g. "10ms") above which the rewrite of a body is logged as slow.
+	// Slow rewrites are not logged when empty.
+	SlowRewriteThreshold string `json:"slowRewriteThreshold,omitempty"`
+	// RewriteTimingHeader is the name of a header exposing the duration of the rewrite of the body. It is
+	// sent as a trailer when the headers had to be sent before the body was rewritten, e.g. when the upstream
+	// flushed the body. No header is added when empty.
+	RewriteTimingHeader string `json:"rewriteTimingHeader,omitempty"`
+	// RewriteStatsInterval is the interval (e.g. "5m") at which the minimum, average and maximum rewrite
+	// durations of each response block since startup are logged. Stats are not logged when empty.
+	RewriteStatsInterval string `json:"rewriteStatsInterval,omitempty"`
+	// MetricsInterval is the interval (e.g. "1m") at which a summary of the responses matched, modified and
+	// passed through since startup is logged. It defaults to 5m, "0" disabling the summary.
+	MetricsInterval string `json:"metricsInterval,omitempty"`
+	// MetricsAddr is the host:port address of a StatsD server the counters of the metrics are sent to over UDP,
+	// every MetricsFlushInterval, whether the summary is logged or not. Nothing is sent when empty.
+	MetricsAddr string `json:"metricsAddr,omitempty"`
+	// MetricsFlushInterval is the interval at which the counters are sent to MetricsAddr. It defaults to 10s.
+	MetricsFlushInterval string `json:"metricsFlushInterval,omitempty"`
+	// MetricsTags sends the names of the middleware and of the response blocks as DogStatsD tags rather than as
+	// parts of the names of the metrics.
+	MetricsTags bool `json:"metricsTags,omitempty"`
+	// LogModifications logs a line for every response whose body is modified, with the method, path and status,
+	// the response block, the number of replacements and the original and new body sizes, whatever the logLevel.
+	LogModifications bool `json:"logModifications,omitempty"`
+	// RecomputeETag replaces the ETag of the upstream, which doesn't identify the rewritten body, by one
+	// computed from the rewritten body. It is only computed for bodies found in the cache, the upstream ETag
+	// being removed otherwise. A request whose
+	// If-None-Match matches the recomputed ETag gets a 304 Not Modified response.
+	RecomputeETag bool `json:"recomputeETag,omitempty"`
+	// CacheSize is the maximum number of rewritten bodies kept in memory, to be sent again without being
+	// rewritten as long as the upstream sends the same ETag or Last-Modified. Zero disables the cache.
+	CacheSize int `json:"cacheSize,omitempty"`
+	// CacheTTL is the maximum duration (e.g. "10m") a rewritten body is kept in the cache. When empty, bodies
+	// are kept until they are evicted by more recent ones.
+	CacheTTL string `json:"cacheTTL,omitempty"`
+	// RecoverPanics recovers from the panics of the upstream handler: a 500 Internal Server Error response is
+	// sent instead, its body being rewritten by the response block matching the 500 status, if any. When the
+	// headers have already been sent, the connection is aborted. Panics are propagated when false.
+	RecoverPanics bool `json:"recoverPanics,omitempty"`
+	// FreezeHeaders ignores the header changes made by the upstream once it has written the status code, as
+	// net/http does. By default, the changes made while a body is buffered are sent with the rewritten body.
+	// Trailers are sent in both cases.
+	FreezeHeaders bool `json:"freezeHeaders,omitempty"`
+	// DisableByteRanges removes the Range header of the requests, so that the upstream sends whole bodies,
+	// whose rewrite is consistent from one request to another. A partial response sent nonetheless is passed
+	// through with a warning. Accept-Ranges is removed from the responses to rewrite in any case.
+	DisableByteRanges bool `json:"disableByteRanges,omitempty"`
+	// ErrorLog is the level at which the errors sending the body to the client are logged: "info" (default),
+	// "warn", or "off" to not log them. Errors caused by a client which has gone, such as broken pipes, are
+	// logged at most once per second.
+	ErrorLog string `json:"errorLog,omitempty"`
+	// LastStatusWins makes a status code written again by the upstream replace the previous one, as long as
+	// the headers haven't been sent, i.e. while the body is buffered. By default, the first status code is
+	// kept, as done by net/http. Duplicate status codes are logged in both cases.
+	LastStatusWins bool `json:"lastStatusWins,omitempty"`
+	// SkipEncodedBodies sends the bodies with a Content-Encoding other than identity, e.g. compressed, without
+	// rewriting them. The encoding is checked again before the body is rewritten, as the upstream may set it
+	// once it has started to write the body. Bodies are rewritten regardless of their encoding when false.
+	SkipEncodedBodies bool `json:"skipEncodedBodies,omitempty"`
+	// StreamingContentTypes are the media types of the responses which are unbounded streams, passed through
+	// without being rewritten, their flushes reaching the client right away. It defaults to
+	// text/event-stream, application/x-ndjson and multipart/x-mixed-replace.
+	StreamingContentTypes []string `json:"streamingContentTypes,omitempty"`
+	// RewriteStreamingResponses rewrites the responses of the StreamingContentTypes instead: the Server-Sent
+	// Events one event at a time, the other streams as any other response.
+	RewriteStreamingResponses bool `json:"rewriteStreamingResponses,omitempty"`
+	// BinaryContentTypes are the media types of the binary responses, which regexes would corrupt, passed
+	// through without being rewritten. A type followed by "/*" stands for all its subtypes. It defaults to
+	// application/octet-stream, the protocol buffers, application/pdf, application/zip, application/gzip, and
+	// the image, audio and video types.
+	BinaryContentTypes []string `json:"binaryContentTypes,omitempty"`
+	// RewriteBinaryContentTypes are media types rewritten even though they are BinaryContentTypes, e.g.
+	// image/svg+xml.
+	RewriteBinaryContentTypes []string `json:"rewriteBinaryContentTypes,omitempty"`
+	// InvalidStatusCode is the status code sent in place of a status code outside of the 100-599 range written
+	// by the upstream, which would make net/http panic. It defaults to 500.
+	InvalidStatusCode int `json:"invalidStatusCode,omitempty"`
+	// LogLevel is the level of the messages logged: "error", "warn", "info" (default) or "debug". The debug
+	// level logs the configuration, and for each response the matching response block, whether the body is
+	// passed through, and the number of matches replaced by each rule.
+	LogLevel string `json:"logLevel,omitempty"`
+	// LogFormat is the format of the messages logged: "text" (default), or "json" for one JSON object per line,
+	// with the fields of the response the message is about, such as its path and status code.
+	LogFormat string `json:"logFormat,omitempty"`
+	// LogRateLimit limits the number of messages logged per second at each level, so that a busy route
+	// doesn't flood the logs. The number of messages dropped is logged along with the next message logged.
+	LogRateLimit LogRateLimit `json:"logRateLimit,omitempty"`
+	// RequestIDHeader is the request header holding the ID of the request, added to the messages logged while
+	// serving it. It defaults to X-Request-Id.
+	RequestIDHeader string `json:"requestIDHeader,omitempty"`
+	// TraceContext tells the headers of the trace context of the requests, whose trace and span IDs are added
+	// to the messages logged while serving them: "traceparent" (default) for the W3C traceparent header, or
+	// "b3" for the b3 header or the X-B3-TraceId and X-B3-SpanId headers.
+	TraceContext string `json:"traceContext,omitempty"`
+	// Rewrites are applied to the bodies of all the responses matching a response block, along with the
+	// rewrites of the block.
+	Rewrites []Rewrite `json:"rewrites,omitempty"`
+	// WatchRulesFile reloads the rules of the response blocks when one of their rulesFiles changes, checking
+	// them every WatchInterval, 10s by default, until the context given to New is done. A rulesFile which
+	// can't be loaded anymore is logged as an error, and the previous rules are kept.
+	WatchRulesFile bool   `json:"watchRulesFile,omitempty"`
+	WatchInterval  string `json:"watchInterval,omitempty"`
+	// RulesRefreshInterval is the interval between the fetches of the rulesURLs of the response blocks, until
+	// the context given to New is done, e.g. "1m". The rulesURLs are only fetched when the middleware is
+	// created if empty. A rulesURL which can't be fetched or loaded anymore is logged as an error, and the
+	// previous rules are kept.
+	RulesRefreshInterval string `json:"rulesRefreshInterval,omitempty"`
+	// RulesAuthHeader is the value of the Authorization header of the requests fetching the rulesURLs, e.g.
+	// "Bearer <token>", none if empty.
+	RulesAuthHeader string `json:"rulesAuthHeader,omitempty"`
+	// AllowEmptyReplacement allows the rewrites with an empty Replacement to remove their matches without
+	// setting Remove. It is set by the migration of the configurations of version 1.
+	AllowEmptyReplacement bool `json:"allowEmptyReplacement,omitempty"`
+	// RewritesPosition tells whether Rewrites are applied "before" (default) or "after" the rewrites of the
+	// matching response block.
+	RewritesPosition string `json:"rewritesPosition,omitempty"`
+	// Always applies Rewrites to the bodies of the responses no response block matches too, whatever their
+	// status code.
+	Always bool `json:"always,omitempty"`
+	// StrictConfig rejects the configurations where the status codes of a response block are also matched by
+	// a previous response block, which takes precedence. Such overlaps are only logged as warnings otherwise.
+	StrictConfig bool `json:"strictConfig,omitempty"`
+	// StrictPatterns rejects the regexes flagged when the middleware is created, which are only logged as
+	// warnings otherwise: those whose minimum match length is zero, those starting with ".*" and those whose
+	// complexity exceeds MaxPatternComplexity. The regexes matching the empty string wherever they are tried,
+	// e.g. "a*", are always rejected, unless they start with ".*".
+	StrictPatterns bool `json:"strictPatterns,omitempty"`
+	// MaxPatternComplexity is the number of instructions of a compiled regex above which it is flagged,
+	// 2000 if not set.
+	MaxPatternComplexity int `json:"maxPatternComplexity,omitempty"`
+	// MaxResponses is the maximum number of response blocks, 200 if not set. The configurations exceeding it,
+	// or one of the following limits, are rejected before any pattern is compiled.
+	MaxResponses int `json:"maxResponses,omitempty"`
+	// MaxRewritesPerResponse is the maximum number of rewrites of a response block, those of its rulesFile
+	// included, and of the global rewrites, 1000 if not set.
+	MaxRewritesPerResponse int `json:"maxRewritesPerResponse,omitempty"`
+	// MaxPatternLength is the maximum length in bytes of a regex, 16384 if not set.
+	MaxPatternLength int `json:"maxPatternLength,omitempty"`
+	// DebugHeader is the name of a header added to the responses whose body has been modified, telling the
+	// index of the response block and the number of matches replaced by each of its rules, e.g. "0 r0:2,r1:0".
+	// It is only added to buffered bodies. No header is added when empty.
+	DebugHeader string `json:"debugHeader,omitempty"`
+	// DebugHeaderSizes appends the sizes of the body before and after the rewrites to the debugHeader, e.g.
+	// "0 r0:2,r1:0 in=12345;out=12801".
+	DebugHeaderSizes bool `json:"debugHeaderSizes,omitempty"`
+	// AddWarningHeader adds a `Warning: 214 <name> "Transformation Applied"` header to the responses whose body
+	// has been modified, keeping the Warning headers of the upstream. Like the debugHeader, it is only added
+	// to buffered bodies.
+	AddWarningHeader bool `json:"addWarningHeader,omitempty"`
+	// MatchedHeader is the name of a header, e.g. X-Rewrite-Matched, added to the responses whose body has been
+	// modified, for the middlewares wrapping this one and the access logs. Its value is the name of the response
+	// block, or its index if unnamed, and "global" for the global response block. Like the debugHeader, it is
+	// only added to buffered bodies. No header is added when empty.
+	MatchedHeader string `json:"matchedHeader,omitempty"`
+	// DebugPath is the path of the requests answered with a JSON dump of the parsed configuration and of the
+	// metrics, instead of being sent to the upstream, when they hold the DebugToken in the
+	// X-Rewrite-Debug-Token header. Both must be set to enable the dump. The counters of the metrics are also
+	// served in the Prometheus text format under DebugPath + "/metrics", with the same token.
+	DebugPath  string `json:"debugPath,omitempty"`
+	DebugToken string `json:"debugToken,omitempty"`
+	// DebugRedactPatterns hides the regexes and replacements of the rewrites in the debug dump.
+	DebugRedactPatterns bool `json:"debugRedactPatterns,omitempty"`
+	// DryRun enables the dry-run mode of all the responses: their original bodies and headers are sent, the
+	// number of matches each rewrite would have replaced being logged instead.
+	DryRun bool `json:"dryRun,omitempty"`
+	// FailureMode tells what is sent when a stage of the rewrite of a body fails, such as a rewrite exceeding
+	// maxOutputBytes or a body failing to decode in JSON streaming mode: "passthrough" (default) sends the
+	// original body, "error" sends FailureStatus and FailureBody instead, so that a broken rewrite never
+	// reaches the clients. When the headers have already been sent, the connection is aborted instead.
+	FailureMode string `json:"failureMode,omitempty"`
+	// FailureStatus is the status code sent with the "error" failureMode. It defaults to 502.
+	FailureStatus int `json:"failureStatus,omitempty"`
+	// FailureBody is the body sent with the "error" failureMode. It defaults to the text of FailureStatus.
+	FailureBody string `json:"failureBody,omitempty"`
 }
 
 // CreateConfig creates the default plugin configuration.
@@ -52,141 +590,1620 @@ func CreateConfig() *Config {
 
 // responsebodyrewrite is a middleware that rewrites the response body based on the status code and the content of the response.
 type responsebodyrewrite struct {
-	next       http.Handler
-	name       string
-	responses  []parsedResponse
-	infoLogger *log.Logger
+	// bufferedBytes is the number of body bytes currently buffered in memory by all the responses.
+	// It is accessed atomically, and kept first for its 64-bit alignment.
+	bufferedBytes int64
+	// lastBudgetWarning is the time, in nanoseconds, of the last warning about maxTotalBufferedBytes.
+	lastBudgetWarning int64
+	// lastWriteErrorLog is the time, in nanoseconds, of the last log of a write error caused by a client which
+	// has gone, and suppressedWriteErrors the number of such errors not logged since then.
+	lastWriteErrorLog     int64
+	suppressedWriteErrors int64
+	// duplicateWriteHeaders is the number of status codes written again by the upstream with a different code.
+	duplicateWriteHeaders int64
+	// lastDryRunLog is the time, in nanoseconds, of the last log of the matches found in dry-run mode, and
+	// suppressedDryRunLogs the number of bodies with matches not logged since then.
+	lastDryRunLog         int64
+	suppressedDryRunLogs  int64
+	maxTotalBufferedBytes int64
+	next                  http.Handler
+	name                  string
+	// responses are the response blocks parsed when the middleware is created, replaced by those stored in
+	// reloadedResponses once the rulesFiles or the rulesURLs are reloaded. They are read through
+	// currentResponses, and reloadMu serializes the reloads.
+	responses          []parsedResponse
+	reloadedResponses  atomic.Value
+	reloadMu           sync.Mutex
+	maxBodySize        int64
+	maxBodySizeHeader  string
+	spillThreshold     int64
+	spillDir           string
+	maxRewriteBytes    int64
+	maxRewriteDuration time.Duration
+	// sendOriginalOnTimeout is set when the original body is sent once maxRewriteDuration has expired.
+	sendOriginalOnTimeout bool
+	slowRewriteThreshold  time.Duration
+	// rewriteTimingHeader is the name of the header exposing the rewrite duration, none if empty.
+	rewriteTimingHeader string
+	// debugHeader is the name of the header telling what was rewritten, none if empty.
+	debugHeader string
+	// debugHeaderSizes is set when the debugHeader tells the sizes of the body.
+	debugHeaderSizes bool
+	// stats are the rewrite durations per response block, nil if they are not logged.
+	stats *rewriteStats
+	// metrics are the counters of the summary logged every metricsInterval and sent to metricsAddr, nil if
+	// both are disabled.
+	metrics *rewriteMetrics
+	// logModifications is set when a line is logged for every modified body.
+	logModifications bool
+	// transformationWarning is the value of the Warning header added to the modified responses, none if empty.
+	transformationWarning string
+	// matchedHeader is the name of the header telling the response block which modified the body, none if empty.
+	matchedHeader string
+	// debugPath is the path of the debug dump, disabled if empty, asked for with debugToken.
+	debugPath           string
+	debugToken          string
+	debugRedactPatterns bool
+	// cache holds the rewritten bodies, nil if caching is disabled.
+	cache             *bodyCache
+	recomputeETag     bool
+	recoverPanics     bool
+	freezeHeaders     bool
+	disableByteRanges bool
+	errorLog          string
+	lastStatusWins    bool
+	skipEncodedBodies bool
+	// streamingContentTypes are the media types of the responses passed through as streams, nil with
+	// rewriteStreamingResponses.
+	streamingContentTypes map[string]bool
+	// binaryContentTypes are the media types of the responses passed through as binary data.
+	binaryContentTypes *binaryContentTypes
+	invalidStatusCode  int
+	// failureMode tells what is sent when a stage of a rewrite fails, the failureStatus and failureBody being
+	// sent with the error mode.
+	failureMode   string
+	failureStatus int
+	failureBody   []byte
+	logLevel      logLevel
+	errorLogger   *log.Logger
+	warnLogger    *log.Logger
+	infoLogger    *log.Logger
+	debugLogger   *log.Logger
+	// jsonLogger logs the messages of all the levels in the JSON format, nil with the text format.
+	jsonLogger *log.Logger
+	// logLimiters limit the messages of each level, nil for the levels without limit.
+	logLimiters [levelDebug + 1]*logLimiter
+	// requestIDHeader is the request header holding the ID added to the messages about a request.
+	requestIDHeader string
+	// traceContext tells the headers holding the trace and span IDs added to the messages about a request.
+	traceContext string
 }
 
-// New creates a new instance of the responsebodyrewrite middleware.
-// It takes a context.Context, an http.Handler, a *Config, and a name string as parameters.
-// It returns an http.Handler and an error.
-func New(_ context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
-	infoLogger := log.New(io.Discard, "INFO: responsebodyrewrite: ", log.Ldate|log.Ltime)
-	infoLogger.SetOutput(os.Stdout)
-	infoLogger.Printf("Responses config: %s", config.Responses)
+// responseLabel returns the label of a response in the configuration errors and warnings.
+func responseLabel(response *parsedResponse) string {
+	label := fmt.Sprintf("responses[%d]", response.index)
+	if response.name != "" {
+		label += fmt.Sprintf(" %q", response.name)
+	}
+	return withDescription(label, response.description)
+}
 
+// responseOverlaps describes the status codes of the responses which are also matched by a previous response,
+// the first matching response being used. A response whose status codes are all matched by previous ones is
+// never used. The disabled responses neither match nor are matched, and the responses with a variant or
+// restricted to some content types only match some of the responses, so they leave the following responses
+// used.
+func responseOverlaps(responses []parsedResponse) []string {
+	var overlaps []string
+	for i := range responses {
+		response := &responses[i]
+		if response.disabled {
+			continue
+		}
+		remaining := response.status
+		for j := range responses[:i] {
+			previous := &responses[j]
+			if previous.disabled || previous.variant != nil || !previous.matchesAllContentTypes() {
+				continue
+			}
+			common := response.status.intersect(previous.status)
+			if len(common) == 0 {
+				continue
+			}
+			overlaps = append(overlaps, fmt.Sprintf("status codes %s of %s are matched by %s first",
+				common.Normalize(), responseLabel(response), responseLabel(previous)))
+			remaining = remaining.subtract(previous.status)
+		}
+		if len(remaining) == 0 {
+			overlaps = append(overlaps, fmt.Sprintf("%s is never used: all its status codes are matched by previous responses", responseLabel(response)))
+		}
+	}
+	return overlaps
+}
+
+// responseID returns the identifier of a response in the logs: its name, or its index if unnamed.
+func responseID(index int, name string) string {
+	if name != "" {
+		return name
+	}
+	return strconv.Itoa(index)
+}
+
+// validateResponseName checks the name of a response, which may be empty. Names made of digits only are
+// rejected, as they would be mistaken for the index of another response, as well as the identifier of the
+// response applying the global rewrites.
+func validateResponseName(name string) error {
+	if name == "" {
+		return nil
+	}
+	if strings.Trim(name, "0123456789") == "" {
+		return fmt.Errorf("invalid name %q: must not be a number", name)
+	}
+	if name == globalResponseID {
+		return fmt.Errorf("invalid name %q: reserved for the global rewrites", name)
+	}
+	for _, r := range name {
+		if !isNameRune(r) && r != '-' && r != '.' {
+			return fmt.Errorf("invalid name %q: must only contain letters, digits, '-', '_' and '.'", name)
+		}
+	}
+	return nil
+}
+
+// compileRewrites compiles the given rewrites. Its errors name the offending rewrite and field.
+func compileRewrites(configs []Rewrite, allowEmptyReplacement bool) ([]parsedRewrite, error) {
+	rewrites := make([]parsedRewrite, len(configs))
+	for i, rewriteConfig := range configs {
+		var err error
+		if rewrites[i], err = compileRewrite(rewriteConfig, allowEmptyReplacement); err != nil {
+			return nil, fmt.Errorf("%s.%w", rewriteLabel(i, rewriteConfig), err)
+		}
+	}
+	return rewrites, nil
+}
+
+// compileRewrite compiles a rewrite, whose replacement may be empty without Remove if allowEmptyReplacement
+// is set. Its errors start with the name of the offending field, to be prefixed with the path of the rewrite.
+func compileRewrite(rewriteConfig Rewrite, allowEmptyReplacement bool) (parsedRewrite, error) {
+	// An empty regex matches between every byte of the body.
+	if rewriteConfig.Regex == "" {
+		return parsedRewrite{}, errors.New("regex: must not be empty")
+	}
+	if err := checkEngine(rewriteConfig.Engine); err != nil {
+		return parsedRewrite{}, fmt.Errorf("engine: %w", err)
+	}
+	if rewriteConfig.Engine == engineRegexp2 {
+		return compileBacktrackingRewrite(rewriteConfig, allowEmptyReplacement)
+	}
+	if rewriteConfig.MatchTimeout != "" {
+		return parsedRewrite{}, fmt.Errorf("matchTimeout: can only be used with the %s engine", engineRegexp2)
+	}
+	regex := rewriteConfig.regex
+	if regex == nil {
+		var err error
+		if regex, err = sharedRegexCache.compile(rewriteConfig.Regex); err != nil {
+			if hint := backtrackingSyntaxHint(err); hint != "" {
+				return parsedRewrite{}, fmt.Errorf("regex: error compiling regex %q with the %s engine: %w (%s)", rewriteConfig.Regex, engineRegexp, err, hint)
+			}
+			return parsedRewrite{}, fmt.Errorf("regex: error compiling regex %q with the %s engine: %w", rewriteConfig.Regex, engineRegexp, err)
+		}
+	}
+	if err := checkEmptyMatch(regex); err != nil {
+		return parsedRewrite{}, fmt.Errorf("regex: %w", err)
+	}
+	if err := checkReplacement(rewriteConfig, regex, allowEmptyReplacement); err != nil {
+		return parsedRewrite{}, err
+	}
+
+	return parsedRewrite{
+		regex:       regex,
+		replacement: sharedRegexCache.replacement(rewriteConfig.Replacement),
+		description: rewriteConfig.Description,
+	}, nil
+}
+
+// compileBacktrackingRewrite is compileRewrite for a rewrite of the regexp2 engine. Its regexes are not shared
+// by the regex cache, their matchTimeout being part of them.
+func compileBacktrackingRewrite(rewriteConfig Rewrite, allowEmptyReplacement bool) (parsedRewrite, error) {
+	if rewriteConfig.regex != nil {
+		return parsedRewrite{}, fmt.Errorf("engine: can't be %s for a regex compiled by the %s engine", engineRegexp2, engineRegexp)
+	}
+	if rewriteConfig.MatchTimeout == "" {
+		return parsedRewrite{}, fmt.Errorf("matchTimeout: must be set with the %s engine", engineRegexp2)
+	}
+	timeout, err := time.ParseDuration(rewriteConfig.MatchTimeout)
+	if err != nil {
+		return parsedRewrite{}, fmt.Errorf("matchTimeout: %w", err)
+	}
+	if timeout <= 0 {
+		return parsedRewrite{}, fmt.Errorf("invalid matchTimeout %q: must be positive", rewriteConfig.MatchTimeout)
+	}
+
+	regex, err := compileBacktracking(rewriteConfig.Regex, timeout)
+	if err != nil {
+		return parsedRewrite{}, fmt.Errorf("regex: error compiling regex %q with the %s engine: %w", rewriteConfig.Regex, engineRegexp2, err)
+	}
+	empty, err := regex.matchesEmpty()
+	if err != nil {
+		return parsedRewrite{}, fmt.Errorf("regex: %w", err)
+	}
+	if empty {
+		return parsedRewrite{}, fmt.Errorf("regex: %q matches the empty string, which inserts the replacement between every byte of the body", rewriteConfig.Regex)
+	}
+	if err := checkReplacement(rewriteConfig, regex, allowEmptyReplacement); err != nil {
+		return parsedRewrite{}, err
+	}
+
+	return parsedRewrite{
+		backtracking: regex,
+		replacement:  sharedRegexCache.replacement(rewriteConfig.Replacement),
+		description:  rewriteConfig.Description,
+	}, nil
+}
+
+// checkReplacement checks the replacement of a rewrite, whose groups must be those of its compiled regex.
+func checkReplacement(rewriteConfig Rewrite, regex groupRegex, allowEmptyReplacement bool) error {
+	if rewriteConfig.Remove {
+		if rewriteConfig.Replacement != "" {
+			return fmt.Errorf("replacement: %q must be empty when remove is set", rewriteConfig.Replacement)
+		}
+	} else if rewriteConfig.Replacement == "" && !allowEmptyReplacement {
+		return errors.New("replacement: must not be empty unless remove is set, to remove the matches, or allowEmptyReplacement")
+	}
+	if group := missingGroup(regex, rewriteConfig.Replacement); group != "" {
+		return fmt.Errorf("replacement: %q refers to group %q, which regex %q doesn't have",
+			rewriteConfig.Replacement, group, rewriteConfig.Regex)
+	}
+	return nil
+}
+
+// parseResponse parses the response configuration at the given index, applying the global rewrites along
+// with its own, within the limits. Its errors name the offending field, the index of the response being added
+// by the caller.
+func parseResponse(index int, response Response, global globalRewrites, allowEmptyReplacement bool, limits ruleLimits, urlContents rulesURLContents) (parsedResponse, error) {
+	if err := validateResponseName(response.Name); err != nil {
+		return parsedResponse{}, err
+	}
+
+	var httpCodeRanges HTTPCodeRanges
+	var err error
+	if len(response.StatusCodes) > 0 {
+		if response.Status != "" {
+			return parsedResponse{}, errors.New("status and statusCodes can't both be set")
+		}
+		if httpCodeRanges, err = statusCodeRanges(response.StatusCodes); err != nil {
+			return parsedResponse{}, err
+		}
+	} else if httpCodeRanges, err = NewHTTPCodeRanges(response.Status.blocks()); err != nil {
+		return parsedResponse{}, fmt.Errorf("status: %w", err)
+	}
+
+	if err := limits.checkRewrites(response.Rewrites); err != nil {
+		return parsedResponse{}, err
+	}
+	rewrites, err := compileRewrites(response.Rewrites, allowEmptyReplacement)
+	if err != nil {
+		return parsedResponse{}, err
+	}
+	if response.RulesFile != "" {
+		fileRewrites, err := loadRulesFile(response.RulesFile, allowEmptyReplacement, limits)
+		if err != nil {
+			return parsedResponse{}, err
+		}
+		rewrites = append(rewrites, fileRewrites...)
+		if len(rewrites) > limits.maxRewrites {
+			return parsedResponse{}, fmt.Errorf("rewrites: %d rules with those of the rulesFile exceed maxRewritesPerResponse of %d", len(rewrites), limits.maxRewrites)
+		}
+	}
+	// The rulesURL is not fetched when the configuration is only validated, its syntax having been checked.
+	unfetched := response.RulesURL != "" && urlContents == nil
+	if response.RulesURL != "" && !unfetched {
+		urlRewrites, err := urlContents.rewrites(response.RulesURL, allowEmptyReplacement, limits)
+		if err != nil {
+			return parsedResponse{}, err
+		}
+		rewrites = append(rewrites, urlRewrites...)
+		if len(rewrites) > limits.maxRewrites {
+			return parsedResponse{}, fmt.Errorf("rewrites: %d rules with those of the rulesURL exceed maxRewritesPerResponse of %d", len(rewrites), limits.maxRewrites)
+		}
+	}
+	if response.CSV != nil && response.CSV.Mask != "" && len(rewrites) > 0 {
+		return parsedResponse{}, fmt.Errorf("csv: mask can't be used with rewrites")
+	}
+
+	if response.MaxOutputBytes < 0 {
+		return parsedResponse{}, fmt.Errorf("invalid maxOutputBytes %d: must not be negative", response.MaxOutputBytes)
+	}
+	if response.RewriteFirstBytes < 0 {
+		return parsedResponse{}, fmt.Errorf("invalid rewriteFirstBytes %d: must not be negative", response.RewriteFirstBytes)
+	}
+	if response.RewriteFirstBytes > 0 && response.Stream {
+		return parsedResponse{}, fmt.Errorf("rewriteFirstBytes can't be used in streaming mode")
+	}
+
+	variant, err := parseVariant(response.Variant)
+	if err != nil {
+		return parsedResponse{}, fmt.Errorf("variant: %w", err)
+	}
+
+	jsonPaths, err := parseJSONPaths(response.JSONPaths)
+	if err != nil {
+		return parsedResponse{}, fmt.Errorf("jsonPaths: %w", err)
+	}
+	if len(jsonPaths) > 0 && (response.Stream || response.RewriteFirstBytes > 0) {
+		return parsedResponse{}, fmt.Errorf("jsonPaths can't be used with stream or rewriteFirstBytes")
+	}
+
+	if response.RewriteMultipart && (response.Stream || len(jsonPaths) > 0 || response.RewriteFirstBytes > 0) {
+		return parsedResponse{}, fmt.Errorf("rewriteMultipart can't be used with stream, jsonPaths or rewriteFirstBytes")
+	}
+	mode, err := parseMode(response, jsonPaths)
+	if err != nil {
+		return parsedResponse{}, err
+	}
+
+	linkHeader, err := parseLinkHeader(response.LinkHeader)
+	if err != nil {
+		return parsedResponse{}, fmt.Errorf("linkHeader: %w", err)
+	}
+
+	// A response without rewrites has nothing to do, unless it strips the trailers, its mode changes the
+	// bodies on its own, or it rewrites the Link header.
+	if len(rewrites) == 0 && !unfetched && len(global.rewrites) == 0 && response.Trailers != trailersStrip && (mode == nil || !mode.changesBody()) && linkHeader == nil {
+		return parsedResponse{}, fmt.Errorf("rewrites: must not be empty unless trailers is %q, or jsonapiErrors, yamlOps, html, sitemap, feed, hls, dash, vast, openapi, oidc, linkHeader or a csv mask is set", trailersStrip)
+	}
+
+	if len(response.MultipartContentTypes) > 0 && !response.RewriteMultipart {
+		return parsedResponse{}, fmt.Errorf("multipartContentTypes can only be used with rewriteMultipart")
+	}
+	multipartTypes, err := parseMultipartContentTypes(response.MultipartContentTypes)
+	if err != nil {
+		return parsedResponse{}, err
+	}
+
+	if response.Trailers != "" && response.Trailers != trailersForward && response.Trailers != trailersStrip {
+		return parsedResponse{}, fmt.Errorf("invalid trailers %q: must be %q or %q", response.Trailers, trailersForward, trailersStrip)
+	}
+
+	// Responses which are not in streaming mode can still switch to it if the upstream flushes the body.
+	windows, err := streamWindows(rewrites, response.WindowBytes)
+	if err != nil && response.Stream {
+		return parsedResponse{}, err
+	}
+	globalWindows, globalErr := streamWindows(global.rewrites, response.WindowBytes)
+	if globalErr != nil && response.Stream {
+		return parsedResponse{}, fmt.Errorf("global %w", globalErr)
+	}
+	// A multipart body can't switch to the streaming mode, which would rewrite its boundaries, nor a body
+	// transformed as a whole by the mode of the response block.
+	if _, whole := mode.(bodyTransformer); err != nil || globalErr != nil || response.RewriteMultipart || whole {
+		windows = nil
+	} else {
+		windows = global.aroundWindows(windows, globalWindows)
+	}
+	rewrites = global.around(rewrites)
+
+	return parsedResponse{
+		index:    index,
+		name:     response.Name,
+		id:       responseID(index, response.Name),
+		rewrites: rewrites,
+
+		description: response.Description,
+		passes:      optimizePasses(rewrites),
+		status:      httpCodeRanges.Normalize(),
+		stream:      response.Stream,
+		windows:     windows,
+
+		jsonPaths: jsonPaths,
+
+		firstBytes:     int64(response.RewriteFirstBytes),
+		maxOutputBytes: response.MaxOutputBytes,
+		stripTrailers:  response.Trailers == trailersStrip,
+		dryRun:         response.DryRun,
+		disabled:       response.Enabled != nil && !*response.Enabled,
+		variant:        variant,
+		mode:           mode,
+		linkHeader:     linkHeader,
+		multipart:      response.RewriteMultipart,
+		multipartTypes: multipartTypes,
+	}, nil
+}
+
+// parseResponses parses the response blocks of the configuration, without the global response block of
+// Always, within the limits.
+func parseResponses(config *Config, global globalRewrites, limits ruleLimits, urlContents rulesURLContents) ([]parsedResponse, error) {
 	parsedResponses := make([]parsedResponse, len(config.Responses))
+	names := make(map[string]int)
 	for i, response := range config.Responses {
-		// Parse the HTTP code ranges
-		httpCodeRanges, err := NewHTTPCodeRanges([]string{response.Status})
+		var err error
+		parsedResponses[i], err = parseResponse(i, response, global, config.AllowEmptyReplacement, limits, urlContents)
+		if err == nil {
+			parsedResponses[i].logLevel, err = responseLogLevel(response.LogLevel, config.LogLevel)
+		}
 		if err != nil {
-			return nil, err
+			label := fmt.Sprintf("responses[%d]", i)
+			if response.Name != "" {
+				label += fmt.Sprintf(" %q", response.Name)
+			}
+			return nil, fmt.Errorf("%s: %w", withDescription(label, response.Description), err)
+		}
+		parsedResponses[i].dryRun = parsedResponses[i].dryRun || config.DryRun
+		if response.Name == "" {
+			continue
+		}
+		if previous, ok := names[response.Name]; ok {
+			return nil, fmt.Errorf("responses[%d]: name %q already used by responses[%d]", i, response.Name, previous)
 		}
+		names[response.Name] = i
+	}
+	return parsedResponses, nil
+}
 
-		// Parse the rewrites
-		rewrites := make([]parsedRewrite, len(response.Rewrites))
-		for i, rewriteConfig := range response.Rewrites {
-			regex, err := regexp.Compile(rewriteConfig.Regex)
-			if err != nil {
-				return nil, fmt.Errorf("error compiling regex %q: %w", rewriteConfig.Regex, err)
-			}
+// New creates a new instance of the responsebodyrewrite middleware.
+// It takes a context.Context, an http.Handler, a *Config, and a name string as parameters.
+// It returns an http.Handler and an error.
+func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
+	return NewMiddleware(next, WithContext(ctx), WithConfig(config), WithName(name))
+}
 
-			rewrites[i] = parsedRewrite{
-				regex:       regex,
-				replacement: []byte(rewriteConfig.Replacement),
-			}
+// newMiddleware creates the middleware from its configuration, logging to logger if not nil, and to the
+// standard output otherwise. The metrics summary is logged until ctx is done. When validate is true, the
+// configuration is only checked: the rulesURLs are not fetched, and nothing is started in the background.
+func newMiddleware(ctx context.Context, next http.Handler, config *Config, name string, logger *log.Logger, validate bool) (http.Handler, error) {
+	config, migrations, err := migrateConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	level, err := parseLogLevel(config.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateLogFormat(config.LogFormat); err != nil {
+		return nil, err
+	}
+	if err := validateTraceContext(config.TraceContext); err != nil {
+		return nil, err
+	}
+
+	if config.MaxBodySize < 0 {
+		return nil, fmt.Errorf("invalid maxBodySize %d: must not be negative", config.MaxBodySize)
+	}
+	if config.SpillThresholdBytes < 0 {
+		return nil, fmt.Errorf("invalid spillThresholdBytes %d: must not be negative", config.SpillThresholdBytes)
+	}
+	if config.MaxTotalBufferedBytes < 0 {
+		return nil, fmt.Errorf("invalid maxTotalBufferedBytes %d: must not be negative", config.MaxTotalBufferedBytes)
+	}
+	if config.MaxRewriteBytes < 0 {
+		return nil, fmt.Errorf("invalid maxRewriteBytes %d: must not be negative", config.MaxRewriteBytes)
+	}
+
+	var maxRewriteDuration time.Duration
+	if config.MaxRewriteDuration != "" {
+		var err error
+		maxRewriteDuration, err = time.ParseDuration(config.MaxRewriteDuration)
+		if err != nil || maxRewriteDuration < 0 {
+			return nil, fmt.Errorf("invalid maxRewriteDuration %q: must be a positive duration", config.MaxRewriteDuration)
 		}
+	}
+
+	switch config.MaxRewriteDurationPolicy {
+	case "", "partial", "original":
+	default:
+		return nil, fmt.Errorf("invalid maxRewriteDurationPolicy %q: must be partial or original", config.MaxRewriteDurationPolicy)
+	}
 
-		parsedResponses[i] = parsedResponse{
-			rewrites: rewrites,
-			status:   httpCodeRanges,
+	var slowRewriteThreshold time.Duration
+	if config.SlowRewriteThreshold != "" {
+		var err error
+		slowRewriteThreshold, err = time.ParseDuration(config.SlowRewriteThreshold)
+		if err != nil || slowRewriteThreshold < 0 {
+			return nil, fmt.Errorf("invalid slowRewriteThreshold %q: must be a positive duration", config.SlowRewriteThreshold)
 		}
 	}
 
-	return &responsebodyrewrite{
-		responses:  parsedResponses,
-		next:       next,
-		name:       name,
-		infoLogger: infoLogger,
-	}, nil
+	var statsInterval time.Duration
+	if config.RewriteStatsInterval != "" {
+		var err error
+		statsInterval, err = time.ParseDuration(config.RewriteStatsInterval)
+		if err != nil || statsInterval <= 0 {
+			return nil, fmt.Errorf("invalid rewriteStatsInterval %q: must be a positive duration", config.RewriteStatsInterval)
+		}
+	}
+
+	metricsInterval, err := parseMetricsInterval(config.MetricsInterval)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateMetricsAddr(config.MetricsAddr); err != nil {
+		return nil, err
+	}
+	metricsFlushInterval, err := parseMetricsFlushInterval(config.MetricsFlushInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateDebugPath(config.DebugPath, config.DebugToken); err != nil {
+		return nil, err
+	}
+
+	if config.CacheSize < 0 {
+		return nil, fmt.Errorf("invalid cacheSize %d: must not be negative", config.CacheSize)
+	}
+	var cacheTTL time.Duration
+	if config.CacheTTL != "" {
+		var err error
+		cacheTTL, err = time.ParseDuration(config.CacheTTL)
+		if err != nil || cacheTTL < 0 {
+			return nil, fmt.Errorf("invalid cacheTTL %q: must be a positive duration", config.CacheTTL)
+		}
+	}
+	var cache *bodyCache
+	if config.CacheSize > 0 {
+		cache = newBodyCache(config.CacheSize, cacheTTL)
+	}
+	errorLog, err := validateErrorLog(config.ErrorLog)
+	if err != nil {
+		return nil, err
+	}
+	invalidStatusCode := http.StatusInternalServerError
+	if config.InvalidStatusCode != 0 {
+		if config.InvalidStatusCode < http.StatusOK || !validStatusCode(config.InvalidStatusCode) {
+			return nil, fmt.Errorf("invalid invalidStatusCode %d: must be a final status code between 200 and 599", config.InvalidStatusCode)
+		}
+		invalidStatusCode = config.InvalidStatusCode
+	}
+	streamingContentTypes, err := parseStreamingContentTypes(config.StreamingContentTypes)
+	if err != nil {
+		return nil, err
+	}
+	if config.RewriteStreamingResponses {
+		streamingContentTypes = nil
+	}
+	binaryContentTypes, err := parseBinaryContentTypes(config.BinaryContentTypes, config.RewriteBinaryContentTypes)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateFailureMode(config.FailureMode, config.FailureStatus); err != nil {
+		return nil, err
+	}
+	failureStatus := http.StatusBadGateway
+	if config.FailureStatus != 0 {
+		failureStatus = config.FailureStatus
+	}
+	failureBody := config.FailureBody
+	if failureBody == "" {
+		failureBody = http.StatusText(failureStatus)
+	}
+
+	limits, err := parseRuleLimits(config)
+	if err != nil {
+		return nil, err
+	}
+	// The size of the configuration is checked before any pattern is compiled.
+	if err := limits.checkResponses(len(config.Responses)); err != nil {
+		return nil, err
+	}
+	compileStart := time.Now()
+	global, err := parseGlobalRewrites(config, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.MaxPatternComplexity < 0 {
+		return nil, fmt.Errorf("invalid maxPatternComplexity %d: must not be negative", config.MaxPatternComplexity)
+	}
+	maxPatternComplexity := defaultMaxPatternComplexity
+	if config.MaxPatternComplexity > 0 {
+		maxPatternComplexity = config.MaxPatternComplexity
+	}
+	fetcher, err := newRulesFetcher(config)
+	if err != nil {
+		return nil, err
+	}
+	var refreshInterval time.Duration
+	if config.RulesRefreshInterval != "" {
+		refreshInterval, err = time.ParseDuration(config.RulesRefreshInterval)
+		if err != nil || refreshInterval <= 0 {
+			return nil, fmt.Errorf("invalid rulesRefreshInterval %q: must be a positive duration", config.RulesRefreshInterval)
+		}
+	}
+	if fetcher != nil && !validate {
+		if err := fetcher.fetchAll(ctx); err != nil {
+			return nil, err
+		}
+	}
+	reloader := &rulesReloader{config: config, global: global, maxComplexity: maxPatternComplexity, limits: limits, fetcher: fetcher}
+	// The rulesFiles are watched from the content about to be loaded, so that none of their changes is missed.
+	var watcher *rulesWatcher
+	if config.WatchRulesFile {
+		if watcher, err = newRulesWatcher(reloader); err != nil {
+			return nil, err
+		}
+	}
+	// The rules of the rulesURLs are left out when they are not fetched.
+	var urlContents rulesURLContents
+	if !validate {
+		urlContents = fetcher.contents()
+	}
+	parsedResponses, err := parseResponses(config, global, limits, urlContents)
+	if err != nil {
+		return nil, err
+	}
+	compileDuration := time.Since(compileStart)
+	overlaps := responseOverlaps(parsedResponses)
+	if config.StrictConfig && len(overlaps) > 0 {
+		return nil, errors.New(strings.Join(overlaps, "; "))
+	}
+	patternIssues := vetPatterns(global, parsedResponses, maxPatternComplexity)
+	if config.StrictPatterns && len(patternIssues) > 0 {
+		return nil, errors.New(strings.Join(patternIssues, "; "))
+	}
+
+	// The responses no response block matches are rewritten by the global rewrites alone.
+	if config.Always {
+		parsedResponses = append(parsedResponses, newGlobalResponse(len(parsedResponses), global, config.DryRun, level))
+	}
+
+	var stats *rewriteStats
+	if statsInterval > 0 {
+		stats = newRewriteStats(len(parsedResponses), statsInterval)
+	}
+
+	r := &responsebodyrewrite{
+		responses:             parsedResponses,
+		next:                  next,
+		name:                  name,
+		maxBodySize:           config.MaxBodySize,
+		maxBodySizeHeader:     config.MaxBodySizeHeader,
+		spillThreshold:        config.SpillThresholdBytes,
+		spillDir:              config.SpillDir,
+		maxTotalBufferedBytes: config.MaxTotalBufferedBytes,
+		maxRewriteBytes:       config.MaxRewriteBytes,
+		maxRewriteDuration:    maxRewriteDuration,
+		sendOriginalOnTimeout: config.MaxRewriteDurationPolicy == "original",
+		slowRewriteThreshold:  slowRewriteThreshold,
+		rewriteTimingHeader:   config.RewriteTimingHeader,
+		debugHeader:           config.DebugHeader,
+		debugHeaderSizes:      config.DebugHeaderSizes,
+		logModifications:      config.LogModifications,
+		debugPath:             config.DebugPath,
+		debugToken:            config.DebugToken,
+		debugRedactPatterns:   config.DebugRedactPatterns,
+		stats:                 stats,
+		cache:                 cache,
+		recomputeETag:         config.RecomputeETag,
+		recoverPanics:         config.RecoverPanics,
+		freezeHeaders:         config.FreezeHeaders,
+		disableByteRanges:     config.DisableByteRanges,
+		errorLog:              errorLog,
+		lastStatusWins:        config.LastStatusWins,
+		skipEncodedBodies:     config.SkipEncodedBodies,
+		streamingContentTypes: streamingContentTypes,
+		binaryContentTypes:    binaryContentTypes,
+		invalidStatusCode:     invalidStatusCode,
+		failureMode:           config.FailureMode,
+		failureStatus:         failureStatus,
+		failureBody:           []byte(failureBody),
+		logLevel:              level,
+		errorLogger:           newLogger(logger, "ERROR"),
+		warnLogger:            newLogger(logger, "WARN"),
+		infoLogger:            newLogger(logger, "INFO"),
+		debugLogger:           newLogger(logger, "DEBUG"),
+		requestIDHeader:       config.RequestIDHeader,
+		traceContext:          config.TraceContext,
+		matchedHeader:         config.MatchedHeader,
+	}
+	r.logLimiters = newLogLimiters(config.LogRateLimit, r.reportSuppressed)
+	if config.LogFormat == logFormatJSON {
+		r.jsonLogger = newJSONLogger(logger)
+	}
+	if r.requestIDHeader == "" {
+		r.requestIDHeader = defaultRequestIDHeader
+	}
+	if config.AddWarningHeader {
+		r.transformationWarning = transformationWarning(name)
+	}
+	if (metricsInterval > 0 || config.MetricsAddr != "") && len(parsedResponses) > 0 {
+		r.metrics = newRewriteMetrics(len(parsedResponses))
+	}
+	if validate {
+		return r, nil
+	}
+	if r.metrics != nil && metricsInterval > 0 {
+		go r.logMetrics(ctx, metricsInterval)
+	}
+	if r.metrics != nil && config.MetricsAddr != "" {
+		go r.pushMetrics(ctx, newStatsdClient(config.MetricsAddr, name, config.MetricsTags), metricsFlushInterval)
+	}
+	if watcher != nil {
+		go r.watchRulesFiles(ctx, watcher)
+	}
+	if fetcher != nil && refreshInterval > 0 {
+		go r.refreshRulesURLs(ctx, reloader, refreshInterval)
+	}
+	for _, migration := range migrations {
+		r.infof("%s: configuration migrated from %s", name, migration)
+	}
+	for _, overlap := range overlaps {
+		r.warnf("%s: %s", name, overlap)
+	}
+	for i := range parsedResponses {
+		if parsedResponses[i].disabled {
+			r.infof("%s: %s is disabled", name, responseLabel(&parsedResponses[i]))
+		}
+	}
+	for _, issue := range patternIssues {
+		r.warnf("%s: %s", name, issue)
+	}
+	if rules := compiledRules(global, parsedResponses); rules > 0 {
+		r.infof("%s: compiled %d rules of %d response blocks in %s", name, rules, len(config.Responses), compileDuration)
+	}
+	r.debugf("%s: responses config: %v", name, config.Responses)
+	return r, nil
 }
 
 // ServeHTTP is the method that handles the HTTP request.
 // It rewrites the response body based on the status code and the content of the response.
 func (r *responsebodyrewrite) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if r.isDebugRequest(req) {
+		if r.isDebugMetricsRequest(req) {
+			r.serveDebugMetrics(rw)
+		} else {
+			r.serveDebug(rw)
+		}
+		return
+	}
+
+	if r.disableByteRanges {
+		req = withoutRange(req)
+	}
+
+	// The response blocks are loaded once, so that a reload doesn't change them while the response is served.
+	responses := r.currentResponses()
+	r.metrics.countResponse()
+
+	// Without any response block applying to the request, there is nothing to rewrite: the response writer is
+	// left untouched, unless it is needed to recover from panics.
+	if !r.recoverPanics && bypasses(responses, req) {
+		r.next.ServeHTTP(rw, req)
+		return
+	}
+
+	wrappedWriter := acquireResponseWriter(r, rw, req, responses)
+	defer releaseResponseWriter(wrappedWriter)
+	defer wrappedWriter.finishTrailers()
+
+	if r.serveNext(wrappedWriter, req) {
+		return
+	}
+
+	// The failure response has been sent in place of the response.
+	if wrappedWriter.failed {
+		return
+	}
+
+	// The connection has been taken over by the upstream, the HTTP layer must stay out of its way.
+	if wrappedWriter.hijacked || wrappedWriter.passthrough {
+		wrappedWriter.reportDryRun()
+		return
+	}
+
+	// The upstream wrote nothing, not even a status code: nothing is forwarded, so that the server sends
+	// its default response, rather than a made up 200.
+	if !wrappedWriter.wroteHeader {
+		return
+	}
+
+	// Nobody is going to receive the body once the client has gone, there is no need to rewrite it.
+	if wrappedWriter.stream == nil && wrappedWriter.abortIfClientGone() {
+		return
+	}
+
+	if wrappedWriter.cacheHit {
+		if wrappedWriter.notModified {
+			return
+		}
+		if err := writeBody(rw, wrappedWriter.cachedBody); err != nil {
+			wrappedWriter.logWriteError(int64(len(wrappedWriter.cachedBody)), err)
+		}
+		if m := wrappedWriter.cachedModification; m != nil {
+			r.metrics.countBytes(wrappedWriter.response.index, m.originalSize, m.size)
+			wrappedWriter.logModification(m.originalSize, m.size, m.replacements)
+		} else {
+			size := int64(len(wrappedWriter.cachedBody))
+			r.metrics.countBytes(wrappedWriter.response.index, size, size)
+		}
+		return
+	}
 
-	wrappedWriter := &responseWriter{
-		code:           http.StatusOK,
-		headerMap:      make(http.Header),
-		ResponseWriter: rw,
-		responses:      r.responses,
+	if wrappedWriter.stream != nil {
+		if err := wrappedWriter.stream.Close(); err != nil {
+			wrappedWriter.logWriteError(-1, err)
+		}
+		if json, ok := wrappedWriter.stream.(*jsonRewriter); ok && json.decodeErr != nil {
+			if wrappedWriter.handleFailure(stageJSON, json.decodeErr) {
+				wrappedWriter.sendFailureResponse()
+			}
+		}
+		return
 	}
 
-	r.next.ServeHTTP(wrappedWriter, req)
+	// The encoding may have been set once the upstream started to write the body.
+	if wrappedWriter.skipEncodedBody() {
+		wrappedWriter.sendHeaders()
+		size := int64(wrappedWriter.buffer.Len())
+		var err error
+		if wrappedWriter.spill != nil {
+			size = wrappedWriter.spill.size
+			_, err = wrappedWriter.spill.WriteTo(rw)
+		} else {
+			err = writeBody(rw, wrappedWriter.buffer.Bytes())
+		}
+		if err != nil {
+			wrappedWriter.logWriteError(size, err)
+		}
+		return
+	}
+
+	if wrappedWriter.spill != nil {
+		// The rewritten body is sent as it is produced, the headers can't wait for it.
+		wrappedWriter.sendHeaders()
+		// The time spent sending the body is part of the rewrite of a spilled body.
+		start := time.Now()
+		sent := &countingWriter{w: rw}
+		err := wrappedWriter.rewriteSpilled(sent)
+		r.recordRewrite(wrappedWriter, time.Since(start))
+		if err != nil {
+			wrappedWriter.logWriteError(wrappedWriter.spill.size, err)
+		}
+		r.metrics.countBytes(wrappedWriter.response.index, wrappedWriter.spill.size, sent.n)
+		if total := totalReplacements(wrappedWriter.spillReplaced); total > 0 {
+			wrappedWriter.logModification(wrappedWriter.spill.size, sent.n, total)
+		}
+		return
+	}
 
 	bodyBytes := wrappedWriter.buffer.Bytes()
 
-	for _, response := range r.responses {
-		if !response.status.Contains(wrappedWriter.code) {
-			continue
+	var modification *bodyModification
+	if response := wrappedWriter.response; response != nil {
+		start := time.Now()
+		originalSize := int64(len(bodyBytes))
+		var modified, complete bool
+		var replaced []int
+		var err error
+		// The mode may set the Content-Type of the rewritten body, which is cached along with it.
+		originalContentType := wrappedWriter.ResponseWriter.Header().Get("Content-Type")
+		// The multipart bodies and the modes don't check maxRewriteBytes themselves, only failing with errors,
+		// whose failureMode is applied here for the stage which failed.
+		mode, transforms := response.mode.(bodyTransformer)
+		original := bodyBytes
+		switch boundary := wrappedWriter.multipartBoundary; {
+		case r.skipsRewrite(response, req, originalSize):
+			complete = true
+		case boundary != "":
+			bodyBytes, modified, complete, replaced, err = r.rewriteMultipart(response, boundary, bodyBytes, req)
+		case transforms:
+			bodyBytes, modified, complete, replaced, err = mode.transform(wrappedWriter, bodyBytes)
+		default:
+			bodyBytes, modified, complete, replaced, err = r.rewriteBody(response, bodyBytes, req)
+		}
+		if err != nil {
+			if wrappedWriter.handleFailure(failureStageOf(err), err) {
+				r.recordRewrite(wrappedWriter, time.Since(start))
+				wrappedWriter.sendFailureResponse()
+				return
+			}
+			bodyBytes, modified, replaced = original, false, nil
 		}
-		for _, rewrite := range response.rewrites {
-			bodyBytes = rewrite.regex.ReplaceAll(bodyBytes, rewrite.replacement)
+		var contentType string
+		if current := wrappedWriter.ResponseWriter.Header().Get("Content-Type"); current != originalContentType {
+			contentType = current
 		}
-		break
+		r.recordRewrite(wrappedWriter, time.Since(start))
+		r.metrics.countBytes(response.index, originalSize, int64(len(bodyBytes)))
+		var debugHeader string
+		if modified {
+			r.metrics.countModified(response.index, replaced)
+			debugHeader = wrappedWriter.setDebugHeader(replaced, originalSize, int64(len(bodyBytes)))
+			wrappedWriter.addModificationHeaders()
+			modification = &bodyModification{
+				replacements: totalReplacements(replaced),
+				originalSize: originalSize,
+				size:         int64(len(bodyBytes)),
+			}
+		} else {
+			wrappedWriter.restoreContentLength()
+		}
+
+		// A body whose rewrite was cut short by maxRewriteDuration may be rewritten completely next time.
+		if complete && wrappedWriter.cacheKey != "" {
+			entry := &bodyCacheEntry{
+				key:       wrappedWriter.cacheKey,
+				validator: wrappedWriter.validator,
+				status:    wrappedWriter.code,
+				body:      bytes.Clone(bodyBytes),
+
+				contentType:  contentType,
+				debugHeader:  debugHeader,
+				modification: modification,
+			}
+			if r.recomputeETag {
+				entry.etag = computeETag(entry.body)
+			}
+			r.cache.put(entry, time.Now())
+		}
+	}
+
+	wrappedWriter.sendHeaders()
+	if len(bodyBytes) > 0 {
+		if err := writeBody(rw, bodyBytes); err != nil {
+			wrappedWriter.logWriteError(int64(len(bodyBytes)), err)
+		}
+	}
+	if modification != nil {
+		wrappedWriter.logModification(modification.originalSize, modification.size, modification.replacements)
+	}
+}
+
+// rewriteBody applies the rewrites of response to body, within the maxRewriteBytes and maxRewriteDuration
+// limits. It returns the body to send, whether it differs from the original body, whether it is the
+// result of all the rewrites, i.e. whether the rewrite was not cut short by maxRewriteDuration, and the
+// number of matches replaced by each rule applied, only counted for debug logs, the debugHeader and
+// logModifications. A rewrite exceeding maxOutputBytes, or a regexp2 matchTimeout, returns the original body
+// with the error, for the caller to apply the failureMode.
+func (r *responsebodyrewrite) rewriteBody(response *parsedResponse, body []byte, req *http.Request) ([]byte, bool, bool, []int, error) {
+	if r.skipsRewrite(response, req, int64(len(body))) {
+		return body, false, true, nil, nil
+	}
+
+	var replaced []int
+	if response.logLevel >= levelDebug || r.debugHeader != "" || r.logModifications {
+		replaced = make([]int, len(response.rewrites))
 	}
+	rewritten, modified, skipped, err := response.rewriteUntil(body, r.rewriteDeadline(), replaced)
+	if err != nil {
+		// A regexp2 match timing out depends on the load, the original body is not cached as its rewrite.
+		var timeoutErr *matchTimeoutError
+		return body, false, !errors.As(err, &timeoutErr), nil, err
+	}
+	complete := skipped < 0
+	if !complete {
+		r.logfUpTo(response.logLevel, levelWarn, logFields{request: req, response: response}, "%s: rewrite of %s by response %s exceeded maxRewriteDuration of %s, skipping rules %d to %d",
+			r.name, req.URL, response.id, r.maxRewriteDuration, skipped, len(response.rewrites)-1)
+		if r.sendOriginalOnTimeout {
+			return body, false, false, nil, nil
+		}
+	} else {
+		skipped = len(response.rewrites)
+	}
+
+	if replaced != nil {
+		replaced = replaced[:skipped]
+		r.logReplacements(req, response, replaced)
+	}
+	return rewritten, modified, complete, replaced, nil
+}
+
+// logReplacements logs at the debug level the number of matches replaced by each rule of a response.
+func (r *responsebodyrewrite) logReplacements(req *http.Request, response *parsedResponse, replaced []int) {
+	fields := logFields{request: req, response: response, replacements: replaced}
+	r.logfUpTo(response.logLevel, levelDebug, fields, "%s: rewrite of %s by response %s replaced %v matches%s",
+		r.name, req.URL, response.id, replaced, describeReplacements(response.rewrites, replaced))
+}
 
-	if _, err := rw.Write(bodyBytes); err != nil {
-		r.infoLogger.Printf("unable to write body: %v", err)
+// skipsRewrite reports whether a body of the given size is too big to be rewritten by response, logging that
+// it is sent unmodified.
+func (r *responsebodyrewrite) skipsRewrite(response *parsedResponse, req *http.Request, size int64) bool {
+	if !r.exceedsMaxRewriteBytes(size) {
+		return false
 	}
+	r.logfUpTo(response.logLevel, levelWarn, logFields{request: req, response: response}, "%s: skipping rewrite of %s by response %s: body of %d bytes exceeds maxRewriteBytes of %d",
+		r.name, req.URL, response.id, size, r.maxRewriteBytes)
+	return true
+}
+
+// exceedsMaxRewriteBytes reports whether a body of the given size is too big to be rewritten.
+func (r *responsebodyrewrite) exceedsMaxRewriteBytes(size int64) bool {
+	return r.maxRewriteBytes > 0 && size > r.maxRewriteBytes
+}
 
+// rewriteDeadline returns the deadline of a rewrite starting now, or a zero time without maxRewriteDuration.
+func (r *responsebodyrewrite) rewriteDeadline() time.Time {
+	if r.maxRewriteDuration == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(r.maxRewriteDuration)
 }
 
 // responseWriter is a wrapper around an http.ResponseWriter that allows us to intercept the response.
 // It implements the http.ResponseWriter interface.
 type responseWriter struct {
-	buffer      bytes.Buffer
-	headerMap   http.Header
+	buffer bytes.Buffer
+	// wroteHeader is set once the upstream has written the status code.
+	wroteHeader bool
+	// headersSent is set once the status code and the headers have been sent to the client. While a body is
+	// buffered, they are only sent once it has been rewritten, so that they can still be changed until then:
+	// the header map of the underlying writer is not serialized before its WriteHeader is called.
 	headersSent bool
 	code        int
 	http.ResponseWriter
 	responses []parsedResponse
+	// response is the response configuration matching the status code, if any.
+	response *parsedResponse
+	// stream rewrites the body as it is written when the matching response is in streaming mode, or when
+	// the body is an event stream.
+	stream io.WriteCloser
+	// passthrough is set when the body is sent unmodified to the underlying writer as it is written.
+	passthrough bool
+	// contentLength is the Content-Length announced by the upstream for a body to rewrite, -1 if unknown.
+	contentLength int64
+	// spill holds the body when it is too big to be buffered in memory.
+	spill *spillFile
+	// reserved is the number of bytes accounted for the response in the maxTotalBufferedBytes budget.
+	reserved int64
+	// spillFiles are all the temporary files created for the response, to be removed once it is complete.
+	spillFiles  []*spillFile
+	spillFailed bool
+	// spillReplaced is the number of matches replaced by each rule in the spilled body, nil if it was sent
+	// unmodified.
+	spillReplaced []int
+	// cacheHit is set when the rewritten body is found in the cache, in which case cachedBody is sent and
+	// the body written by the upstream is discarded.
+	cacheHit   bool
+	cachedBody []byte
+	cachedETag string
+	// cachedModification describes how the cached body was modified, nil if it was not.
+	cachedModification *bodyModification
+	// notModified is set when a 304 Not Modified is sent instead of the cached body.
+	notModified bool
+	// multipartBoundary is the boundary of a multipart body rewritten part by part, empty otherwise.
+	multipartBoundary string
+	// failed is set once the failure response has been sent in place of a response whose rewrite failed, the
+	// body written by the upstream being discarded.
+	failed bool
+	// cacheKey and validator identify the rewritten body to cache, none if cacheKey is empty.
+	cacheKey  string
+	validator string
+	// aborted is set once the client has gone while the body was buffered, further writes being discarded.
+	aborted bool
+	// frozenHeader is the header map handed to the upstream once the status code has been written with
+	// freezeHeaders, so that its changes don't reach the response.
+	frozenHeader http.Header
+	// fullDuplex is set once the upstream has enabled full duplex, the body being passed through.
+	fullDuplex bool
+	// dryRun is the response matching the status code in dry-run mode, nil otherwise. The body is passed
+	// through, and captured in buffer to count the matches of its rewrites.
+	dryRun *parsedResponse
+	// hijacked is set once the upstream has taken over the connection, the wrapper doing nothing more.
+	hijacked   bool
+	middleware *responsebodyrewrite
+	request    *http.Request
 }
 
 // WriteHeader implements the http.ResponseWriter interface.
-// It intercepts the response status code and stores it in the responseWriter struct.
+// It intercepts the response status code and stores it in the responseWriter struct. The status code and
+// the headers of a body to buffer are only sent once the body has been rewritten.
 func (rw *responseWriter) WriteHeader(statusCode int) {
-	if rw.headersSent {
+	if rw.hijacked {
 		return
 	}
+	if !validStatusCode(statusCode) {
+		rw.warnf("%s: invalid status code %d written for %s, sending %d instead",
+			rw.middleware.name, statusCode, rw.request.URL, rw.middleware.invalidStatusCode)
+		statusCode = rw.middleware.invalidStatusCode
+	}
+	if rw.wroteHeader {
+		rw.duplicateWriteHeader(statusCode)
+		return
+	}
+
+	// Informational responses, such as 103 Early Hints, precede the final response and are forwarded right
+	// away. 101 Switching Protocols is the final response of a connection being upgraded.
+	if isInformational(statusCode) {
+		rw.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	rw.wroteHeader = true
 
 	rw.code = statusCode
 
+	// The body is sent as is when no response configuration matches the status code.
+	rw.passthrough = true
+
+	// A body written while the request body is read must reach the client right away.
+	if rw.fullDuplex {
+		rw.logMatch("full duplex enabled")
+		rw.sendHeaders()
+		return
+	}
+
+	// Without a body, there is nothing to rewrite: the headers, including Content-Length, are left untouched.
+	if hasNoBody(rw.request.Method, statusCode, contentLength(rw.ResponseWriter.Header())) {
+		rw.logMatch("no body")
+		rw.sendHeaders()
+		return
+	}
+
 	// Check if the status code is in the list of status codes to rewrite.
-	for _, response := range rw.responses {
-		if !response.status.Contains(statusCode) {
+	reason := "no matching response"
+	rw.addVariantVary(statusCode)
+	for i := range rw.responses {
+		if !rw.responses[i].matches(statusCode, rw.request) {
 			continue
 		}
-		rw.ResponseWriter.Header().Del("Content-Length")
+		if !rw.responses[i].matchesContentType(rw.ResponseWriter.Header().Get("Content-Type")) {
+			continue
+		}
+		rw.middleware.metrics.countMatch(rw.responses[i].index)
+		// The Link header is rewritten whatever happens to the body, unless in dry-run mode.
+		if linkHeader := rw.responses[i].linkHeader; linkHeader != nil && !rw.responses[i].dryRun {
+			linkHeader.rewriteHeader(rw.ResponseWriter.Header(), rw.request)
+		}
+		if rw.skipEncodedBody() {
+			break
+		}
+		// An unbounded stream would never be complete, the client waiting for a body which never comes.
+		if mediaType := rw.streamingMediaType(); mediaType != "" {
+			reason = fmt.Sprintf("response %s matches, but %s is a streaming content type", rw.responses[i].id, mediaType)
+			break
+		}
+		// Rewriting binary data would corrupt it, the headers such as Content-Length being left untouched.
+		if mediaType := rw.binaryMediaType(); mediaType != "" {
+			reason = fmt.Sprintf("response %s matches, but %s is a binary content type", rw.responses[i].id, mediaType)
+			break
+		}
+		// Rewriting a multipart body as a whole would corrupt its boundaries.
+		multipart, boundary := multipartBoundary(rw.ResponseWriter.Header().Get("Content-Type"))
+		if multipart && (!rw.responses[i].multipart || boundary == "") {
+			reason = fmt.Sprintf("response %s matches, but multipart bodies are only rewritten part by part with rewriteMultipart", rw.responses[i].id)
+			break
+		}
+		if statusCode == http.StatusPartialContent && rw.middleware.disableByteRanges {
+			rw.warnf("%s: partial response to %s despite disableByteRanges, skipping rewrite", rw.middleware.name, rw.request.URL)
+			break
+		}
+		if rw.responses[i].dryRun {
+			rw.dryRun = &rw.responses[i]
+			break
+		}
+		rw.response = &rw.responses[i]
+		rw.passthrough = false
+		rw.multipartBoundary = boundary
+		rw.contentLength = contentLength(rw.ResponseWriter.Header())
+		if rw.exceedsMaxBodySize(rw.contentLength) {
+			rw.skipRewrite()
+			break
+		}
+		rw.prepareRewriteHeaders()
+		mode, streams := rw.response.mode.(streamTransformer)
+		switch {
+		case isEventStream(rw.ResponseWriter.Header().Get("Content-Type")):
+			rw.stream = newSSERewriter(rw.ResponseWriter, rw.response)
+		case streams:
+			rw.stream = mode.newStream(rw)
+		case len(rw.response.jsonPaths) > 0:
+			rw.stream = newJSONRewriter(rw.ResponseWriter, rw.response, rw.middleware.failureMode == failureModeError)
+		case rw.response.stream:
+			rw.stream = newStreamRewriter(rw.ResponseWriter, rw.response.rewrites, rw.response.windows)
+		case rw.contentLength > 0:
+			// Make the body land in a single allocation.
+			rw.growBuffer(rw.contentLength)
+		}
+		if rw.stream == nil {
+			if rw.middleware.cache != nil {
+				rw.lookupCache(statusCode)
+			}
+			if rw.middleware.recomputeETag {
+				rw.code = rw.setRewrittenETag(statusCode)
+			}
+		}
 		break
 	}
+
+	rw.logMatch(reason)
+
+	if rw.buffering() {
+		return
+	}
+	rw.sendHeaders()
+}
+
+// logMatch logs at the debug level the response matching the status code, and whether the body is passed
+// through, for the given reason when no response matches.
+func (rw *responseWriter) logMatch(reason string) {
+	if !rw.debugEnabled() {
+		return
+	}
+	statusCode := rw.code
+	switch {
+	case rw.dryRun != nil:
+		rw.debugf("%s: response %s matches status %d of %s, passing it through in dry-run mode", rw.middleware.name, rw.dryRun.id, statusCode, rw.request.URL)
+	case rw.response == nil:
+		rw.debugf("%s: passing %s through with status %d: %s", rw.middleware.name, rw.request.URL, statusCode, reason)
+	case rw.passthrough:
+		rw.debugf("%s: response %s matches status %d of %s, passing it through", rw.middleware.name, rw.response.id, statusCode, rw.request.URL)
+	default:
+		rw.debugf("%s: response %s matches status %d of %s", rw.middleware.name, rw.response.id, statusCode, rw.request.URL)
+	}
+}
+
+// buffering reports whether the body is buffered to be rewritten once complete.
+func (rw *responseWriter) buffering() bool {
+	return !rw.passthrough && rw.stream == nil && !rw.cacheHit
+}
+
+// sendHeaders sends the status code and the headers to the client, if not done yet.
+func (rw *responseWriter) sendHeaders() {
+	if rw.headersSent {
+		return
+	}
 	rw.headersSent = true
 
-	rw.ResponseWriter.WriteHeader(statusCode)
+	header := rw.ResponseWriter.Header()
+	if rw.stripTrailers() {
+		for _, name := range declaredTrailers(header) {
+			header.Del(name)
+		}
+		header.Del("Trailer")
+		rw.ResponseWriter.WriteHeader(rw.code)
+		return
+	}
+
+	// The values of the trailers set while the body was buffered would also be sent as headers, they are
+	// only put back once the headers have been sent.
+	trailers := http.Header{}
+	for _, name := range declaredTrailers(header) {
+		if values, ok := header[name]; ok {
+			trailers[name] = values
+			delete(header, name)
+		}
+	}
+	rw.ResponseWriter.WriteHeader(rw.code)
+	for name, values := range trailers {
+		header[name] = values
+	}
 }
 
 // Write implements the http.ResponseWriter interface.
 func (rw *responseWriter) Write(p []byte) (int, error) {
+	if rw.hijacked {
+		return 0, http.ErrHijacked
+	}
 
-	if !rw.headersSent {
+	if !rw.wroteHeader {
 		rw.WriteHeader(http.StatusOK)
 	}
 
+	// Body bytes written to a bodiless response are dropped, rather than risking a framing of an empty body.
+	if !bodyAllowed(rw.code) {
+		return 0, http.ErrBodyNotAllowed
+	}
+
+	if rw.stream != nil {
+		return rw.stream.Write(p)
+	}
+
+	// The body of a cached or failed response is replaced, what the upstream writes is discarded.
+	if rw.cacheHit || rw.failed {
+		return len(p), nil
+	}
+
+	if !rw.passthrough && rw.exceedsMaxBodySize(rw.bufferedSize()+int64(len(p))) {
+		rw.skipRewrite()
+		if err := rw.downgradeToPassthrough(); err != nil {
+			return 0, err
+		}
+	}
+
+	if rw.passthrough {
+		n, err := rw.ResponseWriter.Write(p)
+		rw.captureDryRun(p[:n])
+		return n, err
+	}
+
+	if rw.abortIfClientGone() {
+		return 0, rw.request.Context().Err()
+	}
+
+	if head := rw.response.firstBytes; head > 0 && int64(rw.buffer.Len()+len(p)) >= head {
+		return rw.writeHead(p)
+	}
+
+	if rw.spill == nil && rw.exceedsSpillThreshold(int64(rw.buffer.Len()+len(p))) {
+		rw.startSpill()
+	}
+	if rw.spill != nil {
+		return rw.spill.Write(p)
+	}
+
+	if !rw.reserveBudget(int64(len(p))) {
+		rw.passthrough = true
+		if err := rw.downgradeToPassthrough(); err != nil {
+			return 0, err
+		}
+		return rw.ResponseWriter.Write(p)
+	}
+
 	return rw.buffer.Write(p)
 }
 
+// ReadFrom implements the io.ReaderFrom interface.
+// In passthrough mode, it uses the io.ReaderFrom implementation of the underlying writer when available,
+// so that file transfers can still benefit from sendfile. Otherwise, it reads directly into the buffer.
+func (rw *responseWriter) ReadFrom(r io.Reader) (int64, error) {
+	if rw.hijacked {
+		return 0, http.ErrHijacked
+	}
+
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	if !bodyAllowed(rw.code) {
+		return 0, http.ErrBodyNotAllowed
+	}
+
+	if rw.stream != nil {
+		return io.Copy(rw.stream, r)
+	}
+
+	if rw.cacheHit {
+		return io.Copy(io.Discard, r)
+	}
+
+	// The body of a dry-run response is captured by Write.
+	if rw.dryRun != nil {
+		return io.Copy(writerOnly{rw}, r)
+	}
+
+	var n int64
+	if !rw.passthrough {
+		// The body may have to be spilled to a temporary file, or accounted in the memory budget, while it
+		// is read: let Write handle that.
+		if rw.middleware.spillThreshold > 0 || rw.middleware.maxTotalBufferedBytes > 0 || rw.response.firstBytes > 0 {
+			return io.Copy(writerOnly{rw}, r)
+		}
+
+		// Stop reading the body once the client has gone.
+		var reader io.Reader = contextReader{ctx: rw.request.Context(), reader: r}
+		// Read one byte over the limit to detect a body too big to be rewritten.
+		if max := rw.middleware.maxBodySize; max > 0 {
+			reader = io.LimitReader(reader, max-int64(rw.buffer.Len())+1)
+		}
+
+		read, err := rw.buffer.ReadFrom(reader)
+		n += read
+		if rw.abortIfClientGone() {
+			return n, rw.request.Context().Err()
+		}
+		if err != nil || !rw.exceedsMaxBodySize(int64(rw.buffer.Len())) {
+			return n, err
+		}
+
+		rw.skipRewrite()
+		if err := rw.downgradeToPassthrough(); err != nil {
+			return n, err
+		}
+	}
+
+	var written int64
+	var err error
+	if readerFrom, ok := rw.ResponseWriter.(io.ReaderFrom); ok {
+		written, err = readerFrom.ReadFrom(r)
+	} else {
+		written, err = io.Copy(writerOnly{rw.ResponseWriter}, r)
+	}
+	return n + written, err
+}
+
+// abortIfClientGone reports whether the client has gone, in which case the body buffered so far is
+// discarded and the rewrite is aborted.
+func (rw *responseWriter) abortIfClientGone() bool {
+	if rw.aborted {
+		return true
+	}
+
+	select {
+	case <-rw.request.Context().Done():
+	default:
+		return false
+	}
+
+	rw.aborted = true
+	rw.buffer.Reset()
+	rw.releaseBudget()
+	rw.spill = nil
+	rw.infof("client of %s has gone, aborting rewrite", rw.request.URL)
+	return true
+}
+
+// contextReader reads from reader until its context is done.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+// Read implements the io.Reader interface.
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(p)
+}
+
+// writerOnly hides the optional interfaces of an io.Writer, such as io.ReaderFrom, from io.Copy.
+type writerOnly struct {
+	io.Writer
+}
+
+// exceedsMaxBodySize reports whether a body of the given size is too big to be rewritten.
+func (rw *responseWriter) exceedsMaxBodySize(size int64) bool {
+	return rw.middleware.maxBodySize > 0 && size > rw.middleware.maxBodySize
+}
+
+// skipRewrite switches the responseWriter to passthrough mode because the body is too big to be rewritten.
+func (rw *responseWriter) skipRewrite() {
+	rw.passthrough = true
+	rw.middleware.metrics.countTooBig()
+	rw.warnf("response body of %s exceeds maxBodySize of %d bytes, skipping rewrite", rw.request.URL, rw.middleware.maxBodySize)
+
+	if rw.middleware.maxBodySizeHeader != "" && !rw.headersSent {
+		rw.ResponseWriter.Header().Set(rw.middleware.maxBodySizeHeader, "skipped")
+	}
+}
+
 // Hijack implements the http.Hijacker interface.
+// Once the connection has been hijacked, whatever has been buffered is dropped and the responseWriter can't
+// be written to anymore.
 func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if h, ok := rw.ResponseWriter.(http.Hijacker); ok {
-		return h.Hijack()
+		conn, brw, err := h.Hijack()
+		if err != nil {
+			return nil, nil, err
+		}
+		rw.hijacked = true
+		rw.dryRun = nil
+		rw.buffer.Reset()
+		rw.releaseBudget()
+		return conn, brw, nil
 	}
 
 	return nil, nil, fmt.Errorf("not a hijacker: %T", rw.ResponseWriter)
 }
 
+// Unwrap returns the wrapped http.ResponseWriter, so that an http.ResponseController can reach the features
+// the responseWriter doesn't implement itself, such as write deadlines.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// maxBufferPreallocation is the maximum number of bytes allocated upfront for a body to rewrite, so that a
+// wrong or malicious Content-Length can't cause a huge allocation.
+const maxBufferPreallocation = 8 << 20
+
+// growBuffer grows the buffer capacity to hold a body of the given size, within the preallocation limits.
+// Past these limits, the buffer grows as the body is written.
+func (rw *responseWriter) growBuffer(size int64) {
+	if size > maxBufferPreallocation {
+		size = maxBufferPreallocation
+	}
+	if max := rw.middleware.maxBodySize; max > 0 && size > max {
+		size = max
+	}
+	// Only the head of the body is buffered when it is the only part rewritten.
+	if head := rw.response.firstBytes; head > 0 && size > head {
+		size = head
+	}
+	// A body bigger than the spill threshold doesn't stay in the buffer.
+	if threshold := rw.middleware.spillThreshold; threshold > 0 && size > threshold {
+		size = threshold
+	}
+	if n := int(size) - rw.buffer.Len(); n > 0 {
+		rw.buffer.Grow(n)
+	}
+}
+
+// writeHead completes the head of the body with p, rewrites and sends it, then switches to the passthrough
+// mode for the rest of the body.
+func (rw *responseWriter) writeHead(p []byte) (int, error) {
+	rw.buffer.Write(p)
+	head := rw.buffer.Bytes()
+	if !rw.skipEncodedBody() {
+		start := time.Now()
+		var modified bool
+		var replaced []int
+		var err error
+		headSize := int64(len(head))
+		head, modified, _, replaced, err = rw.middleware.rewriteBody(rw.response, head, rw.request)
+		rw.middleware.recordRewrite(rw, time.Since(start))
+		if err != nil && rw.handleFailure(stageRewrite, err) {
+			rw.sendFailureResponse()
+			return len(p), nil
+		}
+		if modified {
+			rw.setDebugHeader(replaced, headSize, int64(len(head)))
+			rw.addModificationHeaders()
+		} else {
+			rw.restoreContentLength()
+		}
+	}
+
+	rw.passthrough = true
+	rw.buffer.Reset()
+	rw.releaseBudget()
+
+	rw.sendHeaders()
+	if _, err := rw.ResponseWriter.Write(head); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// downgradeToPassthrough sends the body buffered so far when a response switches to the passthrough mode
+// in the middle of its body, so that the client doesn't wait for the end of the body to receive its first
+// bytes. The headers, deferred while the body was buffered, are sent first.
+func (rw *responseWriter) downgradeToPassthrough() error {
+	rw.restoreContentLength()
+	rw.sendHeaders()
+	if err := rw.writeBuffered(rw.ResponseWriter); err != nil {
+		return err
+	}
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// writeBuffered writes what has been buffered so far to w, and empties the buffer.
+func (rw *responseWriter) writeBuffered(w io.Writer) error {
+	if rw.spill != nil {
+		_, err := rw.spill.WriteTo(w)
+		rw.spill = nil
+		return err
+	}
+
+	_, err := w.Write(rw.buffer.Bytes())
+	rw.buffer.Reset()
+	rw.releaseBudget()
+	return err
+}
+
 // Flush implements the http.Flusher interface.
+// As the upstream wants the client to receive what has been written so far, a buffered body is switched
+// to the streaming mode, or to the passthrough mode when its rewrites can't be applied to a stream.
 func (rw *responseWriter) Flush() {
+	if rw.hijacked {
+		return
+	}
+
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	if rw.buffering() {
+		var err error
+		encoded := rw.skipEncodedBody()
+		if rw.response.windows != nil && !encoded {
+			rw.sendHeaders()
+			rw.stream = newStreamRewriter(rw.ResponseWriter, rw.response.rewrites, rw.response.windows)
+			err = rw.writeBuffered(rw.stream)
+		} else {
+			rw.passthrough = true
+			if !encoded {
+				rw.infof("response body of %s is flushed but can't be rewritten as a stream, skipping rewrite", rw.request.URL)
+			}
+			rw.restoreContentLength()
+			rw.sendHeaders()
+			err = rw.writeBuffered(rw.ResponseWriter)
+		}
+		if err != nil {
+			rw.logWriteError(-1, err)
+		}
+	}
+
 	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
 		flusher.Flush()
 	}
@@ -3,11 +3,23 @@ package traefik_responsebodyrewrite
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"os"
+	"reflect"
+	"regexp"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestServeHTTP(t *testing.T) {
@@ -247,6 +259,39 @@ func TestNew(t *testing.T) {
 			},
 			expErr: true,
 		},
+		{
+			desc: "should return an error for an unbounded pattern in streaming mode",
+			responses: []Response{
+				{
+					Status: "200",
+					Stream: true,
+					Rewrites: []Rewrite{
+						{
+							Regex:       "fo+",
+							Replacement: "bar",
+						},
+					},
+				},
+			},
+			expErr: true,
+		},
+		{
+			desc: "should return an error for rewriteFirstBytes in streaming mode",
+			responses: []Response{
+				{
+					Status:            "200",
+					Stream:            true,
+					RewriteFirstBytes: 1024,
+					Rewrites: []Rewrite{
+						{
+							Regex:       "foo",
+							Replacement: "bar",
+						},
+					},
+				},
+			},
+			expErr: true,
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.desc, func(t *testing.T) {
@@ -261,3 +306,2059 @@ func TestNew(t *testing.T) {
 		})
 	}
 }
+
+func TestServeHTTP_stream(t *testing.T) {
+	config := &Config{
+		Responses: []Response{
+			{
+				Status: "200",
+				Stream: true,
+				Rewrites: []Rewrite{
+					{
+						Regex:       "foo",
+						Replacement: "bar",
+					},
+				},
+			},
+		},
+	}
+
+	recorder := httptest.NewRecorder()
+	chunks := []string{"a fo", "o b", "efore the end fo", "o"}
+	expFlushed := []string{"a ", "a bar", "a bar before the end ", "a bar before the end bar"}
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Length", "38")
+		for i, chunk := range chunks {
+			_, _ = rw.Write([]byte(chunk))
+
+			// Rewritten bytes which can't be affected by the next chunks must have been sent already.
+			if recorder.Body.String() != expFlushed[i] {
+				t.Errorf("after chunk %d: got body %q, want %q", i, recorder.Body.String(), expFlushed[i])
+			}
+		}
+	}
+
+	rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rewriteBody.ServeHTTP(recorder, req)
+
+	if _, exists := recorder.Result().Header["Content-Length"]; exists {
+		t.Error("The Content-Length Header must be deleted")
+	}
+
+	if recorder.Body.String() != "a bar before the end bar" {
+		t.Errorf("got body %q, want %q", recorder.Body.String(), "a bar before the end bar")
+	}
+}
+
+func TestServeHTTP_maxBodySize(t *testing.T) {
+	tests := []struct {
+		desc          string
+		contentLength bool
+		chunks        []string
+		expResBody    string
+		expHeader     string
+	}{
+		{
+			desc:          "should rewrite a body smaller than the limit",
+			contentLength: true,
+			chunks:        []string{"foo is ", "the new bar"},
+			expResBody:    "bar is the new bar",
+		},
+		{
+			desc:          "should skip a body announced bigger than the limit",
+			contentLength: true,
+			chunks:        []string{"foo is the new bar, ", "foo is the new bar"},
+			expResBody:    "foo is the new bar, foo is the new bar",
+			expHeader:     "skipped",
+		},
+		{
+			desc:       "should skip a body growing bigger than the limit",
+			chunks:     []string{"foo is the new bar, ", "foo is the new bar"},
+			expResBody: "foo is the new bar, foo is the new bar",
+			expHeader:  "skipped",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				MaxBodySize:       20,
+				MaxBodySizeHeader: "X-Rewrite-Skipped",
+				Responses: []Response{
+					{
+						Status: "200",
+						Rewrites: []Rewrite{
+							{
+								Regex:       "foo",
+								Replacement: "bar",
+							},
+						},
+					},
+				},
+			}
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				if test.contentLength {
+					length := 0
+					for _, chunk := range test.chunks {
+						length += len(chunk)
+					}
+					rw.Header().Set("Content-Length", strconv.Itoa(length))
+				}
+				for _, chunk := range test.chunks {
+					_, _ = rw.Write([]byte(chunk))
+				}
+			}
+
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			rewriteBody.ServeHTTP(recorder, req)
+
+			if header := recorder.Result().Header.Get("X-Rewrite-Skipped"); header != test.expHeader {
+				t.Errorf("got header %q, want %q", header, test.expHeader)
+			}
+
+			if recorder.Body.String() != test.expResBody {
+				t.Errorf("got body %q, want %q", recorder.Body.String(), test.expResBody)
+			}
+		})
+	}
+}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	config := &Config{
+		Responses: []Response{
+			{
+				Status: "200-299",
+				Rewrites: []Rewrite{
+					{
+						Regex:       "foo",
+						Replacement: "bar",
+					},
+				},
+			},
+		},
+	}
+
+	body := bytes.Repeat([]byte("foo is the new bar. "), 1024)
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write(body)
+	}
+
+	rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rewriteBody.ServeHTTP(discardResponseWriter{header: make(http.Header)}, req)
+	}
+}
+
+// discardResponseWriter is an http.ResponseWriter discarding everything written to it.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d discardResponseWriter) Header() http.Header { return d.header }
+
+func (d discardResponseWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+func (d discardResponseWriter) WriteHeader(int) {}
+
+func TestServeHTTP_passthrough(t *testing.T) {
+	config := &Config{
+		Responses: []Response{
+			{
+				Status: "200",
+				Rewrites: []Rewrite{
+					{
+						Regex:       "foo",
+						Replacement: "bar",
+					},
+				},
+			},
+		},
+	}
+
+	recorder := httptest.NewRecorder()
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Length", "18")
+		rw.WriteHeader(http.StatusNotFound)
+		_, _ = rw.Write([]byte("foo is "))
+
+		// The body of a response matching no configuration must not be buffered.
+		if recorder.Body.String() != "foo is " {
+			t.Errorf("got body %q, want %q", recorder.Body.String(), "foo is ")
+		}
+
+		_, _ = rw.Write([]byte("the new bar"))
+	}
+
+	rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rewriteBody.ServeHTTP(recorder, req)
+
+	if header := recorder.Result().Header.Get("Content-Length"); header != "18" {
+		t.Errorf("got Content-Length %q, want %q", header, "18")
+	}
+
+	if recorder.Body.String() != "foo is the new bar" {
+		t.Errorf("got body %q, want %q", recorder.Body.String(), "foo is the new bar")
+	}
+}
+
+func BenchmarkServeHTTP_passthrough(b *testing.B) {
+	config := &Config{
+		Responses: []Response{
+			{
+				Status: "200-299",
+				Rewrites: []Rewrite{
+					{
+						Regex:       "foo",
+						Replacement: "bar",
+					},
+				},
+			},
+		},
+	}
+
+	body := bytes.Repeat([]byte("foo is the new bar. "), 1024)
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+		_, _ = rw.Write(body)
+	}
+
+	rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rw := discardResponseWriter{header: make(http.Header)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rewriteBody.ServeHTTP(rw, req)
+	}
+}
+
+func BenchmarkServeHTTP_noMatch(b *testing.B) {
+	config := &Config{
+		Responses: []Response{
+			{
+				Status: "200-299",
+				Rewrites: []Rewrite{
+					{
+						Regex:       "foo",
+						Replacement: "bar",
+					},
+					{
+						Regex:       "baz",
+						Replacement: "qux",
+					},
+					{
+						Regex:       "[0-9]{4}-[0-9]{4}",
+						Replacement: "XXXX-XXXX",
+					},
+				},
+			},
+		},
+	}
+
+	body := bytes.Repeat([]byte(`{"key":"value"},`), 128*1024)
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write(body)
+	}
+
+	rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rw := discardResponseWriter{header: make(http.Header)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rewriteBody.ServeHTTP(rw, req)
+	}
+}
+
+func TestParsedResponse_rewrite(t *testing.T) {
+	response := parsedResponse{
+		rewrites: []parsedRewrite{
+			{regex: regexp.MustCompile("foo"), replacement: []byte("bar")},
+			{regex: regexp.MustCompile("baz"), replacement: []byte("qux")},
+		},
+	}
+	response.passes = optimizePasses(response.rewrites)
+
+	body := []byte("nothing to see here")
+	res, modified := response.rewrite(body)
+	if modified {
+		t.Error("body without any match must not be reported as modified")
+	}
+	if &res[0] != &body[0] {
+		t.Error("body without any match must not be copied")
+	}
+
+	res, modified = response.rewrite([]byte("foo is the new bar"))
+	if !modified {
+		t.Error("body with a match must be reported as modified")
+	}
+	if string(res) != "bar is the new bar" {
+		t.Errorf("got body %q, want %q", res, "bar is the new bar")
+	}
+}
+
+func TestServeHTTP_flush(t *testing.T) {
+	tests := []struct {
+		desc       string
+		regex      string
+		expEvent   string
+		expResBody string
+	}{
+		{
+			desc:       "should switch to streaming mode when the rewrites allow it",
+			regex:      "foo",
+			expEvent:   "event %d: bar",
+			expResBody: "event 0: bar\nevent 1: bar\nevent 2: bar\n",
+		},
+		{
+			desc:       "should switch to passthrough mode when the rewrites can't be streamed",
+			regex:      "fo+",
+			expEvent:   "event %d: foo",
+			expResBody: "event 0: foo\nevent 1: foo\nevent 2: foo\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				Responses: []Response{
+					{
+						Status: "200",
+						Rewrites: []Rewrite{
+							{
+								Regex:       test.regex,
+								Replacement: "bar",
+							},
+						},
+					},
+				},
+			}
+
+			recorder := httptest.NewRecorder()
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				for i := 0; i < 3; i++ {
+					_, _ = fmt.Fprintf(rw, "event %d: foo\n", i)
+					rw.(http.Flusher).Flush()
+
+					// What has been flushed must have reached the client.
+					if expected := fmt.Sprintf(test.expEvent, i); !strings.Contains(recorder.Body.String(), expected) {
+						t.Errorf("after flush %d: got body %q, want it to contain %q", i, recorder.Body.String(), expected)
+					}
+					if !recorder.Flushed {
+						t.Errorf("after flush %d: underlying writer not flushed", i)
+					}
+				}
+			}
+
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rewriteBody.ServeHTTP(recorder, req)
+
+			if recorder.Body.String() != test.expResBody {
+				t.Errorf("got body %q, want %q", recorder.Body.String(), test.expResBody)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_readFrom(t *testing.T) {
+	tests := []struct {
+		desc         string
+		status       int
+		maxBodySize  int64
+		expResBody   string
+		expReadFrom  bool
+		expReadBytes int64
+	}{
+		{
+			desc:         "should read a body to rewrite into the buffer",
+			status:       http.StatusOK,
+			expResBody:   "bar is the new bar",
+			expReadBytes: 18,
+		},
+		{
+			desc:         "should send a body too big to be rewritten unmodified",
+			status:       http.StatusOK,
+			maxBodySize:  10,
+			expResBody:   "foo is the new bar",
+			expReadFrom:  true,
+			expReadBytes: 18,
+		},
+		{
+			desc:         "should delegate to the underlying writer in passthrough mode",
+			status:       http.StatusNotFound,
+			expResBody:   "foo is the new bar",
+			expReadFrom:  true,
+			expReadBytes: 18,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				MaxBodySize: test.maxBodySize,
+				Responses: []Response{
+					{
+						Status: "200",
+						Rewrites: []Rewrite{
+							{
+								Regex:       "foo",
+								Replacement: "bar",
+							},
+						},
+					},
+				},
+			}
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Content-Length", "18")
+				rw.WriteHeader(test.status)
+
+				// Hide the io.WriterTo implementation of the reader, so that io.Copy uses io.ReaderFrom.
+				n, err := io.Copy(rw, struct{ io.Reader }{strings.NewReader("foo is the new bar")})
+				if err != nil {
+					t.Error(err)
+				}
+				if n != test.expReadBytes {
+					t.Errorf("got %d bytes copied, want %d", n, test.expReadBytes)
+				}
+			}
+
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			rewriteBody.ServeHTTP(recorder, req)
+
+			if recorder.readFrom != test.expReadFrom {
+				t.Errorf("got underlying ReadFrom called %v, want %v", recorder.readFrom, test.expReadFrom)
+			}
+
+			if recorder.Body.String() != test.expResBody {
+				t.Errorf("got body %q, want %q", recorder.Body.String(), test.expResBody)
+			}
+		})
+	}
+}
+
+// readerFromRecorder is an httptest.ResponseRecorder implementing io.ReaderFrom.
+type readerFromRecorder struct {
+	*httptest.ResponseRecorder
+	readFrom bool
+}
+
+func (r *readerFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.readFrom = true
+	return r.Body.ReadFrom(src)
+}
+
+func BenchmarkServeHTTP_file(b *testing.B) {
+	file, err := os.CreateTemp(b.TempDir(), "body")
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err = file.Write(bytes.Repeat([]byte("foo is the new bar. "), 512*1024)); err != nil {
+		b.Fatal(err)
+	}
+	if err = file.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.ServeFile(rw, req, file.Name())
+	})
+
+	config := &Config{
+		Responses: []Response{
+			{
+				Status: "500",
+				Rewrites: []Rewrite{
+					{
+						Regex:       "foo",
+						Replacement: "bar",
+					},
+				},
+			},
+		},
+	}
+
+	rewriteBody, err := New(context.Background(), next, config, "rewriteBody")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for name, handler := range map[string]http.Handler{"direct": next, "wrapped": rewriteBody} {
+		b.Run(name, func(b *testing.B) {
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			b.SetBytes(10 * 1024 * 1024)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				res, err := server.Client().Get(server.URL)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_, _ = io.Copy(io.Discard, res.Body)
+				_ = res.Body.Close()
+			}
+		})
+	}
+}
+
+func TestServeHTTP_spill(t *testing.T) {
+	tests := []struct {
+		desc       string
+		panics     bool
+		expResBody string
+	}{
+		{
+			desc:       "should rewrite a body spilled to a temporary file",
+			expResBody: "qux is the new qux, qux is the new qux",
+		},
+		{
+			desc:   "should remove the temporary file when the upstream panics",
+			panics: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			dir := t.TempDir()
+			config := &Config{
+				SpillThresholdBytes: 10,
+				SpillDir:            dir,
+				Responses: []Response{
+					{
+						Status: "200",
+						Rewrites: []Rewrite{
+							{
+								Regex:       "foo",
+								Replacement: "bar",
+							},
+							{
+								Regex:       "bar",
+								Replacement: "qux",
+							},
+						},
+					},
+				},
+			}
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				_, _ = rw.Write([]byte("foo is the new bar, "))
+				_, _ = rw.Write([]byte("foo is the new bar"))
+
+				if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+					t.Errorf("got %d temporary files, want 1", len(entries))
+				}
+
+				if test.panics {
+					panic("upstream failure")
+				}
+			}
+
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			func() {
+				defer func() { _ = recover() }()
+				rewriteBody.ServeHTTP(recorder, req)
+			}()
+
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("got %d temporary files left, want 0", len(entries))
+			}
+
+			if recorder.Body.String() != test.expResBody {
+				t.Errorf("got body %q, want %q", recorder.Body.String(), test.expResBody)
+			}
+		})
+	}
+}
+
+func BenchmarkServeHTTP_contentLength(b *testing.B) {
+	config := &Config{
+		Responses: []Response{
+			{
+				Status: "200-299",
+				Rewrites: []Rewrite{
+					{
+						Regex:       "foo",
+						Replacement: "bar",
+					},
+				},
+			},
+		},
+	}
+
+	chunk := bytes.Repeat([]byte("no match in this body. "), 1424)
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Length", strconv.Itoa(len(chunk)*160))
+		rw.WriteHeader(http.StatusOK)
+		for i := 0; i < 160; i++ {
+			_, _ = rw.Write(chunk)
+		}
+	}
+
+	rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rewriteBody.ServeHTTP(discardResponseWriter{header: make(http.Header)}, req)
+	}
+}
+
+func TestResponseWriter_growBuffer(t *testing.T) {
+	tests := []struct {
+		desc        string
+		size        int64
+		maxBodySize int64
+		firstBytes  int64
+		maxCap      int
+		minCap      int
+	}{
+		{
+			desc:   "should grow the buffer to the body size",
+			size:   1024,
+			minCap: 1024,
+			maxCap: 4096,
+		},
+		{
+			desc:   "should clamp a huge body size",
+			size:   1 << 40,
+			minCap: maxBufferPreallocation,
+			maxCap: 2 * maxBufferPreallocation,
+		},
+		{
+			desc:        "should clamp the body size to maxBodySize",
+			size:        1 << 20,
+			maxBodySize: 1024,
+			minCap:      1024,
+			maxCap:      4096,
+		},
+		{
+			desc:       "should clamp the body size to rewriteFirstBytes",
+			size:       1 << 20,
+			firstBytes: 1024,
+			minCap:     1024,
+			maxCap:     4096,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			rw := &responseWriter{
+				middleware: &responsebodyrewrite{maxBodySize: test.maxBodySize},
+				response:   &parsedResponse{firstBytes: test.firstBytes},
+			}
+			rw.growBuffer(test.size)
+
+			if rw.buffer.Cap() < test.minCap || rw.buffer.Cap() > test.maxCap {
+				t.Errorf("got capacity %d, want between %d and %d", rw.buffer.Cap(), test.minCap, test.maxCap)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_rewriteGuardrails(t *testing.T) {
+	tests := []struct {
+		desc       string
+		config     Config
+		expResBody string
+	}{
+		{
+			desc:       "should rewrite a body within the limits",
+			config:     Config{MaxRewriteBytes: 100, MaxRewriteDuration: "1m"},
+			expResBody: "baz is the new baz",
+		},
+		{
+			desc:       "should skip the rewrite of a body bigger than maxRewriteBytes",
+			config:     Config{MaxRewriteBytes: 10},
+			expResBody: "foo is the new bar",
+		},
+		{
+			desc:       "should send the partially rewritten body once maxRewriteDuration expired",
+			config:     Config{MaxRewriteDuration: "1ns"},
+			expResBody: "bar is the new bar",
+		},
+		{
+			desc:       "should send the original body once maxRewriteDuration expired",
+			config:     Config{MaxRewriteDuration: "1ns", MaxRewriteDurationPolicy: "original"},
+			expResBody: "foo is the new bar",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := test.config
+			config.Responses = []Response{
+				{
+					Status: "200",
+					Rewrites: []Rewrite{
+						{
+							Regex:       "foo",
+							Replacement: "bar",
+						},
+						{
+							Regex:       "bar",
+							Replacement: "baz",
+						},
+					},
+				},
+			}
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				_, _ = rw.Write([]byte("foo is the new bar"))
+			}
+
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), &config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			rewriteBody.ServeHTTP(recorder, req)
+
+			if recorder.Body.String() != test.expResBody {
+				t.Errorf("got body %q, want %q", recorder.Body.String(), test.expResBody)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_rewriteFirstBytes(t *testing.T) {
+	config := &Config{
+		Responses: []Response{
+			{
+				Status:            "200",
+				RewriteFirstBytes: 10,
+				Rewrites: []Rewrite{
+					{
+						Regex:       "foo",
+						Replacement: "bar",
+					},
+				},
+			},
+		},
+	}
+
+	recorder := httptest.NewRecorder()
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Length", "23")
+		_, _ = rw.Write([]byte("foo is "))
+		_, _ = rw.Write([]byte("foo, "))
+
+		// Once the head has been written, it must be rewritten and sent.
+		if recorder.Body.String() != "bar is bar, " {
+			t.Errorf("got body %q, want %q", recorder.Body.String(), "bar is bar, ")
+		}
+
+		_, _ = rw.Write([]byte("foo is foo"))
+	}
+
+	rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rewriteBody.ServeHTTP(recorder, req)
+
+	if header := recorder.Result().Header.Get("Content-Length"); header != "" {
+		t.Errorf("got Content-Length %q, want none", header)
+	}
+
+	if recorder.Body.String() != "bar is bar, foo is foo" {
+		t.Errorf("got body %q, want %q", recorder.Body.String(), "bar is bar, foo is foo")
+	}
+}
+
+func TestServeHTTP_jsonPaths(t *testing.T) {
+	config := &Config{
+		Responses: []Response{
+			{
+				Status:    "200",
+				JSONPaths: []string{"links.self"},
+				Rewrites: []Rewrite{
+					{
+						Regex:       "internal.local",
+						Replacement: "example.com",
+					},
+				},
+			},
+		},
+	}
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`{"id": 12, "host": "internal.local", "links": {"self": "https://inter`))
+		_, _ = rw.Write([]byte(`nal.local/12"}}`))
+	}
+
+	rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rewriteBody.ServeHTTP(recorder, req)
+
+	expBody := `{"id": 12, "host": "internal.local", "links": {"self": "https://example.com/12"}}`
+	if recorder.Body.String() != expBody {
+		t.Errorf("got body %q, want %q", recorder.Body.String(), expBody)
+	}
+}
+
+func TestServeHTTP_maxOutputBytes(t *testing.T) {
+	tests := []struct {
+		desc           string
+		spillThreshold int64
+		maxOutputBytes int64
+		expResBody     string
+	}{
+		{
+			desc:           "should rewrite a body within maxOutputBytes",
+			maxOutputBytes: 100,
+			expResBody:     "barbar is the new barbar",
+		},
+		{
+			desc:           "should send the original body when the rewrite exceeds maxOutputBytes",
+			maxOutputBytes: 20,
+			expResBody:     "foo is the new bar",
+		},
+		{
+			desc:           "should rewrite a spilled body within maxOutputBytes",
+			spillThreshold: 5,
+			maxOutputBytes: 100,
+			expResBody:     "barbar is the new barbar",
+		},
+		{
+			desc:           "should send the original spilled body when the rewrite exceeds maxOutputBytes",
+			spillThreshold: 5,
+			maxOutputBytes: 20,
+			expResBody:     "foo is the new bar",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				SpillThresholdBytes: test.spillThreshold,
+				SpillDir:            t.TempDir(),
+				Responses: []Response{
+					{
+						Status:         "200",
+						MaxOutputBytes: test.maxOutputBytes,
+						Rewrites: []Rewrite{
+							{
+								Regex:       "foo",
+								Replacement: "bar",
+							},
+							{
+								Regex:       "bar",
+								Replacement: "barbar",
+							},
+						},
+					},
+				},
+			}
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				_, _ = rw.Write([]byte("foo is the new bar"))
+			}
+
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rewriteBody.ServeHTTP(recorder, req)
+
+			if recorder.Body.String() != test.expResBody {
+				t.Errorf("got body %q, want %q", recorder.Body.String(), test.expResBody)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_clientGone(t *testing.T) {
+	type result struct {
+		writes   int
+		buffered int
+		err      error
+	}
+	results := make(chan result, 1)
+
+	// The upstream writes slowly, until a write fails.
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		chunk := bytes.Repeat([]byte("foo "), 256)
+		for i := 0; i < 500; i++ {
+			if _, err := rw.Write(chunk); err != nil {
+				results <- result{writes: i, buffered: rw.(*responseWriter).buffer.Len(), err: err}
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		results <- result{writes: 500}
+	}
+
+	config := &Config{
+		Responses: []Response{
+			{
+				Status:   "200",
+				Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+			},
+		},
+	}
+	rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(rewriteBody)
+	defer server.Close()
+
+	// The client gives up before the body is complete.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := server.Client().Do(req); err == nil {
+		_ = res.Body.Close()
+		t.Fatal("expected the request to be canceled")
+	}
+
+	select {
+	case res := <-results:
+		if res.err == nil {
+			t.Fatalf("expected a write to fail once the client has gone, got %d successful writes", res.writes)
+		}
+		if res.buffered != 0 {
+			t.Errorf("got %d bytes still buffered once the client has gone, want 0", res.buffered)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the upstream kept writing once the client has gone")
+	}
+}
+
+func TestServeHTTP_downgradeToPassthrough(t *testing.T) {
+	config := &Config{
+		MaxBodySize: 20,
+		Responses: []Response{
+			{
+				Status:   "200",
+				Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+			},
+		},
+	}
+
+	recorder := httptest.NewRecorder()
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain")
+		_, _ = rw.Write([]byte("foo is the new bar, "))
+		_, _ = rw.Write([]byte("foo is "))
+
+		// Once the body exceeds the limit, what has been buffered must be sent without waiting for the rest.
+		if !recorder.Flushed {
+			t.Error("expected the body to be flushed once it exceeded the limit")
+		}
+		if recorder.Body.String() != "foo is the new bar, foo is " {
+			t.Errorf("got body %q, want %q", recorder.Body.String(), "foo is the new bar, foo is ")
+		}
+
+		_, _ = rw.Write([]byte("the new bar"))
+	}
+
+	rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rewriteBody.ServeHTTP(recorder, req)
+
+	if header := recorder.Result().Header.Get("Content-Type"); header != "text/plain" {
+		t.Errorf("got Content-Type %q, want %q", header, "text/plain")
+	}
+	if recorder.Body.String() != "foo is the new bar, foo is the new bar" {
+		t.Errorf("got body %q, want %q", recorder.Body.String(), "foo is the new bar, foo is the new bar")
+	}
+}
+
+func TestServeHTTP_noBody(t *testing.T) {
+	tests := []struct {
+		desc          string
+		method        string
+		status        int
+		contentLength string
+	}{
+		{
+			desc:          "should not touch the response to a HEAD request",
+			method:        http.MethodHead,
+			status:        http.StatusOK,
+			contentLength: "18",
+		},
+		{
+			desc:   "should not touch a 204 response",
+			method: http.MethodGet,
+			status: http.StatusNoContent,
+		},
+		{
+			desc:          "should not touch a 304 response",
+			method:        http.MethodGet,
+			status:        http.StatusNotModified,
+			contentLength: "18",
+		},
+		{
+			desc:          "should not touch an empty response",
+			method:        http.MethodGet,
+			status:        http.StatusOK,
+			contentLength: "0",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				Responses: []Response{
+					{
+						Status:   "200-399",
+						Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+					},
+				},
+			}
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				if test.contentLength != "" {
+					rw.Header().Set("Content-Length", test.contentLength)
+				}
+				rw.WriteHeader(test.status)
+				// The responses to HEAD requests aren't even wrapped.
+				if wrapped, ok := rw.(*responseWriter); ok && !wrapped.passthrough {
+					t.Error("expected a response without body to be passed through")
+				}
+			}
+
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(test.method, "/", nil)
+			rewriteBody.ServeHTTP(recorder, req)
+
+			if recorder.Code != test.status {
+				t.Errorf("got status %d, want %d", recorder.Code, test.status)
+			}
+			if header := recorder.Header().Get("Content-Length"); header != test.contentLength {
+				t.Errorf("got Content-Length %q, want %q", header, test.contentLength)
+			}
+			if recorder.Body.Len() != 0 {
+				t.Errorf("got body %q, want none", recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestServeHTTP_deferredHeaders(t *testing.T) {
+	tests := []struct {
+		desc      string
+		status    int
+		flush     bool
+		expSent   bool
+		expHeader string
+		expBody   string
+	}{
+		{
+			desc:      "should defer the headers of a buffered body until it is rewritten",
+			status:    http.StatusCreated,
+			expHeader: "late",
+			expBody:   "bar is the new bar",
+		},
+		{
+			desc:    "should send the headers of a passed through body right away",
+			status:  http.StatusNotFound,
+			expSent: true,
+			expBody: "foo is the new bar",
+		},
+		{
+			desc:    "should send the headers of a flushed body right away",
+			status:  http.StatusCreated,
+			flush:   true,
+			expSent: true,
+			expBody: "bar is the new bar",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				Responses: []Response{
+					{
+						Status:   "200-299",
+						Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+					},
+				},
+			}
+
+			recorder := httptest.NewRecorder()
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("X-Early", "early")
+				rw.WriteHeader(test.status)
+				_, _ = rw.Write([]byte("foo is the "))
+				if test.flush {
+					rw.(http.Flusher).Flush()
+				}
+				if sent := recorder.Code == test.status; sent != test.expSent {
+					t.Errorf("got headers sent %t after the first write, want %t", sent, test.expSent)
+				}
+				rw.Header().Set("X-Late", "late")
+				_, _ = rw.Write([]byte("new bar"))
+			}
+
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rewriteBody.ServeHTTP(recorder, req)
+
+			result := recorder.Result()
+			if result.StatusCode != test.status {
+				t.Errorf("got status %d, want %d", result.StatusCode, test.status)
+			}
+			if header := result.Header.Get("X-Early"); header != "early" {
+				t.Errorf("got X-Early header %q, want %q", header, "early")
+			}
+			if header := result.Header.Get("X-Late"); header != test.expHeader {
+				t.Errorf("got X-Late header %q, want %q", header, test.expHeader)
+			}
+			if recorder.Body.String() != test.expBody {
+				t.Errorf("got body %q, want %q", recorder.Body.String(), test.expBody)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_unmodifiedContentLength(t *testing.T) {
+	tests := []struct {
+		desc          string
+		body          string
+		expBody       string
+		contentLength string
+	}{
+		{
+			desc:          "should keep the headers of a body left unmodified",
+			body:          "baz is the new qux",
+			expBody:       "baz is the new qux",
+			contentLength: "18",
+		},
+		{
+			desc:    "should remove the Content-Length of a rewritten body",
+			body:    "foo is the new qux",
+			expBody: "bar is the new qux",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				Responses: []Response{
+					{
+						Status:   "200",
+						Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+					},
+				},
+			}
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Content-Type", "text/plain")
+				rw.Header().Set("Content-Length", strconv.Itoa(len(test.body)))
+				_, _ = rw.Write([]byte(test.body))
+			}
+
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rewriteBody.ServeHTTP(recorder, req)
+
+			expHeader := http.Header{"Content-Type": {"text/plain"}}
+			if test.contentLength != "" {
+				expHeader.Set("Content-Length", test.contentLength)
+			}
+			if header := recorder.Result().Header; !reflect.DeepEqual(header, expHeader) {
+				t.Errorf("got headers %v, want %v", header, expHeader)
+			}
+			if recorder.Body.String() != test.expBody {
+				t.Errorf("got body %q, want %q", recorder.Body.String(), test.expBody)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_informational(t *testing.T) {
+	tests := []struct {
+		desc       string
+		interim    []int
+		expectBody bool
+		expInterim []int
+	}{
+		{
+			desc:       "should forward several 103 Early Hints before the final response",
+			interim:    []int{http.StatusEarlyHints, http.StatusEarlyHints},
+			expInterim: []int{http.StatusEarlyHints, http.StatusEarlyHints},
+		},
+		{
+			desc:       "should forward a 100 Continue to a request expecting it",
+			interim:    []int{http.StatusContinue},
+			expectBody: true,
+			expInterim: []int{http.StatusContinue},
+		},
+		{
+			desc:       "should let the server send a 100 Continue when the body is read",
+			expectBody: true,
+			expInterim: []int{http.StatusContinue},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				Responses: []Response{
+					{
+						Status:   "200",
+						Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+					},
+				},
+			}
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				for _, code := range test.interim {
+					if code == http.StatusEarlyHints {
+						rw.Header().Add("Link", "</style.css>; rel=preload; as=style")
+					}
+					rw.WriteHeader(code)
+				}
+				// Reading the body of a request expecting 100-continue sends the 100 Continue response.
+				body, _ := io.ReadAll(req.Body)
+				rw.WriteHeader(http.StatusOK)
+				_, _ = rw.Write(append([]byte("foo is the new bar"), body...))
+			}
+
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			server := httptest.NewServer(rewriteBody)
+			defer server.Close()
+
+			var interim []int
+			var gotContinue bool
+			trace := &httptrace.ClientTrace{
+				Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+					interim = append(interim, code)
+					return nil
+				},
+				Got100Continue: func() {
+					gotContinue = true
+				},
+			}
+
+			var reqBody io.Reader
+			if test.expectBody {
+				reqBody = strings.NewReader(", foo")
+			}
+			req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodPost, server.URL, reqBody)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.expectBody {
+				req.Header.Set("Expect", "100-continue")
+			}
+
+			res, err := server.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = res.Body.Close() }()
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(interim, test.expInterim) {
+				t.Errorf("got interim responses %v, want %v", interim, test.expInterim)
+			}
+			if gotContinue != test.expectBody {
+				t.Errorf("got 100 Continue %t, want %t", gotContinue, test.expectBody)
+			}
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("got status %d, want %d", res.StatusCode, http.StatusOK)
+			}
+			expBody := "bar is the new bar"
+			if test.expectBody {
+				expBody += ", bar"
+			}
+			if string(body) != expBody {
+				t.Errorf("got body %q, want %q", body, expBody)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_hijack(t *testing.T) {
+	const raw = "HTTP/1.1 200 OK\r\nContent-Length: 3\r\nConnection: close\r\n\r\nfoo"
+
+	writeErrs := make(chan error, 1)
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		// What is buffered before the connection is hijacked is never sent.
+		_, _ = rw.Write([]byte("foo is the new bar"))
+
+		conn, brw, err := rw.(http.Hijacker).Hijack()
+		if err != nil {
+			writeErrs <- err
+			return
+		}
+		_, _ = brw.WriteString(raw)
+		_ = brw.Flush()
+		_ = conn.Close()
+
+		rw.WriteHeader(http.StatusInternalServerError)
+		_, err = rw.Write([]byte("foo"))
+		writeErrs <- err
+	}
+
+	config := &Config{
+		Responses: []Response{
+			{
+				Status:   "200",
+				Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+			},
+		},
+	}
+	rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(rewriteBody)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	received, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(received) != raw {
+		t.Errorf("got %q on the hijacked connection, want %q", received, raw)
+	}
+	if err := <-writeErrs; !errors.Is(err, http.ErrHijacked) {
+		t.Errorf("got error %v writing after the hijack, want %v", err, http.ErrHijacked)
+	}
+}
+
+// controlledRecorder is a ResponseRecorder recording the ResponseController calls reaching it.
+type controlledRecorder struct {
+	*httptest.ResponseRecorder
+	writeDeadline time.Time
+	fullDuplex    bool
+}
+
+func (c *controlledRecorder) SetWriteDeadline(deadline time.Time) error {
+	c.writeDeadline = deadline
+	return nil
+}
+
+func (c *controlledRecorder) EnableFullDuplex() error {
+	c.fullDuplex = true
+	return nil
+}
+
+func TestServeHTTP_responseController(t *testing.T) {
+	deadline := time.Now().Add(time.Minute)
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		controller := http.NewResponseController(rw)
+		if err := controller.SetWriteDeadline(deadline); err != nil {
+			t.Errorf("unable to set the write deadline: %v", err)
+		}
+		_, _ = rw.Write([]byte("foo is the new bar"))
+		if err := controller.Flush(); err != nil {
+			t.Errorf("unable to flush: %v", err)
+		}
+	}
+
+	config := &Config{
+		Responses: []Response{
+			{
+				Status:   "200",
+				Stream:   true,
+				Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+			},
+		},
+	}
+	rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &controlledRecorder{ResponseRecorder: httptest.NewRecorder()}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rewriteBody.ServeHTTP(recorder, req)
+
+	if !recorder.writeDeadline.Equal(deadline) {
+		t.Errorf("got write deadline %v, want %v", recorder.writeDeadline, deadline)
+	}
+	if !recorder.Flushed {
+		t.Error("expected the flush to reach the underlying writer")
+	}
+	if recorder.Body.String() != "bar is the new bar" {
+		t.Errorf("got body %q, want %q", recorder.Body.String(), "bar is the new bar")
+	}
+}
+
+func TestServeHTTP_bodilessStatus(t *testing.T) {
+	for _, status := range []int{http.StatusNoContent, http.StatusNotModified} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			config := &Config{
+				Responses: []Response{
+					{
+						Status:   "200-399",
+						Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+					},
+				},
+			}
+
+			writeErrs := make(chan error, 1)
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Cache-Control", "max-age=60")
+				rw.Header().Set("ETag", `"foo"`)
+				rw.WriteHeader(status)
+				_, err := rw.Write([]byte("foo is the new bar"))
+				writeErrs <- err
+			}
+
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			server := httptest.NewServer(rewriteBody)
+			defer server.Close()
+
+			res, err := server.Client().Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = res.Body.Close() }()
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := <-writeErrs; !errors.Is(err, http.ErrBodyNotAllowed) {
+				t.Errorf("got error %v writing a body, want %v", err, http.ErrBodyNotAllowed)
+			}
+			if res.StatusCode != status {
+				t.Errorf("got status %d, want %d", res.StatusCode, status)
+			}
+			if len(res.TransferEncoding) != 0 || res.Header.Get("Transfer-Encoding") != "" {
+				t.Errorf("got Transfer-Encoding %v, want none", res.TransferEncoding)
+			}
+			if res.Header.Get("Cache-Control") != "max-age=60" || res.Header.Get("ETag") != `"foo"` {
+				t.Errorf("got headers %v, want the upstream Cache-Control and ETag", res.Header)
+			}
+			if len(body) != 0 {
+				t.Errorf("got body %q, want none", body)
+			}
+		})
+	}
+}
+
+// callRecorder is a ResponseRecorder counting the calls made to it.
+type callRecorder struct {
+	*httptest.ResponseRecorder
+	writeHeaders int
+	writes       int
+}
+
+func (c *callRecorder) WriteHeader(statusCode int) {
+	c.writeHeaders++
+	c.ResponseRecorder.WriteHeader(statusCode)
+}
+
+func (c *callRecorder) Write(p []byte) (int, error) {
+	c.writes++
+	return c.ResponseRecorder.Write(p)
+}
+
+func TestServeHTTP_finalize(t *testing.T) {
+	tests := []struct {
+		desc            string
+		next            func(rw http.ResponseWriter, req *http.Request)
+		expWriteHeaders int
+		expStatus       int
+		expBody         string
+	}{
+		{
+			desc:      "should forward nothing when the upstream writes nothing",
+			next:      func(rw http.ResponseWriter, req *http.Request) {},
+			expStatus: http.StatusOK,
+		},
+		{
+			desc: "should only forward the status code when the upstream writes no body",
+			next: func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusCreated)
+			},
+			expWriteHeaders: 1,
+			expStatus:       http.StatusCreated,
+		},
+		{
+			desc: "should forward the status code of an empty body written deliberately",
+			next: func(rw http.ResponseWriter, req *http.Request) {
+				_, _ = rw.Write(nil)
+			},
+			expWriteHeaders: 1,
+			expStatus:       http.StatusOK,
+		},
+		{
+			desc: "should forward the rewritten empty body",
+			next: func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusAccepted)
+			},
+			expWriteHeaders: 1,
+			expStatus:       http.StatusAccepted,
+			expBody:         "accepted",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				Responses: []Response{
+					{
+						Status:   "200-201",
+						Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+					},
+					{
+						Status:   "202",
+						Rewrites: []Rewrite{{Regex: "^$", Replacement: "accepted"}},
+					},
+				},
+			}
+
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(test.next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := &callRecorder{ResponseRecorder: httptest.NewRecorder()}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rewriteBody.ServeHTTP(recorder, req)
+
+			if recorder.writeHeaders != test.expWriteHeaders {
+				t.Errorf("got %d status codes forwarded, want %d", recorder.writeHeaders, test.expWriteHeaders)
+			}
+			expWrites := 0
+			if test.expBody != "" {
+				expWrites = 1
+			}
+			if recorder.writes != expWrites {
+				t.Errorf("got %d body writes forwarded, want %d", recorder.writes, expWrites)
+			}
+			if recorder.Code != test.expStatus {
+				t.Errorf("got status %d, want %d", recorder.Code, test.expStatus)
+			}
+			if recorder.Body.String() != test.expBody {
+				t.Errorf("got body %q, want %q", recorder.Body.String(), test.expBody)
+			}
+		})
+	}
+}
+
+func TestNew_responseErrors(t *testing.T) {
+	tests := []struct {
+		desc      string
+		responses []Response
+		expErr    string
+	}{
+		{
+			desc: "invalid status",
+			responses: []Response{
+				{Status: "200", Rewrites: []Rewrite{{Regex: "foo"}}},
+				{Status: "404, 2x0 - 299", Rewrites: []Rewrite{{Regex: "foo"}}},
+			},
+			expErr: `responses[1]: status: invalid block "2x0-299": `,
+		},
+		{
+			desc: "invalid regex",
+			responses: []Response{
+				{Status: "200", Rewrites: []Rewrite{{Regex: "foo"}, {Regex: "ba(r"}}},
+			},
+			expErr: `responses[0]: rewrites[1].regex: error compiling regex "ba(r" with the regexp engine: `,
+		},
+		{
+			desc: "empty regex",
+			responses: []Response{
+				{Status: "200", Rewrites: []Rewrite{{Regex: "", Replacement: "foo"}}},
+			},
+			expErr: "responses[0]: rewrites[0].regex: must not be empty",
+		},
+		{
+			desc: "missing group",
+			responses: []Response{
+				{Status: "200", Rewrites: []Rewrite{{Regex: "f(o)o", Replacement: "$1x"}}},
+			},
+			expErr: `responses[0]: rewrites[0].replacement: "$1x" refers to group "1x", which regex "f(o)o" doesn't have`,
+		},
+		{
+			desc: "named response",
+			responses: []Response{
+				{Name: "api", Status: "200", Rewrites: []Rewrite{{Regex: ""}}},
+			},
+			expErr: `responses[0] "api": rewrites[0].regex: must not be empty`,
+		},
+		{
+			desc: "duplicate name",
+			responses: []Response{
+				{Name: "api", Status: "200", Rewrites: []Rewrite{{Regex: "foo"}}},
+				{Status: "404", Rewrites: []Rewrite{{Regex: "foo"}}},
+				{Name: "api", Status: "500", Rewrites: []Rewrite{{Regex: "foo"}}},
+			},
+			expErr: `responses[2]: name "api" already used by responses[0]`,
+		},
+		{
+			desc: "numeric name",
+			responses: []Response{
+				{Name: "1", Status: "200", Rewrites: []Rewrite{{Regex: "foo"}}},
+			},
+			expErr: `responses[0] "1": invalid name "1": must not be a number`,
+		},
+		{
+			desc: "invalid name",
+			responses: []Response{
+				{Name: "my api", Status: "200", Rewrites: []Rewrite{{Regex: "foo"}}},
+			},
+			expErr: `responses[0] "my api": invalid name "my api": must only contain`,
+		},
+		{
+			desc: "no rewrites",
+			responses: []Response{
+				{Status: "200"},
+			},
+			expErr: `responses[0]: rewrites: must not be empty unless trailers is "strip", or jsonapiErrors, yamlOps, html, sitemap, feed, hls, dash, vast, openapi, oidc, linkHeader or a csv mask is set`,
+		},
+		{
+			desc: "unbounded regex in streaming mode",
+			responses: []Response{
+				{Status: "200", Stream: true, Rewrites: []Rewrite{{Regex: "foo"}, {Regex: "fo+"}}},
+			},
+			expErr: `responses[0]: rewrites[1].regex: "fo+" can't be used in streaming mode: `,
+		},
+		{
+			desc: "regexp2 regex in streaming mode",
+			responses: []Response{
+				{Status: "200", Stream: true, Rewrites: []Rewrite{{Regex: "foo(?!bar)", Replacement: "baz", Engine: "regexp2", MatchTimeout: "100ms"}}},
+			},
+			expErr: `responses[0]: rewrites[0].regex: "foo(?!bar)" can't be used in streaming mode: the regexp2 engine only rewrites whole bodies`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := New(context.Background(), nil, &Config{Responses: test.responses}, "rewriteBody")
+			if err == nil || !strings.HasPrefix(err.Error(), test.expErr) {
+				t.Errorf("got error %v, want %q", err, test.expErr)
+			}
+		})
+	}
+
+	config := &Config{Responses: []Response{{Status: "200", Trailers: trailersStrip}}}
+	if _, err := New(context.Background(), nil, config, "rewriteBody"); err != nil {
+		t.Errorf("got error %v for a response stripping trailers, want none", err)
+	}
+}
+
+func TestNew_emptyReplacement(t *testing.T) {
+	tests := []struct {
+		desc   string
+		config Config
+		// json, if set, is decoded onto CreateConfig in place of config, as done by Traefik.
+		json    string
+		expErr  string
+		expBody string
+	}{
+		{
+			desc:   "empty replacement",
+			config: Config{Version: 2, Responses: []Response{{Status: "200", Rewrites: []Rewrite{{Regex: "bar", Replacement: "baz"}, {Regex: "foo"}}}}},
+			expErr: "responses[0]: rewrites[1].replacement: must not be empty unless remove is set, to remove the matches, or allowEmptyReplacement",
+		},
+		{
+			desc:   "global empty replacement",
+			config: Config{Version: 2, Rewrites: []Rewrite{{Regex: "foo"}}},
+			expErr: "rewrites[0].replacement: must not be empty unless remove is set, to remove the matches, or allowEmptyReplacement",
+		},
+		{
+			desc:   "replacement with remove",
+			config: Config{Version: 2, Responses: []Response{{Status: "200", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar", Remove: true}}}}},
+			expErr: `responses[0]: rewrites[0].replacement: "bar" must be empty when remove is set`,
+		},
+		{
+			desc:    "remove",
+			config:  Config{Version: 2, Responses: []Response{{Status: "200", Rewrites: []Rewrite{{Regex: "foo ", Remove: true}}}}},
+			expBody: "bar",
+		},
+		{
+			desc:    "allowEmptyReplacement",
+			config:  Config{Version: 2, AllowEmptyReplacement: true, Responses: []Response{{Status: "200", Rewrites: []Rewrite{{Regex: "foo "}}}}},
+			expBody: "bar",
+		},
+		{
+			desc:    "version 1",
+			config:  Config{Version: 1, Responses: []Response{{Status: "200", Rewrites: []Rewrite{{Regex: "foo "}}}}},
+			expBody: "bar",
+		},
+		{
+			desc:    "traefik configuration without version",
+			json:    `{"responses":[{"status":"200","rewrites":[{"regex":"foo "}]}]}`,
+			expBody: "bar",
+		},
+		{
+			desc:   "traefik configuration of version 2",
+			json:   `{"version":2,"responses":[{"status":"200","rewrites":[{"regex":"foo "}]}]}`,
+			expErr: "responses[0]: rewrites[0].replacement: must not be empty unless remove is set, to remove the matches, or allowEmptyReplacement",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &test.config
+			if test.json != "" {
+				config = CreateConfig()
+				if err := json.Unmarshal([]byte(test.json), config); err != nil {
+					t.Fatal(err)
+				}
+			}
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				_, _ = rw.Write([]byte("foo bar"))
+			}), config, "rewriteBody")
+			if test.expErr != "" {
+				if err == nil || err.Error() != test.expErr {
+					t.Errorf("got error %v, want %q", err, test.expErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_disabledResponse(t *testing.T) {
+	disabled, enabled := false, true
+	tests := []struct {
+		desc      string
+		responses []Response
+		expBody   string
+		expLogs   string
+	}{
+		{
+			desc: "next response block",
+			responses: []Response{
+				{Name: "new", Status: "200", Enabled: &disabled, Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}},
+				{Status: "200-299", Enabled: &enabled, Rewrites: []Rewrite{{Regex: "foo", Replacement: "baz"}}},
+			},
+			expBody: "baz",
+			expLogs: "INFO: rewriteBody: responses[0] \"new\" is disabled\n",
+		},
+		{
+			desc: "no other response block",
+			responses: []Response{
+				{Status: "200", Enabled: &disabled, Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}},
+			},
+			expBody: "foo",
+			expLogs: "INFO: rewriteBody: responses[0] is disabled\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var logs bytes.Buffer
+			handler, err := NewMiddleware(
+				http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+					_, _ = rw.Write([]byte("foo"))
+				}),
+				WithName("rewriteBody"),
+				WithResponses(test.responses...),
+				WithLogger(log.New(&logs, "", 0)),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if logs := withoutCompileLog(logs.String()); logs != test.expLogs {
+				t.Errorf("got logs %q, want %q", logs, test.expLogs)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+
+	// A disabled response block is validated as usual.
+	_, err := NewMiddleware(http.NotFoundHandler(), WithResponses(Response{Status: "200", Enabled: &disabled, Rewrites: []Rewrite{{Regex: "ba(r"}}}))
+	if err == nil {
+		t.Error("expected an error for an invalid regex of a disabled response block")
+	}
+}
+
+func TestServeHTTP_noResponses(t *testing.T) {
+	disabled := false
+	rewrites := []Rewrite{{Regex: "foo", Replacement: "bar"}}
+	tests := []struct {
+		desc       string
+		config     *Config
+		method     string
+		path       string
+		expWrapped bool
+		expBody    string
+	}{
+		{
+			desc:   "default config",
+			config: CreateConfig(),
+		},
+		{
+			desc:   "byte ranges disabled",
+			config: &Config{DisableByteRanges: true},
+		},
+		{
+			desc:       "panics recovered",
+			config:     &Config{RecoverPanics: true},
+			expWrapped: true,
+		},
+		{
+			desc:   "HEAD request",
+			config: &Config{Responses: []Response{{Status: "200", Rewrites: rewrites}}},
+			method: http.MethodHead,
+		},
+		{
+			desc:   "disabled response block",
+			config: &Config{Responses: []Response{{Status: "200", Rewrites: rewrites, Enabled: &disabled}}},
+		},
+		{
+			desc:   "response block of other paths",
+			config: &Config{Responses: []Response{{Status: "200", Sitemap: &Sitemap{Origin: "https://example.com"}}}},
+		},
+		{
+			desc:       "response block of the path",
+			config:     &Config{Responses: []Response{{Status: "200", Sitemap: &Sitemap{Origin: "https://example.com"}}}},
+			path:       "/sitemap.xml",
+			expWrapped: true,
+		},
+		{
+			desc:       "response block of another variant",
+			config:     &Config{Responses: []Response{{Status: "200", Rewrites: rewrites, Variant: &Variant{Header: "X-Group", Value: "beta"}}}},
+			expWrapped: true,
+		},
+		{
+			desc:       "response block applying",
+			config:     &Config{Responses: []Response{{Status: "200", Rewrites: rewrites}}},
+			expWrapped: true,
+			expBody:    "bar",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			var wrapped bool
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				wrapped = rw != http.ResponseWriter(recorder)
+				_, _ = rw.Write([]byte("foo"))
+			}
+			handler, err := New(context.Background(), http.HandlerFunc(next), test.config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			method, path := http.MethodGet, "/"
+			if test.method != "" {
+				method = test.method
+			}
+			if test.path != "" {
+				path = test.path
+			}
+			handler.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+
+			if wrapped != test.expWrapped {
+				t.Errorf("got wrapped writer %t, want %t", wrapped, test.expWrapped)
+			}
+			expBody := test.expBody
+			if expBody == "" {
+				expBody = "foo"
+			}
+			if body := recorder.Body.String(); body != expBody {
+				t.Errorf("got body %q, want %q", body, expBody)
+			}
+		})
+	}
+}
+
+func TestNew_responseOverlaps(t *testing.T) {
+	rewrites := []Rewrite{{Regex: "foo", Replacement: "bar"}}
+	tests := []struct {
+		desc        string
+		responses   []Response
+		expOverlaps []string
+	}{
+		{
+			desc: "disjoint",
+			responses: []Response{
+				{Status: "200-299", Rewrites: rewrites},
+				{Status: "404", Rewrites: rewrites},
+			},
+		},
+		{
+			desc: "shadowed",
+			responses: []Response{
+				{Status: "200-299", Rewrites: rewrites},
+				{Name: "no-content", Status: "204", Rewrites: rewrites},
+			},
+			expOverlaps: []string{
+				`status codes 204 of responses[1] "no-content" are matched by responses[0] first`,
+				`responses[1] "no-content" is never used: all its status codes are matched by previous responses`,
+			},
+		},
+		{
+			desc: "partial overlap",
+			responses: []Response{
+				{Status: "200-299", Rewrites: rewrites},
+				{Status: "404", Rewrites: rewrites},
+				{Status: "250-410", Rewrites: rewrites},
+			},
+			expOverlaps: []string{
+				"status codes 250-299 of responses[2] are matched by responses[0] first",
+				"status codes 404 of responses[2] are matched by responses[1] first",
+			},
+		},
+		{
+			desc: "shadowed by several responses",
+			responses: []Response{
+				{Status: "200-249", Rewrites: rewrites},
+				{Status: "250-299", Rewrites: rewrites},
+				{Status: "200-299", Rewrites: rewrites},
+			},
+			expOverlaps: []string{
+				"status codes 200-249 of responses[2] are matched by responses[0] first",
+				"status codes 250-299 of responses[2] are matched by responses[1] first",
+				"responses[2] is never used: all its status codes are matched by previous responses",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			handler, err := New(context.Background(), nil, &Config{Responses: test.responses}, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+			overlaps := responseOverlaps(handler.(*responsebodyrewrite).responses)
+			if !reflect.DeepEqual(overlaps, test.expOverlaps) {
+				t.Errorf("got overlaps %q, want %q", overlaps, test.expOverlaps)
+			}
+
+			_, err = New(context.Background(), nil, &Config{StrictConfig: true, Responses: test.responses}, "rewriteBody")
+			if expErr := strings.Join(test.expOverlaps, "; "); err == nil && expErr != "" || err != nil && err.Error() != expErr {
+				t.Errorf("got error %v with strictConfig, want %q", err, expErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// defaultMetricsInterval is the interval of the metrics summary when metricsInterval is not set.
+const defaultMetricsInterval = 5 * time.Minute
+
+// responseCounters are the counters of a response block, accessed atomically.
+type responseCounters struct {
+	// matched is the number of responses whose status code matched the block.
+	matched int64
+	// modified is the number of buffered bodies modified by the rewrites of the block.
+	modified int64
+	// replacements is the number of matches replaced, only counted along with the debug logs, the debugHeader
+	// and logModifications, since counting them slows the rewrites down.
+	replacements int64
+	// bytesIn and bytesOut are the sizes of the bodies rewritten by the block, before and after the rewrites,
+	// whether the rewrites modified them or not.
+	bytesIn  int64
+	bytesOut int64
+}
+
+// rewriteMetrics counts what the middleware did since startup, for the summary logged every metricsInterval.
+// Plugins can't register Prometheus collectors, the summary is meant to be graphed from the logs.
+type rewriteMetrics struct {
+	// The counters are accessed atomically, and kept first for their 64-bit alignment.
+	responses int64
+	// tooBig and encoded are the numbers of responses passed through because of maxBodySize and of
+	// skipEncodedBodies.
+	tooBig  int64
+	encoded int64
+	// failures are the numbers of rewrites which failed, per stage.
+	failures [numFailureStages]int64
+	blocks   []responseCounters
+}
+
+// newRewriteMetrics creates the metrics of the given number of response blocks.
+func newRewriteMetrics(responses int) *rewriteMetrics {
+	return &rewriteMetrics{blocks: make([]responseCounters, responses)}
+}
+
+// parseMetricsInterval parses the metricsInterval option, an empty value meaning defaultMetricsInterval and
+// 0 disabling the summary.
+func parseMetricsInterval(interval string) (time.Duration, error) {
+	if interval == "" {
+		return defaultMetricsInterval, nil
+	}
+	parsed, err := time.ParseDuration(interval)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("invalid metricsInterval %q: must be a duration, 0 disabling the summary", interval)
+	}
+	return parsed, nil
+}
+
+// The count methods increment the counters of the metrics, which are nil when the summary is disabled.
+
+// countResponse counts a response going through the middleware.
+func (m *rewriteMetrics) countResponse() {
+	if m != nil {
+		atomic.AddInt64(&m.responses, 1)
+	}
+}
+
+// countMatch counts a response matched by the response block of the given index.
+func (m *rewriteMetrics) countMatch(index int) {
+	if m != nil {
+		atomic.AddInt64(&m.blocks[index].matched, 1)
+	}
+}
+
+// countTooBig counts a response passed through because of maxBodySize.
+func (m *rewriteMetrics) countTooBig() {
+	if m != nil {
+		atomic.AddInt64(&m.tooBig, 1)
+	}
+}
+
+// countEncoded counts a response passed through because its body is encoded.
+func (m *rewriteMetrics) countEncoded() {
+	if m != nil {
+		atomic.AddInt64(&m.encoded, 1)
+	}
+}
+
+// countFailure counts a rewrite which failed at the given stage.
+func (m *rewriteMetrics) countFailure(stage failureStage) {
+	if m != nil {
+		atomic.AddInt64(&m.failures[stage], 1)
+	}
+}
+
+// countModified counts a body modified by the response block of the given index, with the number of matches
+// replaced by each rule, nil if they were not counted.
+func (m *rewriteMetrics) countModified(index int, replaced []int) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.blocks[index].modified, 1)
+	if total := totalReplacements(replaced); total > 0 {
+		atomic.AddInt64(&m.blocks[index].replacements, int64(total))
+	}
+}
+
+// countBytes counts a body of in bytes rewritten into out bytes by the response block of the given index.
+func (m *rewriteMetrics) countBytes(index int, in, out int64) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.blocks[index].bytesIn, in)
+	atomic.AddInt64(&m.blocks[index].bytesOut, out)
+}
+
+// metric is the value of a counter, named as in the summary. The counters of a response block are also
+// identified by the id of the block and their name within the block, for the tags of metricsTags.
+type metric struct {
+	name     string
+	response string
+	counter  string
+	value    int64
+}
+
+// responseMetric returns the metric of the counter of a response block.
+func responseMetric(id, counter string, value int64) metric {
+	return metric{name: "response." + id + "." + counter, response: id, counter: counter, value: value}
+}
+
+// values returns the values of the counters, those of a response block being named after its id.
+func (m *rewriteMetrics) values(responses []parsedResponse) []metric {
+	values := []metric{
+		{name: "responses", counter: "responses", value: atomic.LoadInt64(&m.responses)},
+		{name: "passthrough.maxBodySize", counter: "passthrough.maxBodySize", value: atomic.LoadInt64(&m.tooBig)},
+		{name: "passthrough.encoded", counter: "passthrough.encoded", value: atomic.LoadInt64(&m.encoded)},
+	}
+	for stage := failureStage(0); stage < numFailureStages; stage++ {
+		name := "failures." + stage.String()
+		values = append(values, metric{name: name, counter: name, value: atomic.LoadInt64(&m.failures[stage])})
+	}
+	for i := range responses {
+		counters := &m.blocks[responses[i].index]
+		id := responses[i].id
+		values = append(values,
+			responseMetric(id, "matched", atomic.LoadInt64(&counters.matched)),
+			responseMetric(id, "modified", atomic.LoadInt64(&counters.modified)),
+			responseMetric(id, "replacements", atomic.LoadInt64(&counters.replacements)),
+			responseMetric(id, "bytesIn", atomic.LoadInt64(&counters.bytesIn)),
+			responseMetric(id, "bytesOut", atomic.LoadInt64(&counters.bytesOut)),
+		)
+	}
+	return values
+}
+
+// summary formats the counters on a single line of key=value pairs.
+func (m *rewriteMetrics) summary(responses []parsedResponse) string {
+	values := m.values(responses)
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = value.name + "=" + strconv.FormatInt(value.value, 10)
+	}
+	return strings.Join(pairs, " ")
+}
+
+// logMetrics logs the summary of the metrics every interval, until ctx is done.
+func (r *responsebodyrewrite) logMetrics(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.infof("%s: metrics since startup: %s", r.name, r.metrics.summary(r.currentResponses()))
+		}
+	}
+}
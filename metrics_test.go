@@ -0,0 +1,139 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseMetricsInterval(t *testing.T) {
+	tests := []struct {
+		interval    string
+		expInterval time.Duration
+		expErr      bool
+	}{
+		{interval: "", expInterval: defaultMetricsInterval},
+		{interval: "0", expInterval: 0},
+		{interval: "30s", expInterval: 30 * time.Second},
+		{interval: "-1m", expErr: true},
+		{interval: "often", expErr: true},
+	}
+	for _, test := range tests {
+		interval, err := parseMetricsInterval(test.interval)
+		if (err != nil) != test.expErr || interval != test.expInterval {
+			t.Errorf("%q: got %s, %v, want %s, error %t", test.interval, interval, err, test.expInterval, test.expErr)
+		}
+	}
+}
+
+func TestServeHTTP_metrics(t *testing.T) {
+	config := &Config{
+		MaxBodySize:       8,
+		SkipEncodedBodies: true,
+		DebugHeader:       "X-Rewrite-Debug",
+		Always:            true,
+		Rewrites:          []Rewrite{{Regex: "baz", Replacement: "qux"}},
+		Responses: []Response{
+			{Name: "ok", Status: "200", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}},
+			{Status: "404", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}},
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler, err := New(ctx, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/gzip" {
+			rw.Header().Set("Content-Encoding", "gzip")
+		}
+		if req.URL.Path == "/created" {
+			rw.WriteHeader(http.StatusCreated)
+		}
+		_, _ = rw.Write([]byte(strings.TrimPrefix(req.URL.Query().Get("body"), "/")))
+	}), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range []string{"/?body=foofoo", "/?body=nothing", "/?body=toolongbody", "/gzip?body=foo", "/created?body=baz"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	expected := "responses=5 passthrough.maxBodySize=1 passthrough.encoded=1 failures.rewrite=0 failures.json=0 failures.csv=0 failures.yaml=0 failures.sitemap=0 failures.feed=0 failures.dash=0 failures.vast=0 failures.openapi=0 failures.oidc=0 failures.soap=0 failures.multipart=0" +
+		" response.ok.matched=4 response.ok.modified=1 response.ok.replacements=2 response.ok.bytesIn=13 response.ok.bytesOut=13" +
+		" response.1.matched=0 response.1.modified=0 response.1.replacements=0 response.1.bytesIn=0 response.1.bytesOut=0" +
+		" response.global.matched=1 response.global.modified=1 response.global.replacements=1 response.global.bytesIn=3 response.global.bytesOut=3"
+	r := handler.(*responsebodyrewrite)
+	if summary := r.metrics.summary(r.responses); summary != expected {
+		t.Errorf("got summary:\n%s\nwant:\n%s", summary, expected)
+	}
+}
+
+func TestNew_metrics(t *testing.T) {
+	responses := []Response{{Status: "200", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}}}
+
+	handler, err := New(context.Background(), http.NotFoundHandler(), &Config{MetricsInterval: "0", Responses: responses}, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handler.(*responsebodyrewrite).metrics != nil {
+		t.Error("got metrics with a metricsInterval of 0, want none")
+	}
+	// The counters can be incremented once disabled.
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if _, err := New(context.Background(), http.NotFoundHandler(), &Config{MetricsInterval: "soon"}, "rewriteBody"); err == nil {
+		t.Error("expected an error for an invalid metricsInterval")
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestLogMetrics(t *testing.T) {
+	var logs syncBuffer
+	r := &responsebodyrewrite{
+		name:       "rewriteBody",
+		logLevel:   levelInfo,
+		infoLogger: log.New(&logs, "", 0),
+		responses:  []parsedResponse{{index: 0, id: "0"}},
+		metrics:    newRewriteMetrics(1),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.logMetrics(ctx, time.Millisecond)
+		close(done)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); logs.String() == "" && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	expected := "rewriteBody: metrics since startup: responses=0 passthrough.maxBodySize=0 passthrough.encoded=0 failures.rewrite=0 failures.json=0 failures.csv=0 failures.yaml=0 failures.sitemap=0 failures.feed=0 failures.dash=0 failures.vast=0 failures.openapi=0 failures.oidc=0 failures.soap=0 failures.multipart=0" +
+		" response.0.matched=0 response.0.modified=0 response.0.replacements=0 response.0.bytesIn=0 response.0.bytesOut=0\n"
+	if line := logs.String(); !strings.HasPrefix(line, expected) {
+		t.Errorf("got logs %q, want them to start with %q", line, expected)
+	}
+}
@@ -0,0 +1,177 @@
+package traefik_responsebodyrewrite
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// transformer is the mode of a response block, which changes the way its bodies are rewritten. A response
+// block has at most one mode, the bodies of those without one being rewritten as a whole by rewriteBody.
+type transformer interface {
+	// option returns the name of the option of the response block setting the mode.
+	option() string
+	// changesBody reports whether the mode changes the bodies even without rewrites.
+	changesBody() bool
+	// String describes the mode in the debug dump.
+	String() string
+}
+
+// bodyTransformer is a transformer rewriting the bodies once they are complete, in place of rewriteBody.
+type bodyTransformer interface {
+	transformer
+	// transform returns the rewritten body of the response of rw, along with the same results as
+	// rewriteBody. The body is within maxRewriteBytes, and the original body is sent on error, as told by the
+	// failureMode.
+	transform(rw *responseWriter, body []byte) ([]byte, bool, bool, []int, error)
+}
+
+// streamTransformer is a transformer rewriting the bodies as they are written.
+type streamTransformer interface {
+	transformer
+	// newStream returns the writer rewriting the body of the response of rw to the underlying writer.
+	newStream(rw *responseWriter) io.WriteCloser
+}
+
+// contentTypeMatcher is a transformer restricting the response block to the responses of its content types.
+type contentTypeMatcher interface {
+	matchesContentType(contentType string) bool
+}
+
+// pathMatcher is a transformer restricting the response block to the requests of its paths.
+type pathMatcher interface {
+	matchesPath(requestPath string) bool
+}
+
+// cacheKeyer is a transformer whose rewritten bodies depend on the request, beyond its method and URL.
+type cacheKeyer interface {
+	// cacheKey returns what the rewritten body of a response to req depends on.
+	cacheKey(req *http.Request) string
+}
+
+// modeParsers parse the options setting the mode of a response block, each returning nil if its option is not
+// set.
+var modeParsers = []func(response Response) (transformer, error){
+	func(response Response) (transformer, error) {
+		graphQL, err := parseGraphQL(response.GraphQL)
+		if graphQL == nil || err != nil {
+			return nil, wrapModeError("graphql", err)
+		}
+		return graphQL, nil
+	},
+	func(response Response) (transformer, error) {
+		jsonAPIErrors, err := parseJSONAPIErrors(response.JSONAPIErrors)
+		if jsonAPIErrors == nil || err != nil {
+			return nil, wrapModeError("jsonapiErrors", err)
+		}
+		return jsonAPIErrors, nil
+	},
+	func(response Response) (transformer, error) {
+		if !response.SOAPFaults {
+			return nil, nil
+		}
+		return soapFaults{}, nil
+	},
+	func(response Response) (transformer, error) {
+		csv, err := parseCSV(response.CSV)
+		if csv == nil || err != nil {
+			return nil, wrapModeError("csv", err)
+		}
+		return csv, nil
+	},
+	func(response Response) (transformer, error) {
+		// The errors of the yamlOps already tell which one is invalid.
+		ops, err := parseYAMLOps(response.YAMLOps)
+		if len(ops) == 0 || err != nil {
+			return nil, err
+		}
+		return ops, nil
+	},
+	func(response Response) (transformer, error) {
+		html, err := parseHTML(response.HTML)
+		if html == nil || err != nil {
+			return nil, wrapModeError("html", err)
+		}
+		return html, nil
+	},
+	func(response Response) (transformer, error) {
+		sitemap, err := parseSitemap(response.Sitemap)
+		if sitemap == nil || err != nil {
+			return nil, wrapModeError("sitemap", err)
+		}
+		return sitemap, nil
+	},
+	func(response Response) (transformer, error) {
+		feed, err := parseFeed(response.Feed)
+		if feed == nil || err != nil {
+			return nil, wrapModeError("feed", err)
+		}
+		return feed, nil
+	},
+	func(response Response) (transformer, error) {
+		hls, err := parseHLS(response.HLS)
+		if hls == nil || err != nil {
+			return nil, wrapModeError("hls", err)
+		}
+		return hls, nil
+	},
+	func(response Response) (transformer, error) {
+		dash, err := parseDASH(response.DASH)
+		if dash == nil || err != nil {
+			return nil, wrapModeError("dash", err)
+		}
+		return dash, nil
+	},
+	func(response Response) (transformer, error) {
+		vast, err := parseVAST(response.VAST)
+		if vast == nil || err != nil {
+			return nil, wrapModeError("vast", err)
+		}
+		return vast, nil
+	},
+	func(response Response) (transformer, error) {
+		openAPI, err := parseOpenAPI(response.OpenAPI)
+		if openAPI == nil || err != nil {
+			return nil, wrapModeError("openapi", err)
+		}
+		return openAPI, nil
+	},
+	func(response Response) (transformer, error) {
+		oidc, err := parseOIDC(response.OIDC)
+		if oidc == nil || err != nil {
+			return nil, wrapModeError("oidc", err)
+		}
+		return oidc, nil
+	},
+}
+
+// wrapModeError prefixes the error parsing a mode with its option, nil if there is none.
+func wrapModeError(option string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", option, err)
+}
+
+// parseMode parses the mode of a response block, nil if it has none. At most one mode can be set, and a mode
+// can't be used along with the options which rewrite the bodies in their own way.
+func parseMode(response Response, jsonPaths [][]string) (transformer, error) {
+	var mode transformer
+	for _, parse := range modeParsers {
+		next, err := parse(response)
+		if err != nil {
+			return nil, err
+		}
+		if next == nil {
+			continue
+		}
+		if mode != nil {
+			return nil, fmt.Errorf("%s and %s can't both be set", mode.option(), next.option())
+		}
+		mode = next
+	}
+	if mode != nil && (response.Stream || len(jsonPaths) > 0 || response.RewriteFirstBytes > 0 || response.RewriteMultipart) {
+		return nil, fmt.Errorf("%s can't be used with stream, jsonPaths, rewriteFirstBytes or rewriteMultipart", mode.option())
+	}
+	return mode, nil
+}
@@ -0,0 +1,68 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeHTTP_modeMaxRewriteBytes(t *testing.T) {
+	column := 0
+	tests := []struct {
+		desc        string
+		response    Response
+		contentType string
+		body        string
+	}{
+		{
+			desc:        "csv",
+			response:    Response{Status: "200", CSV: &CSV{ColumnIndex: &column}, Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}},
+			contentType: "text/csv",
+			body:        "foo,1\nfoo,2\n",
+		},
+		{
+			desc:        "hls",
+			response:    Response{Status: "200", HLS: &HLS{Origin: "https://cdn.example.com"}},
+			contentType: "application/vnd.apple.mpegurl",
+			body:        "#EXTM3U\nhttp://origin.example.com/segment.ts\n",
+		},
+		{
+			desc:        "sitemap",
+			response:    Response{Status: "200", Sitemap: &Sitemap{Paths: []string{"/*"}, Origin: "https://www.example.com"}},
+			contentType: "application/xml",
+			body:        "<urlset><url><loc>http://origin.example.com/</loc></url></urlset>",
+		},
+		{
+			desc:        "multipart",
+			response:    Response{Status: "200", RewriteMultipart: true, Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}},
+			contentType: "multipart/mixed; boundary=frontier",
+			body:        "--frontier\r\nContent-Type: text/plain\r\n\r\nfoo\r\n--frontier--\r\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var logs bytes.Buffer
+			config := Config{MetricsInterval: "1h", MaxRewriteBytes: 8, Responses: []Response{test.response}}
+			handler, err := NewMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", test.contentType)
+				_, _ = rw.Write([]byte(test.body))
+			}), WithConfig(&config), WithName("rewriteBody"), WithLogger(log.New(&logs, "", 0)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if body := recorder.Body.String(); body != test.body {
+				t.Errorf("got body %q, want the original body %q", body, test.body)
+			}
+			if count := strings.Count(logs.String(), "exceeds maxRewriteBytes of 8"); count != 1 {
+				t.Errorf("got %d logs of the skipped rewrite, want 1: %q", count, logs.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package traefik_responsebodyrewrite
+
+import "io"
+
+// bodyModification describes a body modified by the rewrites, for the lines of logModifications.
+type bodyModification struct {
+	replacements int
+	originalSize int64
+	size         int64
+}
+
+// totalReplacements returns the number of matches replaced by all the rules, given per rule.
+func totalReplacements(replaced []int) int {
+	total := 0
+	for _, n := range replaced {
+		total += n
+	}
+	return total
+}
+
+// logModification logs the line of logModifications about a body of originalSize bytes, modified into size
+// bytes by the given number of replacements, if enabled. The line is logged whatever the logLevel, and only
+// tells sizes and counts: neither the query of the request nor the content of the bodies are logged.
+func (rw *responseWriter) logModification(originalSize, size int64, replacements int) {
+	m := rw.middleware
+	if !m.logModifications {
+		return
+	}
+	fields := rw.logFields()
+	fields.modification = &bodyModification{replacements: replacements, originalSize: originalSize, size: size}
+	m.output(levelInfo, fields, "%s: modified response: method=%s path=%q status=%d response=%s replacements=%d original_size=%d size=%d",
+		m.name, rw.request.Method, rw.request.URL.Path, rw.code, rw.response.id, replacements, originalSize, size)
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements the io.Writer interface.
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,106 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeHTTP_logModifications(t *testing.T) {
+	tests := []struct {
+		desc      string
+		config    Config
+		targets   []string
+		expLines  []string
+		expFields map[string]interface{}
+	}{
+		{
+			desc:    "buffered and cached bodies",
+			config:  Config{CacheSize: 10},
+			targets: []string{"/foo?secret=foo", "/bar", "/foo?secret=foo"},
+			expLines: []string{
+				`rewriteBody: modified response: method=GET path="/foo" status=200 response=page replacements=2 original_size=10 size=12`,
+				`rewriteBody: modified response: method=GET path="/foo" status=200 response=page replacements=2 original_size=10 size=12`,
+			},
+		},
+		{
+			desc:    "spilled body",
+			config:  Config{SpillThresholdBytes: 4, SpillDir: "."},
+			targets: []string{"/foo"},
+			expLines: []string{
+				`rewriteBody: modified response: method=GET path="/foo" status=200 response=page replacements=2 original_size=10 size=12`,
+			},
+		},
+		{
+			desc:    "JSON format",
+			config:  Config{LogFormat: logFormatJSON},
+			targets: []string{"/foo"},
+			expFields: map[string]interface{}{
+				"level":              "info",
+				"msg":                `modified response: method=GET path="/foo" status=200 response=page replacements=2 original_size=10 size=12`,
+				"method":             "GET",
+				"path":               "/foo",
+				"status":             float64(200),
+				"response_name":      "page",
+				"total_replacements": float64(2),
+				"original_size":      float64(10),
+				"size":               float64(12),
+			},
+		},
+		{
+			desc:    "disabled",
+			config:  Config{},
+			targets: []string{"/foo"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := test.config
+			config.LogModifications = test.desc != "disabled"
+			config.LogLevel = "error"
+			if config.SpillDir != "" {
+				config.SpillDir = t.TempDir()
+			}
+			config.Responses = []Response{{Name: "page", Status: "200", Rewrites: []Rewrite{{Regex: "foo", Replacement: "quux"}}}}
+
+			var logs bytes.Buffer
+			handler, err := NewMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("ETag", `"v1"`)
+				_, _ = rw.Write([]byte(strings.Repeat(strings.TrimPrefix(req.URL.Path, "/"), 2) + " bar"))
+			}), WithConfig(&config), WithName("rewriteBody"), WithLogger(log.New(&logs, "", 0)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, target := range test.targets {
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+			}
+
+			if test.expFields != nil {
+				var entry map[string]interface{}
+				if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+					t.Fatalf("got logs %q: %v", logs.String(), err)
+				}
+				for name, value := range test.expFields {
+					if entry[name] != value {
+						t.Errorf("got %s %v, want %v", name, entry[name], value)
+					}
+				}
+				return
+			}
+
+			var lines []string
+			for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+				if line != "" {
+					lines = append(lines, strings.TrimPrefix(line, "INFO: "))
+				}
+			}
+			if strings.Join(lines, "\n") != strings.Join(test.expLines, "\n") {
+				t.Errorf("got logs:\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(test.expLines, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// multipartBoundary reports whether the given Content-Type is the one of a multipart body, and returns its
+// boundary, empty if it has none.
+func multipartBoundary(contentType string) (bool, string) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return false, ""
+	}
+	return true, params["boundary"]
+}
+
+// parseMultipartContentTypes parses the multipartContentTypes option into the set of their media types, nil
+// if empty.
+func parseMultipartContentTypes(contentTypes []string) (map[string]bool, error) {
+	if len(contentTypes) == 0 {
+		return nil, nil
+	}
+	mediaTypes := make(map[string]bool, len(contentTypes))
+	for _, contentType := range contentTypes {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil || len(params) > 0 || !strings.Contains(mediaType, "/") {
+			return nil, fmt.Errorf("invalid multipartContentTypes %q: must be a media type without parameters", contentType)
+		}
+		mediaTypes[mediaType] = true
+	}
+	return mediaTypes, nil
+}
+
+// rewritesPart reports whether the part with the given header is rewritten: the nested multiparts and the
+// parts with a transfer encoding, whose matches would be encoded, are sent as is, as well as the parts whose
+// media type is not one of the multipartContentTypes, if any.
+func (p *parsedResponse) rewritesPart(header textproto.MIMEHeader) bool {
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "", "7bit", "8bit", "binary":
+	default:
+		return false
+	}
+	// The parts without Content-Type are plain text.
+	mediaType := "text/plain"
+	if contentType := header.Get("Content-Type"); contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return false
+		}
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		return false
+	}
+	return p.multipartTypes == nil || p.multipartTypes[mediaType]
+}
+
+// rewriteMultipart is rewriteBody for a multipart body with the given boundary: the rewrites are applied to
+// each part, and the parts are serialized again with the same boundary. The preamble and the epilogue of a
+// modified body are dropped, and the headers of its parts are sorted. A body which can't be parsed fails the
+// multipart stage.
+func (r *responsebodyrewrite) rewriteMultipart(response *parsedResponse, boundary string, body []byte, req *http.Request) ([]byte, bool, bool, []int, error) {
+	var out bytes.Buffer
+	writer := multipart.NewWriter(&out)
+	if err := writer.SetBoundary(boundary); err != nil {
+		return body, false, true, nil, &stageError{stage: stageMultipart, err: fmt.Errorf("invalid multipart boundary %q: %w", boundary, err)}
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	modified, complete := false, true
+	var replaced []int
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		var partBody []byte
+		if err == nil {
+			partBody, err = io.ReadAll(part)
+		}
+		if err != nil {
+			return body, false, true, nil, &stageError{stage: stageMultipart, err: fmt.Errorf("unable to parse the multipart body: %w", err)}
+		}
+
+		if response.rewritesPart(part.Header) {
+			var partModified, partComplete bool
+			var partReplaced []int
+			partBody, partModified, partComplete, partReplaced, err = r.rewriteBody(response, partBody, req)
+			if err != nil {
+				return body, false, true, nil, err
+			}
+			modified = modified || partModified
+			complete = complete && partComplete
+			replaced = addReplacements(replaced, partReplaced)
+		}
+
+		partWriter, err := writer.CreatePart(part.Header)
+		if err == nil {
+			_, err = partWriter.Write(partBody)
+		}
+		if err != nil {
+			return body, false, true, nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return body, false, true, nil, err
+	}
+
+	if !modified {
+		return body, false, complete, replaced, nil
+	}
+	return out.Bytes(), true, complete, replaced, nil
+}
+
+// addReplacements adds the numbers of matches replaced by each rule in a part to those of the previous parts.
+func addReplacements(total, replaced []int) []int {
+	if total == nil {
+		return replaced
+	}
+	for i := range replaced {
+		if i < len(total) {
+			total[i] += replaced[i]
+		}
+	}
+	return total
+}
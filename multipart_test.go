@@ -0,0 +1,134 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeHTTP_multipart(t *testing.T) {
+	body := strings.Join([]string{
+		"--frontier",
+		"Content-Type: application/json",
+		"",
+		`{"host": "internal.local"}`,
+		"--frontier",
+		"Content-Type: text/csv",
+		"",
+		"host\r\ninternal.local",
+		"--frontier",
+		"Content-Transfer-Encoding: base64",
+		"Content-Type: text/plain",
+		"",
+		"aW50ZXJuYWwubG9jYWw=",
+		"--frontier",
+		"Content-Type: multipart/alternative; boundary=inner",
+		"",
+		"--inner",
+		"",
+		"internal.local",
+		"--inner--",
+		"--frontier--",
+		"",
+	}, "\r\n")
+
+	tests := []struct {
+		desc        string
+		response    Response
+		contentType string
+		body        string
+		expBody     string
+		expErr      string
+	}{
+		{
+			desc:        "skipped by default",
+			contentType: "multipart/mixed; boundary=frontier",
+			body:        body,
+			expBody:     body,
+		},
+		{
+			desc:        "rewritten per part",
+			response:    Response{RewriteMultipart: true},
+			contentType: "multipart/mixed; boundary=frontier",
+			body:        body,
+			expBody:     strings.NewReplacer(`{"host": "internal.local"}`, `{"host": "example.com"}`, "host\r\ninternal.local", "host\r\nexample.com").Replace(body),
+		},
+		{
+			desc:        "rewritten parts of the content types",
+			response:    Response{RewriteMultipart: true, MultipartContentTypes: []string{"text/csv"}},
+			contentType: "multipart/mixed; boundary=frontier",
+			body:        body,
+			expBody:     strings.Replace(body, "host\r\ninternal.local", "host\r\nexample.com", 1),
+		},
+		{
+			desc:        "without boundary",
+			response:    Response{RewriteMultipart: true},
+			contentType: "multipart/mixed",
+			body:        body,
+			expBody:     body,
+		},
+		{
+			desc:        "malformed body",
+			response:    Response{RewriteMultipart: true},
+			contentType: "multipart/mixed; boundary=frontier",
+			body:        "--frontier\r\nContent-Type: text/plain\r\n\r\ninternal.local",
+			expBody:     "--frontier\r\nContent-Type: text/plain\r\n\r\ninternal.local",
+		},
+		{
+			desc:        "not multipart",
+			response:    Response{RewriteMultipart: true},
+			contentType: "text/plain",
+			body:        "internal.local",
+			expBody:     "example.com",
+		},
+		{
+			desc:     "streaming mode",
+			response: Response{RewriteMultipart: true, Stream: true},
+			expErr:   "responses[0]: rewriteMultipart can't be used with stream, jsonPaths or rewriteFirstBytes",
+		},
+		{
+			desc:     "content types without rewriteMultipart",
+			response: Response{MultipartContentTypes: []string{"text/csv"}},
+			expErr:   "responses[0]: multipartContentTypes can only be used with rewriteMultipart",
+		},
+		{
+			desc:     "invalid content type",
+			response: Response{RewriteMultipart: true, MultipartContentTypes: []string{"text/csv; charset=utf-8"}},
+			expErr:   `responses[0]: invalid multipartContentTypes "text/csv; charset=utf-8": must be a media type without parameters`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			response := test.response
+			response.Status = "200"
+			response.Rewrites = []Rewrite{{Regex: `internal\.local`, Replacement: "example.com"}}
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", test.contentType)
+				_, _ = rw.Write([]byte(test.body))
+			}), &Config{SpillThresholdBytes: 16, Responses: []Response{response}}, "rewriteBody")
+			if test.expErr != "" {
+				if err == nil || err.Error() != test.expErr {
+					t.Errorf("got error %v, want %q", err, test.expErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_multipartFailure(t *testing.T) {
+	testModeFailure(t, Response{Status: "200", RewriteMultipart: true, Rewrites: []Rewrite{{Regex: `internal\.local`, Replacement: "example.com"}}},
+		"multipart/mixed; boundary=frontier", "--frontier\r\nContent-Type: text/plain\r\n\r\ninternal.local", stageMultipart)
+}
@@ -0,0 +1,243 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// oidcDiscoveryPath is the suffix of the paths of the OpenID Connect discovery documents.
+const oidcDiscoveryPath = "/.well-known/openid-configuration"
+
+// defaultOIDCFields are the fields of the discovery documents rewritten when none is configured.
+var defaultOIDCFields = []string{
+	"issuer",
+	"authorization_endpoint",
+	"token_endpoint",
+	"userinfo_endpoint",
+	"jwks_uri",
+	"registration_endpoint",
+	"revocation_endpoint",
+	"introspection_endpoint",
+	"end_session_endpoint",
+}
+
+// OIDC rewrites the origin of the URLs of the OpenID Connect discovery documents.
+type OIDC struct {
+	// Paths are the patterns of the request paths of the documents, as path.Match. If empty, they are the
+	// paths ending with /.well-known/openid-configuration.
+	Paths []string `json:"paths,omitempty"`
+	// Fields are the top-level fields whose URLs are rewritten, the issuer and the endpoints if empty.
+	Fields []string `json:"fields,omitempty"`
+	// Origin is the scheme and the host the URLs are rewritten to, e.g. "https://login.example.com". If
+	// empty, it is the public origin of the request, from its X-Forwarded-Proto and X-Forwarded-Host headers,
+	// or else from its own scheme and Host.
+	Origin string `json:"origin,omitempty"`
+}
+
+// parsedOIDC is a parsed OIDC.
+type parsedOIDC struct {
+	// paths are the patterns of the request paths of the documents, nil for the default suffix.
+	paths  []string
+	fields map[string]bool
+	// origin is the configured origin, empty if it is derived from the request.
+	origin string
+}
+
+// parseOIDC parses the oidc option of a response block, nil if it has none.
+func parseOIDC(config *OIDC) (*parsedOIDC, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if err := checkPathPatterns(config.Paths); err != nil {
+		return nil, err
+	}
+	fieldNames := config.Fields
+	if len(fieldNames) == 0 {
+		fieldNames = defaultOIDCFields
+	}
+	fields := make(map[string]bool, len(fieldNames))
+	for _, field := range fieldNames {
+		if field == "" {
+			return nil, errors.New("fields: must not hold an empty name")
+		}
+		fields[field] = true
+	}
+	origin, err := parseOrigin(config.Origin)
+	if err != nil {
+		return nil, err
+	}
+	return &parsedOIDC{paths: config.Paths, fields: fields, origin: origin}, nil
+}
+
+// isOIDCResponse reports whether the given Content-Type is the one of a discovery document.
+func isOIDCResponse(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/json"
+}
+
+// matchesPath reports whether the request path is the one of a discovery document.
+func (o *parsedOIDC) matchesPath(requestPath string) bool {
+	if o.paths == nil {
+		return strings.HasSuffix(requestPath, oidcDiscoveryPath)
+	}
+	return matchesPathPattern(o.paths, requestPath)
+}
+
+// publicOrigin returns the origin the URLs of the discovery document of req are rewritten to.
+func (o *parsedOIDC) publicOrigin(req *http.Request) string {
+	if o.origin != "" {
+		return o.origin
+	}
+	return requestOrigin(req)
+}
+
+// jsonStringField is a top-level string field of a JSON object.
+type jsonStringField struct {
+	// start and end are the offsets of the value of the field.
+	start int64
+	end   int64
+	value string
+}
+
+// topLevelJSONStrings returns the positions of the string values of the given top-level fields of a JSON
+// object. A field set several times is returned each time, and a JSON value which is not an object has
+// none.
+func topLevelJSONStrings(body []byte, names map[string]bool) ([]jsonStringField, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var fields []jsonStringField
+	// depth is the nesting level of the token being decoded, and key the last key of the top-level object,
+	// if the JSON value is one.
+	depth := 0
+	object, expectKey := false, false
+	var key string
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err == io.EOF && depth > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err == io.EOF {
+			return fields, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch value := token.(type) {
+		case json.Delim:
+			if value == '{' || value == '[' {
+				if depth == 0 && value == '{' {
+					object, expectKey = true, true
+				}
+				depth++
+				continue
+			}
+			depth--
+		case string:
+			switch {
+			case depth == 1 && object && expectKey:
+				key = value
+				expectKey = false
+				continue
+			case depth == 1 && object && names[key]:
+				start := offset + int64(bytes.IndexByte(body[offset:decoder.InputOffset()], '"'))
+				fields = append(fields, jsonStringField{start: start, end: decoder.InputOffset(), value: value})
+			}
+		}
+		if depth == 1 {
+			expectKey = true
+		}
+	}
+}
+
+// rewriteOIDCDocument replaces the scheme and the host of the absolute http and https URLs of the given
+// top-level fields of a discovery document with origin, keeping their paths, and reports whether the
+// document was modified. The other fields, and the order of the keys, are left as they are, byte for byte.
+func rewriteOIDCDocument(body []byte, fieldNames map[string]bool, origin string) ([]byte, bool, error) {
+	fields, err := topLevelJSONStrings(body, fieldNames)
+	if err != nil {
+		return body, false, err
+	}
+
+	var out bytes.Buffer
+	var sent int64
+	for _, field := range fields {
+		urlPath, absolute := httpURLPath(field.value)
+		value := origin + urlPath
+		if !absolute || value == field.value {
+			continue
+		}
+
+		encoded, err := encodeJSONValue(value)
+		if err != nil {
+			return body, false, err
+		}
+		out.Write(body[sent:field.start])
+		out.Write(encoded)
+		sent = field.end
+	}
+
+	if sent == 0 {
+		return body, false, nil
+	}
+	out.Write(body[sent:])
+	return out.Bytes(), true, nil
+}
+
+// String describes the discovery documents in the debug dump.
+func (o *parsedOIDC) String() string {
+	paths := "paths ending with " + oidcDiscoveryPath
+	if o.paths != nil {
+		paths = "paths " + strings.Join(o.paths, ", ")
+	}
+	fields := make([]string, 0, len(o.fields))
+	for field := range o.fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fmt.Sprintf("fields %s of %s rewritten to %s", strings.Join(fields, ", "), paths, describeOrigin(o.origin))
+}
+
+func (o *parsedOIDC) option() string { return "oidc" }
+
+// changesBody implements the transformer interface.
+func (o *parsedOIDC) changesBody() bool { return true }
+
+// matchesContentType implements the contentTypeMatcher interface.
+func (o *parsedOIDC) matchesContentType(contentType string) bool {
+	return isOIDCResponse(contentType)
+}
+
+// cacheKey implements the cacheKeyer interface: the rewritten URLs depend on the public origin of req.
+func (o *parsedOIDC) cacheKey(req *http.Request) string {
+	return o.publicOrigin(req)
+}
+
+// transform implements the bodyTransformer interface, as rewriteBody for an OpenID Connect discovery document:
+// once the rewrites are applied, the origin of the URLs of its fields is replaced with the public origin. A
+// document which can't be parsed fails the oidc stage.
+func (o *parsedOIDC) transform(rw *responseWriter, body []byte) ([]byte, bool, bool, []int, error) {
+	r, response, req := rw.middleware, rw.response, rw.request
+	rewritten, modified, complete, replaced := body, false, true, []int(nil)
+	if len(response.rewrites) > 0 {
+		var err error
+		if rewritten, modified, complete, replaced, err = r.rewriteBody(response, body, req); err != nil {
+			return body, false, true, nil, err
+		}
+	}
+
+	result, changed, err := rewriteOIDCDocument(rewritten, o.fields, o.publicOrigin(req))
+	if err != nil {
+		return body, false, true, nil, &stageError{stage: stageOIDC, err: fmt.Errorf("unable to parse the discovery document: %w", err)}
+	}
+	return result, modified || changed, complete, replaced, nil
+}
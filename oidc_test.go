@@ -0,0 +1,142 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRewriteOIDCDocument(t *testing.T) {
+	const document = `{
+  "issuer": "http://keycloak.internal.local:8080/realms/main",
+  "authorization_endpoint": "http://keycloak.internal.local:8080/realms/main/protocol/openid-connect/auth",
+  "token_endpoint":"http:\/\/keycloak.internal.local:8080\/realms\/main\/protocol\/openid-connect\/token",
+  "jwks_uri": "http://keycloak.internal.local:8080/realms/main/protocol/openid-connect/certs",
+  "mtls_endpoint_aliases": {"token_endpoint": "http://keycloak.internal.local:8443/token"},
+  "service_documentation": "http://keycloak.internal.local:8080/docs",
+  "response_types_supported": ["code", "id_token"]
+}`
+
+	tests := []struct {
+		desc    string
+		body    string
+		fields  []string
+		expBody string
+		expErr  bool
+	}{
+		{
+			desc: "default fields",
+			body: document,
+			expBody: strings.NewReplacer(
+				`"http://keycloak.internal.local:8080/realms`, `"https://login.example.com/realms`,
+				`"http:\/\/keycloak.internal.local:8080\/realms\/main\/protocol\/openid-connect\/token"`, `"https://login.example.com/realms/main/protocol/openid-connect/token"`,
+			).Replace(document),
+		},
+		{
+			desc:    "configured fields",
+			body:    document,
+			fields:  []string{"service_documentation"},
+			expBody: strings.Replace(document, "http://keycloak.internal.local:8080/docs", "https://login.example.com/docs", 1),
+		},
+		{
+			desc:    "already rewritten",
+			body:    `{"issuer": "https://login.example.com"}`,
+			expBody: `{"issuer": "https://login.example.com"}`,
+		},
+		{
+			desc:    "not an object",
+			body:    `["issuer", "http://keycloak.internal.local"]`,
+			expBody: `["issuer", "http://keycloak.internal.local"]`,
+		},
+		{
+			desc:    "malformed document",
+			body:    `{"issuer": "http://keycloak.internal.local"`,
+			expBody: `{"issuer": "http://keycloak.internal.local"`,
+			expErr:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			oidc, err := parseOIDC(&OIDC{Fields: test.fields})
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, modified, err := rewriteOIDCDocument([]byte(test.body), oidc.fields, "https://login.example.com")
+			if (err != nil) != test.expErr {
+				t.Errorf("got error %v, want one: %t", err, test.expErr)
+			}
+			if string(body) != test.expBody {
+				t.Errorf("got body %s, want %s", body, test.expBody)
+			}
+			if expModified := test.expBody != test.body; modified != expModified {
+				t.Errorf("got modified %t, want %t", modified, expModified)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_oidc(t *testing.T) {
+	const body = `{"issuer":"http://keycloak.internal.local:8080/realms/main","jwks_uri":"http://keycloak.internal.local:8080/realms/main/certs"}`
+
+	tests := []struct {
+		desc    string
+		config  OIDC
+		path    string
+		expBody string
+	}{
+		{
+			desc:    "default path",
+			path:    "/realms/main/.well-known/openid-configuration",
+			expBody: `{"issuer":"https://login.example.com/realms/main","jwks_uri":"https://login.example.com/realms/main/certs"}`,
+		},
+		{
+			desc:    "configured paths",
+			config:  OIDC{Paths: []string{"/.well-known/oauth-authorization-server"}},
+			path:    "/.well-known/oauth-authorization-server",
+			expBody: `{"issuer":"https://login.example.com/realms/main","jwks_uri":"https://login.example.com/realms/main/certs"}`,
+		},
+		{
+			desc:    "other path",
+			path:    "/realms/main/account",
+			expBody: body,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := test.config
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", "application/json")
+				_, _ = rw.Write([]byte(body))
+			}), &Config{Responses: []Response{{Status: "200", OIDC: &config}}}, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, test.path, nil)
+			req.Header.Set("X-Forwarded-Proto", "https")
+			req.Header.Set("X-Forwarded-Host", "login.example.com")
+			handler.ServeHTTP(recorder, req)
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+
+	_, err := New(context.Background(), http.NotFoundHandler(), &Config{Responses: []Response{{
+		Status: "200",
+		OIDC:   &OIDC{Fields: []string{""}},
+	}}}, "rewriteBody")
+	expErr := "responses[0]: oidc: fields: must not hold an empty name"
+	if err == nil || err.Error() != expErr {
+		t.Errorf("got error %v, want %q", err, expErr)
+	}
+}
+
+func TestServeHTTP_oidcFailure(t *testing.T) {
+	testModeFailure(t, Response{Status: "200", OIDC: &OIDC{Paths: []string{"/"}, Origin: "https://auth.example.com"}},
+		"application/json", `{"issuer": "http://keycloak.internal.local"`, stageOIDC)
+}
@@ -0,0 +1,312 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// OpenAPI rewrites the server URLs of the OpenAPI and Swagger documents to the public origin.
+type OpenAPI struct {
+	// Paths are the patterns of the request paths of the documents, as path.Match. If empty, the documents are
+	// told by their openapi or swagger field, whatever their path.
+	Paths []string `json:"paths,omitempty"`
+	// Origin is the scheme and the host the server URLs are rewritten to, e.g. "https://api.example.com". If
+	// empty, it is the public origin of the request, from its X-Forwarded-Proto and X-Forwarded-Host headers,
+	// or else from its own scheme and Host.
+	Origin string `json:"origin,omitempty"`
+}
+
+// parsedOpenAPI is a parsed OpenAPI.
+type parsedOpenAPI struct {
+	// paths are the patterns of the request paths of the documents, nil for any path.
+	paths []string
+	// origin is the configured origin, empty if it is derived from the request.
+	origin string
+}
+
+// parseOpenAPI parses the openapi option of a response block, nil if it has none.
+func parseOpenAPI(config *OpenAPI) (*parsedOpenAPI, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if err := checkPathPatterns(config.Paths); err != nil {
+		return nil, err
+	}
+	origin, err := parseOrigin(config.Origin)
+	if err != nil {
+		return nil, err
+	}
+	return &parsedOpenAPI{paths: config.Paths, origin: origin}, nil
+}
+
+// isOpenAPIResponse reports whether the given Content-Type is the one of a JSON document, possibly an OpenAPI
+// or Swagger one.
+func isOpenAPIResponse(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || mediaType == "application/vnd.oai.openapi+json")
+}
+
+// matchesPath reports whether the request path is the one of a document.
+func (o *parsedOpenAPI) matchesPath(requestPath string) bool {
+	return o.paths == nil || matchesPathPattern(o.paths, requestPath)
+}
+
+// publicOrigin returns the origin the server URLs of the document of req are rewritten to.
+func (o *parsedOpenAPI) publicOrigin(req *http.Request) string {
+	if o.origin != "" {
+		return o.origin
+	}
+	return requestOrigin(req)
+}
+
+// forwardedPrefix returns the path prefix of the X-Forwarded-Prefix header of req, without trailing slash,
+// empty if it has none or if it is not a path.
+func forwardedPrefix(req *http.Request) string {
+	prefix := strings.TrimRight(firstForwardedValue(req.Header.Get("X-Forwarded-Prefix")), "/")
+	if !strings.HasPrefix(prefix, "/") {
+		return ""
+	}
+	return prefix
+}
+
+// openAPIField is a field of an OpenAPI or Swagger document holding a server URL or a part of it.
+type openAPIField struct {
+	// name is "url" for the URL of a server, or the name of the top-level field, "host", "basePath" or
+	// "schemes".
+	name string
+	// start and end are the offsets of the value of the field.
+	start int64
+	end   int64
+	// value is the value of the field, nil for schemes.
+	value interface{}
+}
+
+// openAPIFields decodes an OpenAPI 3 or Swagger 2.0 document and returns its version field, "openapi" or
+// "swagger", and the positions of the fields holding its server URLs: the url of the servers at the top
+// level for OpenAPI, the host, basePath and schemes for Swagger. A JSON document which is neither has no
+// version.
+func openAPIFields(body []byte) (string, []openAPIField, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var version string
+	var fields []openAPIField
+	var frames []jsonFrame
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err == io.EOF && len(frames) > 0 {
+			return "", nil, io.ErrUnexpectedEOF
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		// The token starts after the separators and spaces preceding it.
+		start := offset + int64(bytes.IndexAny(body[offset:decoder.InputOffset()], `"{[]}tfn-0123456789`))
+
+		var top *jsonFrame
+		if len(frames) > 0 {
+			top = &frames[len(frames)-1]
+		}
+		key := openAPIFieldKey(frames)
+
+		switch value := token.(type) {
+		case json.Delim:
+			switch value {
+			case '{':
+				frames = append(frames, jsonFrame{object: true, expectKey: true})
+			case '[':
+				if key == "schemes" {
+					fields = append(fields, openAPIField{name: key, start: start})
+				}
+				frames = append(frames, jsonFrame{})
+			default:
+				frames = frames[:len(frames)-1]
+				if len(frames) > 0 {
+					frames[len(frames)-1].expectKey = true
+				}
+				if value == ']' && openAPIFieldKey(frames) == "schemes" {
+					fields[len(fields)-1].end = decoder.InputOffset()
+				}
+			}
+			continue
+
+		case string:
+			if top != nil && top.object && top.expectKey {
+				top.key = value
+				top.expectKey = false
+				continue
+			}
+			switch key {
+			case "openapi", "swagger":
+				version = key
+			case "url", "host", "basePath":
+				fields = append(fields, openAPIField{name: key, start: start, end: decoder.InputOffset(), value: value})
+			}
+		}
+		if top != nil {
+			top.expectKey = true
+		}
+	}
+
+	// The fields which are not those of the version of the document are left as they are.
+	n := 0
+	for _, field := range fields {
+		if (field.name == "url") == (version == "openapi") && version != "" {
+			fields[n] = field
+			n++
+		}
+	}
+	return version, fields[:n], nil
+}
+
+// openAPIFieldKey returns the name of the field whose value is being decoded if it is one of those of
+// openAPIFields, empty otherwise.
+func openAPIFieldKey(frames []jsonFrame) string {
+	switch {
+	case len(frames) == 1 && frames[0].object:
+		switch key := frames[0].key; key {
+		case "openapi", "swagger", "host", "basePath", "schemes":
+			return key
+		}
+	case len(frames) == 3 && frames[0].key == "servers" && !frames[1].object && frames[2].object && frames[2].key == "url":
+		return "url"
+	}
+	return ""
+}
+
+// rewriteOpenAPIDocument rewrites the server URLs of an OpenAPI or Swagger document to origin, prepending
+// prefix to their paths, and reports whether the document was modified. The server URLs of OpenAPI which
+// are neither absolute http or https URLs nor absolute paths, such as those with variables, are left as
+// they are, as well as the rest of the document, byte for byte.
+func rewriteOpenAPIDocument(body []byte, origin, prefix string) ([]byte, bool, error) {
+	version, fields, err := openAPIFields(body)
+	if err != nil || version == "" {
+		return body, false, err
+	}
+
+	scheme, host := origin, ""
+	if i := strings.Index(origin, "://"); i >= 0 {
+		scheme, host = origin[:i], origin[i+3:]
+	}
+	var out bytes.Buffer
+	var sent int64
+	for _, field := range fields {
+		var value interface{}
+		switch field.name {
+		case "url":
+			url := field.value.(string)
+			urlPath, absolute := httpURLPath(url)
+			switch {
+			case absolute:
+				value = origin + prefixPath(prefix, urlPath)
+			case strings.HasPrefix(url, "/"):
+				value = prefixPath(prefix, url)
+			default:
+				continue
+			}
+		case "host":
+			value = host
+		case "basePath":
+			value = prefixPath(prefix, field.value.(string))
+		case "schemes":
+			value = []string{scheme}
+		}
+
+		encoded, err := encodeJSONValue(value)
+		if err != nil {
+			return body, false, err
+		}
+		if bytes.Equal(encoded, body[field.start:field.end]) {
+			continue
+		}
+		out.Write(body[sent:field.start])
+		out.Write(encoded)
+		sent = field.end
+	}
+
+	if sent == 0 {
+		return body, false, nil
+	}
+	out.Write(body[sent:])
+	return out.Bytes(), true, nil
+}
+
+// prefixPath prepends prefix to path, unless it already starts with it.
+func prefixPath(prefix, path string) string {
+	if prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/") {
+		return path
+	}
+	if path == "" || path == "/" {
+		return prefix
+	}
+	if path[0] != '/' {
+		return prefix + "/" + path
+	}
+	return prefix + path
+}
+
+// encodeJSONValue encodes a value without escaping the HTML characters, nor a trailing newline.
+func encodeJSONValue(value interface{}) ([]byte, error) {
+	var encoded bytes.Buffer
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(encoded.Bytes(), []byte("\n")), nil
+}
+
+// String describes the documents in the debug dump.
+func (o *parsedOpenAPI) String() string {
+	paths := "any path"
+	if o.paths != nil {
+		paths = "paths " + strings.Join(o.paths, ", ")
+	}
+	return fmt.Sprintf("server URLs of %s rewritten to %s, with the forwarded prefix", paths, describeOrigin(o.origin))
+}
+
+func (o *parsedOpenAPI) option() string { return "openapi" }
+
+// changesBody implements the transformer interface.
+func (o *parsedOpenAPI) changesBody() bool { return true }
+
+// matchesContentType implements the contentTypeMatcher interface.
+func (o *parsedOpenAPI) matchesContentType(contentType string) bool {
+	return isOpenAPIResponse(contentType)
+}
+
+// cacheKey implements the cacheKeyer interface: the server URLs depend on the public origin of req and on its
+// forwarded prefix.
+func (o *parsedOpenAPI) cacheKey(req *http.Request) string {
+	return o.publicOrigin(req) + forwardedPrefix(req)
+}
+
+// transform implements the bodyTransformer interface, as rewriteBody for an OpenAPI or Swagger document: once
+// the rewrites are applied, its server URLs are rewritten to the public origin, with the X-Forwarded-Prefix of
+// the request prepended to their paths. The other JSON documents are sent unmodified, whereas those which can't
+// be parsed fail the openapi stage.
+func (o *parsedOpenAPI) transform(rw *responseWriter, body []byte) ([]byte, bool, bool, []int, error) {
+	r, response, req := rw.middleware, rw.response, rw.request
+	rewritten, modified, complete, replaced := body, false, true, []int(nil)
+	if len(response.rewrites) > 0 {
+		var err error
+		if rewritten, modified, complete, replaced, err = r.rewriteBody(response, body, req); err != nil {
+			return body, false, true, nil, err
+		}
+	}
+
+	result, changed, err := rewriteOpenAPIDocument(rewritten, o.publicOrigin(req), forwardedPrefix(req))
+	if err != nil {
+		return body, false, true, nil, &stageError{stage: stageOpenAPI, err: fmt.Errorf("unable to parse the OpenAPI document: %w", err)}
+	}
+	return result, modified || changed, complete, replaced, nil
+}
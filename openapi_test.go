@@ -0,0 +1,162 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRewriteOpenAPIDocument(t *testing.T) {
+	const openAPI = `{
+  "openapi": "3.0.3",
+  "info": {"title": "Orders", "version": "1.0", "x-url": "http://orders.internal.local:8080"},
+  "servers": [
+    {"url": "http://orders.internal.local:8080/v1", "description": "internal"},
+    {"url": "/v2"},
+    {"url": "{scheme}://orders.internal.local/v3"}
+  ],
+  "paths": {"/orders": {"servers": [{"url": "http://orders.internal.local:8080/v4"}]}}
+}`
+	const swagger = `{"swagger":"2.0","host":"orders.internal.local:8080","basePath":"/v1","schemes":["http", "https"],"paths":{}}`
+
+	tests := []struct {
+		desc    string
+		body    string
+		prefix  string
+		expBody string
+		expErr  bool
+	}{
+		{
+			desc:    "OpenAPI",
+			body:    openAPI,
+			expBody: strings.Replace(openAPI, `"url": "http://orders.internal.local:8080/v1"`, `"url": "https://api.example.com/v1"`, 1),
+		},
+		{
+			desc:   "OpenAPI with prefix",
+			body:   openAPI,
+			prefix: "/orders",
+			expBody: strings.NewReplacer(
+				`"url": "http://orders.internal.local:8080/v1"`, `"url": "https://api.example.com/orders/v1"`,
+				`"url": "/v2"`, `"url": "/orders/v2"`,
+			).Replace(openAPI),
+		},
+		{
+			desc:    "Swagger",
+			body:    swagger,
+			prefix:  "/orders",
+			expBody: `{"swagger":"2.0","host":"api.example.com","basePath":"/orders/v1","schemes":["https"],"paths":{}}`,
+		},
+		{
+			desc:    "already rewritten",
+			body:    `{"swagger":"2.0","host":"api.example.com","basePath":"/orders/v1","schemes":["https"]}`,
+			prefix:  "/orders",
+			expBody: `{"swagger":"2.0","host":"api.example.com","basePath":"/orders/v1","schemes":["https"]}`,
+		},
+		{
+			desc:    "other JSON document",
+			body:    `{"host": "orders.internal.local", "servers": [{"url": "http://orders.internal.local"}]}`,
+			expBody: `{"host": "orders.internal.local", "servers": [{"url": "http://orders.internal.local"}]}`,
+		},
+		{
+			desc:    "malformed document",
+			body:    `{"openapi": "3.0.3", "servers": [{"url": "http://orders.internal.local"}`,
+			expBody: `{"openapi": "3.0.3", "servers": [{"url": "http://orders.internal.local"}`,
+			expErr:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			body, modified, err := rewriteOpenAPIDocument([]byte(test.body), "https://api.example.com", test.prefix)
+			if (err != nil) != test.expErr {
+				t.Errorf("got error %v, want one: %t", err, test.expErr)
+			}
+			if string(body) != test.expBody {
+				t.Errorf("got body %s, want %s", body, test.expBody)
+			}
+			if expModified := test.expBody != test.body; modified != expModified {
+				t.Errorf("got modified %t, want %t", modified, expModified)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_openAPI(t *testing.T) {
+	const body = `{"openapi": "3.1.0", "servers": [{"url": "http://orders.internal.local:8080/v1"}]}`
+
+	tests := []struct {
+		desc        string
+		config      OpenAPI
+		path        string
+		header      http.Header
+		contentType string
+		expBody     string
+	}{
+		{
+			desc:        "forwarded origin and prefix",
+			path:        "/docs/openapi.json",
+			header:      http.Header{"X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"api.example.com"}, "X-Forwarded-Prefix": {"/orders/"}},
+			contentType: "application/json",
+			expBody:     `{"openapi": "3.1.0", "servers": [{"url": "https://api.example.com/orders/v1"}]}`,
+		},
+		{
+			desc:        "configured paths",
+			config:      OpenAPI{Paths: []string{"/openapi.json"}, Origin: "https://api.example.com"},
+			path:        "/openapi.json",
+			contentType: "application/vnd.oai.openapi+json",
+			expBody:     `{"openapi": "3.1.0", "servers": [{"url": "https://api.example.com/v1"}]}`,
+		},
+		{
+			desc:        "other path",
+			config:      OpenAPI{Paths: []string{"/openapi.json"}, Origin: "https://api.example.com"},
+			path:        "/docs/openapi.json",
+			contentType: "application/json",
+			expBody:     body,
+		},
+		{
+			desc:        "other content type",
+			config:      OpenAPI{Origin: "https://api.example.com"},
+			path:        "/openapi.json",
+			contentType: "application/yaml",
+			expBody:     body,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := test.config
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", test.contentType)
+				_, _ = rw.Write([]byte(body))
+			}), &Config{Responses: []Response{{Status: "200", OpenAPI: &config}}}, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, test.path, nil)
+			for name, values := range test.header {
+				req.Header[name] = values
+			}
+			handler.ServeHTTP(recorder, req)
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+
+	_, err := New(context.Background(), http.NotFoundHandler(), &Config{Responses: []Response{{
+		Status:  "200",
+		OpenAPI: &OpenAPI{Paths: []string{"openapi.json"}},
+	}}}, "rewriteBody")
+	expErr := `responses[0]: openapi: invalid path "openapi.json": must be a pattern starting with /`
+	if err == nil || err.Error() != expErr {
+		t.Errorf("got error %v, want %q", err, expErr)
+	}
+}
+
+func TestServeHTTP_openapiFailure(t *testing.T) {
+	testModeFailure(t, Response{Status: "200", OpenAPI: &OpenAPI{Paths: []string{"/"}, Origin: "https://api.example.com"}},
+		"application/json", `{"openapi": "3.0.3", "servers": [{"url": "http://orders.internal.local"}`, stageOpenAPI)
+}
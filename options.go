@@ -0,0 +1,120 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// defaultMiddlewareName is the name of the middlewares created by NewMiddleware without WithName.
+const defaultMiddlewareName = "responsebodyrewrite"
+
+// middlewareOptions are the settings of a middleware created by NewMiddleware.
+type middlewareOptions struct {
+	ctx    context.Context
+	config Config
+	name   string
+	logger *log.Logger
+}
+
+// Option configures a middleware created by NewMiddleware.
+type Option func(*middlewareOptions)
+
+// WithConfig starts from a copy of config, as given to the plugin by Traefik. The options given after it
+// override its settings.
+func WithConfig(config *Config) Option {
+	return func(o *middlewareOptions) {
+		if config != nil {
+			o.config = *config
+		}
+	}
+}
+
+// WithContext sets the context of the middleware, which stops logging the metrics summary once ctx is done.
+// It defaults to context.Background().
+func WithContext(ctx context.Context) Option {
+	return func(o *middlewareOptions) {
+		o.ctx = ctx
+	}
+}
+
+// WithName sets the name of the middleware, which prefixes its logs.
+func WithName(name string) Option {
+	return func(o *middlewareOptions) {
+		o.name = name
+	}
+}
+
+// WithResponses sets the response blocks of the middleware, replacing those of a previous WithConfig.
+// Their rewrites can be created with NewRewrite to use regexes already compiled.
+func WithResponses(responses ...Response) Option {
+	return func(o *middlewareOptions) {
+		o.config.Responses = responses
+	}
+}
+
+// WithMaxBodySize sets the maximum number of bytes buffered for a rewrite, see Config.MaxBodySize.
+func WithMaxBodySize(size int64) Option {
+	return func(o *middlewareOptions) {
+		o.config.MaxBodySize = size
+	}
+}
+
+// WithLogger writes the logs of the middleware to logger instead of the standard output. In the text format,
+// the messages are prefixed by their level, the logger adding its own prefix and flags.
+func WithLogger(logger *log.Logger) Option {
+	return func(o *middlewareOptions) {
+		o.logger = logger
+	}
+}
+
+// NewMiddleware creates the middleware outside of Traefik, with the given options applied in order to an
+// empty configuration.
+func NewMiddleware(next http.Handler, opts ...Option) (http.Handler, error) {
+	o := middlewareOptions{ctx: context.Background(), name: defaultMiddlewareName}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return newMiddleware(o.ctx, next, &o.config, o.name, o.logger, false)
+}
+
+// NewRewrite returns a rewrite replacing the matches of a regex already compiled, skipping its compilation
+// by the middleware. Like the regexes of the configuration, it is applied with leftmost-first semantics.
+func NewRewrite(regex *regexp.Regexp, replacement string) Rewrite {
+	return Rewrite{Regex: regex.String(), Replacement: replacement, regex: regex}
+}
+
+// Rewriter applies rewrites to a body the way the middleware does for a response block, to test them in
+// isolation.
+type Rewriter struct {
+	response parsedResponse
+}
+
+// NewRewriter returns a Rewriter of the given rewrites, applied in order. It fails on the rewrites the
+// middleware would reject, except for the empty replacements, allowed without Remove as with
+// allowEmptyReplacement.
+func NewRewriter(rewrites ...Rewrite) (*Rewriter, error) {
+	if len(rewrites) == 0 {
+		return nil, errors.New("rewrites: must not be empty")
+	}
+	parsed, err := compileRewrites(rewrites, true)
+	if err != nil {
+		return nil, err
+	}
+	return &Rewriter{response: parsedResponse{rewrites: parsed, passes: optimizePasses(parsed)}}, nil
+}
+
+// Rewrite returns the rewritten body, and whether any rewrite matched. The body is not modified.
+func (r *Rewriter) Rewrite(body []byte) ([]byte, bool) {
+	return r.response.rewrite(body)
+}
+
+// Replacements returns the number of matches of each rewrite, in the body as rewritten by the previous ones.
+func (r *Rewriter) Replacements(body []byte) []int {
+	replaced := make([]int, len(r.response.rewrites))
+	_, _, _, _ = r.response.rewriteUntil(body, time.Time{}, replaced)
+	return replaced
+}
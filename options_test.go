@@ -0,0 +1,113 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNewMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	handler, err := NewMiddleware(
+		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("foo FOO bar"))
+		}),
+		WithConfig(&Config{LogLevel: "debug", MaxBodySize: 1}),
+		WithName("rewriteBody"),
+		WithResponses(Response{
+			Status:   "200",
+			Rewrites: []Rewrite{NewRewrite(regexp.MustCompile("(?i)foo"), "baz")},
+		}),
+		WithMaxBodySize(1024),
+		WithLogger(log.New(&logs, "test: ", 0)),
+	)
+	if err != nil {
+		t.Fatalf("got error %v, want none", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if body := recorder.Body.String(); body != "baz baz bar" {
+		t.Errorf("got body %q, want %q", body, "baz baz bar")
+	}
+	if !strings.HasPrefix(logs.String(), "test: INFO: rewriteBody: compiled 1 rules of 1 response blocks in ") {
+		t.Errorf("got logs %q, want them written to the logger with the level and the name", logs.String())
+	}
+}
+
+func TestNewMiddleware_errors(t *testing.T) {
+	_, err := NewMiddleware(http.NotFoundHandler(), WithResponses(Response{Status: "200"}))
+	if err == nil || err.Error() != `responses[0]: rewrites: must not be empty unless trailers is "strip", or jsonapiErrors, yamlOps, html, sitemap, feed, hls, dash, vast, openapi, oidc, linkHeader or a csv mask is set` {
+		t.Errorf("got error %v, want the one of New", err)
+	}
+	if _, err := NewMiddleware(http.NotFoundHandler(), WithMaxBodySize(-1)); err == nil {
+		t.Error("expected an error for a negative maxBodySize")
+	}
+}
+
+func TestWithConfig_copy(t *testing.T) {
+	config := &Config{MaxBodySize: 1}
+	if _, err := NewMiddleware(http.NotFoundHandler(), WithConfig(config), WithMaxBodySize(2)); err != nil {
+		t.Fatalf("got error %v, want none", err)
+	}
+	if config.MaxBodySize != 1 {
+		t.Errorf("got maxBodySize %d, want the config left unmodified", config.MaxBodySize)
+	}
+}
+
+func TestRewriter(t *testing.T) {
+	rewriter, err := NewRewriter(
+		Rewrite{Regex: "foo", Replacement: "bar"},
+		NewRewrite(regexp.MustCompile(`bar(\d)`), "baz$1"),
+	)
+	if err != nil {
+		t.Fatalf("got error %v, want none", err)
+	}
+
+	tests := []struct {
+		body            string
+		expBody         string
+		expModified     bool
+		expReplacements []int
+	}{
+		{body: "foo1 bar2", expBody: "baz1 baz2", expModified: true, expReplacements: []int{1, 2}},
+		{body: "qux", expBody: "qux", expModified: false, expReplacements: []int{0, 0}},
+	}
+	for _, test := range tests {
+		body, modified := rewriter.Rewrite([]byte(test.body))
+		if string(body) != test.expBody || modified != test.expModified {
+			t.Errorf("%q: got %q, %t, want %q, %t", test.body, body, modified, test.expBody, test.expModified)
+		}
+		if replacements := rewriter.Replacements([]byte(test.body)); !reflect.DeepEqual(replacements, test.expReplacements) {
+			t.Errorf("%q: got replacements %v, want %v", test.body, replacements, test.expReplacements)
+		}
+	}
+}
+
+func TestNewRewriter_errors(t *testing.T) {
+	tests := []struct {
+		desc     string
+		rewrites []Rewrite
+		expErr   string
+	}{
+		{desc: "no rewrites", expErr: "rewrites: must not be empty"},
+		{desc: "empty regex", rewrites: []Rewrite{{Replacement: "foo"}}, expErr: "rewrites[0].regex: must not be empty"},
+		{
+			desc:     "missing group",
+			rewrites: []Rewrite{{Regex: "foo"}, NewRewrite(regexp.MustCompile("bar"), "$1")},
+			expErr:   `rewrites[1].replacement: "$1" refers to group "1", which regex "bar" doesn't have`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if _, err := NewRewriter(test.rewrites...); err == nil || err.Error() != test.expErr {
+				t.Errorf("got error %v, want %q", err, test.expErr)
+			}
+		})
+	}
+}
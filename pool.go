@@ -0,0 +1,52 @@
+package traefik_responsebodyrewrite
+
+import (
+	"net/http"
+	"sync"
+)
+
+// maxPooledBufferSize is the capacity above which a buffer is not kept in the pool, so that a single huge
+// response doesn't keep its memory retained forever.
+const maxPooledBufferSize = 1 << 20
+
+// responseWriterPool holds the responseWriters, and their buffers, reused across requests.
+var responseWriterPool = sync.Pool{
+	New: func() interface{} {
+		return &responseWriter{}
+	},
+}
+
+// acquireResponseWriter returns a responseWriter from the pool, wrapping rw for the given request, whose
+// response is rewritten by the given response blocks.
+func acquireResponseWriter(r *responsebodyrewrite, rw http.ResponseWriter, req *http.Request, responses []parsedResponse) *responseWriter {
+	wrappedWriter := responseWriterPool.Get().(*responseWriter)
+	wrappedWriter.code = http.StatusOK
+	wrappedWriter.ResponseWriter = rw
+	wrappedWriter.responses = responses
+	wrappedWriter.middleware = r
+	wrappedWriter.request = req
+	return wrappedWriter
+}
+
+// releaseResponseWriter resets rw and puts it back in the pool.
+// rw must not be used after this call.
+func releaseResponseWriter(rw *responseWriter) {
+	rw.removeSpillFiles()
+	// A JSON decoder left waiting for a body which is never going to be completed must be stopped.
+	if json, ok := rw.stream.(*jsonRewriter); ok {
+		json.abort()
+	}
+	rw.releaseBudget()
+
+	buffer := rw.buffer
+	buffer.Reset()
+	if buffer.Cap() > maxPooledBufferSize {
+		return
+	}
+
+	// Reset every other field, so that no state leaks to the next request.
+	*rw = responseWriter{
+		buffer: buffer,
+	}
+	responseWriterPool.Put(rw)
+}
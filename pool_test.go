@@ -0,0 +1,45 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReleaseResponseWriter(t *testing.T) {
+	r := &responsebodyrewrite{}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	rw := acquireResponseWriter(r, httptest.NewRecorder(), req, nil)
+	rw.code = http.StatusNotFound
+	rw.wroteHeader = true
+	rw.headersSent = true
+	rw.passthrough = true
+	_, _ = rw.buffer.WriteString("foo")
+
+	releaseResponseWriter(rw)
+
+	if rw.code != 0 || rw.wroteHeader || rw.headersSent || rw.passthrough || rw.ResponseWriter != nil || rw.middleware != nil || rw.request != nil {
+		t.Errorf("responseWriter state not reset: %+v", rw)
+	}
+	if rw.buffer.Len() != 0 {
+		t.Errorf("got buffer length %d, want 0", rw.buffer.Len())
+	}
+}
+
+func TestReleaseResponseWriter_bigBuffer(t *testing.T) {
+	r := &responsebodyrewrite{}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	rw := acquireResponseWriter(r, httptest.NewRecorder(), req, nil)
+	_, _ = rw.buffer.Write(bytes.Repeat([]byte("a"), maxPooledBufferSize+1))
+	rw.headersSent = true
+
+	releaseResponseWriter(rw)
+
+	// A responseWriter with a buffer over the cap is dropped instead of being reset and pooled.
+	if !rw.headersSent {
+		t.Error("responseWriter with a big buffer must not be put back in the pool")
+	}
+}
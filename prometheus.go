@@ -0,0 +1,92 @@
+package traefik_responsebodyrewrite
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// debugMetricsPath is the path, under the debugPath, of the counters of the metrics in the Prometheus text
+// format.
+const debugMetricsPath = "/metrics"
+
+// prometheusContentType is the content type of the Prometheus text format.
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// isDebugMetricsRequest reports whether a debug request asks for the metrics rather than the debug dump.
+func (r *responsebodyrewrite) isDebugMetricsRequest(req *http.Request) bool {
+	return req.URL.Path == strings.TrimSuffix(r.debugPath, "/")+debugMetricsPath
+}
+
+// prometheusName returns the name of the Prometheus metric of a counter, in snake case, e.g.
+// "responsebodyrewrite_passthrough_max_body_size_total" for "passthrough.maxBodySize".
+func prometheusName(value metric) string {
+	var name strings.Builder
+	name.WriteString(statsdPrefix + "_")
+	if value.response != "" {
+		name.WriteString("response_")
+	}
+	for i, c := range value.counter {
+		switch {
+		case c == '.':
+			name.WriteByte('_')
+		case c >= 'A' && c <= 'Z':
+			if i > 0 {
+				name.WriteByte('_')
+			}
+			name.WriteRune(c - 'A' + 'a')
+		default:
+			name.WriteRune(c)
+		}
+	}
+	name.WriteString("_total")
+	return name.String()
+}
+
+// prometheusLabel escapes the value of a label of the Prometheus text format.
+func prometheusLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// prometheusExposition formats the counters in the Prometheus text format, labelled with the name of the
+// middleware, and of the response block for the counters of a response block. The counters of a metric are
+// grouped after its TYPE line.
+func prometheusExposition(name string, values []metric) string {
+	var names []string
+	families := make(map[string][]string)
+	for _, value := range values {
+		metricName := prometheusName(value)
+		labels := `middleware="` + prometheusLabel(name) + `"`
+		if value.response != "" {
+			labels += `,response="` + prometheusLabel(value.response) + `"`
+		}
+		if _, ok := families[metricName]; !ok {
+			names = append(names, metricName)
+		}
+		families[metricName] = append(families[metricName], metricName+"{"+labels+"} "+strconv.FormatInt(value.value, 10))
+	}
+
+	var exposition strings.Builder
+	for _, metricName := range names {
+		exposition.WriteString("# TYPE " + metricName + " counter\n")
+		for _, sample := range families[metricName] {
+			exposition.WriteString(sample + "\n")
+		}
+	}
+	return exposition.String()
+}
+
+// serveDebugMetrics sends the counters of the metrics in the Prometheus text format, for the scrapers which
+// can't parse the logs. When the metrics are disabled, the exposition is a comment telling so.
+func (r *responsebodyrewrite) serveDebugMetrics(rw http.ResponseWriter) {
+	body := "# metrics are disabled: metricsInterval is 0 without metricsAddr\n"
+	if r.metrics != nil {
+		body = prometheusExposition(r.name, r.metrics.values(r.currentResponses()))
+	}
+	rw.Header().Set("Content-Type", prometheusContentType)
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(http.StatusOK)
+	if err := writeBody(rw, []byte(body)); err != nil {
+		r.warnf("%s: unable to write the debug metrics: %v", r.name, err)
+	}
+}
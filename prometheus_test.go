@@ -0,0 +1,140 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrometheusExposition(t *testing.T) {
+	values := []metric{
+		{name: "responses", counter: "responses", value: 3},
+		{name: "passthrough.maxBodySize", counter: "passthrough.maxBodySize", value: 1},
+		responseMetric("ok", "matched", 2),
+		responseMetric("ok", "bytesIn", 10),
+		responseMetric("0", "matched", 1),
+		responseMetric("0", "bytesIn", 5),
+	}
+	expected := `# TYPE responsebodyrewrite_responses_total counter
+responsebodyrewrite_responses_total{middleware="rewrite\"body\\@file"} 3
+# TYPE responsebodyrewrite_passthrough_max_body_size_total counter
+responsebodyrewrite_passthrough_max_body_size_total{middleware="rewrite\"body\\@file"} 1
+# TYPE responsebodyrewrite_response_matched_total counter
+responsebodyrewrite_response_matched_total{middleware="rewrite\"body\\@file",response="ok"} 2
+responsebodyrewrite_response_matched_total{middleware="rewrite\"body\\@file",response="0"} 1
+# TYPE responsebodyrewrite_response_bytes_in_total counter
+responsebodyrewrite_response_bytes_in_total{middleware="rewrite\"body\\@file",response="ok"} 10
+responsebodyrewrite_response_bytes_in_total{middleware="rewrite\"body\\@file",response="0"} 5
+`
+	if exposition := prometheusExposition(`rewrite"body\@file`, values); exposition != expected {
+		t.Errorf("got exposition:\n%s\nwant:\n%s", exposition, expected)
+	}
+}
+
+func TestServeHTTP_debugMetrics(t *testing.T) {
+	tests := []struct {
+		desc      string
+		debugPath string
+		path      string
+		token     string
+		interval  string
+		expBody   string
+		expStatus int
+	}{
+		{
+			desc:      "metrics",
+			debugPath: "/_rewrite",
+			path:      "/_rewrite/metrics",
+			token:     "secret",
+			expBody: `# TYPE responsebodyrewrite_responses_total counter
+responsebodyrewrite_responses_total{middleware="rewriteBody"} 1
+# TYPE responsebodyrewrite_passthrough_max_body_size_total counter
+responsebodyrewrite_passthrough_max_body_size_total{middleware="rewriteBody"} 0
+# TYPE responsebodyrewrite_passthrough_encoded_total counter
+responsebodyrewrite_passthrough_encoded_total{middleware="rewriteBody"} 0
+# TYPE responsebodyrewrite_failures_rewrite_total counter
+responsebodyrewrite_failures_rewrite_total{middleware="rewriteBody"} 0
+# TYPE responsebodyrewrite_failures_json_total counter
+responsebodyrewrite_failures_json_total{middleware="rewriteBody"} 0
+# TYPE responsebodyrewrite_failures_csv_total counter
+responsebodyrewrite_failures_csv_total{middleware="rewriteBody"} 0
+# TYPE responsebodyrewrite_failures_yaml_total counter
+responsebodyrewrite_failures_yaml_total{middleware="rewriteBody"} 0
+# TYPE responsebodyrewrite_failures_sitemap_total counter
+responsebodyrewrite_failures_sitemap_total{middleware="rewriteBody"} 0
+# TYPE responsebodyrewrite_failures_feed_total counter
+responsebodyrewrite_failures_feed_total{middleware="rewriteBody"} 0
+# TYPE responsebodyrewrite_failures_dash_total counter
+responsebodyrewrite_failures_dash_total{middleware="rewriteBody"} 0
+# TYPE responsebodyrewrite_failures_vast_total counter
+responsebodyrewrite_failures_vast_total{middleware="rewriteBody"} 0
+# TYPE responsebodyrewrite_failures_openapi_total counter
+responsebodyrewrite_failures_openapi_total{middleware="rewriteBody"} 0
+# TYPE responsebodyrewrite_failures_oidc_total counter
+responsebodyrewrite_failures_oidc_total{middleware="rewriteBody"} 0
+# TYPE responsebodyrewrite_failures_soap_total counter
+responsebodyrewrite_failures_soap_total{middleware="rewriteBody"} 0
+# TYPE responsebodyrewrite_failures_multipart_total counter
+responsebodyrewrite_failures_multipart_total{middleware="rewriteBody"} 0
+# TYPE responsebodyrewrite_response_matched_total counter
+responsebodyrewrite_response_matched_total{middleware="rewriteBody",response="ok"} 1
+# TYPE responsebodyrewrite_response_modified_total counter
+responsebodyrewrite_response_modified_total{middleware="rewriteBody",response="ok"} 1
+# TYPE responsebodyrewrite_response_replacements_total counter
+responsebodyrewrite_response_replacements_total{middleware="rewriteBody",response="ok"} 0
+# TYPE responsebodyrewrite_response_bytes_in_total counter
+responsebodyrewrite_response_bytes_in_total{middleware="rewriteBody",response="ok"} 3
+# TYPE responsebodyrewrite_response_bytes_out_total counter
+responsebodyrewrite_response_bytes_out_total{middleware="rewriteBody",response="ok"} 3
+`,
+		},
+		{
+			desc:      "debugPath ending with a slash",
+			debugPath: "/_rewrite/",
+			path:      "/_rewrite/metrics",
+			token:     "secret",
+			interval:  "0",
+			expBody:   "# metrics are disabled: metricsInterval is 0 without metricsAddr\n",
+		},
+		{desc: "wrong token", debugPath: "/_rewrite", path: "/_rewrite/metrics", token: "guess", expStatus: http.StatusOK},
+		{desc: "no token", debugPath: "/_rewrite", path: "/_rewrite/metrics", expStatus: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				_, _ = rw.Write([]byte("foo"))
+			}), &Config{
+				DebugPath:       test.debugPath,
+				DebugToken:      "secret",
+				MetricsInterval: test.interval,
+				Responses:       []Response{{Name: "ok", Status: "200", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}}},
+			}, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			req := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.token != "" {
+				req.Header.Set(debugTokenHeader, test.token)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if test.expBody == "" {
+				// The upstream answers the requests without the token, its body being rewritten.
+				if body := recorder.Body.String(); recorder.Code != test.expStatus || body != "bar" {
+					t.Errorf("got status %d and body %q, want the rewritten body of the upstream", recorder.Code, body)
+				}
+				return
+			}
+			if contentType := recorder.Header().Get("Content-Type"); recorder.Code != http.StatusOK || contentType != prometheusContentType {
+				t.Errorf("got status %d and Content-Type %q, want the Prometheus text format", recorder.Code, contentType)
+			}
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body:\n%s\nwant:\n%s", body, test.expBody)
+			}
+		})
+	}
+}
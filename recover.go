@@ -0,0 +1,82 @@
+package traefik_responsebodyrewrite
+
+import (
+	"net/http"
+	"runtime/debug"
+)
+
+// serveNext calls the upstream handler. With recoverPanics, it recovers from a panic of the handler and
+// sends an error response instead, returning true once the response has been sent.
+func (r *responsebodyrewrite) serveNext(rw *responseWriter, req *http.Request) (recovered bool) {
+	if !r.recoverPanics {
+		r.next.ServeHTTP(rw, req)
+		return false
+	}
+
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		// http.ErrAbortHandler is the way for a handler to abort the response on purpose.
+		if v == http.ErrAbortHandler {
+			panic(v)
+		}
+
+		r.logf(levelError, logFields{request: req}, "%s: recovered from a panic serving %s: %v\n%s", r.name, req.URL.Path, v, debug.Stack())
+		rw.sendPanicResponse()
+		recovered = true
+	}()
+
+	r.next.ServeHTTP(rw, req)
+	return false
+}
+
+// sendPanicResponse sends a 500 Internal Server Error response in place of the response of a handler which
+// panicked. Whatever the handler wrote is dropped. If the headers have already been sent, a valid response
+// can't be sent anymore and the connection is aborted.
+func (rw *responseWriter) sendPanicResponse() {
+	if rw.headersSent || rw.hijacked {
+		panic(http.ErrAbortHandler)
+	}
+
+	body := []byte(http.StatusText(http.StatusInternalServerError))
+	for i := range rw.responses {
+		if rw.responses[i].matches(http.StatusInternalServerError, rw.request) {
+			if !rw.responses[i].dryRun {
+				var err error
+				body, _, _, _, err = rw.middleware.rewriteBody(&rw.responses[i], body, rw.request)
+				if err != nil {
+					rw.logFailure(&rw.responses[i], stageRewrite, err, "sending the original body")
+				}
+			}
+			break
+		}
+	}
+	rw.replaceResponse(http.StatusInternalServerError, body)
+}
+
+// replaceResponse sends a plain text response with the given status code and body, in place of whatever the
+// handler wrote, which must not have been sent yet.
+func (rw *responseWriter) replaceResponse(statusCode int, body []byte) {
+	if json, ok := rw.stream.(*jsonRewriter); ok {
+		json.abort()
+	}
+	rw.stream = nil
+	rw.spill = nil
+	rw.buffer.Reset()
+	rw.releaseBudget()
+
+	header := rw.ResponseWriter.Header()
+	for name := range header {
+		delete(header, name)
+	}
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	header.Set("X-Content-Type-Options", "nosniff")
+
+	rw.code = statusCode
+	rw.sendHeaders()
+	if err := writeBody(rw.ResponseWriter, body); err != nil {
+		rw.logWriteError(int64(len(body)), err)
+	}
+}
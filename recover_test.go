@@ -0,0 +1,97 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeHTTP_recoverPanics(t *testing.T) {
+	tests := []struct {
+		desc          string
+		recoverPanics bool
+		flush         bool
+		expPanic      interface{}
+		expBody       string
+	}{
+		{
+			desc:          "should send a rewritten 500 response",
+			recoverPanics: true,
+			expBody:       "Something went wrong",
+		},
+		{
+			desc:          "should abort a response whose headers have been sent",
+			recoverPanics: true,
+			flush:         true,
+			expPanic:      http.ErrAbortHandler,
+		},
+		{
+			desc:     "should propagate the panic",
+			expPanic: "boom",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				RecoverPanics: test.recoverPanics,
+				Responses: []Response{
+					{
+						Status:   "200",
+						Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+					},
+					{
+						Status:   "500",
+						Rewrites: []Rewrite{{Regex: "Internal Server Error", Replacement: "Something went wrong"}},
+					},
+				},
+			}
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Content-Type", "application/json")
+				rw.Header().Set("Content-Length", "100")
+				_, _ = rw.Write([]byte("foo is the new bar"))
+				if test.flush {
+					rw.(http.Flusher).Flush()
+				}
+				panic("boom")
+			}
+
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			var panicked interface{}
+			func() {
+				defer func() { panicked = recover() }()
+				rewriteBody.ServeHTTP(recorder, req)
+			}()
+
+			if panicked != test.expPanic {
+				t.Fatalf("got panic %v, want %v", panicked, test.expPanic)
+			}
+			if test.expPanic != nil {
+				return
+			}
+
+			result := recorder.Result()
+			if result.StatusCode != http.StatusInternalServerError {
+				t.Errorf("got status %d, want %d", result.StatusCode, http.StatusInternalServerError)
+			}
+			if header := result.Header.Get("Content-Type"); header != "text/plain; charset=utf-8" {
+				t.Errorf("got Content-Type %q, want %q", header, "text/plain; charset=utf-8")
+			}
+			if header := result.Header.Get("Content-Length"); header != "" {
+				t.Errorf("got Content-Length %q, want none", header)
+			}
+			if recorder.Body.String() != test.expBody {
+				t.Errorf("got body %q, want %q", recorder.Body.String(), test.expBody)
+			}
+		})
+	}
+}
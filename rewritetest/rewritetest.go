@@ -0,0 +1,89 @@
+// Package rewritetest provides utilities to test the rewrite rules of the responsebodyrewrite middleware,
+// without running Traefik.
+package rewritetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	rewrite "github.com/quortex/traefik-responsebodyrewrite"
+)
+
+// update makes CompareGolden write the golden files instead of comparing them, e.g. with
+// go test ./... -rewritetest.update
+var update = flag.Bool("rewritetest.update", false, "update the golden files of rewritetest.CompareGolden")
+
+// Apply runs a GET request of / through the middleware configured by config, the upstream answering with
+// the given status, header and body, and returns the response received by the client.
+func Apply(config *rewrite.Config, status int, header http.Header, body []byte) (*http.Response, error) {
+	return ApplyRequest(config, httptest.NewRequest(http.MethodGet, "/", nil), status, header, body)
+}
+
+// ApplyRequest is Apply for the given request.
+func ApplyRequest(config *rewrite.Config, req *http.Request, status int, header http.Header, body []byte) (*http.Response, error) {
+	if config == nil {
+		return nil, errors.New("rewritetest: nil config")
+	}
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		for name, values := range header {
+			w.Header()[name] = append([]string(nil), values...)
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write(body)
+	})
+	// The context stops the goroutine logging the metrics of the middleware.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler, err := rewrite.New(ctx, upstream, config, "rewritetest")
+	if err != nil {
+		return nil, err
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder.Result(), nil
+}
+
+// CompareGolden fails the test when got differs from the content of the golden file at path. With the
+// -rewritetest.update flag, the golden file is written with got instead.
+func CompareGolden(tb testing.TB, path string, got []byte) {
+	tb.Helper()
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			tb.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			tb.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		tb.Fatalf("%v, run the test with -rewritetest.update to create it", err)
+	}
+	if !bytes.Equal(got, want) {
+		tb.Errorf("got body:\n%s\nwant the content of %s:\n%s", got, path, want)
+	}
+}
+
+// CompareGoldenBody reads the body of response and compares it with the golden file at path, like
+// CompareGolden.
+func CompareGoldenBody(tb testing.TB, path string, response *http.Response) {
+	tb.Helper()
+
+	got, err := io.ReadAll(response.Body)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	CompareGolden(tb, path, got)
+}
@@ -0,0 +1,81 @@
+package rewritetest
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	rewrite "github.com/quortex/traefik-responsebodyrewrite"
+)
+
+func testConfig() *rewrite.Config {
+	return &rewrite.Config{
+		Responses: []rewrite.Response{
+			{
+				Status:   "200",
+				Rewrites: []rewrite.Rewrite{{Regex: "foo", Replacement: "Bar"}},
+			},
+		},
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		desc      string
+		status    int
+		expBody   string
+		expHeader string
+	}{
+		{desc: "matching status", status: http.StatusOK, expBody: "Hello Bar, bar!", expHeader: "bar"},
+		{desc: "other status", status: http.StatusNotFound, expBody: "Hello foo, bar!", expHeader: "bar"},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			response, err := Apply(testConfig(), test.status, http.Header{"X-Foo": {"bar"}}, []byte("Hello foo, bar!"))
+			if err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != test.status {
+				t.Errorf("got status %d, want %d", response.StatusCode, test.status)
+			}
+			if string(body) != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+			if header := response.Header.Get("X-Foo"); header != test.expHeader {
+				t.Errorf("got header %q, want %q", header, test.expHeader)
+			}
+		})
+	}
+}
+
+func TestApply_errors(t *testing.T) {
+	if _, err := Apply(nil, http.StatusOK, nil, nil); err == nil {
+		t.Error("expected an error for a nil config")
+	}
+	config := &rewrite.Config{Responses: []rewrite.Response{{Status: "200", Rewrites: []rewrite.Rewrite{{Regex: "ba(r"}}}}}
+	if _, err := Apply(config, http.StatusOK, nil, nil); err == nil {
+		t.Error("expected an error for an invalid config")
+	}
+}
+
+func TestCompareGolden(t *testing.T) {
+	response, err := Apply(testConfig(), http.StatusOK, nil, []byte("Hello foo, bar!"))
+	if err != nil {
+		t.Fatalf("got error %v, want none", err)
+	}
+	CompareGoldenBody(t, filepath.Join("testdata", "rewritten.golden"), response)
+
+	*update = true
+	defer func() { *update = false }()
+	path := filepath.Join(t.TempDir(), "new", "body.golden")
+	CompareGolden(t, path, []byte("foo"))
+	if b, err := os.ReadFile(path); err != nil || string(b) != "foo" {
+		t.Errorf("got golden file %q, %v, want %q", b, err, "foo")
+	}
+}
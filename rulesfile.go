@@ -0,0 +1,248 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// rulesFileEntry is a rewrite read from a rulesFile, with the line where it starts.
+type rulesFileEntry struct {
+	rewrite Rewrite
+	line    int
+}
+
+// loadRulesFile reads and compiles the rewrites of a rulesFile, whose replacements may be empty without remove
+// if allowEmptyReplacement is set, within the limits. Its errors tell the line and the index of the offending
+// rewrite in the file.
+func loadRulesFile(path string, allowEmptyReplacement bool, limits ruleLimits) ([]parsedRewrite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("rulesFile: %w", err)
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".json" && ext != ".yml" && ext != ".yaml" {
+		return nil, fmt.Errorf("rulesFile %q: must be a .json, .yml or .yaml file", path)
+	}
+	return compileRules(fmt.Sprintf("rulesFile %q", path), data, ext, allowEmptyReplacement, limits)
+}
+
+// compileRules parses and compiles a JSON or YAML list of rewrites, told by ext, within the limits. Its errors
+// start with source, followed by the line and the index of the offending rewrite.
+func compileRules(source string, data []byte, ext string, allowEmptyReplacement bool, limits ruleLimits) ([]parsedRewrite, error) {
+	var entries []rulesFileEntry
+	var err error
+	if ext == ".json" {
+		entries, err = parseRulesJSON(data)
+	} else {
+		entries, err = parseRulesYAML(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+
+	if len(entries) > limits.maxRewrites {
+		return nil, fmt.Errorf("%s: %d rules exceed maxRewritesPerResponse of %d", source, len(entries), limits.maxRewrites)
+	}
+	for i, entry := range entries {
+		if err := limits.checkPattern(entry.rewrite.Regex); err != nil {
+			return nil, fmt.Errorf("%s: line %d: %s.%w", source, entry.line, rewriteLabel(i, entry.rewrite), err)
+		}
+	}
+
+	rewrites := make([]parsedRewrite, len(entries))
+	for i, entry := range entries {
+		if rewrites[i], err = compileRewrite(entry.rewrite, allowEmptyReplacement); err != nil {
+			return nil, fmt.Errorf("%s: line %d: %s.%w", source, entry.line, rewriteLabel(i, entry.rewrite), err)
+		}
+	}
+	return rewrites, nil
+}
+
+// parseRulesJSON parses a JSON list of rewrites, rejecting the unknown fields.
+func parseRulesJSON(data []byte) ([]rulesFileEntry, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return nil, errors.New("line 1: must be a list of rewrites")
+	}
+	var entries []rulesFileEntry
+	for decoder.More() {
+		line := lineAt(data, skipJSONSeparators(data, decoder.InputOffset()))
+		var rewrite Rewrite
+		if err := decoder.Decode(&rewrite); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				line = lineAt(data, syntaxErr.Offset)
+			}
+			return nil, fmt.Errorf("line %d: rewrites[%d]: %w", line, len(entries), err)
+		}
+		entries = append(entries, rulesFileEntry{rewrite: rewrite, line: line})
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("line %d: %w", lineAt(data, decoder.InputOffset()), err)
+	}
+	return entries, nil
+}
+
+// skipJSONSeparators returns the offset of the first byte of data from offset which is neither a space nor
+// a comma, i.e. the start of the next value of a list.
+func skipJSONSeparators(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) && strings.IndexByte(" \t\r\n,", data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// lineAt returns the line of the byte of data at the given offset, starting from 1.
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return 1 + bytes.Count(data[:offset], []byte("\n"))
+}
+
+// parseRulesYAML parses a YAML list of rewrites. Only the subset of YAML needed for such a list is supported,
+// the standard library having no YAML parser:
+//
+//	# Comment.
+//	- regex: "foo(bar)?"
+//	  replacement: 'baz'
+//	- regex: plain scalar
+//	  remove: true
+//	  description: remove the plain scalars
+//
+// Plain scalars which YAML would read as something else than a string, e.g. starting with "[", must be quoted.
+func parseRulesYAML(data []byte) ([]rulesFileEntry, error) {
+	var entries []rulesFileEntry
+	var seen map[string]bool
+	for i, line := range strings.Split(string(data), "\n") {
+		number := i + 1
+		content := strings.TrimRight(line, " \t\r")
+		trimmed := strings.TrimLeft(content, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || (trimmed == "---" && len(entries) == 0) {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", number)
+		}
+
+		if trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			entries = append(entries, rulesFileEntry{line: number})
+			seen = map[string]bool{}
+			trimmed = strings.TrimLeft(trimmed[1:], " ")
+			if trimmed == "" {
+				continue
+			}
+		} else if len(entries) == 0 || len(trimmed) == len(content) {
+			return nil, fmt.Errorf("line %d: must be a list of rewrites", number)
+		}
+
+		key, value, err := parseYAMLPair(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number, err)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("line %d: duplicate key %q", number, key)
+		}
+		seen[key] = true
+		entry := &entries[len(entries)-1]
+		switch key {
+		case "regex":
+			entry.rewrite.Regex = value
+		case "replacement":
+			entry.rewrite.Replacement = value
+		case "description":
+			entry.rewrite.Description = value
+		case "remove":
+			if value != "true" && value != "false" {
+				return nil, fmt.Errorf("line %d: invalid remove %q: must be true or false", number, value)
+			}
+			entry.rewrite.Remove = value == "true"
+		default:
+			return nil, fmt.Errorf("line %d: unknown key %q: must be regex, replacement, remove or description", number, key)
+		}
+	}
+	return entries, nil
+}
+
+// parseYAMLPair parses a "key: value" pair of a YAML mapping, the value being a scalar.
+func parseYAMLPair(pair string) (string, string, error) {
+	colon := strings.Index(pair, ": ")
+	if colon < 0 {
+		if !strings.HasSuffix(pair, ":") {
+			return "", "", fmt.Errorf("%q: must be a key: value pair", pair)
+		}
+		colon = len(pair) - 1
+	}
+	key := strings.TrimSpace(pair[:colon])
+	value, err := parseYAMLScalar(strings.TrimSpace(pair[colon+1:]))
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", key, err)
+	}
+	return key, value, nil
+}
+
+// parseYAMLScalar parses a single-line YAML scalar: double-quoted, single-quoted or plain, followed by an
+// optional comment.
+func parseYAMLScalar(value string) (string, error) {
+	switch {
+	case value == "" || strings.HasPrefix(value, "#"):
+		return "", nil
+	case value[0] == '"':
+		for end := 1; end < len(value); end++ {
+			switch value[end] {
+			case '\\':
+				end++
+			case '"':
+				if !isYAMLComment(value[end+1:]) {
+					return "", fmt.Errorf("unexpected %q after the quoted string", value[end+1:])
+				}
+				unquoted, err := strconv.Unquote(value[:end+1])
+				if err != nil {
+					return "", fmt.Errorf("invalid double-quoted string %s", value[:end+1])
+				}
+				return unquoted, nil
+			}
+		}
+		return "", errors.New("unterminated double-quoted string")
+	case value[0] == '\'':
+		var unquoted strings.Builder
+		for end := 1; end < len(value); end++ {
+			if value[end] != '\'' {
+				unquoted.WriteByte(value[end])
+				continue
+			}
+			if end+1 < len(value) && value[end+1] == '\'' {
+				unquoted.WriteByte('\'')
+				end++
+				continue
+			}
+			if !isYAMLComment(value[end+1:]) {
+				return "", fmt.Errorf("unexpected %q after the quoted string", value[end+1:])
+			}
+			return unquoted.String(), nil
+		}
+		return "", errors.New("unterminated single-quoted string")
+	case strings.IndexByte("[]{}&*!|>%@`,", value[0]) >= 0:
+		return "", fmt.Errorf("plain scalar %q: must be quoted", value)
+	default:
+		if comment := strings.Index(value, " #"); comment >= 0 {
+			value = value[:comment]
+		}
+		return strings.TrimSpace(value), nil
+	}
+}
+
+// isYAMLComment reports whether rest, following a value, is empty or a comment.
+func isYAMLComment(rest string) bool {
+	rest = strings.TrimLeft(rest, " ")
+	return rest == "" || strings.HasPrefix(rest, "#")
+}
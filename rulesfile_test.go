@@ -0,0 +1,161 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseRulesYAML(t *testing.T) {
+	tests := []struct {
+		desc       string
+		data       string
+		expEntries []rulesFileEntry
+		expErr     string
+	}{
+		{
+			desc: "scalars",
+			data: "---\n# Redactions.\n- regex: plain value # comment\n  replacement: \"double \\\"quoted\\\"\\n\"\n\n-\n  regex: 'single ''quoted'' # not a comment'\n  replacement:\n- regex: \"[0-9]+\"\n  replacement: ''\n",
+			expEntries: []rulesFileEntry{
+				{rewrite: Rewrite{Regex: "plain value", Replacement: "double \"quoted\"\n"}, line: 3},
+				{rewrite: Rewrite{Regex: "single 'quoted' # not a comment"}, line: 6},
+				{rewrite: Rewrite{Regex: "[0-9]+"}, line: 9},
+			},
+		},
+		{
+			desc:       "remove",
+			data:       "- regex: foo\n  remove: true\n",
+			expEntries: []rulesFileEntry{{rewrite: Rewrite{Regex: "foo", Remove: true}, line: 1}},
+		},
+		{
+			desc:       "description",
+			data:       "- regex: '\\bACME-\\d{4}\\b'\n  replacement: ACME-XXXX\n  description: mask legacy order IDs\n",
+			expEntries: []rulesFileEntry{{rewrite: Rewrite{Regex: `\bACME-\d{4}\b`, Replacement: "ACME-XXXX", Description: "mask legacy order IDs"}, line: 1}},
+		},
+		{desc: "invalid remove", data: "- regex: foo\n  remove: yes\n", expErr: `line 2: invalid remove "yes": must be true or false`},
+		{desc: "empty", data: "# Nothing yet.\n"},
+		{desc: "not a list", data: "regex: foo\n", expErr: "line 1: must be a list of rewrites"},
+		{desc: "unknown key", data: "- regex: foo\n  replacment: bar\n", expErr: `line 2: unknown key "replacment": must be regex, replacement, remove or description`},
+		{desc: "duplicate key", data: "- regex: foo\n  regex: bar\n", expErr: `line 2: duplicate key "regex"`},
+		{desc: "unquoted flow sequence", data: "- regex: [0-9]+\n", expErr: `line 1: regex: plain scalar "[0-9]+": must be quoted`},
+		{desc: "unterminated string", data: "- regex: \"foo\n", expErr: "line 1: regex: unterminated double-quoted string"},
+		{desc: "text after string", data: "- regex: 'foo' bar\n", expErr: `line 1: regex: unexpected " bar" after the quoted string`},
+		{desc: "not a pair", data: "- regex\n", expErr: `line 1: "regex": must be a key: value pair`},
+		{desc: "tabs", data: "- regex: foo\n\treplacement: bar\n", expErr: "line 2: tabs are not allowed for indentation"},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			entries, err := parseRulesYAML([]byte(test.data))
+			if test.expErr != "" {
+				if err == nil || err.Error() != test.expErr {
+					t.Errorf("got error %v, want %q", err, test.expErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+			if !reflect.DeepEqual(entries, test.expEntries) {
+				t.Errorf("got entries %+v, want %+v", entries, test.expEntries)
+			}
+		})
+	}
+}
+
+func TestParseRulesJSON(t *testing.T) {
+	tests := []struct {
+		desc       string
+		data       string
+		expEntries []rulesFileEntry
+		expErr     string
+	}{
+		{
+			desc: "list",
+			data: "[\n  {\"regex\": \"foo\", \"replacement\": \"bar\"},\n\n  {\"regex\": \"baz\"}\n]\n",
+			expEntries: []rulesFileEntry{
+				{rewrite: Rewrite{Regex: "foo", Replacement: "bar"}, line: 2},
+				{rewrite: Rewrite{Regex: "baz"}, line: 4},
+			},
+		},
+		{desc: "not a list", data: `{"regex": "foo"}`, expErr: "line 1: must be a list of rewrites"},
+		{desc: "unknown field", data: "[\n  {\"regex\": \"foo\"},\n  {\"regex\": \"foo\", \"replacment\": \"bar\"}\n]", expErr: `line 3: rewrites[1]: json: unknown field "replacment"`},
+		{desc: "syntax error", data: "[\n  {\"regex\": \"foo\"},\n  {\"regex\" \"foo\"}\n]", expErr: "line 3: rewrites[1]: invalid character '\"' after object key"},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			entries, err := parseRulesJSON([]byte(test.data))
+			if test.expErr != "" {
+				if err == nil || err.Error() != test.expErr {
+					t.Errorf("got error %v, want %q", err, test.expErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+			if !reflect.DeepEqual(entries, test.expEntries) {
+				t.Errorf("got entries %+v, want %+v", entries, test.expEntries)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_rulesFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"rules.yml":  "- regex: foo\n  replacement: bar\n",
+		"rules.json": `[{"regex": "bar", "replacement": "baz"}]`,
+		"broken.yml": "- regex: foo\n- regex: ba(r\n",
+		"rules.txt":  "",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		desc      string
+		response  Response
+		expBody   string
+		expErrFmt string
+	}{
+		{desc: "YAML", response: Response{Status: "200", RulesFile: "rules.yml"}, expBody: "bar bar"},
+		{desc: "after the rewrites", response: Response{Status: "200", RulesFile: "rules.json", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}}, expBody: "baz baz"},
+		{desc: "invalid rule", response: Response{Status: "200", RulesFile: "broken.yml"}, expErrFmt: "responses[0]: rulesFile %q: line 2: rewrites[1].regex: error compiling regex \"ba(r\" with the regexp engine: error parsing regexp: missing closing ): `ba(r`"},
+		{desc: "unsupported extension", response: Response{Status: "200", RulesFile: "rules.txt"}, expErrFmt: "responses[0]: rulesFile %q: must be a .json, .yml or .yaml file"},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			path := filepath.Join(dir, test.response.RulesFile)
+			test.response.RulesFile = path
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				_, _ = rw.Write([]byte("foo bar"))
+			}), &Config{Responses: []Response{test.response}}, "rewriteBody")
+			if test.expErrFmt != "" {
+				if expErr := fmt.Sprintf(test.expErrFmt, path); err == nil || err.Error() != expErr {
+					t.Errorf("got error %v, want %q", err, expErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+
+	if _, err := New(context.Background(), http.NotFoundHandler(), &Config{Responses: []Response{{Status: "200", RulesFile: filepath.Join(dir, "missing.yml")}}}, "rewriteBody"); err == nil {
+		t.Error("expected an error for a missing rulesFile")
+	}
+}
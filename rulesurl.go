@@ -0,0 +1,285 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rulesURLTimeout bounds each fetch of a rulesURL.
+const rulesURLTimeout = 10 * time.Second
+
+// maxRulesURLBytes is the maximum size of the content of a rulesURL.
+const maxRulesURLBytes = 10 << 20
+
+// rulesURLContent is the content of a rulesURL, with the extension telling its format and its ETag.
+type rulesURLContent struct {
+	data []byte
+	ext  string
+	etag string
+}
+
+// rulesURLContents are the contents of the rulesURLs, by URL.
+type rulesURLContents map[string]rulesURLContent
+
+// rulesFetcher fetches the rulesURLs of the response blocks, keeping the last content of each from which the
+// response blocks have been parsed.
+type rulesFetcher struct {
+	client     *http.Client
+	authHeader string
+	// urls are the rulesURLs, in the order of the response blocks, and indexes the index of the first
+	// response block of each.
+	urls    []string
+	indexes map[string]int
+
+	mu sync.Mutex
+	// parsed are the contents the response blocks in use have been parsed from.
+	parsed rulesURLContents
+	// errs are the last errors fetching the rulesURLs, and reloadErr the last error reloading the rules,
+	// logged once until they change.
+	errs      map[string]string
+	reloadErr string
+}
+
+// newRulesFetcher creates the fetcher of the rulesURLs of config, nil if no response block has one.
+func newRulesFetcher(config *Config) (*rulesFetcher, error) {
+	f := &rulesFetcher{
+		client:     &http.Client{Timeout: rulesURLTimeout},
+		authHeader: config.RulesAuthHeader,
+		indexes:    make(map[string]int),
+		parsed:     make(rulesURLContents),
+		errs:       make(map[string]string),
+	}
+	for i, response := range config.Responses {
+		if response.RulesURL == "" {
+			continue
+		}
+		if _, ok := f.indexes[response.RulesURL]; ok {
+			continue
+		}
+		u, err := url.Parse(response.RulesURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("responses[%d]: invalid rulesURL %q: must be an http or https URL", i, response.RulesURL)
+		}
+		f.urls = append(f.urls, response.RulesURL)
+		f.indexes[response.RulesURL] = i
+	}
+
+	if len(f.urls) == 0 {
+		if config.RulesRefreshInterval != "" {
+			return nil, errors.New("rulesRefreshInterval: no response block has a rulesURL")
+		}
+		if config.RulesAuthHeader != "" {
+			return nil, errors.New("rulesAuthHeader: no response block has a rulesURL")
+		}
+		return nil, nil
+	}
+	return f, nil
+}
+
+// fetchAll fetches all the rulesURLs, when the middleware is created.
+func (f *rulesFetcher) fetchAll(ctx context.Context) error {
+	for _, rawURL := range f.urls {
+		content, _, err := f.fetch(ctx, rawURL)
+		if err != nil {
+			return fmt.Errorf("responses[%d]: %w", f.indexes[rawURL], err)
+		}
+		f.parsed[rawURL] = content
+	}
+	return nil
+}
+
+// fetch fetches a rulesURL, and reports whether its content changed since the one the response blocks have
+// been parsed from. The request is conditional once the rulesURL has been fetched with an ETag.
+func (f *rulesFetcher) fetch(ctx context.Context, rawURL string) (rulesURLContent, bool, error) {
+	f.mu.Lock()
+	current, fetched := f.parsed[rawURL]
+	f.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return rulesURLContent{}, false, fmt.Errorf("rulesURL: %w", err)
+	}
+	req.Header.Set("Accept", "application/json, application/yaml;q=0.9")
+	if f.authHeader != "" {
+		req.Header.Set("Authorization", f.authHeader)
+	}
+	if current.etag != "" {
+		req.Header.Set("If-None-Match", current.etag)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return rulesURLContent{}, false, fmt.Errorf("rulesURL: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && current.etag != "":
+		return current, false, nil
+	case resp.StatusCode != http.StatusOK:
+		return rulesURLContent{}, false, fmt.Errorf("rulesURL %q: unexpected status %d", rawURL, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRulesURLBytes+1))
+	if err != nil {
+		return rulesURLContent{}, false, fmt.Errorf("rulesURL %q: %w", rawURL, err)
+	}
+	if len(data) > maxRulesURLBytes {
+		return rulesURLContent{}, false, fmt.Errorf("rulesURL %q: content exceeds %d bytes", rawURL, maxRulesURLBytes)
+	}
+	ext, err := rulesURLFormat(rawURL, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return rulesURLContent{}, false, fmt.Errorf("rulesURL %q: %w", rawURL, err)
+	}
+
+	content := rulesURLContent{data: data, ext: ext, etag: resp.Header.Get("ETag")}
+	changed := !fetched || ext != current.ext || !bytes.Equal(data, current.data)
+	return content, changed, nil
+}
+
+// rulesURLFormat returns the extension telling the format of the content of a rulesURL: the one of its
+// Content-Type if it is JSON or YAML, or else the one of its path.
+func rulesURLFormat(rawURL, contentType string) (string, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return ".json", nil
+	case mediaType == "application/yaml" || mediaType == "application/x-yaml" || mediaType == "text/yaml" || mediaType == "text/x-yaml":
+		return ".yml", nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	switch ext := strings.ToLower(path.Ext(u.Path)); ext {
+	case ".json", ".yml", ".yaml":
+		return ext, nil
+	}
+	return "", fmt.Errorf("content type %q: must be JSON or YAML, or the path end with .json, .yml or .yaml", contentType)
+}
+
+// contents returns the contents of the rulesURLs the response blocks have been parsed from, none if f is nil.
+func (f *rulesFetcher) contents() rulesURLContents {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	contents := make(rulesURLContents, len(f.parsed))
+	for rawURL, content := range f.parsed {
+		contents[rawURL] = content
+	}
+	return contents
+}
+
+// commit records the contents the response blocks in use have been parsed from.
+func (f *rulesFetcher) commit(contents rulesURLContents) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for rawURL, content := range contents {
+		f.parsed[rawURL] = content
+	}
+}
+
+// reportError records the last error fetching a rulesURL, nil if it has been fetched, and reports whether it
+// must be logged, i.e. whether it changed.
+func (f *rulesFetcher) reportError(rawURL string, err error) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.errs, rawURL)
+		return false
+	}
+	if f.errs[rawURL] == err.Error() {
+		return false
+	}
+	f.errs[rawURL] = err.Error()
+	return true
+}
+
+// rewrites compiles the rewrites of the content of a rulesURL, like those of a rulesFile.
+func (c rulesURLContents) rewrites(rawURL string, allowEmptyReplacement bool, limits ruleLimits) ([]parsedRewrite, error) {
+	content, ok := c[rawURL]
+	if !ok {
+		return nil, fmt.Errorf("rulesURL %q: not fetched", rawURL)
+	}
+	return compileRules(fmt.Sprintf("rulesURL %q", rawURL), content.data, content.ext, allowEmptyReplacement, limits)
+}
+
+// refreshRulesURLs fetches the rulesURLs every interval until ctx is done, and reloads the rules of the
+// response blocks when one of them changed. The requests being served keep the rules they started with, and
+// the cached bodies, rewritten by the previous rules, are dropped. When a rulesURL can't be fetched, or the
+// rules can't be reloaded, the previous ones are kept.
+func (r *responsebodyrewrite) refreshRulesURLs(ctx context.Context, reloader *rulesReloader, interval time.Duration) {
+	f := reloader.fetcher
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed := rulesURLContents{}
+		for _, rawURL := range f.urls {
+			content, contentChanged, err := f.fetch(ctx, rawURL)
+			if ctx.Err() != nil {
+				return
+			}
+			if f.reportError(rawURL, err) {
+				r.errorf("%s: can't fetch %v, keeping the current rules", r.name, err)
+			}
+			switch {
+			case err != nil:
+			case contentChanged:
+				changed[rawURL] = content
+			default:
+				// The same content may come with another ETag.
+				f.commit(rulesURLContents{rawURL: content})
+			}
+		}
+		if len(changed) == 0 {
+			continue
+		}
+
+		// The rulesFiles may be reloaded meanwhile, the latest rules of both must be stored last.
+		r.reloadMu.Lock()
+		contents := f.contents()
+		for rawURL, content := range changed {
+			contents[rawURL] = content
+		}
+		responses, issues, err := reloader.reload(contents)
+		if err == nil {
+			f.commit(changed)
+			r.storeResponses(responses)
+		}
+		r.reloadMu.Unlock()
+
+		if err != nil {
+			f.mu.Lock()
+			report := err.Error() != f.reloadErr
+			f.reloadErr = err.Error()
+			f.mu.Unlock()
+			if report {
+				r.errorf("%s: can't reload the rulesURLs, keeping the current rules: %v", r.name, err)
+			}
+			continue
+		}
+		f.mu.Lock()
+		f.reloadErr = ""
+		f.mu.Unlock()
+		for _, issue := range issues {
+			r.warnf("%s: %s", r.name, issue)
+		}
+		r.infof("%s: rulesURLs reloaded", r.name)
+	}
+}
@@ -0,0 +1,210 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rulesServer serves rules with an ETag, answering the conditional requests with 304 Not Modified.
+type rulesServer struct {
+	mu          sync.Mutex
+	status      int
+	contentType string
+	rules       string
+	version     int
+	// notModified is the number of 304 responses sent, and auth the last Authorization header received.
+	notModified int
+	auth        string
+}
+
+func (s *rulesServer) set(status int, rules string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+	s.rules = rules
+	s.version++
+}
+
+func (s *rulesServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auth = req.Header.Get("Authorization")
+	etag := `"` + strconv.Itoa(s.version) + `"`
+	if s.status != http.StatusOK {
+		rw.WriteHeader(s.status)
+		return
+	}
+	if req.Header.Get("If-None-Match") == etag {
+		s.notModified++
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	rw.Header().Set("ETag", etag)
+	rw.Header().Set("Content-Type", s.contentType)
+	_, _ = rw.Write([]byte(s.rules))
+}
+
+func (s *rulesServer) stats() (int, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.notModified, s.auth
+}
+
+func TestServeHTTP_rulesURL(t *testing.T) {
+	rules := &rulesServer{status: http.StatusOK, contentType: "application/json", rules: `[{"regex": "foo", "replacement": "bar"}]`}
+	server := httptest.NewServer(rules)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var logs syncBuffer
+	handler, err := NewMiddleware(
+		http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			_, _ = rw.Write([]byte("foo"))
+		}),
+		WithContext(ctx),
+		WithName("rewriteBody"),
+		WithConfig(&Config{RulesRefreshInterval: "1ms", RulesAuthHeader: "Bearer s3cr3t"}),
+		WithResponses(Response{Status: "200", RulesURL: server.URL + "/rules"}),
+		WithLogger(log.New(&logs, "", 0)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := func() string {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		return recorder.Body.String()
+	}
+	waitFor := func(desc string, done func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); !done(); time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s, got logs:\n%s", desc, logs.String())
+			}
+		}
+	}
+
+	if got := body(); got != "bar" {
+		t.Fatalf("got body %q, want %q", got, "bar")
+	}
+	waitFor("a conditional request", func() bool {
+		notModified, _ := rules.stats()
+		return notModified > 0
+	})
+	if _, auth := rules.stats(); auth != "Bearer s3cr3t" {
+		t.Errorf("got Authorization header %q, want %q", auth, "Bearer s3cr3t")
+	}
+
+	rules.set(http.StatusOK, `[{"regex": "foo", "replacement": "baz"}]`)
+	waitFor("the new rules", func() bool { return body() == "baz" })
+
+	rules.set(http.StatusOK, `[{"regex": "fo(o", "replacement": "qux"}]`)
+	waitFor("the reload error", func() bool { return strings.Contains(logs.String(), "can't reload the rulesURLs") })
+	if got := body(); got != "baz" {
+		t.Errorf("got body %q after invalid rules, want the previous rules to be kept", got)
+	}
+
+	rules.set(http.StatusOK, `[]`)
+	waitFor("the empty rules error", func() bool { return strings.Contains(logs.String(), "rewrites: must not be empty") })
+	if got := body(); got != "baz" {
+		t.Errorf("got body %q after an empty list of rules, want the previous rules to be kept", got)
+	}
+
+	rules.set(http.StatusServiceUnavailable, "")
+	waitFor("the fetch error", func() bool { return strings.Contains(logs.String(), "can't fetch rulesURL") })
+	if got := body(); got != "baz" {
+		t.Errorf("got body %q after a fetch error, want the previous rules to be kept", got)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := strings.Count(logs.String(), "can't fetch rulesURL"); n != 1 {
+		t.Errorf("got the fetch error logged %d times, want once", n)
+	}
+	if n := strings.Count(logs.String(), "can't reload the rulesURLs"); n != 2 {
+		t.Errorf("got the reload errors logged %d times, want twice", n)
+	}
+}
+
+func TestNew_rulesURLErrors(t *testing.T) {
+	rules := &rulesServer{status: http.StatusOK, contentType: "text/plain", rules: "- regex: foo\n  replacement: bar\n- regex: ba(r\n  replacement: baz\n"}
+	server := httptest.NewServer(rules)
+	defer server.Close()
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+
+	tests := []struct {
+		desc   string
+		config *Config
+		expErr string
+	}{
+		{
+			desc:   "invalid rules",
+			config: &Config{Responses: []Response{{Status: "200", RulesURL: server.URL + "/rules.yml"}}},
+			expErr: "responses[0]: rulesURL \"" + server.URL + "/rules.yml\": line 3: rewrites[1].regex: error compiling regex \"ba(r\" with the regexp engine: error parsing regexp: missing closing ): `ba(r`",
+		},
+		{
+			desc:   "unknown format",
+			config: &Config{Responses: []Response{{Status: "200", RulesURL: server.URL + "/rules"}}},
+			expErr: "responses[0]: rulesURL \"" + server.URL + "/rules\": content type \"text/plain\": must be JSON or YAML, or the path end with .json, .yml or .yaml",
+		},
+		{
+			desc:   "not found",
+			config: &Config{Responses: []Response{{Status: "200", Rewrites: []Rewrite{{Regex: "a", Replacement: "b"}}}, {Status: "200", RulesURL: missing.URL + "/rules.json"}}},
+			expErr: "responses[1]: rulesURL \"" + missing.URL + "/rules.json\": unexpected status 404",
+		},
+		{
+			desc:   "invalid URL",
+			config: &Config{Responses: []Response{{Status: "200", RulesURL: "rules.yml"}}},
+			expErr: `responses[0]: invalid rulesURL "rules.yml": must be an http or https URL`,
+		},
+		{
+			desc:   "invalid interval",
+			config: &Config{RulesRefreshInterval: "-1m", Responses: []Response{{Status: "200", RulesURL: server.URL + "/rules.yml"}}},
+			expErr: `invalid rulesRefreshInterval "-1m": must be a positive duration`,
+		},
+		{
+			desc:   "interval without rulesURL",
+			config: &Config{RulesRefreshInterval: "1m", Responses: []Response{{Status: "200", Rewrites: []Rewrite{{Regex: "a", Replacement: "b"}}}}},
+			expErr: "rulesRefreshInterval: no response block has a rulesURL",
+		},
+		{
+			desc:   "auth header without rulesURL",
+			config: &Config{RulesAuthHeader: "Bearer s3cr3t", Responses: []Response{{Status: "200", Rewrites: []Rewrite{{Regex: "a", Replacement: "b"}}}}},
+			expErr: "rulesAuthHeader: no response block has a rulesURL",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if _, err := New(context.Background(), http.NotFoundHandler(), test.config, "rewriteBody"); err == nil || err.Error() != test.expErr {
+				t.Errorf("got error %v, want %q", err, test.expErr)
+			}
+		})
+	}
+}
+
+func TestRulesURLFormat(t *testing.T) {
+	tests := []struct {
+		url         string
+		contentType string
+		expExt      string
+	}{
+		{url: "https://rules.example.com/rules", contentType: "application/json; charset=utf-8", expExt: ".json"},
+		{url: "https://rules.example.com/rules", contentType: "application/vnd.rules+json", expExt: ".json"},
+		{url: "https://rules.example.com/rules.json", contentType: "application/x-yaml", expExt: ".yml"},
+		{url: "https://rules.example.com/rules.YAML?v=2", contentType: "application/octet-stream", expExt: ".yaml"},
+		{url: "https://rules.example.com/rules.txt", contentType: "text/plain", expExt: ""},
+	}
+	for _, test := range tests {
+		ext, err := rulesURLFormat(test.url, test.contentType)
+		if ext != test.expExt || (err == nil) != (test.expExt != "") {
+			t.Errorf("got extension %q and error %v for %s with content type %q, want %q", ext, err, test.url, test.contentType, test.expExt)
+		}
+	}
+}
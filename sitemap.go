@@ -0,0 +1,239 @@
+package traefik_responsebodyrewrite
+
+import (
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// The namespaces of the sitemaps and of their alternate links.
+const (
+	sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+	xhtmlNamespace   = "http://www.w3.org/1999/xhtml"
+)
+
+// hrefURLOrigin matches the origin of the URL of the href attribute of a start tag.
+var hrefURLOrigin = xmlAttributeURLOrigin("href")
+
+// defaultSitemapPaths are the paths of the sitemaps when none is configured.
+var defaultSitemapPaths = []string{"/sitemap*.xml"}
+
+// Sitemap rewrites the origin of the URLs of the sitemaps and of the sitemap index files.
+type Sitemap struct {
+	// Paths are the patterns of the request paths of the sitemaps, as path.Match, "/sitemap*.xml" if empty.
+	Paths []string `json:"paths,omitempty"`
+	// Origin is the scheme and the host the URLs are rewritten to, e.g. "https://www.example.com". If empty,
+	// it is the public origin of the request, from its X-Forwarded-Proto and X-Forwarded-Host headers, or
+	// else from its own scheme and Host.
+	Origin string `json:"origin,omitempty"`
+}
+
+// parsedSitemap is a parsed Sitemap.
+type parsedSitemap struct {
+	paths []string
+	// origin is the configured origin, empty if it is derived from the request.
+	origin string
+}
+
+// parseSitemap parses the sitemap option of a response block, nil if it has none.
+func parseSitemap(config *Sitemap) (*parsedSitemap, error) {
+	if config == nil {
+		return nil, nil
+	}
+	paths := config.Paths
+	if len(paths) == 0 {
+		paths = defaultSitemapPaths
+	}
+	if err := checkPathPatterns(paths); err != nil {
+		return nil, err
+	}
+	origin, err := parseOrigin(config.Origin)
+	if err != nil {
+		return nil, err
+	}
+	return &parsedSitemap{paths: paths, origin: origin}, nil
+}
+
+// parseOrigin parses the origin the URLs are rewritten to, empty if it is derived from the request.
+func parseOrigin(origin string) (string, error) {
+	if origin == "" {
+		return "", nil
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" || parsed.User != nil || (parsed.Path != "" && parsed.Path != "/") || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return "", fmt.Errorf("invalid origin %q: must be a scheme and a host, e.g. https://www.example.com", origin)
+	}
+	return strings.TrimSuffix(origin, "/"), nil
+}
+
+// isSitemapResponse reports whether the given Content-Type is the one of a sitemap.
+func isSitemapResponse(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/xml" || mediaType == "text/xml")
+}
+
+// matchesPath reports whether the request path is the one of a sitemap.
+func (s *parsedSitemap) matchesPath(requestPath string) bool {
+	return matchesPathPattern(s.paths, requestPath)
+}
+
+// checkPathPatterns checks the patterns of request paths of a response block, as path.Match.
+func checkPathPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil || !strings.HasPrefix(pattern, "/") {
+			return fmt.Errorf("invalid path %q: must be a pattern starting with /", pattern)
+		}
+	}
+	return nil
+}
+
+// matchesPathPattern reports whether the request path matches one of the patterns.
+func matchesPathPattern(patterns []string, requestPath string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, requestPath); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// publicOrigin returns the origin the URLs of the sitemap of req are rewritten to.
+func (s *parsedSitemap) publicOrigin(req *http.Request) string {
+	if s.origin != "" {
+		return s.origin
+	}
+	return requestOrigin(req)
+}
+
+// requestOrigin returns the public origin of req, from its X-Forwarded-Proto and X-Forwarded-Host headers, or
+// else from its own scheme and Host. Only the first value of the forwarded headers sent several times, or
+// listing several proxies, is considered, and the values which are not an http scheme or a host are ignored.
+func requestOrigin(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	if proto := strings.ToLower(firstForwardedValue(req.Header.Get("X-Forwarded-Proto"))); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	host := req.Host
+	if forwardedHost := firstForwardedValue(req.Header.Get("X-Forwarded-Host")); forwardedHost != "" && isHost(forwardedHost) {
+		host = forwardedHost
+	}
+	return scheme + "://" + host
+}
+
+// httpURLPath returns what follows the origin of an absolute http or https URL, its path, query and fragment,
+// and false if the URL is not one.
+func httpURLPath(url string) (string, bool) {
+	lowerURL := strings.ToLower(url)
+	if !strings.HasPrefix(lowerURL, "http://") && !strings.HasPrefix(lowerURL, "https://") {
+		return "", false
+	}
+	hostStart := strings.Index(url, "://") + 3
+	if i := strings.IndexAny(url[hostStart:], "/?#"); i >= 0 {
+		return url[hostStart+i:], true
+	}
+	return "", true
+}
+
+// isHost reports whether value is made of the characters of a host and an optional port only.
+func isHost(value string) bool {
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '.' || c == '-' || c == '_' || c == ':' || c == '[' || c == ']':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// firstForwardedValue returns the first of the comma-separated values of a forwarded header.
+func firstForwardedValue(value string) string {
+	if i := strings.IndexByte(value, ','); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
+
+// String describes the sitemap in the debug dump.
+func (s *parsedSitemap) String() string {
+	return fmt.Sprintf("paths %s rewritten to %s", strings.Join(s.paths, ", "), describeOrigin(s.origin))
+}
+
+// describeOrigin describes the origin the URLs of a mode are rewritten to in the debug dump.
+func describeOrigin(origin string) string {
+	if origin == "" {
+		return "the public origin of the request"
+	}
+	return origin
+}
+
+// isSitemapURL reports whether the element at the end of the path holds a URL of a sitemap: the loc of a
+// url of a sitemap or of a sitemap of an index file, with or without the sitemap namespace, or an alternate
+// link of the xhtml namespace.
+func isSitemapURL(path []xml.Name) bool {
+	n := len(path)
+	switch {
+	case n >= 1 && path[n-1] == xml.Name{Space: xhtmlNamespace, Local: "link"}:
+		return true
+	case n >= 2 && path[n-1].Local == "loc" && (path[n-1].Space == sitemapNamespace || path[n-1].Space == ""):
+		return path[n-2] == xml.Name{Space: path[n-1].Space, Local: "url"} ||
+			path[n-2] == xml.Name{Space: path[n-1].Space, Local: "sitemap"}
+	}
+	return false
+}
+
+func (s *parsedSitemap) option() string { return "sitemap" }
+
+// changesBody implements the transformer interface.
+func (s *parsedSitemap) changesBody() bool { return true }
+
+// matchesContentType implements the contentTypeMatcher interface.
+func (s *parsedSitemap) matchesContentType(contentType string) bool {
+	return isSitemapResponse(contentType)
+}
+
+// cacheKey implements the cacheKeyer interface: the rewritten URLs depend on the public origin of req.
+func (s *parsedSitemap) cacheKey(req *http.Request) string {
+	return s.publicOrigin(req)
+}
+
+// transform implements the bodyTransformer interface, as rewriteBody for a sitemap: once the rewrites are
+// applied, the scheme and the host of the absolute URLs of its locs and of the href of its alternate links are
+// replaced with the public origin, the rest of the body, its XML declaration and namespaces included, being
+// left byte for byte. A body which can't be parsed fails the sitemap stage.
+func (s *parsedSitemap) transform(rw *responseWriter, body []byte) ([]byte, bool, bool, []int, error) {
+	r, response, req := rw.middleware, rw.response, rw.request
+	rewritten, modified, complete, replaced := body, false, true, []int(nil)
+	if len(response.rewrites) > 0 {
+		var err error
+		if rewritten, modified, complete, replaced, err = r.rewriteBody(response, body, req); err != nil {
+			return body, false, true, nil, err
+		}
+	}
+
+	elements, err := xmlElements(rewritten, isSitemapURL)
+	if err != nil {
+		return body, false, true, nil, &stageError{stage: stageSitemap, err: fmt.Errorf("unable to parse the sitemap: %w", err)}
+	}
+
+	result, changed := rewriteURLOrigins(rewritten, elements, s.publicOrigin(req), sitemapURLOrigins)
+	return result, modified || changed, complete, replaced, nil
+}
+
+// sitemapURLOrigins returns the regex matching the origin of the URL of a sitemap element: that of the href
+// of an alternate link, or of the content of a loc.
+func sitemapURLOrigins(element xmlElement) *regexp.Regexp {
+	if element.tag.Name.Space == xhtmlNamespace {
+		return hrefURLOrigin
+	}
+	return xmlURLOrigin
+}
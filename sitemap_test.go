@@ -0,0 +1,209 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseSitemap(t *testing.T) {
+	tests := []struct {
+		desc   string
+		config Sitemap
+		expErr string
+	}{
+		{
+			desc: "defaults",
+		},
+		{
+			desc:   "paths and origin",
+			config: Sitemap{Paths: []string{"/sitemaps/*.xml"}, Origin: "https://www.example.com/"},
+		},
+		{
+			desc:   "relative path",
+			config: Sitemap{Paths: []string{"sitemap.xml"}},
+			expErr: `invalid path "sitemap.xml": must be a pattern starting with /`,
+		},
+		{
+			desc:   "malformed path",
+			config: Sitemap{Paths: []string{"/sitemap[.xml"}},
+			expErr: `invalid path "/sitemap[.xml": must be a pattern starting with /`,
+		},
+		{
+			desc:   "origin without scheme",
+			config: Sitemap{Origin: "www.example.com"},
+			expErr: `invalid origin "www.example.com": must be a scheme and a host, e.g. https://www.example.com`,
+		},
+		{
+			desc:   "origin with path",
+			config: Sitemap{Origin: "https://www.example.com/shop"},
+			expErr: `invalid origin "https://www.example.com/shop": must be a scheme and a host, e.g. https://www.example.com`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := test.config
+			_, err := parseSitemap(&config)
+			if test.expErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if test.expErr != "" && (err == nil || err.Error() != test.expErr) {
+				t.Errorf("got error %v, want %q", err, test.expErr)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_sitemap(t *testing.T) {
+	const sitemap = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:xhtml="http://www.w3.org/1999/xhtml">
+  <url>
+    <loc>http://app-3.internal.local:8080/products/1?page=2</loc>
+    <xhtml:link rel="alternate" hreflang="fr" href="http://app-3.internal.local:8080/fr/products/1"/>
+    <lastmod>2024-01-01</lastmod>
+  </url>
+  <url>
+    <loc> https://www.example.com/about </loc>
+  </url>
+  <url>
+    <loc>/relative</loc>
+  </url>
+</urlset>`
+	const index = `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>http://app-3.internal.local:8080/sitemap-products.xml</loc></sitemap>
+</sitemapindex>`
+
+	tests := []struct {
+		desc        string
+		config      Sitemap
+		rewrites    []Rewrite
+		path        string
+		header      http.Header
+		contentType string
+		body        string
+		expBody     string
+	}{
+		{
+			desc:        "request origin",
+			path:        "/sitemap.xml",
+			contentType: "application/xml; charset=utf-8",
+			body:        sitemap,
+			expBody: strings.NewReplacer(
+				"http://app-3.internal.local:8080/products", "http://example.com/products",
+				"http://app-3.internal.local:8080/fr", "http://example.com/fr",
+				"https://www.example.com/about", "http://example.com/about",
+			).Replace(sitemap),
+		},
+		{
+			desc:        "forwarded origin",
+			path:        "/sitemap-products.xml",
+			header:      http.Header{"X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"www.example.com, proxy.internal.local"}},
+			contentType: "text/xml",
+			body:        sitemap,
+			expBody:     strings.ReplaceAll(sitemap, "http://app-3.internal.local:8080", "https://www.example.com"),
+		},
+		{
+			desc:        "configured origin",
+			config:      Sitemap{Origin: "https://shop.example.com"},
+			path:        "/sitemap.xml",
+			contentType: "text/xml",
+			body:        index,
+			expBody:     strings.Replace(index, "http://app-3.internal.local:8080", "https://shop.example.com", 1),
+		},
+		{
+			desc:        "rewrites of the URLs",
+			config:      Sitemap{Origin: "https://shop.example.com"},
+			rewrites:    []Rewrite{{Regex: "sitemap-products", Replacement: "sitemap-p"}},
+			path:        "/sitemap.xml",
+			contentType: "text/xml",
+			body:        index,
+			expBody:     strings.Replace(index, "http://app-3.internal.local:8080/sitemap-products", "https://shop.example.com/sitemap-p", 1),
+		},
+		{
+			desc:        "no namespace",
+			config:      Sitemap{Origin: "https://shop.example.com"},
+			path:        "/sitemap.xml",
+			contentType: "text/xml",
+			body:        `<urlset><url><loc>http://internal.local/a</loc></url><loc>http://internal.local/b</loc></urlset>`,
+			expBody:     `<urlset><url><loc>https://shop.example.com/a</loc></url><loc>http://internal.local/b</loc></urlset>`,
+		},
+		{
+			desc:        "CDATA section",
+			config:      Sitemap{Origin: "https://shop.example.com"},
+			path:        "/sitemap.xml",
+			contentType: "text/xml",
+			body:        `<urlset><url><loc><![CDATA[http://[fd00::1]:8080/a?b&c]]></loc></url></urlset>`,
+			expBody:     `<urlset><url><loc><![CDATA[https://shop.example.com/a?b&c]]></loc></url></urlset>`,
+		},
+		{
+			desc:        "other path",
+			path:        "/feeds/products.xml",
+			contentType: "application/xml",
+			body:        index,
+			expBody:     index,
+		},
+		{
+			desc:        "configured paths",
+			config:      Sitemap{Paths: []string{"/feeds/*.xml"}, Origin: "https://shop.example.com"},
+			path:        "/feeds/products.xml",
+			contentType: "application/xml",
+			body:        index,
+			expBody:     strings.Replace(index, "http://app-3.internal.local:8080", "https://shop.example.com", 1),
+		},
+		{
+			desc:        "other content type",
+			path:        "/sitemap.xml",
+			contentType: "text/plain",
+			body:        "<loc>http://internal.local/</loc>",
+			expBody:     "<loc>http://internal.local/</loc>",
+		},
+		{
+			desc:        "malformed body",
+			path:        "/sitemap.xml",
+			contentType: "application/xml",
+			body:        "<urlset><url><loc>http://internal.local/</url>",
+			expBody:     "<urlset><url><loc>http://internal.local/</url>",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := test.config
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", test.contentType)
+				_, _ = rw.Write([]byte(test.body))
+			}), &Config{Responses: []Response{{Status: "200", Sitemap: &config, Rewrites: test.rewrites}}}, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, test.path, nil)
+			for name, values := range test.header {
+				req.Header[name] = values
+			}
+			handler.ServeHTTP(recorder, req)
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+
+	_, err := New(context.Background(), http.NotFoundHandler(), &Config{Responses: []Response{{
+		Status:  "200",
+		Sitemap: &Sitemap{},
+		Stream:  true,
+	}}}, "rewriteBody")
+	expErr := "responses[0]: sitemap can't be used with stream, jsonPaths, rewriteFirstBytes or rewriteMultipart"
+	if err == nil || err.Error() != expErr {
+		t.Errorf("got error %v, want %q", err, expErr)
+	}
+}
+
+func TestServeHTTP_sitemapFailure(t *testing.T) {
+	testModeFailure(t, Response{Status: "200", Sitemap: &Sitemap{Paths: []string{"/"}}},
+		"application/xml", "<urlset><url><loc>http://internal.local/</url>", stageSitemap)
+}
@@ -0,0 +1,91 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"mime"
+)
+
+// The namespaces of the SOAP 1.1 and SOAP 1.2 envelopes.
+const (
+	soap11Namespace = "http://schemas.xmlsoap.org/soap/envelope/"
+	soap12Namespace = "http://www.w3.org/2003/05/soap-envelope"
+)
+
+// soapContentTypes are the media types of the SOAP responses.
+var soapContentTypes = map[string]bool{
+	"text/xml":             true,
+	"application/soap+xml": true,
+}
+
+// isSOAPResponse reports whether the given Content-Type is the one of a SOAP response.
+func isSOAPResponse(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && soapContentTypes[mediaType]
+}
+
+// isFaultMessage reports whether the element at the end of the path is the message of a SOAP fault: the
+// faultstring of a SOAP 1.1 Fault, or a Text of the Reason of a SOAP 1.2 Fault. The elements are matched by
+// namespace, whatever their prefix.
+func isFaultMessage(path []xml.Name) bool {
+	n := len(path)
+	switch {
+	case n >= 2 && path[n-1] == xml.Name{Local: "faultstring"}:
+		return path[n-2] == xml.Name{Space: soap11Namespace, Local: "Fault"}
+	case n >= 3 && path[n-1] == xml.Name{Space: soap12Namespace, Local: "Text"}:
+		return path[n-2] == xml.Name{Space: soap12Namespace, Local: "Reason"} &&
+			path[n-3] == xml.Name{Space: soap12Namespace, Local: "Fault"}
+	}
+	return false
+}
+
+// soapFaults is the mode of the response blocks whose rewrites only apply to the messages of the SOAP faults.
+type soapFaults struct{}
+
+// String describes the mode in the debug dump.
+func (soapFaults) String() string { return "fault messages" }
+
+func (soapFaults) option() string { return "soapFaults" }
+
+// changesBody implements the transformer interface: the faults are only changed by the rewrites.
+func (soapFaults) changesBody() bool { return false }
+
+// matchesContentType implements the contentTypeMatcher interface.
+func (soapFaults) matchesContentType(contentType string) bool {
+	return isSOAPResponse(contentType)
+}
+
+// transform implements the bodyTransformer interface, as rewriteBody for a SOAP body: the rewrites are only
+// applied to the raw contents of the messages of its faults, the rest of the body being left byte for byte. A
+// body which can't be parsed fails the soap stage.
+func (soapFaults) transform(rw *responseWriter, body []byte) ([]byte, bool, bool, []int, error) {
+	r, response, req := rw.middleware, rw.response, rw.request
+	messages, err := xmlElements(body, isFaultMessage)
+	if err != nil {
+		return body, false, true, nil, &stageError{stage: stageSOAP, err: fmt.Errorf("unable to parse the SOAP body: %w", err)}
+	}
+
+	var out bytes.Buffer
+	modified, complete := false, true
+	var replaced []int
+	var sent int64
+	for _, message := range messages {
+		content, contentModified, contentComplete, contentReplaced, err := r.rewriteBody(response, body[message.contentStart:message.contentEnd], req)
+		if err != nil {
+			return body, false, true, nil, err
+		}
+		out.Write(body[sent:message.contentStart])
+		out.Write(content)
+		sent = message.contentEnd
+		modified = modified || contentModified
+		complete = complete && contentComplete
+		replaced = addReplacements(replaced, contentReplaced)
+	}
+
+	if !modified {
+		return body, false, complete, replaced, nil
+	}
+	out.Write(body[sent:])
+	return out.Bytes(), true, complete, replaced, nil
+}
@@ -0,0 +1,136 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeHTTP_soapFaults(t *testing.T) {
+	const soap11 = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">
+  <s:Header><trace host="app-3.internal.local"/></s:Header>
+  <s:Body>
+    <s:Fault>
+      <faultcode>s:Server</faultcode>
+      <faultstring>com.acme.orders.OrderDAO: connection to db-1.internal.local refused</faultstring>
+      <detail>db-1.internal.local</detail>
+    </s:Fault>
+  </s:Body>
+</s:Envelope>`
+	const soap12 = `<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope">
+  <env:Body>
+    <env:Fault>
+      <env:Code><env:Value>env:Receiver</env:Value></env:Code>
+      <env:Reason>
+        <env:Text xml:lang="en">com.acme.orders.OrderDAO: connection to db-1.internal.local refused</env:Text>
+        <env:Text xml:lang="fr">com.acme.orders.OrderDAO: connexion à db-1.internal.local refusée</env:Text>
+      </env:Reason>
+    </env:Fault>
+  </env:Body>
+</env:Envelope>`
+	const soap12Prefix = `<Envelope xmlns="http://www.w3.org/2003/05/soap-envelope">
+  <Body>
+    <Fault>
+      <Reason><Text xml:lang="en">connection to db-1.internal.local refused</Text></Reason>
+    </Fault>
+  </Body>
+</Envelope>`
+
+	tests := []struct {
+		desc        string
+		contentType string
+		body        string
+		expBody     string
+	}{
+		{
+			desc:        "SOAP 1.1",
+			contentType: "text/xml; charset=utf-8",
+			body:        soap11,
+			expBody:     strings.Replace(soap11, "com.acme.orders.OrderDAO: connection to db-1.internal.local refused", "connection to the database refused", 1),
+		},
+		{
+			desc:        "SOAP 1.2",
+			contentType: "application/soap+xml",
+			body:        soap12,
+			expBody: strings.NewReplacer(
+				"com.acme.orders.OrderDAO: connection to db-1.internal.local refused", "connection to the database refused",
+				"com.acme.orders.OrderDAO: connexion à db-1.internal.local refusée", "connexion à the database refusée",
+			).Replace(soap12),
+		},
+		{
+			desc:        "default namespace",
+			contentType: "application/soap+xml",
+			body:        soap12Prefix,
+			expBody:     strings.Replace(soap12Prefix, "db-1.internal.local", "the database", 1),
+		},
+		{
+			desc:        "faultstring outside of a fault",
+			contentType: "text/xml",
+			body:        `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><faultstring>db-1.internal.local</faultstring></s:Body></s:Envelope>`,
+			expBody:     `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><faultstring>db-1.internal.local</faultstring></s:Body></s:Envelope>`,
+		},
+		{
+			desc:        "other namespace",
+			contentType: "text/xml",
+			body:        `<s:Envelope xmlns:s="urn:acme"><s:Body><s:Fault><faultstring>db-1.internal.local</faultstring></s:Fault></s:Body></s:Envelope>`,
+			expBody:     `<s:Envelope xmlns:s="urn:acme"><s:Body><s:Fault><faultstring>db-1.internal.local</faultstring></s:Fault></s:Body></s:Envelope>`,
+		},
+		{
+			desc:        "malformed body",
+			contentType: "text/xml",
+			body:        `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Fault><faultstring>db-1.internal.local</s:Fault>`,
+			expBody:     `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Fault><faultstring>db-1.internal.local</s:Fault>`,
+		},
+		{
+			desc:        "other content type",
+			contentType: "application/json",
+			body:        `{"fault": "db-1.internal.local"}`,
+			expBody:     `{"fault": "db-1.internal.local"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", test.contentType)
+				rw.WriteHeader(http.StatusInternalServerError)
+				_, _ = rw.Write([]byte(test.body))
+			}), &Config{Responses: []Response{{
+				Status:     "500",
+				SOAPFaults: true,
+				Rewrites: []Rewrite{
+					{Regex: `com\.acme\.[\w.]+: `, Remove: true},
+					{Regex: `db-\d+\.internal\.local`, Replacement: "the database"},
+				},
+			}}}, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+
+	_, err := New(context.Background(), http.NotFoundHandler(), &Config{Responses: []Response{{
+		Status:     "500",
+		SOAPFaults: true,
+		Stream:     true,
+		Rewrites:   []Rewrite{{Regex: "a", Replacement: "b"}},
+	}}}, "rewriteBody")
+	expErr := "responses[0]: soapFaults can't be used with stream, jsonPaths, rewriteFirstBytes or rewriteMultipart"
+	if err == nil || err.Error() != expErr {
+		t.Errorf("got error %v, want %q", err, expErr)
+	}
+}
+
+func TestServeHTTP_soapFaultsFailure(t *testing.T) {
+	testModeFailure(t, Response{Status: "200", SOAPFaults: true, Rewrites: []Rewrite{{Regex: `db-\d+\.internal\.local`, Replacement: "database"}}},
+		"text/xml", `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Fault><faultstring>db-1.internal.local</s:Fault>`, stageSOAP)
+}
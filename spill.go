@@ -0,0 +1,260 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+	"unicode/utf8"
+)
+
+// errOutputLimit is returned by a limitedWriter when its limit is exceeded.
+var errOutputLimit = errors.New("output limit exceeded")
+
+// limitedWriter writes to w, failing with errOutputLimit once more than limit bytes would be written.
+type limitedWriter struct {
+	w       io.Writer
+	limit   int64
+	written int64
+}
+
+// Write implements the io.Writer interface.
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.written+int64(len(p)) > l.limit {
+		return 0, errOutputLimit
+	}
+	n, err := l.w.Write(p)
+	l.written += int64(n)
+	return n, err
+}
+
+// spillFile is a temporary file holding a body too big to be buffered in memory.
+type spillFile struct {
+	file *os.File
+	size int64
+}
+
+// newSpillFile creates a temporary file in dir, or in the default temporary directory if dir is empty.
+func newSpillFile(dir string) (*spillFile, error) {
+	file, err := os.CreateTemp(dir, "responsebodyrewrite-*")
+	if err != nil {
+		return nil, err
+	}
+	return &spillFile{file: file}, nil
+}
+
+// exceedsSpillThreshold reports whether a body of the given size must be spilled to a temporary file. The
+// multipart bodies, rewritten part by part, and the bodies needed whole stay in memory.
+func (rw *responseWriter) exceedsSpillThreshold(size int64) bool {
+	return rw.middleware.spillThreshold > 0 && size > rw.middleware.spillThreshold && !rw.spillFailed &&
+		rw.multipartBoundary == "" && !rw.response.needsWholeBody()
+}
+
+// startSpill moves the buffered body to a temporary file, where the rest of the body is going to be written.
+// If the file can't be created, the body keeps being buffered in memory.
+func (rw *responseWriter) startSpill() {
+	spill, err := newSpillFile(rw.middleware.spillDir)
+	if err != nil {
+		rw.spillFailed = true
+		rw.warnf("unable to spill response body of %s to a temporary file: %v", rw.request.URL, err)
+		return
+	}
+
+	rw.spillFiles = append(rw.spillFiles, spill)
+	if _, err = spill.Write(rw.buffer.Bytes()); err != nil {
+		rw.spillFailed = true
+		rw.warnf("unable to spill response body of %s to a temporary file: %v", rw.request.URL, err)
+		return
+	}
+	rw.buffer.Reset()
+	rw.releaseBudget()
+	rw.spill = spill
+}
+
+// bufferedSize returns the number of body bytes buffered so far, in memory or in a temporary file.
+func (rw *responseWriter) bufferedSize() int64 {
+	if rw.spill != nil {
+		return rw.spill.size
+	}
+	return int64(rw.buffer.Len())
+}
+
+// removeSpillFiles deletes all the temporary files created for the response.
+func (rw *responseWriter) removeSpillFiles() {
+	for _, spill := range rw.spillFiles {
+		if err := spill.Remove(); err != nil {
+			rw.warnf("unable to remove temporary file %s: %v", spill.file.Name(), err)
+		}
+	}
+}
+
+// Write implements the io.Writer interface.
+func (s *spillFile) Write(p []byte) (int, error) {
+	n, err := s.file.Write(p)
+	s.size += int64(n)
+	return n, err
+}
+
+// WriteTo implements the io.WriterTo interface, writing the content of the file to w.
+func (s *spillFile) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, io.NewSectionReader(s.file, 0, s.size))
+}
+
+// Remove closes and deletes the file.
+func (s *spillFile) Remove() error {
+	closeErr := s.file.Close()
+	if err := os.Remove(s.file.Name()); err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// rewriteSpilled applies the rewrites of the response to the spilled body, and writes the result to w.
+// Each rewrite reads the output of the previous one from a new temporary file, the last one writing to w.
+func (rw *responseWriter) rewriteSpilled(w io.Writer) error {
+	src := rw.spill
+	rewrites := rw.response.rewrites
+	middleware := rw.middleware
+
+	if middleware.exceedsMaxRewriteBytes(src.size) {
+		rw.warnf("%s: skipping rewrite of %s by response %s: body of %d bytes exceeds maxRewriteBytes of %d",
+			middleware.name, rw.request.URL, rw.response.id, src.size, middleware.maxRewriteBytes)
+		_, err := src.WriteTo(w)
+		return err
+	}
+
+	replaced := make([]int, 0, len(rewrites))
+	deadline := middleware.rewriteDeadline()
+	for i, rewrite := range rewrites {
+		if i > 0 && !deadline.IsZero() && time.Now().After(deadline) {
+			rw.warnf("%s: rewrite of %s by response %s exceeded maxRewriteDuration of %s, skipping rules %d to %d",
+				middleware.name, rw.request.URL, rw.response.id, middleware.maxRewriteDuration, i, len(rewrites)-1)
+			if middleware.sendOriginalOnTimeout {
+				src = rw.spill
+				replaced = nil
+			}
+			break
+		}
+
+		// With a maxOutputBytes limit, the output of the last rewrite must be checked before being sent.
+		limit := rw.response.maxOutputBytes
+		if i == len(rewrites)-1 && limit == 0 {
+			out := bufio.NewWriter(w)
+			matches, err := rewriteReader(src.file, src.size, rewrite, out)
+			if err != nil {
+				return err
+			}
+			rw.spillReplaced = append(replaced, matches)
+			middleware.logReplacements(rw.request, rw.response, rw.spillReplaced)
+			return out.Flush()
+		}
+
+		dst, err := newSpillFile(middleware.spillDir)
+		if err != nil {
+			return err
+		}
+		// Intermediate files are removed with the spill file once the response is complete.
+		rw.spillFiles = append(rw.spillFiles, dst)
+
+		var dstWriter io.Writer = dst
+		if limit > 0 {
+			dstWriter = &limitedWriter{w: dst, limit: limit}
+		}
+		out := bufio.NewWriter(dstWriter)
+		matches, err := rewriteReader(src.file, src.size, rewrite, out)
+		if err == nil {
+			err = out.Flush()
+		}
+		if errors.Is(err, errOutputLimit) {
+			failure := fmt.Errorf("rule %d exceeded maxOutputBytes of %d after replacing %d matches", i, limit, matches)
+			if rw.handleFailure(stageRewrite, failure) {
+				rw.sendFailureResponse()
+			}
+			_, err = rw.spill.WriteTo(w)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		replaced = append(replaced, matches)
+		src = dst
+	}
+
+	if replaced != nil {
+		rw.spillReplaced = replaced
+		middleware.logReplacements(rw.request, rw.response, replaced)
+	}
+
+	// Without any rewrite, once the deadline expired, or once the last rewrite fit within maxOutputBytes, the
+	// current file is sent as is.
+	_, err := src.WriteTo(w)
+	return err
+}
+
+// rewriteReader applies a rewrite to the size first bytes of src, writes the result to dst, and returns the
+// number of matches replaced.
+// It mirrors regexp.ReplaceAll, except that each search restarts where the previous match ended, without
+// the preceding text as context for anchors and word boundaries.
+func rewriteReader(src io.ReaderAt, size int64, rewrite parsedRewrite, dst io.Writer) (int, error) {
+	var searchPos, lastMatchEnd int64
+	matches := 0
+	var matchBytes []byte
+	for searchPos <= size {
+		reader := bufio.NewReader(io.NewSectionReader(src, searchPos, size-searchPos))
+		loc := rewrite.regex.FindReaderSubmatchIndex(reader)
+		if loc == nil {
+			break
+		}
+		start, end := searchPos+int64(loc[0]), searchPos+int64(loc[1])
+
+		if _, err := io.Copy(dst, io.NewSectionReader(src, lastMatchEnd, start-lastMatchEnd)); err != nil {
+			return matches, err
+		}
+
+		// An empty match right after the previous match is ignored, as in regexp.ReplaceAll.
+		if end > lastMatchEnd || start == 0 {
+			if cap(matchBytes) < int(end-start) {
+				matchBytes = make([]byte, end-start)
+			}
+			matchBytes = matchBytes[:end-start]
+			if _, err := src.ReadAt(matchBytes, start); err != nil && err != io.EOF {
+				return matches, err
+			}
+
+			// Make the submatch indexes relative to the match.
+			offset := loc[0]
+			for i := range loc {
+				if loc[i] >= 0 {
+					loc[i] -= offset
+				}
+			}
+			if _, err := dst.Write(rewrite.regex.Expand(nil, rewrite.replacement, matchBytes, loc)); err != nil {
+				return matches, err
+			}
+			matches++
+		}
+		lastMatchEnd = end
+
+		// Advance past this match, always by at least one character.
+		width := int64(1)
+		if searchPos < size {
+			var runeBytes [utf8.UTFMax]byte
+			n, _ := src.ReadAt(runeBytes[:], searchPos)
+			_, w := utf8.DecodeRune(runeBytes[:n])
+			width = int64(w)
+		}
+		switch {
+		case searchPos+width > end:
+			searchPos += width
+		case searchPos+1 > end:
+			searchPos++
+		default:
+			searchPos = end
+		}
+	}
+
+	_, err := io.Copy(dst, io.NewSectionReader(src, lastMatchEnd, size-lastMatchEnd))
+	return matches, err
+}
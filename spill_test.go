@@ -0,0 +1,73 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRewriteReader(t *testing.T) {
+	tests := []struct {
+		desc        string
+		regex       string
+		replacement string
+		body        string
+	}{
+		{
+			desc:        "should replace a literal",
+			regex:       "foo",
+			replacement: "bar",
+			body:        "foo is the new bar, foofoo",
+		},
+		{
+			desc:        "should expand capture groups",
+			regex:       `id=(\d+)`,
+			replacement: "ref=${1}",
+			body:        "id=1 id=12345 id=9876",
+		},
+		{
+			desc:        "should handle unbounded matches",
+			regex:       `<!--.*?-->`,
+			replacement: "",
+			body:        "<p><!-- foo --></p><!-- bar -->",
+		},
+		{
+			desc:        "should handle empty matches like ReplaceAll",
+			regex:       "x*",
+			replacement: "-",
+			body:        "abxxcé",
+		},
+		{
+			desc:        "should leave a body without any match untouched",
+			regex:       "foo",
+			replacement: "bar",
+			body:        "nothing to see here",
+		},
+		{
+			desc:        "should handle an empty body",
+			regex:       "foo",
+			replacement: "bar",
+			body:        "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			rewrite := parsedRewrite{
+				regex:       regexp.MustCompile(test.regex),
+				replacement: []byte(test.replacement),
+			}
+			expected := rewrite.regex.ReplaceAll([]byte(test.body), rewrite.replacement)
+
+			var out bytes.Buffer
+			if _, err := rewriteReader(strings.NewReader(test.body), int64(len(test.body)), rewrite, &out); err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(out.Bytes(), expected) {
+				t.Errorf("got body %q, want %q", out.Bytes(), expected)
+			}
+		})
+	}
+}
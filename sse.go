@@ -0,0 +1,128 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+)
+
+// isEventStream reports whether the given Content-Type is the one of Server-Sent Events.
+func isEventStream(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "text/event-stream"
+}
+
+// sseRewriter applies the rewrites of a response to each Server-Sent Event of a stream, and sends every
+// event to the client as soon as it is complete, since an event stream never ends.
+type sseRewriter struct {
+	response *parsedResponse
+	writer   http.ResponseWriter
+	pending  []byte
+}
+
+// newSSERewriter creates a sseRewriter writing to w.
+func newSSERewriter(w http.ResponseWriter, response *parsedResponse) *sseRewriter {
+	return &sseRewriter{
+		response: response,
+		writer:   w,
+	}
+}
+
+// Write implements the io.Writer interface.
+func (s *sseRewriter) Write(p []byte) (int, error) {
+	s.pending = append(s.pending, p...)
+	if err := s.process(false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close rewrites and sends the last incomplete event, if any.
+func (s *sseRewriter) Close() error {
+	return s.process(true)
+}
+
+// process sends all the complete events of the pending data, and all the pending data when final is true.
+func (s *sseRewriter) process(final bool) error {
+	var out []byte
+	start := 0
+	for start < len(s.pending) {
+		rest := s.pending[start:]
+
+		// Heartbeat comments and empty lines between events are sent untouched.
+		if rest[0] == ':' || rest[0] == '\r' || rest[0] == '\n' {
+			end := lineEnd(rest, final)
+			if end < 0 {
+				break
+			}
+			out = append(out, rest[:end]...)
+			start += end
+			continue
+		}
+
+		end := eventEnd(rest, final)
+		if end < 0 {
+			break
+		}
+		event, _ := s.response.rewrite(rest[:end])
+		out = append(out, event...)
+		start += end
+	}
+	s.pending = append(s.pending[:0], s.pending[start:]...)
+
+	if len(out) == 0 {
+		return nil
+	}
+	if _, err := s.writer.Write(out); err != nil {
+		return err
+	}
+	if flusher, ok := s.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// lineEnd returns the index following the end of line of the first line of b, or -1 if the line is not
+// complete yet. When final is true, an incomplete line ends with b.
+func lineEnd(b []byte, final bool) int {
+	i := bytes.IndexAny(b, "\r\n")
+	switch {
+	case i < 0:
+		if final {
+			return len(b)
+		}
+		return -1
+	case b[i] == '\n':
+		return i + 1
+	case i+1 < len(b):
+		if b[i+1] == '\n' {
+			return i + 2
+		}
+		return i + 1
+	case final:
+		return i + 1
+	default:
+		// A CR may be followed by a LF which hasn't been received yet.
+		return -1
+	}
+}
+
+// eventEnd returns the index following the blank line ending the first event of b, or -1 if the event is
+// not complete yet. When final is true, an incomplete event ends with b.
+func eventEnd(b []byte, final bool) int {
+	start := 0
+	for start < len(b) {
+		end := lineEnd(b[start:], final)
+		if end < 0 {
+			return -1
+		}
+		if b[start] == '\r' || b[start] == '\n' {
+			return start + end
+		}
+		start += end
+	}
+	if final {
+		return len(b)
+	}
+	return -1
+}
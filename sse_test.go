@@ -0,0 +1,135 @@
+package traefik_responsebodyrewrite
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestIsEventStream(t *testing.T) {
+	tests := []struct {
+		contentType string
+		expected    bool
+	}{
+		{contentType: "text/event-stream", expected: true},
+		{contentType: "text/event-stream; charset=utf-8", expected: true},
+		{contentType: "Text/Event-Stream", expected: true},
+		{contentType: "text/html", expected: false},
+		{contentType: "", expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.contentType, func(t *testing.T) {
+			if res := isEventStream(test.contentType); res != test.expected {
+				t.Errorf("got %v, want %v", res, test.expected)
+			}
+		})
+	}
+}
+
+func TestEventEnd(t *testing.T) {
+	tests := []struct {
+		desc     string
+		data     string
+		final    bool
+		expected int
+	}{
+		{
+			desc:     "should find an event ended by LF",
+			data:     "data: foo\n\ndata: bar",
+			expected: 11,
+		},
+		{
+			desc:     "should find an event ended by CRLF",
+			data:     "data: foo\r\ndata: bar\r\n\r\n",
+			expected: 24,
+		},
+		{
+			desc:     "should find an event ended by CR",
+			data:     "data: foo\r\rdata",
+			expected: 11,
+		},
+		{
+			desc:     "should wait for the LF following a CR",
+			data:     "data: foo\r\n\r",
+			expected: -1,
+		},
+		{
+			desc:     "should wait for the end of an incomplete event",
+			data:     "data: foo\ndata: bar\n",
+			expected: -1,
+		},
+		{
+			desc:     "should end an incomplete event when final",
+			data:     "data: foo\ndata: bar",
+			final:    true,
+			expected: 19,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if res := eventEnd([]byte(test.data), test.final); res != test.expected {
+				t.Errorf("got %d, want %d", res, test.expected)
+			}
+		})
+	}
+}
+
+func TestSSERewriter(t *testing.T) {
+	response := &parsedResponse{
+		rewrites: []parsedRewrite{
+			{regex: regexp.MustCompile("foo"), replacement: []byte("bar")},
+		},
+	}
+	response.passes = optimizePasses(response.rewrites)
+
+	tests := []struct {
+		desc   string
+		chunks []string
+		// expected holds the body sent after each chunk, and after the final close.
+		expected []string
+	}{
+		{
+			desc:     "should send complete events only",
+			chunks:   []string{"data: foo\n", "\ndata: fo", "o\n\n"},
+			expected: []string{"", "data: bar\n\n", "data: bar\n\ndata: bar\n\n", "data: bar\n\ndata: bar\n\n"},
+		},
+		{
+			desc:     "should send heartbeat comments immediately and untouched",
+			chunks:   []string{": foo\n", "data: foo"},
+			expected: []string{": foo\n", ": foo\n", ": foo\ndata: bar"},
+		},
+		{
+			desc:     "should rewrite a match spanning writes",
+			chunks:   []string{"data: f", "o", "o\r\n\r\n"},
+			expected: []string{"", "", "data: bar\r\n\r\n", "data: bar\r\n\r\n"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			sse := newSSERewriter(recorder, response)
+
+			for i, chunk := range test.chunks {
+				if _, err := sse.Write([]byte(chunk)); err != nil {
+					t.Fatal(err)
+				}
+				if recorder.Body.String() != test.expected[i] {
+					t.Errorf("after chunk %d: got body %q, want %q", i, recorder.Body.String(), test.expected[i])
+				}
+			}
+
+			if err := sse.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if expected := test.expected[len(test.expected)-1]; recorder.Body.String() != expected {
+				t.Errorf("after close: got body %q, want %q", recorder.Body.String(), expected)
+			}
+			if recorder.Body.Len() > 0 && !recorder.Flushed {
+				t.Error("events must be flushed")
+			}
+		})
+	}
+}
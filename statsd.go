@@ -0,0 +1,191 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultMetricsFlushInterval is the interval at which the counters are sent to metricsAddr when
+// metricsFlushInterval is not set.
+const defaultMetricsFlushInterval = 10 * time.Second
+
+// statsdPrefix is the prefix of the names of the metrics sent to metricsAddr.
+const statsdPrefix = "responsebodyrewrite"
+
+// maxStatsdPacketSize is the maximum size of a packet sent to metricsAddr, several lines being batched in a
+// packet up to this size, which fits in the MTU of most networks.
+const maxStatsdPacketSize = 1432
+
+// statsdErrorLogInterval is the minimum interval between two messages about the metrics which couldn't be
+// sent, so that an unreachable metricsAddr doesn't flood the logs.
+const statsdErrorLogInterval = time.Minute
+
+// statsdClient sends the counters of the metrics to a StatsD server, as the increments of the counters since
+// the previous flush. It is only used by the goroutine of pushMetrics.
+type statsdClient struct {
+	addr string
+	// name is the name of the middleware, part of the names of the metrics, or a tag with tags.
+	name string
+	tags bool
+	// conn is nil until the address is resolved.
+	conn net.Conn
+	// sent are the values of the counters sent so far.
+	sent map[string]int64
+}
+
+// validateMetricsAddr checks the metricsAddr option, a host:port address of a StatsD server.
+func validateMetricsAddr(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	if _, port, err := net.SplitHostPort(addr); err != nil || port == "" {
+		return fmt.Errorf("invalid metricsAddr %q: must be a host:port address", addr)
+	}
+	return nil
+}
+
+// parseMetricsFlushInterval parses the metricsFlushInterval option, an empty value meaning
+// defaultMetricsFlushInterval.
+func parseMetricsFlushInterval(interval string) (time.Duration, error) {
+	if interval == "" {
+		return defaultMetricsFlushInterval, nil
+	}
+	parsed, err := time.ParseDuration(interval)
+	if err != nil || parsed <= 0 {
+		return 0, fmt.Errorf("invalid metricsFlushInterval %q: must be a positive duration", interval)
+	}
+	return parsed, nil
+}
+
+// newStatsdClient creates the client of the StatsD server at addr, which is resolved on the first flush.
+func newStatsdClient(addr, name string, tags bool) *statsdClient {
+	return &statsdClient{addr: addr, name: name, tags: tags, sent: make(map[string]int64)}
+}
+
+// statsdName replaces the characters which can't be part of a StatsD name or tag, such as the "@" of the
+// names of the Traefik middlewares, by "_".
+func statsdName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// lines returns the StatsD lines of the increments of the counters since the previous call, none for the
+// counters which didn't change. Without tags, the names of the middleware and of the response blocks are part
+// of the names of the metrics, e.g. "responsebodyrewrite.rewrite.response.0.matched:3|c". With tags, they are
+// DogStatsD tags, e.g. "responsebodyrewrite.response.matched:3|c|#middleware:rewrite,response:0".
+func (c *statsdClient) lines(values []metric) []string {
+	var lines []string
+	for _, value := range values {
+		delta := value.value - c.sent[value.name]
+		if delta == 0 {
+			continue
+		}
+		c.sent[value.name] = value.value
+
+		count := ":" + strconv.FormatInt(delta, 10) + "|c"
+		if !c.tags {
+			lines = append(lines, statsdPrefix+"."+statsdName(c.name)+"."+statsdName(value.name)+count)
+			continue
+		}
+		name := value.counter
+		tags := "|#middleware:" + statsdName(c.name)
+		if value.response != "" {
+			name = "response." + value.counter
+			tags += ",response:" + statsdName(value.response)
+		}
+		lines = append(lines, statsdPrefix+"."+name+count+tags)
+	}
+	return lines
+}
+
+// statsdPackets batches lines into packets of up to maxStatsdPacketSize bytes, separated by newlines. A line
+// longer than the maximum is sent alone.
+func statsdPackets(lines []string) []string {
+	var packets []string
+	var packet strings.Builder
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxStatsdPacketSize {
+			packets = append(packets, packet.String())
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		packets = append(packets, packet.String())
+	}
+	return packets
+}
+
+// flush sends the increments of the counters. The increments which couldn't be sent are lost, as with any
+// UDP packet.
+func (c *statsdClient) flush(values []metric) error {
+	packets := statsdPackets(c.lines(values))
+	if len(packets) == 0 {
+		return nil
+	}
+	if c.conn == nil {
+		conn, err := net.Dial("udp", c.addr)
+		if err != nil {
+			return err
+		}
+		c.conn = conn
+	}
+	for _, packet := range packets {
+		if _, err := c.conn.Write([]byte(packet)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close closes the connection to the StatsD server, if any.
+func (c *statsdClient) close() {
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+}
+
+// pushMetrics sends the counters of the metrics to the StatsD server of client every interval, and a last
+// time when ctx is done. The failures are logged as warnings, at most once every statsdErrorLogInterval.
+func (r *responsebodyrewrite) pushMetrics(ctx context.Context, client *statsdClient, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer client.close()
+
+	var lastErrorLog time.Time
+	failures := 0
+	flush := func() {
+		err := client.flush(r.metrics.values(r.currentResponses()))
+		if err == nil {
+			return
+		}
+		failures++
+		if now := time.Now(); now.Sub(lastErrorLog) >= statsdErrorLogInterval {
+			r.warnf("%s: can't send the metrics to metricsAddr %s (%d failures since the last message): %v", r.name, client.addr, failures, err)
+			lastErrorLog = now
+			failures = 0
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			flush()
+			return
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsdClient_lines(t *testing.T) {
+	values := []metric{
+		{name: "responses", counter: "responses", value: 3},
+		{name: "passthrough.encoded", counter: "passthrough.encoded"},
+		responseMetric("not-found", "matched", 2),
+	}
+	tests := []struct {
+		desc     string
+		tags     bool
+		expLines []string
+	}{
+		{
+			desc: "names",
+			expLines: []string{
+				"responsebodyrewrite.rewrite_file.responses:3|c",
+				"responsebodyrewrite.rewrite_file.response.not-found.matched:2|c",
+			},
+		},
+		{
+			desc: "tags",
+			tags: true,
+			expLines: []string{
+				"responsebodyrewrite.responses:3|c|#middleware:rewrite_file",
+				"responsebodyrewrite.response.matched:2|c|#middleware:rewrite_file,response:not-found",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			client := newStatsdClient("localhost:8125", "rewrite@file", test.tags)
+			if lines := client.lines(values); !reflect.DeepEqual(lines, test.expLines) {
+				t.Errorf("got lines %q, want %q", lines, test.expLines)
+			}
+
+			// Only the increments since the previous flush are sent.
+			next := []metric{{name: "responses", counter: "responses", value: 5}, responseMetric("not-found", "matched", 2)}
+			if lines := client.lines(next); len(lines) != 1 || !strings.HasPrefix(lines[0], "responsebodyrewrite.") || !strings.Contains(lines[0], "responses:2|c") {
+				t.Errorf("got lines %q, want the increment of responses only", lines)
+			}
+		})
+	}
+}
+
+func TestStatsdPackets(t *testing.T) {
+	line := strings.Repeat("a", 500)
+	long := strings.Repeat("b", maxStatsdPacketSize+1)
+	packets := statsdPackets([]string{line, line, line, long, line})
+	expPackets := []string{line + "\n" + line, line, long, line}
+	if !reflect.DeepEqual(packets, expPackets) {
+		t.Errorf("got packet sizes %d, want %d", packetSizes(packets), packetSizes(expPackets))
+	}
+}
+
+func packetSizes(packets []string) []int {
+	sizes := make([]int, len(packets))
+	for i, packet := range packets {
+		sizes[i] = len(packet)
+	}
+	return sizes
+}
+
+func TestNew_metricsAddr(t *testing.T) {
+	tests := []struct {
+		desc   string
+		config *Config
+		expErr string
+	}{
+		{desc: "no port", config: &Config{MetricsAddr: "localhost"}, expErr: `invalid metricsAddr "localhost": must be a host:port address`},
+		{desc: "empty port", config: &Config{MetricsAddr: "localhost:"}, expErr: `invalid metricsAddr "localhost:": must be a host:port address`},
+		{desc: "invalid interval", config: &Config{MetricsAddr: "localhost:8125", MetricsFlushInterval: "0s"}, expErr: `invalid metricsFlushInterval "0s": must be a positive duration`},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if _, err := New(context.Background(), http.NotFoundHandler(), test.config, "rewriteBody"); err == nil || err.Error() != test.expErr {
+				t.Errorf("got error %v, want %q", err, test.expErr)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_metricsAddr(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("can't listen on UDP: %v", err)
+	}
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler, err := NewMiddleware(
+		http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			_, _ = rw.Write([]byte("foo"))
+		}),
+		WithContext(ctx),
+		WithName("rewriteBody"),
+		WithConfig(&Config{MetricsInterval: "0", MetricsAddr: server.LocalAddr().String(), MetricsFlushInterval: "1ms", MetricsTags: true}),
+		WithResponses(Response{Name: "foo", Status: "200", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var received string
+	buffer := make([]byte, maxStatsdPacketSize)
+	expLine := "responsebodyrewrite.response.modified:1|c|#middleware:rewriteBody,response:foo"
+	for !strings.Contains(received, expLine) {
+		if err := server.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		n, _, err := server.ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("got error %v, want %q in the packets received, got %q", err, expLine, received)
+		}
+		received += string(buffer[:n]) + "\n"
+	}
+}
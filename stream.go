@@ -0,0 +1,227 @@
+package traefik_responsebodyrewrite
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp/syntax"
+	"unicode/utf8"
+)
+
+// errUnboundedWidth is returned by maxMatchWidth when a pattern can match an arbitrarily long text.
+var errUnboundedWidth = errors.New("pattern has an unbounded match width")
+
+// maxMatchWidth returns the maximum number of bytes a match of the given pattern can span.
+// Patterns whose matches depend on the text surrounding them (anchors, word boundaries) are rejected,
+// since that context is lost once a prefix of the stream has been flushed.
+func maxMatchWidth(pattern string) (int, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return 0, err
+	}
+
+	return syntaxMaxWidth(re.Simplify())
+}
+
+// syntaxMaxWidth computes the maximum width in bytes of a match of the given parsed expression.
+func syntaxMaxWidth(re *syntax.Regexp) (int, error) {
+	switch re.Op {
+	case syntax.OpNoMatch, syntax.OpEmptyMatch:
+		return 0, nil
+
+	case syntax.OpLiteral:
+		width := 0
+		for _, r := range re.Rune {
+			if re.Flags&syntax.FoldCase != 0 {
+				// A case folded rune may be encoded on more bytes than the literal one (e.g. k and K).
+				width += utf8.UTFMax
+				continue
+			}
+			width += utf8.RuneLen(r)
+		}
+		return width, nil
+
+	case syntax.OpCharClass:
+		width := 0
+		for i := 1; i < len(re.Rune); i += 2 {
+			if l := utf8.RuneLen(re.Rune[i]); l > width {
+				width = l
+			}
+		}
+		return width, nil
+
+	case syntax.OpAnyCharNotNL, syntax.OpAnyChar:
+		return utf8.UTFMax, nil
+
+	case syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText,
+		syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return 0, fmt.Errorf("assertion %q depends on the surrounding text", re.String())
+
+	case syntax.OpCapture, syntax.OpQuest:
+		return syntaxMaxWidth(re.Sub[0])
+
+	case syntax.OpStar, syntax.OpPlus:
+		return 0, errUnboundedWidth
+
+	case syntax.OpRepeat:
+		if re.Max < 0 {
+			return 0, errUnboundedWidth
+		}
+		width, err := syntaxMaxWidth(re.Sub[0])
+		if err != nil {
+			return 0, err
+		}
+		return width * re.Max, nil
+
+	case syntax.OpConcat:
+		total := 0
+		for _, sub := range re.Sub {
+			width, err := syntaxMaxWidth(sub)
+			if err != nil {
+				return 0, err
+			}
+			total += width
+		}
+		return total, nil
+
+	case syntax.OpAlternate:
+		widest := 0
+		for _, sub := range re.Sub {
+			width, err := syntaxMaxWidth(sub)
+			if err != nil {
+				return 0, err
+			}
+			if width > widest {
+				widest = width
+			}
+		}
+		return widest, nil
+
+	default:
+		return 0, fmt.Errorf("unsupported operator %v", re.Op)
+	}
+}
+
+// streamStage applies one rewrite to a stream of bytes.
+// It holds back the data received so far that could be the beginning of a match of window bytes,
+// since that match may need the bytes that haven't been received yet.
+type streamStage struct {
+	rewrite parsedRewrite
+	window  int
+	pending []byte
+}
+
+// process appends p to the pending data and returns the rewritten bytes that can't be affected by
+// the data to come. When final is true, all the pending data is rewritten and returned.
+func (s *streamStage) process(p []byte, final bool) []byte {
+	s.pending = append(s.pending, p...)
+
+	// A match starting at or before the cut fits in the pending data.
+	cut := len(s.pending) - s.window + 1
+	if final {
+		cut = len(s.pending)
+	}
+	if cut <= 0 {
+		return nil
+	}
+
+	var out []byte
+	last := 0
+	for _, match := range s.rewrite.regex.FindAllSubmatchIndex(s.pending, -1) {
+		// A match starting after the cut might be different once the next bytes are known.
+		if !final && match[0] >= cut {
+			break
+		}
+		out = append(out, s.pending[last:match[0]]...)
+		out = s.rewrite.regex.Expand(out, s.rewrite.replacement, s.pending, match)
+		last = match[1]
+	}
+
+	if last < cut {
+		out = append(out, s.pending[last:cut]...)
+		last = cut
+	}
+
+	s.pending = append(s.pending[:0], s.pending[last:]...)
+	return out
+}
+
+// streamRewriter applies a list of rewrites to a body written in several chunks, and writes the rewritten
+// bytes to the underlying writer as soon as they can't be affected by the data to come anymore.
+// Each rewrite is applied by its own stage, the output of a stage being the input of the next one.
+type streamRewriter struct {
+	stages []*streamStage
+	writer io.Writer
+}
+
+// newStreamRewriter creates a streamRewriter writing to w.
+func newStreamRewriter(w io.Writer, rewrites []parsedRewrite, windows []int) *streamRewriter {
+	stages := make([]*streamStage, len(rewrites))
+	for i, rewrite := range rewrites {
+		stages[i] = &streamStage{
+			rewrite: rewrite,
+			window:  windows[i],
+		}
+	}
+
+	return &streamRewriter{
+		stages: stages,
+		writer: w,
+	}
+}
+
+// Write implements the io.Writer interface.
+func (s *streamRewriter) Write(p []byte) (int, error) {
+	if err := s.push(p, false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close rewrites and writes all the data held back by the stages.
+func (s *streamRewriter) Close() error {
+	return s.push(nil, true)
+}
+
+// push sends p through all the stages, and writes the result to the underlying writer.
+func (s *streamRewriter) push(p []byte, final bool) error {
+	for _, stage := range s.stages {
+		p = stage.process(p, final)
+	}
+
+	if len(p) == 0 {
+		return nil
+	}
+	_, err := s.writer.Write(p)
+	return err
+}
+
+// streamWindows returns the number of bytes to hold back for each rewrite of a response in streaming mode.
+// windowBytes overrides the longest possible match of the patterns when not zero.
+func streamWindows(rewrites []parsedRewrite, windowBytes int) ([]int, error) {
+	windows := make([]int, len(rewrites))
+	for i, rewrite := range rewrites {
+		pattern := rewrite.pattern()
+		if rewrite.backtracking != nil {
+			return nil, fmt.Errorf("rewrites[%d].regex: %q can't be used in streaming mode: the %s engine only rewrites whole bodies", i, pattern, engineRegexp2)
+		}
+
+		width, err := maxMatchWidth(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rewrites[%d].regex: %q can't be used in streaming mode: %w", i, pattern, err)
+		}
+		if rewrite.regex.Match(nil) {
+			return nil, fmt.Errorf("rewrites[%d].regex: %q can't be used in streaming mode: pattern can match the empty string", i, pattern)
+		}
+
+		switch {
+		case windowBytes == 0:
+			windows[i] = width
+		case windowBytes < width:
+			return nil, fmt.Errorf("windowBytes %d is smaller than the longest match of rewrites[%d].regex %q (%d bytes)", windowBytes, i, pattern, width)
+		default:
+			windows[i] = windowBytes
+		}
+	}
+	return windows, nil
+}
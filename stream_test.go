@@ -0,0 +1,220 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+)
+
+func TestMaxMatchWidth(t *testing.T) {
+	tests := []struct {
+		desc      string
+		pattern   string
+		expected  int
+		expectErr bool
+	}{
+		{
+			desc:     "should return the length of a literal",
+			pattern:  "foo",
+			expected: 3,
+		},
+		{
+			desc:     "should return the longest alternative",
+			pattern:  "foo|barbaz",
+			expected: 6,
+		},
+		{
+			desc:     "should multiply bounded repetitions",
+			pattern:  "a{2,5}b?",
+			expected: 6,
+		},
+		{
+			desc:     "should count multi-byte characters",
+			pattern:  "é[a-z]",
+			expected: 3,
+		},
+		{
+			desc:     "should count any character as a full rune",
+			pattern:  "a.",
+			expected: 5,
+		},
+		{
+			desc:     "should count case folded literals as full runes",
+			pattern:  "(?i)k",
+			expected: 4,
+		},
+		{
+			desc:      "should reject star",
+			pattern:   "fo*",
+			expectErr: true,
+		},
+		{
+			desc:      "should reject unbounded repetitions",
+			pattern:   "a{2,}",
+			expectErr: true,
+		},
+		{
+			desc:      "should reject anchors",
+			pattern:   "^foo",
+			expectErr: true,
+		},
+		{
+			desc:      "should reject word boundaries",
+			pattern:   `\bfoo`,
+			expectErr: true,
+		},
+		{
+			desc:      "should reject invalid patterns",
+			pattern:   "*",
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			width, err := maxMatchWidth(test.pattern)
+			if test.expectErr && err == nil {
+				t.Errorf("expected error, but got nil")
+			}
+			if !test.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if width != test.expected {
+				t.Errorf("got %d, want %d", width, test.expected)
+			}
+		})
+	}
+}
+
+func TestStreamWindows(t *testing.T) {
+	tests := []struct {
+		desc        string
+		patterns    []string
+		windowBytes int
+		expected    []int
+		expectErr   bool
+	}{
+		{
+			desc:     "should use the longest match of each pattern",
+			patterns: []string{"foo", "ba[rz]{1,3}"},
+			expected: []int{3, 5},
+		},
+		{
+			desc:        "should use the configured window",
+			patterns:    []string{"foo", "bar"},
+			windowBytes: 10,
+			expected:    []int{10, 10},
+		},
+		{
+			desc:        "should reject a window smaller than the longest match",
+			patterns:    []string{"foobar"},
+			windowBytes: 3,
+			expectErr:   true,
+		},
+		{
+			desc:      "should reject patterns matching the empty string",
+			patterns:  []string{"a?"},
+			expectErr: true,
+		},
+		{
+			desc:      "should reject unbounded patterns",
+			patterns:  []string{".*"},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			rewrites := make([]parsedRewrite, len(test.patterns))
+			for i, pattern := range test.patterns {
+				rewrites[i] = parsedRewrite{regex: regexp.MustCompile(pattern)}
+			}
+
+			windows, err := streamWindows(rewrites, test.windowBytes)
+			if test.expectErr && err == nil {
+				t.Errorf("expected error, but got nil")
+			}
+			if !test.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if len(windows) != len(test.expected) {
+				t.Fatalf("got %v, want %v", windows, test.expected)
+			}
+			for i := range windows {
+				if windows[i] != test.expected[i] {
+					t.Errorf("got %v, want %v", windows, test.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamRewriter(t *testing.T) {
+	tests := []struct {
+		desc     string
+		rewrites []Rewrite
+		body     string
+	}{
+		{
+			desc:     "should replace a literal",
+			rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+			body:     "foo is the new bar, foofoo",
+		},
+		{
+			desc:     "should chain rewrites",
+			rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}, {Regex: "bar", Replacement: "foo"}},
+			body:     "foo is the new bar",
+		},
+		{
+			desc:     "should expand capture groups",
+			rewrites: []Rewrite{{Regex: "id=([0-9]{1,4})", Replacement: "ref=${1}x"}},
+			body:     "id=1 id=12345 id=9876",
+		},
+		{
+			desc:     "should prefer the longest alternative",
+			rewrites: []Rewrite{{Regex: "ab?c?", Replacement: "X"}},
+			body:     "abc ab a ca",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			rewrites := make([]parsedRewrite, len(test.rewrites))
+			expected := []byte(test.body)
+			for i, rewrite := range test.rewrites {
+				rewrites[i] = parsedRewrite{
+					regex:       regexp.MustCompile(rewrite.Regex),
+					replacement: []byte(rewrite.Replacement),
+				}
+				expected = rewrites[i].regex.ReplaceAll(expected, rewrites[i].replacement)
+			}
+
+			windows, err := streamWindows(rewrites, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Split the body in chunks of every possible size, so that matches span chunk boundaries.
+			for size := 1; size <= len(test.body); size++ {
+				var out bytes.Buffer
+				stream := newStreamRewriter(&out, rewrites, windows)
+				for start := 0; start < len(test.body); start += size {
+					end := start + size
+					if end > len(test.body) {
+						end = len(test.body)
+					}
+					if _, err := stream.Write([]byte(test.body[start:end])); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := stream.Close(); err != nil {
+					t.Fatal(err)
+				}
+
+				if !bytes.Equal(out.Bytes(), expected) {
+					t.Errorf("chunk size %d: got body %q, want %q", size, out.Bytes(), expected)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package traefik_responsebodyrewrite
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// defaultStreamingContentTypes are the media types of the responses which are unbounded streams when
+// streamingContentTypes is not set: Server-Sent Events, newline-delimited JSON used for tailing, and the
+// multipart responses replacing their parts, such as MJPEG.
+var defaultStreamingContentTypes = []string{"text/event-stream", "application/x-ndjson", "multipart/x-mixed-replace"}
+
+// parseStreamingContentTypes parses the streamingContentTypes option, or its default if empty, into the set of
+// their media types.
+func parseStreamingContentTypes(contentTypes []string) (map[string]bool, error) {
+	if len(contentTypes) == 0 {
+		contentTypes = defaultStreamingContentTypes
+	}
+	mediaTypes := make(map[string]bool, len(contentTypes))
+	for _, contentType := range contentTypes {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil || len(params) > 0 || !strings.Contains(mediaType, "/") {
+			return nil, fmt.Errorf("invalid streamingContentTypes %q: must be a media type without parameters", contentType)
+		}
+		mediaTypes[mediaType] = true
+	}
+	return mediaTypes, nil
+}
+
+// streamingMediaType returns the media type of the response if it is one of the streamingContentTypes, which
+// are passed through without being rewritten, and an empty string otherwise. Nothing is passed through with
+// rewriteStreamingResponses.
+func (rw *responseWriter) streamingMediaType() string {
+	if rw.middleware.streamingContentTypes == nil {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(rw.ResponseWriter.Header().Get("Content-Type"))
+	if err != nil || !rw.middleware.streamingContentTypes[mediaType] {
+		return ""
+	}
+	return mediaType
+}
@@ -0,0 +1,95 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseStreamingContentTypes(t *testing.T) {
+	mediaTypes, err := parseStreamingContentTypes(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mediaType := range defaultStreamingContentTypes {
+		if !mediaTypes[mediaType] {
+			t.Errorf("got media types %v, want them to include %q by default", mediaTypes, mediaType)
+		}
+	}
+
+	if mediaTypes, err := parseStreamingContentTypes([]string{"Application/X-NDJSON"}); err != nil || len(mediaTypes) != 1 || !mediaTypes["application/x-ndjson"] {
+		t.Errorf("got media types %v and error %v, want application/x-ndjson only", mediaTypes, err)
+	}
+
+	for _, contentType := range []string{"text", "text/event-stream; charset=utf-8"} {
+		_, err := parseStreamingContentTypes([]string{contentType})
+		if expErr := `invalid streamingContentTypes "` + contentType + `": must be a media type without parameters`; err == nil || err.Error() != expErr {
+			t.Errorf("got error %v, want %q", err, expErr)
+		}
+	}
+}
+
+func TestServeHTTP_streamingContentTypes(t *testing.T) {
+	tests := []struct {
+		desc        string
+		config      Config
+		contentType string
+		expBody     string
+	}{
+		{
+			desc:        "newline-delimited JSON",
+			contentType: "application/x-ndjson",
+			expBody:     "data: foo 1\n\ndata: foo 2\n\n",
+		},
+		{
+			desc:        "server-sent events",
+			contentType: "text/event-stream; charset=utf-8",
+			expBody:     "data: foo 1\n\ndata: foo 2\n\n",
+		},
+		{
+			desc:        "server-sent events rewritten",
+			config:      Config{RewriteStreamingResponses: true},
+			contentType: "text/event-stream",
+			expBody:     "data: bar 1\n\ndata: bar 2\n\n",
+		},
+		{
+			desc:        "custom list",
+			config:      Config{StreamingContentTypes: []string{"application/x-ndjson"}},
+			contentType: "text/event-stream",
+			expBody:     "data: bar 1\n\ndata: bar 2\n\n",
+		},
+		{
+			desc:        "other content type",
+			contentType: "application/json",
+			expBody:     "data: bar 1\n\ndata: bar 2\n\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := test.config
+			config.Responses = []Response{{Status: "200", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}}}
+			recorder := httptest.NewRecorder()
+			var flushedBody string
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", test.contentType)
+				_, _ = rw.Write([]byte("data: foo 1\n\n"))
+				rw.(http.Flusher).Flush()
+				flushedBody = recorder.Body.String()
+				_, _ = rw.Write([]byte("data: foo 2\n\n"))
+			}), &config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+			if flushedBody == "" {
+				t.Error("got nothing sent on the flush, want the first line")
+			}
+		})
+	}
+}
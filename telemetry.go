@@ -0,0 +1,120 @@
+package traefik_responsebodyrewrite
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rewriteTiming aggregates the durations of the rewrites of a response block.
+type rewriteTiming struct {
+	count int64
+	total time.Duration
+	min   time.Duration
+	max   time.Duration
+}
+
+// add records the duration of a rewrite.
+func (t *rewriteTiming) add(elapsed time.Duration) {
+	if t.count == 0 || elapsed < t.min {
+		t.min = elapsed
+	}
+	if elapsed > t.max {
+		t.max = elapsed
+	}
+	t.count++
+	t.total += elapsed
+}
+
+// rewriteStats aggregates the rewrite durations of each response block since startup, and tells when they
+// must be logged. There is no background goroutine: the stats are logged by the first rewrite done once the
+// interval has elapsed.
+type rewriteStats struct {
+	mu       sync.Mutex
+	interval time.Duration
+	lastLog  time.Time
+	timings  []rewriteTiming
+}
+
+// newRewriteStats creates the stats of the given number of response blocks, logged every interval.
+func newRewriteStats(responses int, interval time.Duration) *rewriteStats {
+	return &rewriteStats{
+		interval: interval,
+		lastLog:  time.Now(),
+		timings:  make([]rewriteTiming, responses),
+	}
+}
+
+// record adds the duration of a rewrite by the response block of the given index. It returns a copy of the
+// stats of all the response blocks when they must be logged, nil otherwise.
+func (s *rewriteStats) record(index int, elapsed time.Duration, now time.Time) []rewriteTiming {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.timings[index].add(elapsed)
+	if now.Sub(s.lastLog) < s.interval {
+		return nil
+	}
+	s.lastLog = now
+	return append([]rewriteTiming(nil), s.timings...)
+}
+
+// recordRewrite reports the time spent rewriting the body of a response: it warns about slow rewrites,
+// exposes the duration in the timing header, and aggregates it in the stats.
+func (r *responsebodyrewrite) recordRewrite(rw *responseWriter, elapsed time.Duration) {
+	response := rw.response
+
+	if r.slowRewriteThreshold > 0 && elapsed > r.slowRewriteThreshold {
+		rw.warnf("%s: slow rewrite of %s by response %s: took %s, threshold is %s",
+			r.name, rw.request.URL, response.id, elapsed, r.slowRewriteThreshold)
+	}
+
+	if r.rewriteTimingHeader != "" {
+		// Once the headers have been sent, the duration can only be sent as a trailer.
+		name := r.rewriteTimingHeader
+		if rw.headersSent {
+			name = http.TrailerPrefix + name
+		}
+		rw.ResponseWriter.Header().Set(name, elapsed.String())
+	}
+
+	if r.stats == nil {
+		return
+	}
+	timings := r.stats.record(response.index, elapsed, time.Now())
+	for i, timing := range timings {
+		if timing.count == 0 {
+			continue
+		}
+		r.infof("%s: rewrites by response %s since startup: %d, min %s, avg %s, max %s",
+			r.name, r.responses[i].id, timing.count, timing.min, timing.total/time.Duration(timing.count), timing.max)
+	}
+}
+
+// setDebugHeader adds the debugHeader to a response whose body has been modified from originalSize into size
+// bytes by the given number of replacements per rule, if the header is enabled. It returns the value of the
+// header, empty if none is added. The value only tells indexes, counts and sizes, never the patterns.
+func (rw *responseWriter) setDebugHeader(replaced []int, originalSize, size int64) string {
+	name := rw.middleware.debugHeader
+	if name == "" || replaced == nil {
+		return ""
+	}
+
+	var value strings.Builder
+	value.WriteString(rw.response.id)
+	for i, n := range replaced {
+		if i == 0 {
+			value.WriteString(" ")
+		} else {
+			value.WriteString(",")
+		}
+		value.WriteString("r" + strconv.Itoa(i) + ":" + strconv.Itoa(n))
+	}
+	if rw.middleware.debugHeaderSizes {
+		value.WriteString(" in=" + strconv.FormatInt(originalSize, 10) + ";out=" + strconv.FormatInt(size, 10))
+	}
+	rw.ResponseWriter.Header().Set(name, value.String())
+	return value.String()
+}
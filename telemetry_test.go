@@ -0,0 +1,184 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRewriteStats(t *testing.T) {
+	start := time.Now()
+	stats := newRewriteStats(2, time.Minute)
+	stats.lastLog = start
+
+	if timings := stats.record(0, 3*time.Millisecond, start.Add(time.Second)); timings != nil {
+		t.Errorf("got stats to log before the interval elapsed: %+v", timings)
+	}
+	stats.record(0, time.Millisecond, start.Add(2*time.Second))
+
+	timings := stats.record(0, 5*time.Millisecond, start.Add(time.Minute))
+	if timings == nil {
+		t.Fatal("expected the stats to be logged once the interval elapsed")
+	}
+
+	timing := timings[0]
+	if timing.count != 3 || timing.min != time.Millisecond || timing.max != 5*time.Millisecond || timing.total != 9*time.Millisecond {
+		t.Errorf("got timing %+v", timing)
+	}
+	if timings[1].count != 0 {
+		t.Errorf("got %d rewrites for a response block never used, want 0", timings[1].count)
+	}
+
+	if timings := stats.record(1, time.Millisecond, start.Add(time.Minute+time.Second)); timings != nil {
+		t.Errorf("got stats to log right after they were logged: %+v", timings)
+	}
+}
+
+func TestServeHTTP_rewriteTiming(t *testing.T) {
+	config := &Config{
+		SlowRewriteThreshold: "1ns",
+		RewriteTimingHeader:  "X-Rewrite-Duration",
+		RewriteStatsInterval: "1ns",
+		Responses: []Response{
+			{
+				Status:   "200",
+				Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+			},
+		},
+	}
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("foo is the new bar"))
+	}
+
+	rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rewriteBody.ServeHTTP(recorder, req)
+
+	result := recorder.Result()
+	if _, err := time.ParseDuration(result.Header.Get("X-Rewrite-Duration")); err != nil {
+		t.Errorf("got invalid rewrite duration header %q: %v", result.Header.Get("X-Rewrite-Duration"), err)
+	}
+
+	if recorder.Body.String() != "bar is the new bar" {
+		t.Errorf("got body %q, want %q", recorder.Body.String(), "bar is the new bar")
+	}
+}
+
+func TestNew_rewriteTiming(t *testing.T) {
+	for _, config := range []*Config{
+		{SlowRewriteThreshold: "slow"},
+		{SlowRewriteThreshold: "-1s"},
+		{RewriteStatsInterval: "0s"},
+	} {
+		if _, err := New(context.Background(), http.NotFoundHandler(), config, "rewriteBody"); err == nil {
+			t.Errorf("expected an error for config %+v", config)
+		}
+	}
+}
+
+func TestServeHTTP_debugHeader(t *testing.T) {
+	tests := []struct {
+		desc        string
+		debugHeader string
+		sizes       bool
+		response    Response
+		body        string
+		requests    int
+		expHeader   string
+	}{
+		{
+			desc:        "modified body",
+			debugHeader: "X-Rewritten",
+			response: Response{Status: "200", Rewrites: []Rewrite{
+				{Regex: "foo", Replacement: "bar"},
+				{Regex: "qux", Replacement: "baz"},
+			}},
+			body:      "foo foo",
+			expHeader: "1 r0:2,r1:0",
+		},
+		{
+			desc:        "unmodified body",
+			debugHeader: "X-Rewritten",
+			response:    Response{Status: "200", Rewrites: []Rewrite{{Regex: "qux", Replacement: "baz"}}},
+			body:        "foo foo",
+		},
+		{
+			desc:     "no debug header",
+			response: Response{Status: "200", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}},
+			body:     "foo foo",
+		},
+		{
+			desc:        "head of the body",
+			debugHeader: "X-Rewritten",
+			response:    Response{Status: "200", RewriteFirstBytes: 3, Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}},
+			body:        "foo foo",
+			expHeader:   "1 r0:2",
+		},
+		{
+			desc:        "named response",
+			debugHeader: "X-Rewritten",
+			response:    Response{Name: "api", Status: "200", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}},
+			body:        "foo foo",
+			expHeader:   "api r0:2",
+		},
+		{
+			desc:        "cached body",
+			debugHeader: "X-Rewritten",
+			response:    Response{Status: "200", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}},
+			body:        "foo foo",
+			requests:    2,
+			expHeader:   "1 r0:2",
+		},
+		{
+			desc:        "sizes",
+			debugHeader: "X-Rewritten",
+			sizes:       true,
+			response:    Response{Status: "200", Rewrites: []Rewrite{{Regex: "foo", Replacement: "quux"}}},
+			body:        "foo foo",
+			expHeader:   "1 r0:2 in=7;out=9",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				DebugHeader:      test.debugHeader,
+				DebugHeaderSizes: test.sizes,
+				CacheSize:        10,
+				Responses: []Response{
+					{Status: "404", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}},
+					test.response,
+				},
+			}
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("ETag", `"foo"`)
+				_, _ = rw.Write([]byte(test.body))
+			}
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			requests := test.requests
+			if requests == 0 {
+				requests = 1
+			}
+			for i := 0; i < requests; i++ {
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+				values := recorder.Header().Values("X-Rewritten")
+				if test.expHeader == "" && len(values) > 0 || test.expHeader != "" && (len(values) != 1 || values[0] != test.expHeader) {
+					t.Errorf("request %d: got header %q, want %q", i, values, test.expHeader)
+				}
+			}
+		})
+	}
+}
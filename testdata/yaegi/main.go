@@ -0,0 +1,311 @@
+// Command main runs requests through the plugin with every optional feature enabled in turn. It is run by
+// yaegi from TestYaegi, with the plugin source loaded from GOPATH as done by Traefik, so that the code paths
+// relying on something yaegi doesn't support fail the tests.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	rewrite "github.com/quortex/traefik-responsebodyrewrite"
+)
+
+type testCase struct {
+	desc    string
+	config  *rewrite.Config
+	next    http.HandlerFunc
+	header  http.Header
+	expBody string
+}
+
+func main() {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, _ = gz.Write([]byte("foo is the new bar"))
+	_ = gz.Close()
+
+	write := func(body string) http.HandlerFunc {
+		return func(rw http.ResponseWriter, req *http.Request) {
+			_, _ = rw.Write([]byte(body))
+		}
+	}
+	rewrites := []rewrite.Rewrite{{Regex: "foo", Replacement: "bar"}}
+
+	rulesFile, err := os.CreateTemp("", "rules-*.yml")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer os.Remove(rulesFile.Name())
+	_, _ = rulesFile.WriteString("- regex: \"new\"\n  replacement: 'old'\n")
+	_ = rulesFile.Close()
+	rulesServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`[{"regex": "new", "replacement": "old"}]`))
+	}))
+	defer rulesServer.Close()
+
+	tests := []testCase{
+		{
+			desc:    "buffered",
+			config:  &rewrite.Config{Responses: []rewrite.Response{{Status: "200", Rewrites: rewrites}}},
+			next:    write("foo is the new bar"),
+			expBody: "bar is the new bar",
+		},
+		{
+			desc:    "stream",
+			config:  &rewrite.Config{Responses: []rewrite.Response{{Status: "200", Stream: true, Rewrites: rewrites}}},
+			next:    write("foo is the new bar"),
+			expBody: "bar is the new bar",
+		},
+		{
+			desc:   "flush",
+			config: &rewrite.Config{Responses: []rewrite.Response{{Status: "200", Rewrites: rewrites}}},
+			next: func(rw http.ResponseWriter, req *http.Request) {
+				_, _ = rw.Write([]byte("foo is "))
+				rw.(http.Flusher).Flush()
+				_, _ = rw.Write([]byte("the new bar"))
+			},
+			expBody: "bar is the new bar",
+		},
+		{
+			desc:   "server-sent events",
+			config: &rewrite.Config{RewriteStreamingResponses: true, Responses: []rewrite.Response{{Status: "200", Rewrites: rewrites}}},
+			next: func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Content-Type", "text/event-stream")
+				_, _ = rw.Write([]byte("data: foo\n\n"))
+			},
+			expBody: "data: bar\n\n",
+		},
+		{
+			desc:    "JSON paths",
+			config:  &rewrite.Config{Responses: []rewrite.Response{{Status: "200", JSONPaths: []string{"items.name"}, Rewrites: rewrites}}},
+			next:    write(`{"items": [{"name": "foo", "id": "foo"}]}`),
+			expBody: `{"items": [{"name": "bar", "id": "foo"}]}`,
+		},
+		{
+			desc:   "head of the body",
+			config: &rewrite.Config{Responses: []rewrite.Response{{Status: "200", RewriteFirstBytes: 4, Rewrites: rewrites}}},
+			next: func(rw http.ResponseWriter, req *http.Request) {
+				_, _ = rw.Write([]byte("foo is "))
+				_, _ = rw.Write([]byte("the new foo"))
+			},
+			expBody: "bar is the new foo",
+		},
+		{
+			desc:    "output limit",
+			config:  &rewrite.Config{Responses: []rewrite.Response{{Status: "200", MaxOutputBytes: 20, Rewrites: []rewrite.Rewrite{{Regex: "foo", Replacement: "a long replacement"}}}}},
+			next:    write("foo is the new bar"),
+			expBody: "foo is the new bar",
+		},
+		{
+			desc: "regexp2 engine",
+			config: &rewrite.Config{Responses: []rewrite.Response{{Status: "200", Rewrites: []rewrite.Rewrite{
+				{Regex: `(\w)\1(?! bar)`, Replacement: "$1", Engine: "regexp2", MatchTimeout: "1s"},
+			}}}},
+			next:    write("foo is the new bar"),
+			expBody: "fo is the new bar",
+		},
+		{
+			desc: "spill",
+			config: &rewrite.Config{
+				SpillThresholdBytes: 4,
+				Responses:           []rewrite.Response{{Status: "200", Rewrites: rewrites}},
+			},
+			next:    write("foo is the new bar"),
+			expBody: "bar is the new bar",
+		},
+		{
+			desc: "limits and telemetry",
+			config: &rewrite.Config{
+				MaxBodySize:           1 << 20,
+				MaxTotalBufferedBytes: 1 << 20,
+				MaxRewriteBytes:       1 << 20,
+				MaxRewriteDuration:    "1s",
+				SlowRewriteThreshold:  "1ns",
+				RewriteTimingHeader:   "X-Rewrite-Duration",
+				RewriteStatsInterval:  "1ns",
+				ErrorLog:              "warn",
+				LogLevel:              "debug",
+				Responses:             []rewrite.Response{{Status: "200", Rewrites: rewrites}},
+			},
+			next:    write("foo is the new bar"),
+			expBody: "bar is the new bar",
+		},
+		{
+			desc: "cache and ETag",
+			config: &rewrite.Config{
+				CacheSize:     10,
+				CacheTTL:      "1m",
+				RecomputeETag: true,
+				Responses:     []rewrite.Response{{Status: "200", Rewrites: rewrites}},
+			},
+			next: func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("ETag", `"upstream"`)
+				_, _ = rw.Write([]byte("foo is the new bar"))
+			},
+			expBody: "bar is the new bar",
+		},
+		{
+			desc: "encoded body",
+			config: &rewrite.Config{
+				SkipEncodedBodies: true,
+				Responses:         []rewrite.Response{{Status: "200", Rewrites: rewrites}},
+			},
+			next: func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Content-Encoding", "gzip")
+				_, _ = rw.Write(compressed.Bytes())
+			},
+			expBody: compressed.String(),
+		},
+		{
+			desc: "headers",
+			config: &rewrite.Config{
+				LogLevel:          "debug",
+				LogFormat:         "json",
+				FreezeHeaders:     true,
+				DisableByteRanges: true,
+				LastStatusWins:    true,
+				Responses:         []rewrite.Response{{Status: "200", Trailers: "strip", Rewrites: rewrites}},
+			},
+			next: func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Trailer", "X-Foo")
+				rw.WriteHeader(http.StatusOK)
+				_, _ = rw.Write([]byte("foo is the new bar"))
+				rw.WriteHeader(http.StatusOK)
+				rw.Header().Set("X-Foo", "bar")
+			},
+			header:  http.Header{"Range": {"bytes=0-2"}},
+			expBody: "bar is the new bar",
+		},
+		{
+			desc: "invalid status code",
+			config: &rewrite.Config{
+				InvalidStatusCode: http.StatusBadGateway,
+				Responses:         []rewrite.Response{{Status: "502", Rewrites: rewrites}},
+			},
+			next: func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(0)
+				_, _ = rw.Write([]byte("foo is the new bar"))
+			},
+			expBody: "bar is the new bar",
+		},
+		{
+			desc: "global rewrites",
+			config: &rewrite.Config{
+				Rewrites:         rewrites,
+				RewritesPosition: "after",
+				Always:           true,
+				Responses:        []rewrite.Response{{Name: "not-found", Status: "404", Rewrites: rewrites}},
+			},
+			next:    write("foo is the new bar"),
+			expBody: "bar is the new bar",
+		},
+		{
+			desc:    "dry run",
+			config:  &rewrite.Config{DryRun: true, Responses: []rewrite.Response{{Status: "200", Rewrites: rewrites}}},
+			next:    write("foo is the new bar"),
+			expBody: "foo is the new bar",
+		},
+		{
+			desc: "warning header, modification logs and metrics",
+			config: &rewrite.Config{
+				AddWarningHeader: true,
+				LogModifications: true,
+				MetricsInterval:  "1ms",
+				LogRateLimit:     rewrite.LogRateLimit{Info: 1},
+				Responses:        []rewrite.Response{{Status: "200", Rewrites: rewrites}},
+			},
+			next:    write("foo is the new bar"),
+			expBody: "bar is the new bar",
+		},
+		{
+			desc: "statsd metrics",
+			config: &rewrite.Config{
+				MetricsInterval:      "0",
+				MetricsAddr:          "127.0.0.1:9",
+				MetricsFlushInterval: "1ms",
+				MetricsTags:          true,
+				Responses:            []rewrite.Response{{Status: "200", Rewrites: rewrites}},
+			},
+			next:    write("foo is the new bar"),
+			expBody: "bar is the new bar",
+		},
+		{
+			desc: "debug dump",
+			config: &rewrite.Config{
+				DebugPath:           "/",
+				DebugToken:          "token",
+				DebugRedactPatterns: true,
+				MetricsInterval:     "0",
+				Responses:           []rewrite.Response{{Status: "200", Rewrites: rewrites}},
+			},
+			next:    write("foo is the new bar"),
+			header:  http.Header{"X-Rewrite-Debug-Token": {"token"}},
+			expBody: "{\n  \"name\": \"yaegi\",\n  \"responses\": [\n    {\n      \"id\": \"0\",\n      \"index\": 0,\n      \"status\": \"200\",\n      \"rewrites\": [\n        {\n          \"regex\": \"[redacted]\",\n          \"replacement\": \"[redacted]\"\n        }\n      ]\n    }\n  ]\n}",
+		},
+		{
+			desc:    "rules file",
+			config:  &rewrite.Config{Responses: []rewrite.Response{{Status: "200", Rewrites: rewrites, RulesFile: rulesFile.Name()}}},
+			next:    write("foo is the new bar"),
+			expBody: "bar is the old bar",
+		},
+		{
+			desc: "rules URL",
+			config: &rewrite.Config{
+				RulesRefreshInterval: "1h",
+				Responses:            []rewrite.Response{{Status: "200", Rewrites: rewrites, RulesURL: rulesServer.URL}},
+			},
+			next:    write("foo is the new bar"),
+			expBody: "bar is the old bar",
+		},
+		{
+			desc: "panic",
+			config: &rewrite.Config{
+				RecoverPanics: true,
+				Responses:     []rewrite.Response{{Status: "500", Rewrites: []rewrite.Rewrite{{Regex: ".*", Replacement: "oops"}}}},
+			},
+			next: func(rw http.ResponseWriter, req *http.Request) {
+				panic("boom")
+			},
+			expBody: "oops",
+		},
+	}
+
+	failed := false
+	for _, test := range tests {
+		if err := run(test); err != nil {
+			fmt.Printf("FAIL %s: %v\n", test.desc, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("ok   %s\n", test.desc)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func run(test testCase) error {
+	handler, err := rewrite.New(context.Background(), test.next, test.config, "yaegi")
+	if err != nil {
+		return err
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for name, values := range test.header {
+		req.Header[name] = values
+	}
+	handler.ServeHTTP(recorder, req)
+
+	if body := recorder.Body.String(); body != test.expBody {
+		return fmt.Errorf("got body %q, want %q", body, test.expBody)
+	}
+	return nil
+}
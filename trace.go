@@ -0,0 +1,92 @@
+package traefik_responsebodyrewrite
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// The values of the traceContext option, telling the headers the trace context of the requests is read from.
+const (
+	traceContextTraceparent = "traceparent"
+	traceContextB3          = "b3"
+)
+
+// validateTraceContext checks the traceContext option, an empty value meaning traceparent.
+func validateTraceContext(traceContext string) error {
+	switch traceContext {
+	case "", traceContextTraceparent, traceContextB3:
+		return nil
+	default:
+		return fmt.Errorf("invalid traceContext %q: must be %q or %q", traceContext, traceContextTraceparent, traceContextB3)
+	}
+}
+
+// traceIDs returns the trace and span IDs of req, read from the headers of the traceContext option. They are
+// empty when the request has no trace context, or an invalid one, which is not worth a message of its own.
+func (r *responsebodyrewrite) traceIDs(req *http.Request) (string, string) {
+	if req == nil {
+		return "", ""
+	}
+	if r.traceContext == traceContextB3 {
+		return parseB3(req.Header)
+	}
+	return parseTraceparent(req.Header.Get("Traceparent"))
+}
+
+// parseTraceparent returns the trace and span IDs of a W3C traceparent header, e.g.
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01". The headers of the versions after 00 may have
+// more fields, which are ignored.
+func parseTraceparent(value string) (string, string) {
+	const size = len("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if len(value) < size || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return "", ""
+	}
+	version, traceID, spanID, flags := value[:2], value[3:35], value[36:52], value[53:55]
+	if !isLowerHex(version) || version == "ff" || !isLowerHex(flags) {
+		return "", ""
+	}
+	if len(value) > size && (version == "00" || value[size] != '-') {
+		return "", ""
+	}
+	if !validTraceID(traceID) || !validTraceID(spanID) {
+		return "", ""
+	}
+	return traceID, spanID
+}
+
+// parseB3 returns the trace and span IDs of the B3 headers: the single b3 header, e.g.
+// "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1", or else the X-B3-TraceId and X-B3-SpanId headers.
+// The trace IDs are 16 or 32 hexadecimal characters long.
+func parseB3(header http.Header) (string, string) {
+	var traceID, spanID string
+	if value := header.Get("B3"); value != "" {
+		// A value made of the sampling state only, such as "0", has no IDs.
+		fields := strings.Split(value, "-")
+		if len(fields) < 2 {
+			return "", ""
+		}
+		traceID, spanID = fields[0], fields[1]
+	} else {
+		traceID, spanID = header.Get("X-B3-TraceId"), header.Get("X-B3-SpanId")
+	}
+	if (len(traceID) != 16 && len(traceID) != 32) || len(spanID) != 16 || !validTraceID(traceID) || !validTraceID(spanID) {
+		return "", ""
+	}
+	return traceID, spanID
+}
+
+// validTraceID reports whether id is a trace or span ID: lowercase hexadecimal characters, not all zeros.
+func validTraceID(id string) bool {
+	return isLowerHex(id) && strings.Trim(id, "0") != ""
+}
+
+// isLowerHex reports whether s is only made of lowercase hexadecimal characters.
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if (s[i] < '0' || s[i] > '9') && (s[i] < 'a' || s[i] > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		desc       string
+		value      string
+		expTraceID string
+		expSpanID  string
+	}{
+		{desc: "valid", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", expTraceID: "4bf92f3577b34da6a3ce929d0e0e4736", expSpanID: "00f067aa0ba902b7"},
+		{desc: "future version", value: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", expTraceID: "4bf92f3577b34da6a3ce929d0e0e4736", expSpanID: "00f067aa0ba902b7"},
+		{desc: "none"},
+		{desc: "extra field in version 00", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"},
+		{desc: "invalid version", value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{desc: "uppercase", value: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01"},
+		{desc: "zero trace ID", value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{desc: "zero span ID", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+		{desc: "short", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7"},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			traceID, spanID := parseTraceparent(test.value)
+			if traceID != test.expTraceID || spanID != test.expSpanID {
+				t.Errorf("got %q, %q, want %q, %q", traceID, spanID, test.expTraceID, test.expSpanID)
+			}
+		})
+	}
+}
+
+func TestParseB3(t *testing.T) {
+	tests := []struct {
+		desc       string
+		header     http.Header
+		expTraceID string
+		expSpanID  string
+	}{
+		{
+			desc:       "single header",
+			header:     http.Header{"B3": {"80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90"}},
+			expTraceID: "80f198ee56343ba864fe8b2a57d3eff7",
+			expSpanID:  "e457b5a2e4d86bd1",
+		},
+		{
+			desc:       "multiple headers",
+			header:     http.Header{"X-B3-Traceid": {"a3ce929d0e0e4736"}, "X-B3-Spanid": {"00f067aa0ba902b7"}},
+			expTraceID: "a3ce929d0e0e4736",
+			expSpanID:  "00f067aa0ba902b7",
+		},
+		{desc: "sampling state only", header: http.Header{"B3": {"0"}}},
+		{desc: "invalid trace ID length", header: http.Header{"B3": {"f198ee56343ba8-e457b5a2e4d86bd1"}}},
+		{desc: "none", header: http.Header{}},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			traceID, spanID := parseB3(test.header)
+			if traceID != test.expTraceID || spanID != test.expSpanID {
+				t.Errorf("got %q, %q, want %q, %q", traceID, spanID, test.expTraceID, test.expSpanID)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_traceIDs(t *testing.T) {
+	tests := []struct {
+		desc    string
+		config  Config
+		header  http.Header
+		expLine string
+	}{
+		{
+			desc:    "traceparent",
+			header:  http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}},
+			expLine: "response=new replacements=1 original_size=3 size=3 trace_id=4bf92f3577b34da6a3ce929d0e0e4736 span_id=00f067aa0ba902b7",
+		},
+		{
+			desc:    "request ID",
+			header:  http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}, "X-Request-Id": {"abc"}},
+			expLine: `size=3 request_id="abc" trace_id=4bf92f3577b34da6a3ce929d0e0e4736 span_id=00f067aa0ba902b7`,
+		},
+		{
+			desc:    "b3",
+			config:  Config{TraceContext: traceContextB3},
+			header:  http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}, "B3": {"a3ce929d0e0e4736-e457b5a2e4d86bd1"}},
+			expLine: "size=3 trace_id=a3ce929d0e0e4736 span_id=e457b5a2e4d86bd1",
+		},
+		{
+			desc:    "no trace context",
+			header:  http.Header{},
+			expLine: "response=new replacements=1 original_size=3 size=3",
+		},
+		{
+			desc:    "JSON format",
+			config:  Config{LogFormat: logFormatJSON},
+			header:  http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}},
+			expLine: `"response_name":"new","trace_id":"4bf92f3577b34da6a3ce929d0e0e4736","span_id":"00f067aa0ba902b7","total_replacements":1,"original_size":3,"size":3}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := test.config
+			config.LogModifications = true
+			config.Responses = []Response{{Name: "new", Status: "200", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}}}
+			var logs bytes.Buffer
+			handler, err := NewMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				_, _ = rw.Write([]byte("foo"))
+			}), WithConfig(&config), WithName("rewriteBody"), WithLogger(log.New(&logs, "", 0)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header = test.header
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if line := strings.TrimSuffix(logs.String(), "\n"); !strings.HasSuffix(line, test.expLine) {
+				t.Errorf("got line %q, want it to end with %q", line, test.expLine)
+			}
+		})
+	}
+
+	_, err := New(context.Background(), http.NotFoundHandler(), &Config{TraceContext: "zipkin"}, "rewriteBody")
+	if expErr := `invalid traceContext "zipkin": must be "traceparent" or "b3"`; err == nil || err.Error() != expErr {
+		t.Errorf("got error %v, want %q", err, expErr)
+	}
+}
@@ -0,0 +1,54 @@
+package traefik_responsebodyrewrite
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Values of the trailers option of a response.
+const (
+	trailersForward = "forward"
+	trailersStrip   = "strip"
+)
+
+// declaredTrailers returns the canonical names of the trailers announced by the Trailer header.
+func declaredTrailers(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Trailer") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// stripTrailers reports whether the trailers of the response are removed.
+func (rw *responseWriter) stripTrailers() bool {
+	return rw.response != nil && rw.response.stripTrailers
+}
+
+// forwardsTrailers reports whether the upstream announced trailers which are going to be sent.
+func (rw *responseWriter) forwardsTrailers() bool {
+	return !rw.stripTrailers() && rw.ResponseWriter.Header().Get("Trailer") != ""
+}
+
+// finishTrailers completes the trailers once the upstream handler has returned: the trailers set in the
+// detached header map of freezeHeaders are copied, and with the strip option, the trailers set without
+// being announced are removed.
+func (rw *responseWriter) finishTrailers() {
+	if rw.hijacked {
+		return
+	}
+	rw.copyFrozenTrailers()
+
+	if rw.stripTrailers() {
+		header := rw.ResponseWriter.Header()
+		for name := range header {
+			if strings.HasPrefix(name, http.TrailerPrefix) {
+				delete(header, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestServeHTTP_trailers(t *testing.T) {
+	tests := []struct {
+		desc       string
+		trailers   string
+		body       string
+		expBody    string
+		expTrailer string
+	}{
+		{
+			desc:       "should forward the trailers of a rewritten body",
+			body:       "foo is the new bar",
+			expBody:    "bar is the new bar",
+			expTrailer: "late",
+		},
+		{
+			desc:       "should forward the trailers of a body left unmodified",
+			body:       "baz is the new bar",
+			expBody:    "baz is the new bar",
+			expTrailer: "late",
+		},
+		{
+			desc:     "should strip the trailers",
+			trailers: trailersStrip,
+			body:     "foo is the new bar",
+			expBody:  "bar is the new bar",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				Responses: []Response{
+					{
+						Status:   "200",
+						Trailers: test.trailers,
+						Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+					},
+				},
+			}
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Trailer", "X-Foo")
+				rw.Header().Set("Content-Length", strconv.Itoa(len(test.body)))
+				_, _ = rw.Write([]byte(test.body))
+				rw.Header().Set("X-Foo", "late")
+				rw.Header().Set(http.TrailerPrefix+"X-Bar", "undeclared")
+			}
+
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			server := httptest.NewServer(rewriteBody)
+			defer server.Close()
+
+			res, err := server.Client().Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = res.Body.Close() }()
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(body) != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+			if header := res.Header.Get("X-Foo"); header != "" {
+				t.Errorf("got trailer X-Foo sent as a header %q, want none", header)
+			}
+			if test.expTrailer == "" {
+				if header := res.Header.Get("Trailer"); header != "" {
+					t.Errorf("got Trailer header %q, want none", header)
+				}
+				if len(res.Trailer) != 0 {
+					t.Errorf("got trailers %v, want none", res.Trailer)
+				}
+				return
+			}
+			if trailer := res.Trailer.Get("X-Foo"); trailer != test.expTrailer {
+				t.Errorf("got X-Foo trailer %q, want %q", trailer, test.expTrailer)
+			}
+			if trailer := res.Trailer.Get("X-Bar"); trailer != "undeclared" {
+				t.Errorf("got X-Bar trailer %q, want %q", trailer, "undeclared")
+			}
+		})
+	}
+}
+
+func TestNew_trailers(t *testing.T) {
+	config := &Config{
+		Responses: []Response{{Status: "200", Trailers: "drop"}},
+	}
+	if _, err := New(context.Background(), http.NotFoundHandler(), config, "rewriteBody"); err == nil {
+		t.Error("expected an error for an invalid trailers option")
+	}
+}
@@ -1,37 +1,144 @@
 package traefik_responsebodyrewrite
 
 import (
+	"encoding/json"
+	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 )
 
+// StatusCodes are the status codes of the responses of a response block: status codes and ranges of status
+// codes separated by commas, e.g. "200-299,404". In JSON, it can also be a number, or a list of strings and
+// numbers, the elements of which are joined by commas.
+type StatusCodes string
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (s *StatusCodes) UnmarshalJSON(data []byte) error {
+	var list []json.RawMessage
+	if err := json.Unmarshal(data, &list); err != nil {
+		block, err := unmarshalStatusBlock(data)
+		if err != nil {
+			return err
+		}
+		*s = StatusCodes(block)
+		return nil
+	}
+
+	blocks := make([]string, len(list))
+	for i, element := range list {
+		block, err := unmarshalStatusBlock(element)
+		if err != nil {
+			return err
+		}
+		blocks[i] = block
+	}
+	*s = StatusCodes(strings.Join(blocks, ","))
+	return nil
+}
+
+// unmarshalStatusBlock unmarshals a JSON string, or a JSON number, of status codes.
+func unmarshalStatusBlock(data []byte) (string, error) {
+	var block string
+	if err := json.Unmarshal(data, &block); err == nil {
+		return block, nil
+	}
+	var code json.Number
+	if err := json.Unmarshal(data, &code); err != nil {
+		return "", fmt.Errorf("invalid status %s: must be a string, a number, or a list of strings and numbers", data)
+	}
+	return code.String(), nil
+}
+
+// blocks returns the status codes and ranges of status codes, to be parsed by NewHTTPCodeRanges.
+func (s StatusCodes) blocks() []string {
+	blocks := strings.Split(string(s), ",")
+	for i, block := range blocks {
+		blocks[i] = strings.TrimSpace(block)
+	}
+	return blocks
+}
+
 // HTTPCodeRanges holds HTTP code ranges.
 type HTTPCodeRanges [][2]int
 
+// statusCodeRanges creates the HTTPCodeRanges of a list of status codes, each between 100 and 599.
+func statusCodeRanges(codes []int) (HTTPCodeRanges, error) {
+	ranges := make(HTTPCodeRanges, len(codes))
+	for i, code := range codes {
+		if code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid statusCodes[%d] %d: must be between 100 and 599", i, code)
+		}
+		ranges[i] = [2]int{code, code}
+	}
+	return ranges, nil
+}
+
+// statusNames are the names accepted in place of a status code or a range of status codes, for readability.
+var statusNames = map[string][2]int{
+	"ok":           {200, 200},
+	"created":      {201, 201},
+	"no-content":   {204, 204},
+	"success":      {200, 299},
+	"redirection":  {300, 399},
+	"not-modified": {304, 304},
+	"client-error": {400, 499},
+	"bad-request":  {400, 400},
+	"unauthorized": {401, 401},
+	"forbidden":    {403, 403},
+	"not-found":    {404, 404},
+	"server-error": {500, 599},
+}
+
 // NewHTTPCodeRanges creates HTTPCodeRanges from a given []string.
 // Break out the http status code ranges into a low int and high int
 // for ease of use at runtime.
+// The blocks are status codes, ranges of status codes, or names such as "not-found", and the spaces around
+// them and around the "-" of a range are ignored.
 func NewHTTPCodeRanges(strBlocks []string) (HTTPCodeRanges, error) {
 	var blocks HTTPCodeRanges
 	for _, block := range strBlocks {
-		codes := strings.Split(block, "-")
-		// if only a single HTTP code was configured, assume the best and create the correct configuration on the user's behalf
-		if len(codes) == 1 {
-			codes = append(codes, codes[0])
-		}
-		lowCode, err := strconv.Atoi(codes[0])
+		block, err := parseStatusBlock(block)
 		if err != nil {
 			return nil, err
 		}
-		highCode, err := strconv.Atoi(codes[1])
-		if err != nil {
-			return nil, err
-		}
-		blocks = append(blocks, [2]int{lowCode, highCode})
+		blocks = append(blocks, block)
 	}
 	return blocks, nil
 }
 
+// parseStatusBlock parses a status code, a range of status codes or the name of a status code. Its errors
+// quote the block without its spaces.
+func parseStatusBlock(block string) ([2]int, error) {
+	block = strings.TrimSpace(block)
+	if codes, ok := statusNames[strings.ToLower(block)]; ok {
+		return codes, nil
+	}
+
+	codes := strings.Split(block, "-")
+	for i, code := range codes {
+		codes[i] = strings.TrimSpace(code)
+	}
+	normalized := strings.Join(codes, "-")
+	// if only a single HTTP code was configured, assume the best and create the correct configuration on the user's behalf
+	if len(codes) == 1 {
+		codes = append(codes, codes[0])
+	}
+	invalid := fmt.Errorf("invalid block %q: must be a status code, a range such as 200-299, or a name such as not-found", normalized)
+	if len(codes) != 2 {
+		return [2]int{}, invalid
+	}
+	lowCode, err := strconv.Atoi(codes[0])
+	if err != nil {
+		return [2]int{}, invalid
+	}
+	highCode, err := strconv.Atoi(codes[1])
+	if err != nil {
+		return [2]int{}, invalid
+	}
+	return [2]int{lowCode, highCode}, nil
+}
+
 // Contains tests whether the passed status code is within one of its HTTP code ranges.
 func (h HTTPCodeRanges) Contains(statusCode int) bool {
 	for _, block := range h {
@@ -41,3 +148,85 @@ func (h HTTPCodeRanges) Contains(statusCode int) bool {
 	}
 	return false
 }
+
+// intersect returns the status codes of h which are also in other.
+func (h HTTPCodeRanges) intersect(other HTTPCodeRanges) HTTPCodeRanges {
+	var common HTTPCodeRanges
+	for _, a := range h {
+		for _, b := range other {
+			low, high := a[0], a[1]
+			if b[0] > low {
+				low = b[0]
+			}
+			if b[1] < high {
+				high = b[1]
+			}
+			if low <= high {
+				common = append(common, [2]int{low, high})
+			}
+		}
+	}
+	return common
+}
+
+// subtract returns the status codes of h which are not in other.
+func (h HTTPCodeRanges) subtract(other HTTPCodeRanges) HTTPCodeRanges {
+	remaining := h
+	for _, b := range other {
+		var next HTTPCodeRanges
+		for _, a := range remaining {
+			if b[1] < a[0] || b[0] > a[1] {
+				next = append(next, a)
+				continue
+			}
+			if a[0] < b[0] {
+				next = append(next, [2]int{a[0], b[0] - 1})
+			}
+			if b[1] < a[1] {
+				next = append(next, [2]int{b[1] + 1, a[1]})
+			}
+		}
+		remaining = next
+	}
+	return remaining
+}
+
+// Normalize returns the ranges sorted, the overlapping and adjacent ranges being merged, e.g. 200-299,300-302
+// for 200-250,240-299,301,300-302, which contains the same status codes with fewer comparisons. The ranges
+// whose low code is above the high code, which contain nothing, are dropped. h is not modified.
+func (h HTTPCodeRanges) Normalize() HTTPCodeRanges {
+	sorted := make(HTTPCodeRanges, 0, len(h))
+	for _, block := range h {
+		if block[0] <= block[1] {
+			sorted = append(sorted, block)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i][0] < sorted[j][0]
+	})
+
+	var normalized HTTPCodeRanges
+	for _, block := range sorted {
+		if last := len(normalized) - 1; last >= 0 && block[0] <= normalized[last][1]+1 {
+			if block[1] > normalized[last][1] {
+				normalized[last][1] = block[1]
+			}
+			continue
+		}
+		normalized = append(normalized, block)
+	}
+	return normalized
+}
+
+// String returns the ranges in the format of the configuration, e.g. "200-299,404", which is canonical once
+// they are normalized.
+func (h HTTPCodeRanges) String() string {
+	blocks := make([]string, len(h))
+	for i, block := range h {
+		blocks[i] = strconv.Itoa(block[0])
+		if block[1] != block[0] {
+			blocks[i] += "-" + strconv.Itoa(block[1])
+		}
+	}
+	return strings.Join(blocks, ",")
+}
@@ -1,6 +1,10 @@
 package traefik_responsebodyrewrite
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 )
@@ -102,6 +106,30 @@ func TestNewHTTPCodeRanges(t *testing.T) {
 			expected:  nil,
 			expectErr: true,
 		},
+		{
+			desc:      "should ignore the spaces around the blocks and the dashes",
+			strBlocks: []string{" 200 - 299", "404 "},
+			expected:  HTTPCodeRanges{{200, 299}, {404, 404}},
+			expectErr: false,
+		},
+		{
+			desc:      "should create HTTPCodeRanges with status names",
+			strBlocks: []string{"ok", " Not-Found ", "server-error"},
+			expected:  HTTPCodeRanges{{200, 200}, {404, 404}, {500, 599}},
+			expectErr: false,
+		},
+		{
+			desc:      "should return error for too many dashes",
+			strBlocks: []string{"200-299-399"},
+			expected:  nil,
+			expectErr: true,
+		},
+		{
+			desc:      "should return error for an unknown name",
+			strBlocks: []string{"teapot"},
+			expected:  nil,
+			expectErr: true,
+		},
 	}
 
 	for _, test := range tests {
@@ -119,3 +147,281 @@ func TestNewHTTPCodeRanges(t *testing.T) {
 		})
 	}
 }
+
+func TestStatusCodes_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		desc      string
+		json      string
+		expStatus StatusCodes
+		expRanges HTTPCodeRanges
+		expErr    bool
+	}{
+		{
+			desc:      "string",
+			json:      `"200-299"`,
+			expStatus: "200-299",
+			expRanges: HTTPCodeRanges{{200, 299}},
+		},
+		{
+			desc:      "comma separated string",
+			json:      `"200-299, 404"`,
+			expStatus: "200-299, 404",
+			expRanges: HTTPCodeRanges{{200, 299}, {404, 404}},
+		},
+		{
+			desc:      "number",
+			json:      `404`,
+			expStatus: "404",
+			expRanges: HTTPCodeRanges{{404, 404}},
+		},
+		{
+			desc:      "list",
+			json:      `["200-299", "404,410", 500]`,
+			expStatus: "200-299,404,410,500",
+			expRanges: HTTPCodeRanges{{200, 299}, {404, 404}, {410, 410}, {500, 500}},
+		},
+		{
+			desc:   "boolean",
+			json:   `true`,
+			expErr: true,
+		},
+		{
+			desc:   "nested list",
+			json:   `[["200"]]`,
+			expErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var response Response
+			err := json.Unmarshal([]byte(`{"status": `+test.json+`}`), &response)
+			if (err != nil) != test.expErr {
+				t.Fatalf("got error %v, want error %t", err, test.expErr)
+			}
+			if test.expErr {
+				return
+			}
+			if response.Status != test.expStatus {
+				t.Errorf("got status %q, want %q", response.Status, test.expStatus)
+			}
+			ranges, err := NewHTTPCodeRanges(response.Status.blocks())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ranges, test.expRanges) {
+				t.Errorf("got ranges %v, want %v", ranges, test.expRanges)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_statusList(t *testing.T) {
+	config := &Config{}
+	err := json.Unmarshal([]byte(`{"responses": [{"status": ["200", "404-410"], "rewrites": [{"regex": "foo", "replacement": "bar"}]}]}`), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status := range []int{http.StatusOK, http.StatusNotFound, http.StatusGone} {
+		next := func(rw http.ResponseWriter, req *http.Request) {
+			rw.WriteHeader(status)
+			_, _ = rw.Write([]byte("foo"))
+		}
+		handler, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if body := recorder.Body.String(); body != "bar" {
+			t.Errorf("status %d: got body %q, want %q", status, body, "bar")
+		}
+	}
+}
+
+func TestHTTPCodeRanges_intersect(t *testing.T) {
+	tests := []struct {
+		desc     string
+		ranges   HTTPCodeRanges
+		other    HTTPCodeRanges
+		expected HTTPCodeRanges
+	}{
+		{
+			desc:   "disjoint",
+			ranges: HTTPCodeRanges{{200, 299}},
+			other:  HTTPCodeRanges{{400, 499}},
+		},
+		{
+			desc:     "included",
+			ranges:   HTTPCodeRanges{{204, 204}},
+			other:    HTTPCodeRanges{{200, 299}},
+			expected: HTTPCodeRanges{{204, 204}},
+		},
+		{
+			desc:     "partial",
+			ranges:   HTTPCodeRanges{{200, 299}, {404, 404}},
+			other:    HTTPCodeRanges{{250, 410}},
+			expected: HTTPCodeRanges{{250, 299}, {404, 404}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if common := test.ranges.intersect(test.other); !reflect.DeepEqual(common, test.expected) {
+				t.Errorf("got %v, want %v", common, test.expected)
+			}
+		})
+	}
+}
+
+func TestHTTPCodeRanges_subtract(t *testing.T) {
+	tests := []struct {
+		desc     string
+		ranges   HTTPCodeRanges
+		other    HTTPCodeRanges
+		expected HTTPCodeRanges
+	}{
+		{
+			desc:     "disjoint",
+			ranges:   HTTPCodeRanges{{200, 299}},
+			other:    HTTPCodeRanges{{400, 499}},
+			expected: HTTPCodeRanges{{200, 299}},
+		},
+		{
+			desc:   "covered",
+			ranges: HTTPCodeRanges{{204, 204}},
+			other:  HTTPCodeRanges{{200, 299}},
+		},
+		{
+			desc:     "middle",
+			ranges:   HTTPCodeRanges{{200, 299}},
+			other:    HTTPCodeRanges{{204, 204}, {250, 260}},
+			expected: HTTPCodeRanges{{200, 203}, {205, 249}, {261, 299}},
+		},
+		{
+			desc:   "covered by several ranges",
+			ranges: HTTPCodeRanges{{200, 299}},
+			other:  HTTPCodeRanges{{200, 249}, {250, 299}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if remaining := test.ranges.subtract(test.other); !reflect.DeepEqual(remaining, test.expected) {
+				t.Errorf("got %v, want %v", remaining, test.expected)
+			}
+		})
+	}
+}
+
+func TestHTTPCodeRanges_Normalize(t *testing.T) {
+	tests := []struct {
+		desc     string
+		ranges   HTTPCodeRanges
+		expected HTTPCodeRanges
+		expStr   string
+	}{
+		{
+			desc:     "should merge overlapping and adjacent ranges",
+			ranges:   HTTPCodeRanges{{200, 250}, {240, 299}, {301, 301}, {300, 302}},
+			expected: HTTPCodeRanges{{200, 302}},
+			expStr:   "200-302",
+		},
+		{
+			desc:     "should sort the ranges",
+			ranges:   HTTPCodeRanges{{500, 599}, {404, 404}, {200, 299}},
+			expected: HTTPCodeRanges{{200, 299}, {404, 404}, {500, 599}},
+			expStr:   "200-299,404,500-599",
+		},
+		{
+			desc:     "should keep ranges contained by another one once",
+			ranges:   HTTPCodeRanges{{200, 299}, {204, 204}, {200, 299}},
+			expected: HTTPCodeRanges{{200, 299}},
+			expStr:   "200-299",
+		},
+		{
+			desc:     "should drop empty ranges",
+			ranges:   HTTPCodeRanges{{299, 200}, {404, 404}},
+			expected: HTTPCodeRanges{{404, 404}},
+			expStr:   "404",
+		},
+		{
+			desc:   "should return nothing for no ranges",
+			expStr: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			original := append(HTTPCodeRanges(nil), test.ranges...)
+			normalized := test.ranges.Normalize()
+			if !reflect.DeepEqual(normalized, test.expected) {
+				t.Errorf("got %v, want %v", normalized, test.expected)
+			}
+			if normalized.String() != test.expStr {
+				t.Errorf("got string %q, want %q", normalized.String(), test.expStr)
+			}
+			if !reflect.DeepEqual(test.ranges, original) {
+				t.Errorf("got ranges modified into %v, want %v", test.ranges, original)
+			}
+		})
+	}
+}
+
+func TestNew_statusCodes(t *testing.T) {
+	tests := []struct {
+		desc      string
+		response  map[string]interface{}
+		expRanges HTTPCodeRanges
+		expErr    string
+	}{
+		{
+			desc:      "numbers",
+			response:  map[string]interface{}{"statusCodes": []interface{}{float64(404), float64(200), float64(201)}},
+			expRanges: HTTPCodeRanges{{200, 201}, {404, 404}},
+		},
+		{
+			desc:     "out of range",
+			response: map[string]interface{}{"statusCodes": []interface{}{float64(200), float64(600)}},
+			expErr:   "responses[0]: invalid statusCodes[1] 600: must be between 100 and 599",
+		},
+		{
+			desc:     "both fields",
+			response: map[string]interface{}{"status": "200", "statusCodes": []interface{}{float64(200)}},
+			expErr:   "responses[0]: status and statusCodes can't both be set",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			// Traefik gives the configuration of the plugins as maps, whose numbers are float64.
+			test.response["rewrites"] = []interface{}{map[string]interface{}{"regex": "foo", "replacement": "bar"}}
+			data, err := json.Marshal(map[string]interface{}{"responses": []interface{}{test.response}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			config := CreateConfig()
+			if err := json.Unmarshal(data, config); err != nil {
+				t.Fatal(err)
+			}
+
+			handler, err := New(context.Background(), http.NotFoundHandler(), config, "rewriteBody")
+			if test.expErr != "" {
+				if err == nil || err.Error() != test.expErr {
+					t.Errorf("got error %v, want %q", err, test.expErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ranges := handler.(*responsebodyrewrite).responses[0].status; !reflect.DeepEqual(ranges, test.expRanges) {
+				t.Errorf("got ranges %v, want %v", ranges, test.expRanges)
+			}
+		})
+	}
+
+	var config Config
+	if err := json.Unmarshal([]byte(`{"responses": [{"statusCodes": [200.5]}]}`), &config); err == nil {
+		t.Error("expected an error for a status code which is not an integer")
+	}
+}
@@ -0,0 +1,99 @@
+package traefik_responsebodyrewrite
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"regexp"
+)
+
+// Variant selects the requests of an experiment group, assigned upstream in a request header or a cookie,
+// for a response block to rewrite their responses only.
+type Variant struct {
+	// Header is the name of the request header holding the group.
+	Header string `json:"header,omitempty"`
+	// Cookie is the name of the cookie holding the group, in place of Header.
+	Cookie string `json:"cookie,omitempty"`
+	// Value is a regex which must match the whole value of the header or the cookie, e.g. "B" or "B|C". A
+	// request without the header or the cookie has an empty value, so that an empty Value selects the
+	// control group.
+	Value string `json:"value,omitempty"`
+}
+
+// parsedVariant is a parsed Variant.
+type parsedVariant struct {
+	// header is the canonical name of the request header holding the group, empty if it is held by cookie.
+	header string
+	cookie string
+	value  *regexp.Regexp
+}
+
+// parseVariant parses the variant of a response block, nil if it has none.
+func parseVariant(variant *Variant) (*parsedVariant, error) {
+	if variant == nil {
+		return nil, nil
+	}
+	switch {
+	case variant.Header != "" && variant.Cookie != "":
+		return nil, errors.New("header and cookie can't both be set")
+	case variant.Header == "" && variant.Cookie == "":
+		return nil, errors.New("header or cookie must be set")
+	}
+	value, err := regexp.Compile("^(?:" + variant.Value + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q: %w", variant.Value, err)
+	}
+	return &parsedVariant{
+		header: textproto.CanonicalMIMEHeaderKey(variant.Header),
+		cookie: variant.Cookie,
+		value:  value,
+	}, nil
+}
+
+// selects reports whether req belongs to the group of the variant. Only the first value of a header sent
+// several times, or of a cookie, is considered.
+func (v *parsedVariant) selects(req *http.Request) bool {
+	var value string
+	if v.header != "" {
+		value = req.Header.Get(v.header)
+	} else if cookie, err := req.Cookie(v.cookie); err == nil {
+		value = cookie.Value
+	}
+	return v.value.MatchString(value)
+}
+
+// varyField returns the field name to add to the Vary header of the responses depending on the variant.
+func (v *parsedVariant) varyField() string {
+	if v.header != "" {
+		return v.header
+	}
+	return "Cookie"
+}
+
+// String describes the variant in the debug dump.
+func (v *parsedVariant) String() string {
+	if v.header != "" {
+		return fmt.Sprintf("header %s matching %s", v.header, v.value)
+	}
+	return fmt.Sprintf("cookie %s matching %s", v.cookie, v.value)
+}
+
+// addVariantVary adds to the Vary header the fields telling the variants of the response blocks consulted to
+// select the one matching statusCode, so that shared caches don't serve the body rewritten for a group to
+// another one.
+func (rw *responseWriter) addVariantVary(statusCode int) {
+	for i := range rw.responses {
+		response := &rw.responses[i]
+		if response.disabled || !response.status.Contains(statusCode) {
+			continue
+		}
+		if response.variant == nil {
+			return
+		}
+		addVary(rw.ResponseWriter.Header(), response.variant.varyField())
+		if response.variant.selects(rw.request) {
+			return
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package traefik_responsebodyrewrite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseVariant(t *testing.T) {
+	tests := []struct {
+		desc       string
+		variant    *Variant
+		header     string
+		expSelects bool
+		expErr     string
+	}{
+		{desc: "header", variant: &Variant{Header: "x-experiment-group", Value: "B"}, header: "B", expSelects: true},
+		{desc: "other group", variant: &Variant{Header: "X-Experiment-Group", Value: "B"}, header: "C"},
+		{desc: "prefix", variant: &Variant{Header: "X-Experiment-Group", Value: "B"}, header: "BB"},
+		{desc: "alternatives", variant: &Variant{Header: "X-Experiment-Group", Value: "B|C"}, header: "C", expSelects: true},
+		{desc: "control", variant: &Variant{Header: "X-Experiment-Group"}, expSelects: true},
+		{desc: "not control", variant: &Variant{Header: "X-Experiment-Group"}, header: "B"},
+		{desc: "missing header", variant: &Variant{Header: "X-Experiment-Group", Value: "B"}},
+		{desc: "header and cookie", variant: &Variant{Header: "X-Experiment-Group", Cookie: "group"}, expErr: "header and cookie can't both be set"},
+		{desc: "neither header nor cookie", variant: &Variant{Value: "B"}, expErr: "header or cookie must be set"},
+		{desc: "invalid value", variant: &Variant{Header: "X-Experiment-Group", Value: "B("}, expErr: "invalid value \"B(\": error parsing regexp: missing closing ): `^(?:B()$`"},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			variant, err := parseVariant(test.variant)
+			if test.expErr != "" {
+				if err == nil || err.Error() != test.expErr {
+					t.Errorf("got error %v, want %q", err, test.expErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.header != "" {
+				req.Header.Set("X-Experiment-Group", test.header)
+			}
+			if selects := variant.selects(req); selects != test.expSelects {
+				t.Errorf("got selects %t, want %t", selects, test.expSelects)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_variant(t *testing.T) {
+	handler, err := NewMiddleware(
+		http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			rw.Header().Set("ETag", `"v1"`)
+			_, _ = rw.Write([]byte("foo"))
+		}),
+		WithConfig(&Config{CacheSize: 10}),
+		WithResponses(
+			Response{Status: "200", Variant: &Variant{Header: "X-Experiment-Group", Value: "B"}, Rewrites: []Rewrite{{Regex: "foo", Replacement: "header B"}}},
+			Response{Status: "200", Variant: &Variant{Cookie: "group", Value: "C"}, Rewrites: []Rewrite{{Regex: "foo", Replacement: "cookie C"}}},
+			Response{Status: "200", Rewrites: []Rewrite{{Regex: "foo", Replacement: "control"}}},
+		),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc    string
+		header  string
+		cookie  string
+		expBody string
+		expVary []string
+	}{
+		{desc: "header", header: "B", expBody: "header B", expVary: []string{"X-Experiment-Group"}},
+		{desc: "whole value", header: "AB", expBody: "control", expVary: []string{"X-Experiment-Group", "Cookie"}},
+		{desc: "cookie", cookie: "C", expBody: "cookie C", expVary: []string{"X-Experiment-Group", "Cookie"}},
+		{desc: "control", expBody: "control", expVary: []string{"X-Experiment-Group", "Cookie"}},
+		// The body cached for the header B variant is not served to the control group.
+		{desc: "cached", header: "B", expBody: "header B", expVary: []string{"X-Experiment-Group"}},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.header != "" {
+				req.Header.Set("X-Experiment-Group", test.header)
+			}
+			if test.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "group", Value: test.cookie})
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+			if vary := recorder.Header().Values("Vary"); !reflect.DeepEqual(vary, test.expVary) {
+				t.Errorf("got Vary %q, want %q", vary, test.expVary)
+			}
+		})
+	}
+}
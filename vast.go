@@ -0,0 +1,121 @@
+package traefik_responsebodyrewrite
+
+import (
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+)
+
+// vmapNamespace is the namespace of the VMAP documents.
+const vmapNamespace = "http://www.iab.net/videosuite/vmap"
+
+// vastURLElements are the local names of the elements holding the URLs of the VAST and VMAP documents.
+var vastURLElements = map[string]bool{
+	"MediaFile":     true,
+	"Impression":    true,
+	"ClickThrough":  true,
+	"ClickTracking": true,
+	"Tracking":      true,
+	"AdTagURI":      true,
+}
+
+// VAST rewrites the origin of the URLs of the VAST and VMAP ad documents.
+type VAST struct {
+	// Origin is the scheme and the host the URLs are rewritten to, e.g. "https://ads.example.com". If empty,
+	// it is the public origin of the request, from its X-Forwarded-Proto and X-Forwarded-Host headers, or
+	// else from its own scheme and Host.
+	Origin string `json:"origin,omitempty"`
+}
+
+// parsedVAST is a parsed VAST.
+type parsedVAST struct {
+	// origin is the configured origin, empty if it is derived from the request.
+	origin string
+}
+
+// parseVAST parses the vast option of a response block, nil if it has none.
+func parseVAST(config *VAST) (*parsedVAST, error) {
+	if config == nil {
+		return nil, nil
+	}
+	origin, err := parseOrigin(config.Origin)
+	if err != nil {
+		return nil, err
+	}
+	return &parsedVAST{origin: origin}, nil
+}
+
+// isVASTResponse reports whether the given Content-Type is the one of an XML document, possibly a VAST or
+// VMAP one.
+func isVASTResponse(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/xml" || mediaType == "text/xml")
+}
+
+// publicOrigin returns the origin the URLs of the ad document of req are rewritten to.
+func (v *parsedVAST) publicOrigin(req *http.Request) string {
+	if v.origin != "" {
+		return v.origin
+	}
+	return requestOrigin(req)
+}
+
+// isVASTURL reports whether the element at the end of the path holds a URL of a VAST document, or of a VMAP
+// one and the VAST documents it embeds: a MediaFile, an Impression, a ClickThrough, a ClickTracking, a
+// Tracking, or an AdTagURI. Any other document has none.
+func isVASTURL(path []xml.Name) bool {
+	if path[0].Local != "VAST" && path[0] != (xml.Name{Space: vmapNamespace, Local: "VMAP"}) {
+		return false
+	}
+	return len(path) > 1 && vastURLElements[path[len(path)-1].Local]
+}
+
+// vastURLOrigins returns the regex matching the origin of the URL of a VAST element, held by its content.
+func vastURLOrigins(xmlElement) *regexp.Regexp {
+	return xmlURLOrigin
+}
+
+// String describes the ad documents in the debug dump.
+func (v *parsedVAST) String() string {
+	return "URLs rewritten to " + describeOrigin(v.origin)
+}
+
+func (v *parsedVAST) option() string { return "vast" }
+
+// changesBody implements the transformer interface.
+func (v *parsedVAST) changesBody() bool { return true }
+
+// matchesContentType implements the contentTypeMatcher interface.
+func (v *parsedVAST) matchesContentType(contentType string) bool {
+	return isVASTResponse(contentType)
+}
+
+// cacheKey implements the cacheKeyer interface: the rewritten URLs depend on the public origin of req.
+func (v *parsedVAST) cacheKey(req *http.Request) string {
+	return v.publicOrigin(req)
+}
+
+// transform implements the bodyTransformer interface, as rewriteBody for a VAST or VMAP document: once the
+// rewrites are applied, the scheme and the host of the absolute URLs of its media files, impressions, clicks
+// and tracking events are replaced with the public origin, within their CDATA sections, the rest of the body,
+// its whitespace included, being left byte for byte. A body which can't be parsed fails the vast stage.
+func (v *parsedVAST) transform(rw *responseWriter, body []byte) ([]byte, bool, bool, []int, error) {
+	r, response, req := rw.middleware, rw.response, rw.request
+	rewritten, modified, complete, replaced := body, false, true, []int(nil)
+	if len(response.rewrites) > 0 {
+		var err error
+		if rewritten, modified, complete, replaced, err = r.rewriteBody(response, body, req); err != nil {
+			return body, false, true, nil, err
+		}
+	}
+
+	elements, err := xmlElements(rewritten, isVASTURL)
+	if err != nil {
+		return body, false, true, nil, &stageError{stage: stageVAST, err: fmt.Errorf("unable to parse the ad document: %w", err)}
+	}
+
+	result, changed := rewriteURLOrigins(rewritten, elements, v.publicOrigin(req), vastURLOrigins)
+	return result, modified || changed, complete, replaced, nil
+}
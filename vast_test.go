@@ -0,0 +1,122 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeHTTP_vast(t *testing.T) {
+	const vast = `<?xml version="1.0" encoding="UTF-8"?>
+<VAST version="4.0" xmlns="http://www.iab.com/VAST">
+  <Ad id="1">
+    <InLine>
+      <Impression id="imp"><![CDATA[http://ads.internal.local/impression?id=1]]></Impression>
+      <Creatives>
+        <Creative>
+          <Linear>
+            <TrackingEvents>
+              <Tracking event="start">
+                <![CDATA[ http://ads.internal.local/track?event=start ]]>
+              </Tracking>
+            </TrackingEvents>
+            <VideoClicks>
+              <ClickThrough><![CDATA[http://www.advertiser.com/landing]]></ClickThrough>
+              <ClickTracking>http://ads.internal.local/click?id=1&amp;t=2</ClickTracking>
+            </VideoClicks>
+            <MediaFiles>
+              <MediaFile delivery="progressive" type="video/mp4" width="1280" height="720"><![CDATA[https://media.internal.local:8443/ads/1.mp4]]></MediaFile>
+            </MediaFiles>
+          </Linear>
+        </Creative>
+      </Creatives>
+      <Error><![CDATA[http://ads.internal.local/error]]></Error>
+    </InLine>
+  </Ad>
+</VAST>`
+	const vmap = `<vmap:VMAP xmlns:vmap="http://www.iab.net/videosuite/vmap" version="1.0">
+  <vmap:AdBreak timeOffset="start" breakType="linear">
+    <vmap:AdSource><vmap:AdTagURI templateType="vast3"><![CDATA[http://ads.internal.local/vast?slot=pre]]></vmap:AdTagURI></vmap:AdSource>
+    <vmap:TrackingEvents><vmap:Tracking event="breakStart">http://ads.internal.local/break</vmap:Tracking></vmap:TrackingEvents>
+  </vmap:AdBreak>
+</vmap:VMAP>`
+
+	tests := []struct {
+		desc        string
+		contentType string
+		body        string
+		expBody     string
+	}{
+		{
+			desc:        "VAST",
+			contentType: "application/xml",
+			body:        vast,
+			expBody: strings.NewReplacer(
+				"http://ads.internal.local/impression", "https://ads.example.com/impression",
+				"http://ads.internal.local/track", "https://ads.example.com/track",
+				"http://www.advertiser.com", "https://ads.example.com",
+				"http://ads.internal.local/click", "https://ads.example.com/click",
+				"https://media.internal.local:8443", "https://ads.example.com",
+			).Replace(vast),
+		},
+		{
+			desc:        "VMAP",
+			contentType: "text/xml",
+			body:        vmap,
+			expBody:     strings.ReplaceAll(vmap, "http://ads.internal.local", "https://ads.example.com"),
+		},
+		{
+			desc:        "other XML document",
+			contentType: "application/xml",
+			body:        `<Feed><Impression>http://ads.internal.local/impression</Impression></Feed>`,
+			expBody:     `<Feed><Impression>http://ads.internal.local/impression</Impression></Feed>`,
+		},
+		{
+			desc:        "malformed body",
+			contentType: "application/xml",
+			body:        `<VAST><Impression><![CDATA[http://ads.internal.local/impression]]></VAST>`,
+			expBody:     `<VAST><Impression><![CDATA[http://ads.internal.local/impression]]></VAST>`,
+		},
+		{
+			desc:        "other content type",
+			contentType: "application/json",
+			body:        `<VAST><Impression>http://ads.internal.local/impression</Impression></VAST>`,
+			expBody:     `<VAST><Impression>http://ads.internal.local/impression</Impression></VAST>`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", test.contentType)
+				_, _ = rw.Write([]byte(test.body))
+			}), &Config{Responses: []Response{{Status: "200", VAST: &VAST{Origin: "https://ads.example.com"}}}}, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/ads/vast.xml", nil))
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+
+	_, err := New(context.Background(), http.NotFoundHandler(), &Config{Responses: []Response{{
+		Status: "200",
+		VAST:   &VAST{},
+		Stream: true,
+	}}}, "rewriteBody")
+	expErr := "responses[0]: vast can't be used with stream, jsonPaths, rewriteFirstBytes or rewriteMultipart"
+	if err == nil || err.Error() != expErr {
+		t.Errorf("got error %v, want %q", err, expErr)
+	}
+}
+
+func TestServeHTTP_vastFailure(t *testing.T) {
+	testModeFailure(t, Response{Status: "200", VAST: &VAST{Origin: "https://ads.example.com"}},
+		"application/xml", "<VAST><Impression><![CDATA[http://ads.internal.local/impression]]></VAST>", stageVAST)
+}
@@ -0,0 +1,79 @@
+package traefik_responsebodyrewrite
+
+import "fmt"
+
+// configMigration migrates a configuration to the next version, setting the fields whose default changed to
+// the default of the previous version. It returns the descriptions of the fields it set, to be logged. The
+// slices of the configuration are shared with the caller, so a migration must copy those it modifies.
+type configMigration func(config *Config) []string
+
+// configMigrations are the migrations of the configuration, configMigrations[i] migrating version i+1 to
+// version i+2. A migration is added along with each change of defaults which would break the configurations
+// written for the previous version.
+var configMigrations = []configMigration{
+	// Version 2 rejects the empty replacements of the rewrites without remove.
+	func(config *Config) []string {
+		if config.AllowEmptyReplacement {
+			return nil
+		}
+		config.AllowEmptyReplacement = true
+		if !mayHaveEmptyReplacement(config) {
+			return nil
+		}
+		return []string{"allowEmptyReplacement set to true, the empty replacements removing their matches"}
+	},
+}
+
+// mayHaveEmptyReplacement reports whether a rewrite of the configuration has an empty replacement without
+// remove, those of the rulesFiles and the rulesURLs being unknown until they are loaded.
+func mayHaveEmptyReplacement(config *Config) bool {
+	if hasEmptyReplacement(config.Rewrites) {
+		return true
+	}
+	for _, response := range config.Responses {
+		if response.RulesFile != "" || response.RulesURL != "" || hasEmptyReplacement(response.Rewrites) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasEmptyReplacement reports whether one of rewrites has an empty replacement without remove.
+func hasEmptyReplacement(rewrites []Rewrite) bool {
+	for _, rewrite := range rewrites {
+		if rewrite.Replacement == "" && !rewrite.Remove {
+			return true
+		}
+	}
+	return false
+}
+
+// latestConfigVersion returns the version the configurations are migrated to.
+func latestConfigVersion() int {
+	return len(configMigrations) + 1
+}
+
+// migrateConfig returns the configuration migrated to the latest version, and the descriptions of the fields
+// set by the migrations. A configuration without version is a version 1 configuration, while versions above
+// the latest one, written for a newer version of the plugin, are rejected rather than misinterpreted.
+func migrateConfig(config *Config) (*Config, []string, error) {
+	latest := latestConfigVersion()
+	version := config.Version
+	if version == 0 {
+		version = 1
+	}
+	if version < 0 || version > latest {
+		return nil, nil, fmt.Errorf("invalid version %d: must be between 1 and %d, the latest version supported by this version of the plugin",
+			config.Version, latest)
+	}
+
+	migrated := *config
+	var changes []string
+	for ; version < latest; version++ {
+		for _, change := range configMigrations[version-1](&migrated) {
+			changes = append(changes, fmt.Sprintf("version %d to %d: %s", version, version+1, change))
+		}
+	}
+	migrated.Version = latest
+	return &migrated, changes, nil
+}
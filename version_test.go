@@ -0,0 +1,113 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestMigrateConfig(t *testing.T) {
+	tests := []struct {
+		desc       string
+		version    int
+		expChanges []string
+		expErr     string
+	}{
+		{desc: "no version", version: 0},
+		{desc: "version 1", version: 1},
+		{desc: "latest version", version: 2},
+		{desc: "future version", version: 3, expErr: "invalid version 3: must be between 1 and 2, the latest version supported by this version of the plugin"},
+		{desc: "negative version", version: -1, expErr: "invalid version -1: must be between 1 and 2, the latest version supported by this version of the plugin"},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{Version: test.version, MaxBodySize: 10}
+			migrated, changes, err := migrateConfig(config)
+			if test.expErr != "" {
+				if err == nil || err.Error() != test.expErr {
+					t.Errorf("got error %v, want %q", err, test.expErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if migrated.Version != 2 || migrated.MaxBodySize != 10 || changes != nil {
+				t.Errorf("got version %d, maxBodySize %d, changes %q, want version 2 without changes", migrated.Version, migrated.MaxBodySize, changes)
+			}
+			if migrated.AllowEmptyReplacement != (test.version < 2) {
+				t.Errorf("got allowEmptyReplacement %t, want it set for version 1 only", migrated.AllowEmptyReplacement)
+			}
+		})
+	}
+
+}
+
+func TestMigrateConfig_createConfig(t *testing.T) {
+	// Traefik decodes the configuration onto the one emitted by CreateConfig.
+	config := CreateConfig()
+	if err := json.Unmarshal([]byte(`{"responses":[{"status":"200","rewrites":[{"regex":"foo"}]}]}`), config); err != nil {
+		t.Fatal(err)
+	}
+	migrated, changes, err := migrateConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expChanges := []string{"version 1 to 2: allowEmptyReplacement set to true, the empty replacements removing their matches"}
+	if migrated.Version != latestConfigVersion() || !migrated.AllowEmptyReplacement || !reflect.DeepEqual(changes, expChanges) {
+		t.Errorf("got version %d, allowEmptyReplacement %t, changes %q, want version %d migrated from version 1 with changes %q",
+			migrated.Version, migrated.AllowEmptyReplacement, changes, latestConfigVersion(), expChanges)
+	}
+}
+
+func TestMigrateConfig_migrations(t *testing.T) {
+	defer func(migrations []configMigration) { configMigrations = migrations }(configMigrations)
+	configMigrations = []configMigration{
+		func(config *Config) []string {
+			if config.MaxBodySize != 0 {
+				return nil
+			}
+			config.MaxBodySize = 1024
+			return []string{"maxBodySize set to 1024, the default of version 1"}
+		},
+		func(config *Config) []string {
+			config.DebugHeader = "X-Rewritten"
+			return []string{`debugHeader set to "X-Rewritten", the default of version 2`}
+		},
+	}
+
+	config := &Config{}
+	migrated, changes, err := migrateConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expChanges := []string{
+		"version 1 to 2: maxBodySize set to 1024, the default of version 1",
+		`version 2 to 3: debugHeader set to "X-Rewritten", the default of version 2`,
+	}
+	if migrated.Version != 3 || migrated.MaxBodySize != 1024 || !reflect.DeepEqual(changes, expChanges) {
+		t.Errorf("got version %d, maxBodySize %d, changes %q, want version 3, maxBodySize 1024, changes %q",
+			migrated.Version, migrated.MaxBodySize, changes, expChanges)
+	}
+	if config.Version != 0 || config.MaxBodySize != 0 {
+		t.Error("got the configuration modified, want it migrated on a copy")
+	}
+
+	if _, changes, _ := migrateConfig(&Config{Version: 2}); len(changes) != 1 {
+		t.Errorf("got changes %q, want those of version 2 to 3 only", changes)
+	}
+
+	var logs bytes.Buffer
+	if _, err := NewMiddleware(http.NotFoundHandler(), WithName("rewriteBody"), WithLogger(log.New(&logs, "", 0))); err != nil {
+		t.Fatal(err)
+	}
+	expected := `INFO: rewriteBody: configuration migrated from version 1 to 2: maxBodySize set to 1024, the default of version 1
+INFO: rewriteBody: configuration migrated from version 2 to 3: debugHeader set to "X-Rewritten", the default of version 2
+`
+	if logs.String() != expected {
+		t.Errorf("got logs:\n%s\nwant:\n%s", logs.String(), expected)
+	}
+}
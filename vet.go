@@ -0,0 +1,179 @@
+package traefik_responsebodyrewrite
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+)
+
+// defaultMaxPatternComplexity is the complexity above which a pattern is flagged when maxPatternComplexity is
+// not set. It is the number of instructions of the compiled pattern, a few thousands being needed by a list
+// of hundreds of words.
+const defaultMaxPatternComplexity = 2000
+
+// unboundedLength is the minimum match length of the patterns which never match.
+const unboundedLength = 1 << 30
+
+// parsePattern parses the syntax tree of a compiled regex, with the flags of the regexp package.
+func parsePattern(regex *regexp.Regexp) (*syntax.Regexp, error) {
+	tree, err := syntax.Parse(regex.String(), syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	return tree.Simplify(), nil
+}
+
+// checkEmptyMatch rejects the regexes matching the empty string wherever they are tried, e.g. "a*", which
+// make the replacement inserted between every byte of the body. The regexes matching the empty string only at
+// some positions, e.g. "(?m)^", are left to vetPattern, as well as those starting with ".*", e.g. ".*" which
+// replaces whole lines, and only matches the empty string at the end of the lines or in an empty body.
+func checkEmptyMatch(regex *regexp.Regexp) error {
+	tree, err := parsePattern(regex)
+	if err != nil {
+		return nil
+	}
+	if matchesEmpty(tree) && !leadingAnyChars(tree) {
+		return fmt.Errorf("%q matches the empty string, which inserts the replacement between every byte of the body", regex)
+	}
+	return nil
+}
+
+// matchesEmpty reports whether tree matches the empty string without any condition on its position, such
+// as the start of a line.
+func matchesEmpty(tree *syntax.Regexp) bool {
+	switch tree.Op {
+	case syntax.OpEmptyMatch, syntax.OpStar, syntax.OpQuest:
+		return true
+	case syntax.OpCapture, syntax.OpPlus:
+		return matchesEmpty(tree.Sub[0])
+	case syntax.OpRepeat:
+		return tree.Min == 0 || matchesEmpty(tree.Sub[0])
+	case syntax.OpConcat:
+		for _, sub := range tree.Sub {
+			if !matchesEmpty(sub) {
+				return false
+			}
+		}
+		return true
+	case syntax.OpAlternate:
+		for _, sub := range tree.Sub {
+			if matchesEmpty(sub) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// minMatchLength returns the minimum number of characters matched by tree, unboundedLength if it never
+// matches.
+func minMatchLength(tree *syntax.Regexp) int {
+	switch tree.Op {
+	case syntax.OpNoMatch:
+		return unboundedLength
+	case syntax.OpLiteral:
+		return len(tree.Rune)
+	case syntax.OpCharClass:
+		if len(tree.Rune) == 0 {
+			return unboundedLength
+		}
+		return 1
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return 1
+	case syntax.OpCapture, syntax.OpPlus:
+		return minMatchLength(tree.Sub[0])
+	case syntax.OpRepeat:
+		if tree.Min == 0 {
+			return 0
+		}
+		if length := minMatchLength(tree.Sub[0]); length < unboundedLength/tree.Min {
+			return length * tree.Min
+		}
+		return unboundedLength
+	case syntax.OpConcat:
+		total := 0
+		for _, sub := range tree.Sub {
+			if total += minMatchLength(sub); total >= unboundedLength {
+				return unboundedLength
+			}
+		}
+		return total
+	case syntax.OpAlternate:
+		shortest := unboundedLength
+		for _, sub := range tree.Sub {
+			if length := minMatchLength(sub); length < shortest {
+				shortest = length
+			}
+		}
+		return shortest
+	default:
+		// The empty matches, the stars, the question marks and the assertions such as ^ or \b.
+		return 0
+	}
+}
+
+// leadingAnyChars reports whether tree starts with a repetition of any character, e.g. ".*foo".
+func leadingAnyChars(tree *syntax.Regexp) bool {
+	for tree.Op == syntax.OpCapture || tree.Op == syntax.OpConcat {
+		if len(tree.Sub) == 0 {
+			return false
+		}
+		tree = tree.Sub[0]
+	}
+	switch tree.Op {
+	case syntax.OpStar, syntax.OpPlus:
+		op := tree.Sub[0].Op
+		return op == syntax.OpAnyChar || op == syntax.OpAnyCharNotNL
+	default:
+		return false
+	}
+}
+
+// vetPattern describes what makes a legal regex costly or surprising, none if empty: a minimum match length
+// of zero, a leading ".*" or a complexity above maxComplexity. The regexes matching the empty string
+// everywhere are rejected by checkEmptyMatch beforehand.
+func vetPattern(regex *regexp.Regexp, maxComplexity int) []string {
+	tree, err := parsePattern(regex)
+	if err != nil {
+		return nil
+	}
+
+	var issues []string
+	if minMatchLength(tree) == 0 {
+		issues = append(issues, "its minimum match length is zero, the replacement being inserted wherever it matches the empty string")
+	}
+	if leadingAnyChars(tree) {
+		issues = append(issues, "it starts with a repetition of any character, which makes every match extend back to the start of its line, or of the body with the s flag")
+	}
+	if prog, err := syntax.Compile(tree); err == nil && len(prog.Inst) > maxComplexity {
+		issues = append(issues, fmt.Sprintf("its complexity of %d exceeds maxPatternComplexity of %d", len(prog.Inst), maxComplexity))
+	}
+	return issues
+}
+
+// vetPatterns vets the regexes of the global rewrites and of the response blocks, each regex once. The issues
+// are logged as warnings, or rejected with strictPatterns.
+func vetPatterns(global globalRewrites, responses []parsedResponse, maxComplexity int) []string {
+	var issues []string
+	seen := make(map[*regexp.Regexp]bool)
+	vet := func(rewrites []parsedRewrite, label string) {
+		for _, rewrite := range rewrites {
+			// The regexp2 patterns are bounded by their matchTimeout instead.
+			if rewrite.backtracking != nil || seen[rewrite.regex] {
+				continue
+			}
+			seen[rewrite.regex] = true
+			for _, issue := range vetPattern(rewrite.regex, maxComplexity) {
+				issues = append(issues, fmt.Sprintf("%s of %s: %s", withDescription(fmt.Sprintf("regex %q", rewrite.regex), rewrite.description), label, issue))
+			}
+		}
+	}
+
+	vet(global.rewrites, "the global rewrites")
+	for i := range responses {
+		vet(responses[i].rewrites, responseLabel(&responses[i]))
+	}
+	return issues
+}
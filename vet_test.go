@@ -0,0 +1,115 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCheckEmptyMatch(t *testing.T) {
+	tests := []struct {
+		regex  string
+		expErr bool
+	}{
+		{regex: "foo"},
+		{regex: "a*", expErr: true},
+		{regex: "(foo)?", expErr: true},
+		{regex: "x{0,3}", expErr: true},
+		{regex: "foo|", expErr: true},
+		{regex: "(?:a*b*)+", expErr: true},
+		{regex: "a+"},
+		{regex: "^"},
+		{regex: "(?m)^"},
+		{regex: `\b`},
+		{regex: "^a*"},
+		{regex: "a*$"},
+		{regex: ".*"},
+		{regex: "(?s).*x?"},
+	}
+	for _, test := range tests {
+		err := checkEmptyMatch(regexp.MustCompile(test.regex))
+		if (err != nil) != test.expErr {
+			t.Errorf("%q: got error %v, want error %t", test.regex, err, test.expErr)
+		}
+	}
+}
+
+func TestVetPattern(t *testing.T) {
+	tests := []struct {
+		regex     string
+		expIssues []string
+	}{
+		{regex: "foo"},
+		{regex: "^foo$"},
+		{
+			regex:     "(?m)^",
+			expIssues: []string{"its minimum match length is zero, the replacement being inserted wherever it matches the empty string"},
+		},
+		{
+			regex:     `\bx*`,
+			expIssues: []string{"its minimum match length is zero, the replacement being inserted wherever it matches the empty string"},
+		},
+		{
+			regex:     ".*foo",
+			expIssues: []string{"it starts with a repetition of any character, which makes every match extend back to the start of its line, or of the body with the s flag"},
+		},
+		{
+			regex:     "(?s)(.+)foo",
+			expIssues: []string{"it starts with a repetition of any character, which makes every match extend back to the start of its line, or of the body with the s flag"},
+		},
+		{regex: "foo.*"},
+		{
+			regex:     "(foo|bar){20}",
+			expIssues: []string{"its complexity of 182 exceeds maxPatternComplexity of 100"},
+		},
+	}
+	for _, test := range tests {
+		if issues := vetPattern(regexp.MustCompile(test.regex), 100); !reflect.DeepEqual(issues, test.expIssues) {
+			t.Errorf("%q: got issues %q, want %q", test.regex, issues, test.expIssues)
+		}
+	}
+}
+
+func TestNew_vetPatterns(t *testing.T) {
+	config := &Config{
+		Rewrites: []Rewrite{{Regex: ".*foo", Replacement: "bar"}},
+		Responses: []Response{
+			{Status: "200", Rewrites: []Rewrite{{Regex: "(?m)^", Replacement: "> "}}},
+			{Name: "errors", Status: "500", Rewrites: []Rewrite{{Regex: "(?m)^", Replacement: "> "}, {Regex: "baz", Replacement: "qux"}}},
+		},
+	}
+
+	var logs bytes.Buffer
+	if _, err := NewMiddleware(http.NotFoundHandler(), WithConfig(config), WithName("rewriteBody"), WithLogger(log.New(&logs, "", 0))); err != nil {
+		t.Fatalf("got error %v, want none", err)
+	}
+	expected := `WARN: rewriteBody: regex ".*foo" of the global rewrites: it starts with a repetition of any character, which makes every match extend back to the start of its line, or of the body with the s flag
+WARN: rewriteBody: regex "(?m)^" of responses[0]: its minimum match length is zero, the replacement being inserted wherever it matches the empty string
+`
+	if logs := withoutCompileLog(logs.String()); logs != expected {
+		t.Errorf("got logs:\n%s\nwant:\n%s", logs, expected)
+	}
+
+	config.StrictPatterns = true
+	_, err := New(context.Background(), http.NotFoundHandler(), config, "rewriteBody")
+	if err == nil || !strings.HasPrefix(err.Error(), `regex ".*foo" of the global rewrites: `) || !strings.Contains(err.Error(), `; regex "(?m)^" of responses[0]: `) {
+		t.Errorf("got error %v, want both issues", err)
+	}
+
+	config.StrictPatterns = false
+	config.Rewrites = []Rewrite{{Regex: "(foo|bar)*", Replacement: "baz"}}
+	_, err = New(context.Background(), http.NotFoundHandler(), config, "rewriteBody")
+	if err == nil || err.Error() != `rewrites[0].regex: "(foo|bar)*" matches the empty string, which inserts the replacement between every byte of the body` {
+		t.Errorf("got error %v, want the empty match to be rejected", err)
+	}
+
+	config.MaxPatternComplexity = -1
+	if _, err := New(context.Background(), http.NotFoundHandler(), config, "rewriteBody"); err == nil {
+		t.Error("expected an error for a negative maxPatternComplexity")
+	}
+}
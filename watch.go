@@ -0,0 +1,194 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultWatchInterval is the interval between the checks of the rulesFiles when watchInterval is not set.
+const defaultWatchInterval = 10 * time.Second
+
+// rulesFileState identifies the content of a rulesFile, so that it is only reloaded when it changes.
+type rulesFileState struct {
+	modTime time.Time
+	size    int64
+	sum     [sha256.Size]byte
+	// err is the last error reading the file, logged once until it changes.
+	err string
+}
+
+// rulesReloader parses the response blocks again, once the rules of their rulesFiles or rulesURLs changed.
+type rulesReloader struct {
+	config        *Config
+	global        globalRewrites
+	maxComplexity int
+	limits        ruleLimits
+	// fetcher holds the contents of the rulesURLs, nil if no response block has one.
+	fetcher *rulesFetcher
+}
+
+// rulesWatcher reloads the rules of the response blocks when their rulesFiles change.
+type rulesWatcher struct {
+	*rulesReloader
+	interval time.Duration
+	// paths are the rulesFiles, in the order of the response blocks, and states their last known content.
+	paths  []string
+	states map[string]*rulesFileState
+}
+
+// newRulesWatcher creates the watcher of the rulesFiles of the configuration, before they are loaded.
+func newRulesWatcher(reloader *rulesReloader) (*rulesWatcher, error) {
+	config := reloader.config
+	interval := defaultWatchInterval
+	if config.WatchInterval != "" {
+		var err error
+		interval, err = time.ParseDuration(config.WatchInterval)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid watchInterval %q: must be a positive duration", config.WatchInterval)
+		}
+	}
+
+	w := &rulesWatcher{
+		rulesReloader: reloader,
+		interval:      interval,
+		states:        make(map[string]*rulesFileState),
+	}
+	for _, response := range config.Responses {
+		if response.RulesFile == "" || w.states[response.RulesFile] != nil {
+			continue
+		}
+		w.paths = append(w.paths, response.RulesFile)
+		w.states[response.RulesFile] = &rulesFileState{}
+	}
+	if len(w.paths) == 0 {
+		return nil, errors.New("watchRulesFile: no response block has a rulesFile")
+	}
+	// The files are about to be loaded, only their changes from now on trigger a reload.
+	w.changed()
+	return w, nil
+}
+
+// changed reports whether the content of a rulesFile changed since the last call, and the errors reading
+// them which were not reported yet. A file whose modification time and size are unchanged is not read again.
+func (w *rulesWatcher) changed() (bool, []error) {
+	changed := false
+	var errs []error
+	for _, path := range w.paths {
+		state := w.states[path]
+		fileChanged, err := state.update(path)
+		if err != nil {
+			if err.Error() != state.err {
+				state.err = err.Error()
+				errs = append(errs, err)
+			}
+			continue
+		}
+		state.err = ""
+		changed = changed || fileChanged
+	}
+	return changed, errs
+}
+
+// update updates the state of the rulesFile at path, and reports whether its content changed.
+func (s *rulesFileState) update(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, fmt.Errorf("rulesFile: %w", err)
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return false, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("rulesFile: %w", err)
+	}
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	sum := sha256.Sum256(data)
+	if sum == s.sum {
+		return false, nil
+	}
+	s.sum = sum
+	return true, nil
+}
+
+// reload parses the response blocks again, with the current content of their rulesFiles and the given contents
+// of their rulesURLs. They are rejected as when the middleware is created.
+func (w *rulesReloader) reload(contents rulesURLContents) ([]parsedResponse, []string, error) {
+	responses, err := parseResponses(w.config, w.global, w.limits, contents)
+	if err != nil {
+		return nil, nil, err
+	}
+	issues := vetPatterns(w.global, responses, w.maxComplexity)
+	if w.config.StrictPatterns && len(issues) > 0 {
+		return nil, nil, errors.New(strings.Join(issues, "; "))
+	}
+	if w.config.Always {
+		level, err := parseLogLevel(w.config.LogLevel)
+		if err != nil {
+			return nil, nil, err
+		}
+		responses = append(responses, newGlobalResponse(len(responses), w.global, w.config.DryRun, level))
+	}
+	return responses, issues, nil
+}
+
+// watchRulesFiles reloads the rules of the response blocks whenever a rulesFile changes, until ctx is done.
+// The requests being served keep the rules they started with, and the cached bodies, rewritten by the
+// previous rules, are dropped. When the rules can't be reloaded, the previous ones are kept.
+func (r *responsebodyrewrite) watchRulesFiles(ctx context.Context, w *rulesWatcher) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, errs := w.changed()
+		for _, err := range errs {
+			r.errorf("%s: can't check %v, keeping the current rules", r.name, err)
+		}
+		if !changed {
+			continue
+		}
+		// The rulesURLs may be refreshed meanwhile, the latest rules of both must be stored last.
+		r.reloadMu.Lock()
+		responses, issues, err := w.reload(w.fetcher.contents())
+		if err == nil {
+			r.storeResponses(responses)
+		}
+		r.reloadMu.Unlock()
+		if err != nil {
+			r.errorf("%s: can't reload the rulesFiles, keeping the current rules: %v", r.name, err)
+			continue
+		}
+		for _, issue := range issues {
+			r.warnf("%s: %s", r.name, issue)
+		}
+		r.infof("%s: rulesFiles reloaded", r.name)
+	}
+}
+
+// storeResponses replaces the response blocks in use, dropping the bodies cached by the previous ones.
+func (r *responsebodyrewrite) storeResponses(responses []parsedResponse) {
+	r.reloadedResponses.Store(responses)
+	if r.cache != nil {
+		r.cache.clear()
+	}
+}
+
+// currentResponses returns the response blocks in use, those reloaded by watchRulesFiles or refreshRulesURLs
+// if any.
+func (r *responsebodyrewrite) currentResponses() []parsedResponse {
+	if responses, ok := r.reloadedResponses.Load().([]parsedResponse); ok {
+		return responses
+	}
+	return r.responses
+}
@@ -0,0 +1,145 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeRulesFile writes a rulesFile with a modification time different from the previous one, which the
+// filesystem may not tell apart from it when written in the same tick.
+func writeRulesFile(t *testing.T, path, data string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServeHTTP_watchRulesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yml")
+	start := time.Now().Add(-time.Hour)
+	writeRulesFile(t, path, "- regex: foo\n  replacement: bar\n", start)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var logs syncBuffer
+	handler, err := NewMiddleware(
+		http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			rw.Header().Set("ETag", `"v1"`)
+			_, _ = rw.Write([]byte("foo"))
+		}),
+		WithContext(ctx),
+		WithName("rewriteBody"),
+		WithConfig(&Config{CacheSize: 10, WatchRulesFile: true, WatchInterval: "1ms"}),
+		WithResponses(Response{Status: "200", RulesFile: path}),
+		WithLogger(log.New(&logs, "", 0)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := func() string {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		return recorder.Body.String()
+	}
+	waitFor := func(desc string, done func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); !done(); time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s, got logs:\n%s", desc, logs.String())
+			}
+		}
+	}
+
+	if got := body(); got != "bar" {
+		t.Fatalf("got body %q, want %q", got, "bar")
+	}
+
+	// The cached body, rewritten by the previous rules, is not sent anymore.
+	writeRulesFile(t, path, "- regex: foo\n  replacement: baz\n", start.Add(time.Minute))
+	waitFor("the new rules", func() bool { return body() == "baz" })
+
+	writeRulesFile(t, path, "- regex: fo(o\n  replacement: qux\n", start.Add(2*time.Minute))
+	waitFor("the reload error", func() bool { return strings.Contains(logs.String(), "can't reload the rulesFiles") })
+	if got := body(); got != "baz" {
+		t.Errorf("got body %q after an invalid rulesFile, want the previous rules to be kept", got)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("the missing file error", func() bool { return strings.Contains(logs.String(), "can't check rulesFile: ") })
+	if got := body(); got != "baz" {
+		t.Errorf("got body %q after the rulesFile was removed, want the previous rules to be kept", got)
+	}
+	if n := strings.Count(logs.String(), "can't check rulesFile: "); n != 1 {
+		t.Errorf("got the missing file logged %d times, want once", n)
+	}
+}
+
+func TestRulesFileState_update(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yml")
+	start := time.Now().Add(-time.Hour)
+	writeRulesFile(t, path, "- regex: foo\n  replacement: bar\n", start)
+
+	var state rulesFileState
+	steps := []struct {
+		desc       string
+		data       string
+		modTime    time.Time
+		expChanged bool
+	}{
+		{desc: "first read", data: "- regex: foo\n  replacement: bar\n", modTime: start, expChanged: true},
+		{desc: "unchanged", data: "- regex: foo\n  replacement: bar\n", modTime: start},
+		{desc: "touched", data: "- regex: foo\n  replacement: bar\n", modTime: start.Add(time.Minute)},
+		{desc: "modified", data: "- regex: foo\n  replacement: baz\n", modTime: start.Add(2 * time.Minute), expChanged: true},
+	}
+	for _, step := range steps {
+		writeRulesFile(t, path, step.data, step.modTime)
+		changed, err := state.update(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed != step.expChanged {
+			t.Errorf("%s: got changed %t, want %t", step.desc, changed, step.expChanged)
+		}
+	}
+}
+
+func TestNew_watchRulesFileErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yml")
+	writeRulesFile(t, path, "- regex: foo\n  replacement: bar\n", time.Now())
+
+	tests := []struct {
+		desc   string
+		config *Config
+		expErr string
+	}{
+		{
+			desc:   "no rulesFile",
+			config: &Config{WatchRulesFile: true, Responses: []Response{{Status: "200", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}}}},
+			expErr: "watchRulesFile: no response block has a rulesFile",
+		},
+		{
+			desc:   "invalid interval",
+			config: &Config{WatchRulesFile: true, WatchInterval: "0s", Responses: []Response{{Status: "200", RulesFile: path}}},
+			expErr: `invalid watchInterval "0s": must be a positive duration`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if _, err := New(context.Background(), http.NotFoundHandler(), test.config, "rewriteBody"); err == nil || err.Error() != test.expErr {
+				t.Errorf("got error %v, want %q", err, test.expErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// writeErrorLogInterval is the minimum interval between two logs of the write errors caused by a client
+// which has gone.
+const writeErrorLogInterval = time.Second
+
+// Levels of the errorLog option.
+const (
+	errorLogInfo = "info"
+	errorLogWarn = "warn"
+	errorLogOff  = "off"
+)
+
+// writeBody writes body to w, reporting a short write as io.ErrShortWrite.
+func writeBody(w io.Writer, body []byte) error {
+	n, err := w.Write(body)
+	if err == nil && n < len(body) {
+		return io.ErrShortWrite
+	}
+	return err
+}
+
+// isClientGone reports whether err is caused by a client closing the connection or canceling the request,
+// which is expected and frequent.
+// The syscall package is not available to plugins interpreted by yaegi, the errors of the connection are
+// recognized by their message.
+func isClientGone(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return true
+	}
+	message := err.Error()
+	return strings.Contains(message, "broken pipe") || strings.Contains(message, "connection reset by peer")
+}
+
+// logWriteError logs an error sending the body of size bytes to the client, -1 if the size is unknown,
+// at the errorLog level. Errors caused by a client which has gone are logged at most once per
+// writeErrorLogInterval, with the number of such errors not logged since the previous one.
+func (rw *responseWriter) logWriteError(size int64, err error) {
+	m := rw.middleware
+	if m.errorLog == errorLogOff {
+		return
+	}
+
+	var suppressed int64
+	if isClientGone(err) {
+		now := time.Now().UnixNano()
+		last := atomic.LoadInt64(&m.lastWriteErrorLog)
+		if now-last < int64(writeErrorLogInterval) || !atomic.CompareAndSwapInt64(&m.lastWriteErrorLog, last, now) {
+			atomic.AddInt64(&m.suppressedWriteErrors, 1)
+			return
+		}
+		suppressed = atomic.SwapInt64(&m.suppressedWriteErrors, 0)
+	}
+
+	logf := rw.infof
+	if m.errorLog == errorLogWarn {
+		logf = rw.warnf
+	}
+
+	body := "body"
+	if size >= 0 {
+		body = fmt.Sprintf("body of %d bytes", size)
+	}
+	message := fmt.Sprintf("%s: unable to write %s to %s %s with status %d: %T: %v",
+		m.name, body, rw.request.Method, rw.request.URL.Path, rw.code, err, err)
+	if suppressed > 0 {
+		message += fmt.Sprintf(" (%d similar errors not logged)", suppressed)
+	}
+	logf("%s", message)
+}
+
+// validateErrorLog checks the errorLog option, an empty value meaning errorLogInfo.
+func validateErrorLog(level string) (string, error) {
+	switch level {
+	case "":
+		return errorLogInfo, nil
+	case errorLogInfo, errorLogWarn, errorLogOff:
+		return level, nil
+	default:
+		return "", fmt.Errorf("invalid errorLog %q: must be %q, %q or %q", level, errorLogInfo, errorLogWarn, errorLogOff)
+	}
+}
@@ -0,0 +1,85 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// shortWriter accepts at most n bytes per write, without error.
+type shortWriter struct {
+	n int
+}
+
+func (s shortWriter) Write(p []byte) (int, error) {
+	if len(p) > s.n {
+		return s.n, nil
+	}
+	return len(p), nil
+}
+
+func TestWriteBody(t *testing.T) {
+	if err := writeBody(shortWriter{n: 3}, []byte("foo")); err != nil {
+		t.Errorf("got error %v, want none", err)
+	}
+	if err := writeBody(shortWriter{n: 2}, []byte("foo")); !errors.Is(err, io.ErrShortWrite) {
+		t.Errorf("got error %v, want %v", err, io.ErrShortWrite)
+	}
+}
+
+func TestResponseWriter_logWriteError(t *testing.T) {
+	var logs bytes.Buffer
+	r := &responsebodyrewrite{
+		name:       "rewriteBody",
+		errorLog:   errorLogWarn,
+		logLevel:   levelWarn,
+		infoLogger: log.New(&logs, "", 0),
+		warnLogger: log.New(&logs, "", 0),
+	}
+	rw := &responseWriter{
+		code:       http.StatusOK,
+		middleware: r,
+		request:    httptest.NewRequest(http.MethodPost, "/foo?bar", nil),
+	}
+
+	brokenPipe := &net.OpError{Op: "write", Err: errors.New("broken pipe")}
+	for i := 0; i < 3; i++ {
+		rw.logWriteError(42, brokenPipe)
+	}
+	rw.logWriteError(-1, io.ErrShortWrite)
+	// Once the interval has elapsed, the next error caused by the client is logged.
+	r.lastWriteErrorLog = 0
+	rw.logWriteError(42, context.Canceled)
+
+	expected := []string{
+		"rewriteBody: unable to write body of 42 bytes to POST /foo with status 200: *net.OpError: write: broken pipe",
+		"rewriteBody: unable to write body to POST /foo with status 200: *errors.errorString: short write",
+		"rewriteBody: unable to write body of 42 bytes to POST /foo with status 200: *errors.errorString: context canceled (2 similar errors not logged)",
+	}
+	if lines := strings.Split(strings.TrimSpace(logs.String()), "\n"); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("got logs:\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+
+	logs.Reset()
+	r.errorLog = errorLogOff
+	rw.logWriteError(42, io.ErrShortWrite)
+	if logs.Len() != 0 {
+		t.Errorf("got logs %q with errorLog off, want none", logs.String())
+	}
+}
+
+func TestNew_errorLog(t *testing.T) {
+	if _, err := New(context.Background(), http.NotFoundHandler(), &Config{ErrorLog: "debug"}, "rewriteBody"); err == nil {
+		t.Error("expected an error for an invalid errorLog")
+	}
+	if _, err := New(context.Background(), http.NotFoundHandler(), &Config{ErrorLog: errorLogOff}, "rewriteBody"); err != nil {
+		t.Errorf("got error %v, want none", err)
+	}
+}
@@ -0,0 +1,101 @@
+package traefik_responsebodyrewrite
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// xmlURLOrigin matches the scheme and the host of the absolute URL at the start of the content of an element,
+// after its leading spaces and the start of the CDATA section wrapping it, if any.
+var xmlURLOrigin = regexp.MustCompile(`^(\s*(?:<!\[CDATA\[\s*)?)[A-Za-z][A-Za-z0-9+.-]*://(?:\[[0-9A-Fa-f:.]*\])?[^/?#\s<\]]*`)
+
+// xmlAttributeURLOrigin returns the regex matching the scheme and the host of the absolute URLs of the
+// attributes of a start tag with the given names.
+func xmlAttributeURLOrigin(names ...string) *regexp.Regexp {
+	return regexp.MustCompile(`(\s(?:` + strings.Join(names, "|") + `)\s*=\s*["']\s*)[A-Za-z][A-Za-z0-9+.-]*://[^/?#\s"'<]*`)
+}
+
+// xmlElement is the position of an element in an XML body.
+type xmlElement struct {
+	tag xml.StartElement
+	// start is the offset of its start tag, contentStart the offset following it, and contentEnd the offset
+	// of its end tag, contentStart for an empty-element tag.
+	start        int64
+	contentStart int64
+	contentEnd   int64
+}
+
+// xmlElements returns the positions of the elements of an XML body selected by their path, the names of the
+// elements from the root, the names being resolved by namespace. The elements nested in a selected element
+// are not selected.
+func xmlElements(body []byte, selects func(path []xml.Name) bool) ([]xmlElement, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	var path []xml.Name
+	var elements []xmlElement
+	// selected is the depth of the selected element being decoded, zero outside of one.
+	selected := 0
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return elements, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			path = append(path, token.Name)
+			if selected == 0 && selects(path) {
+				selected = len(path)
+				elements = append(elements, xmlElement{tag: token.Copy(), start: offset, contentStart: decoder.InputOffset()})
+			}
+		case xml.EndElement:
+			if selected == len(path) {
+				elements[len(elements)-1].contentEnd = offset
+				selected = 0
+			}
+			path = path[:len(path)-1]
+		}
+	}
+}
+
+// rewriteURLOrigins replaces the scheme and the host of the absolute URLs held by the elements of an XML body
+// with origin, and reports whether the body was modified. The URLs of an element are matched by the regex
+// returned by urlOrigins, xmlURLOrigin for its content or one of xmlAttributeURLOrigin for its start tag,
+// the elements for which it returns nil being left as they are. A host holding a template identifier, like
+// the $RepresentationID$ of DASH, is left as it is too, as well as the rest of the body, byte for byte.
+func rewriteURLOrigins(body []byte, elements []xmlElement, origin string, urlOrigins func(element xmlElement) *regexp.Regexp) ([]byte, bool) {
+	var escaped bytes.Buffer
+	_ = xml.EscapeText(&escaped, []byte(origin))
+	var out bytes.Buffer
+	var sent int64
+	for _, element := range elements {
+		pattern := urlOrigins(element)
+		if pattern == nil {
+			continue
+		}
+		start, end := element.start, element.contentStart
+		if pattern == xmlURLOrigin {
+			start, end = element.contentStart, element.contentEnd
+		}
+		for _, loc := range pattern.FindAllSubmatchIndex(body[start:end], -1) {
+			host := body[start+int64(loc[3]) : start+int64(loc[1])]
+			if bytes.Equal(host, escaped.Bytes()) || bytes.IndexByte(host, '$') >= 0 {
+				continue
+			}
+			out.Write(body[sent : start+int64(loc[3])])
+			out.Write(escaped.Bytes())
+			sent = start + int64(loc[1])
+		}
+	}
+
+	if sent == 0 {
+		return body, false
+	}
+	out.Write(body[sent:])
+	return out.Bytes(), true
+}
@@ -0,0 +1,155 @@
+package traefik_responsebodyrewrite
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// yaegiUnsupportedImports are the packages of the standard library which yaegi, and so Traefik, can't load.
+var yaegiUnsupportedImports = map[string]bool{
+	"C":       true,
+	"plugin":  true,
+	"syscall": true,
+	"unsafe":  true,
+}
+
+// goModRequireRegex matches the module path of a require directive of go.mod, either on its own line or in a
+// require block.
+var goModRequireRegex = regexp.MustCompile(`(?m)^(?:require[ \t]+|[ \t]+)([^\s()]+)[ \t]+v\S+`)
+
+// pluginModules returns the path of the plugin module and those of the modules it requires, which Traefik
+// loads from the vendor directory.
+func pluginModules(t *testing.T) []string {
+	t.Helper()
+
+	b, err := os.ReadFile("go.mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var modules []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "module" {
+			modules = append(modules, fields[1])
+		}
+	}
+	for _, match := range goModRequireRegex.FindAllStringSubmatch(string(b), -1) {
+		modules = append(modules, match[1])
+	}
+	return modules
+}
+
+// pluginSources returns the non-test Go files of the plugin.
+func pluginSources(t *testing.T) []string {
+	t.Helper()
+
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sources []string
+	for _, file := range files {
+		if !strings.HasSuffix(file, "_test.go") {
+			sources = append(sources, file)
+		}
+	}
+	return sources
+}
+
+func TestYaegiImports(t *testing.T) {
+	modules := pluginModules(t)
+	fset := token.NewFileSet()
+	for _, file := range pluginSources(t) {
+		f, err := parser.ParseFile(fset, file, nil, parser.ImportsOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, spec := range f.Imports {
+			path, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(strings.SplitN(path, "/", 2)[0], ".") && !inModules(path, modules) {
+				t.Errorf("%s: import of %q: not in a module required by go.mod", fset.Position(spec.Pos()), path)
+			}
+			if yaegiUnsupportedImports[path] {
+				t.Errorf("%s: import of %q: not supported by yaegi", fset.Position(spec.Pos()), path)
+			}
+		}
+	}
+}
+
+// inModules reports whether the package path belongs to one of the modules.
+func inModules(path string, modules []string) bool {
+	for _, module := range modules {
+		if path == module || strings.HasPrefix(path, module+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// TestYaegi runs testdata/yaegi/main.go with yaegi, the plugin being loaded from a GOPATH as done by Traefik.
+// It is skipped when yaegi is not installed, see the yaegi target of the Makefile.
+func TestYaegi(t *testing.T) {
+	yaegi, err := exec.LookPath(filepath.Join("bin", "yaegi"))
+	if err != nil {
+		if yaegi, err = exec.LookPath("yaegi"); err != nil {
+			t.Skip("yaegi not found, run make yaegi to install it")
+		}
+	}
+	if yaegi, err = filepath.Abs(yaegi); err != nil {
+		t.Fatal(err)
+	}
+
+	gopath := t.TempDir()
+	dir := filepath.Join(gopath, "src", "github.com", "quortex", "traefik-responsebodyrewrite")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range append(pluginSources(t), "go.mod") {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, file), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The required modules are loaded from the vendor directory, see the vendor target of the Makefile.
+	err = filepath.Walk("vendor", func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(file)), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dir, file), b, 0o644)
+	})
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	script, err := filepath.Abs(filepath.Join("testdata", "yaegi", "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(yaegi, "run", script)
+	cmd.Dir = gopath
+	cmd.Env = append(os.Environ(), "GOPATH="+gopath, "GO111MODULE=off")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("yaegi run: %v\n%s", err, out)
+	}
+	t.Logf("%s", out)
+}
@@ -0,0 +1,370 @@
+package traefik_responsebodyrewrite
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// yamlContentTypes are the media types of the YAML responses.
+var yamlContentTypes = map[string]bool{
+	"application/yaml":   true,
+	"application/x-yaml": true,
+	"text/yaml":          true,
+	"text/x-yaml":        true,
+}
+
+// YAMLOp is an operation on the values of a YAML body.
+type YAMLOp struct {
+	// Path is a list of mapping keys separated by dots, "*" matching any key, the sequences being traversed
+	// transparently: "servers.url" is the url of every mapping of the servers sequence.
+	Path string `json:"path,omitempty"`
+	// Set is the string replacing the values at Path, unless Remove is set.
+	Set string `json:"set,omitempty"`
+	// Remove removes the keys at Path along with their values.
+	Remove bool `json:"remove,omitempty"`
+}
+
+// parsedYAMLOps are the parsed yamlOps of a response block.
+type parsedYAMLOps []parsedYAMLOp
+
+// parsedYAMLOp is a parsed YAMLOp.
+type parsedYAMLOp struct {
+	path   []string
+	set    string
+	remove bool
+}
+
+// yamlKey is a key of the mappings a line of a YAML body is in.
+type yamlKey struct {
+	indent int
+	key    string
+}
+
+// parseYAMLOps parses the yamlOps option of a response block.
+func parseYAMLOps(ops []YAMLOp) (parsedYAMLOps, error) {
+	parsed := make(parsedYAMLOps, len(ops))
+	for i, op := range ops {
+		path := strings.Split(op.Path, ".")
+		for _, segment := range path {
+			if segment == "" {
+				return nil, fmt.Errorf("yamlOps[%d]: invalid path %q: empty key", i, op.Path)
+			}
+		}
+		if op.Remove && op.Set != "" {
+			return nil, fmt.Errorf("yamlOps[%d]: set and remove can't both be set", i)
+		}
+		parsed[i] = parsedYAMLOp{path: path, set: op.Set, remove: op.Remove}
+	}
+	return parsed, nil
+}
+
+// isYAMLResponse reports whether the given Content-Type is the one of a YAML response.
+func isYAMLResponse(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && yamlContentTypes[mediaType]
+}
+
+// matchYAMLOp returns the first of the operations whose path is the one of the given keys, nil if none.
+func matchYAMLOp(ops []parsedYAMLOp, keys []yamlKey) *parsedYAMLOp {
+	for i := range ops {
+		if len(ops[i].path) != len(keys) {
+			continue
+		}
+		matches := true
+		for j, segment := range ops[i].path {
+			if segment != "*" && segment != keys[j].key {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return &ops[i]
+		}
+	}
+	return nil
+}
+
+// splitYAMLKey splits the "key: value" content of a line into its unquoted key and the offset of its value.
+// It returns false if the content is not a key of a block mapping.
+func splitYAMLKey(content string) (string, int, bool, error) {
+	var key string
+	var colon int
+	switch {
+	case content[0] == '"' || content[0] == '\'':
+		end := strings.IndexByte(content[1:], content[0])
+		for content[0] == '"' && end > 0 && content[end] == '\\' {
+			next := strings.IndexByte(content[end+2:], '"')
+			if next < 0 {
+				end = -1
+				break
+			}
+			end += 1 + next
+		}
+		if end < 0 {
+			return "", 0, false, errors.New("unterminated quoted string")
+		}
+		colon = end + 2
+		if colon >= len(content) || content[colon] != ':' {
+			return "", 0, false, nil
+		}
+		var err error
+		if key, err = parseYAMLScalar(content[:colon]); err != nil {
+			return "", 0, false, err
+		}
+	case strings.IndexByte("?[{&*!|>", content[0]) >= 0:
+		return "", 0, false, nil
+	default:
+		colon = strings.Index(content, ": ")
+		if colon < 0 {
+			if !strings.HasSuffix(content, ":") {
+				return "", 0, false, nil
+			}
+			colon = len(content) - 1
+		}
+		key = strings.TrimRight(content[:colon], " ")
+	}
+	if colon+1 < len(content) && content[colon+1] != ' ' {
+		return "", 0, false, nil
+	}
+	value := colon + 1
+	for value < len(content) && content[value] == ' ' {
+		value++
+	}
+	return key, value, true, nil
+}
+
+// yamlValueKind tells how the lines following a value belong to it.
+type yamlValueKind int
+
+const (
+	// yamlNested is an empty value, followed by a nested mapping or sequence.
+	yamlNested yamlValueKind = iota
+	// yamlBlockScalar is a literal or folded block scalar, whose content is on the following lines.
+	yamlBlockScalar
+	// yamlScalar is a value complete on its line.
+	yamlScalar
+)
+
+// checkYAMLValue returns the kind of a value, after its key or sequence indicator, and an error if it spans
+// several lines in a way which is not supported.
+func checkYAMLValue(value string) (yamlValueKind, error) {
+	// Anchors and tags are properties of the value which follows them.
+	for value != "" && (value[0] == '&' || value[0] == '!') {
+		end := strings.IndexByte(value, ' ')
+		if end < 0 {
+			return yamlNested, nil
+		}
+		value = strings.TrimLeft(value[end:], " ")
+	}
+	switch {
+	case value == "" || value[0] == '#':
+		return yamlNested, nil
+	case value[0] == '|' || value[0] == '>':
+		return yamlBlockScalar, nil
+	case value[0] == '"' || value[0] == '\'':
+		if _, err := parseYAMLScalar(value); err != nil {
+			return 0, err
+		}
+	case value[0] == '[' || value[0] == '{':
+		if comment := strings.Index(value, " #"); comment >= 0 {
+			value = strings.TrimRight(value[:comment], " ")
+		}
+		if closing := map[byte]byte{'[': ']', '{': '}'}[value[0]]; value[len(value)-1] != closing {
+			return 0, errors.New("flow collections must be on a single line")
+		}
+	}
+	return yamlScalar, nil
+}
+
+// applyYAMLOps applies the operations to a YAML body, line by line, the lines which are not changed being left
+// byte for byte. Only the block mappings and sequences whose values are on a single line, or are block
+// scalars, are supported: the other bodies, such as those with multi-line plain or quoted scalars, return
+// an error.
+func applyYAMLOps(body []byte, ops []parsedYAMLOp) ([]byte, bool, error) {
+	var out, pending strings.Builder
+	var keys []yamlKey
+	modified := false
+	// The lines more indented than skipIndent are removed, those more indented than copyIndent are copied as
+	// is, and those more indented than scalarIndent would continue a plain scalar. They are -1 when unused.
+	skipIndent, copyIndent, scalarIndent := -1, -1, -1
+	lines := strings.SplitAfter(string(body), "\n")
+	for i, line := range lines {
+		number := i + 1
+		content := strings.TrimRight(line, "\r\n")
+		ending := line[len(content):]
+		trimmed := strings.TrimLeft(content, " ")
+		indent := len(content) - len(trimmed)
+		blank := strings.TrimSpace(trimmed) == "" || strings.HasPrefix(trimmed, "#")
+
+		if skipIndent >= 0 {
+			if blank {
+				if indent <= skipIndent {
+					pending.WriteString(line)
+				}
+				continue
+			}
+			if indent > skipIndent {
+				continue
+			}
+			skipIndent = -1
+		}
+		out.WriteString(pending.String())
+		pending.Reset()
+		if copyIndent >= 0 {
+			if blank || indent > copyIndent {
+				out.WriteString(line)
+				continue
+			}
+			copyIndent = -1
+		}
+		if blank {
+			out.WriteString(line)
+			continue
+		}
+		if scalarIndent >= 0 && indent > scalarIndent {
+			return nil, false, fmt.Errorf("line %d: multi-line plain scalars are not supported", number)
+		}
+		scalarIndent = -1
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, false, fmt.Errorf("line %d: tabs are not allowed for indentation", number)
+		}
+
+		// Directives and document markers.
+		if indent == 0 && (strings.HasPrefix(trimmed, "%") || trimmed == "..." || trimmed == "---" || strings.HasPrefix(trimmed, "--- ")) {
+			keys = nil
+			out.WriteString(line)
+			continue
+		}
+
+		// The sequences are traversed transparently: the keys of an item are those of the mapping holding the
+		// sequence, which is less indented.
+		item, itemIndent := false, -1
+		for trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			item, itemIndent = true, indent
+			for len(keys) > 0 && keys[len(keys)-1].indent > indent {
+				keys = keys[:len(keys)-1]
+			}
+			rest := strings.TrimLeft(trimmed[1:], " ")
+			indent += len(trimmed) - len(rest)
+			trimmed = rest
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			out.WriteString(line)
+			continue
+		}
+
+		key, valueOffset, ok, err := splitYAMLKey(trimmed)
+		if err != nil {
+			return nil, false, fmt.Errorf("line %d: %w", number, err)
+		}
+		if !ok {
+			if !item {
+				return nil, false, fmt.Errorf("line %d: %q is not a key of a block mapping", number, trimmed)
+			}
+			// A value of a sequence.
+			kind, err := checkYAMLValue(trimmed)
+			if err != nil {
+				return nil, false, fmt.Errorf("line %d: %w", number, err)
+			}
+			switch kind {
+			case yamlBlockScalar:
+				copyIndent = itemIndent
+			case yamlScalar:
+				scalarIndent = itemIndent
+			}
+			out.WriteString(line)
+			continue
+		}
+
+		for len(keys) > 0 && keys[len(keys)-1].indent >= indent {
+			keys = keys[:len(keys)-1]
+		}
+		keys = append(keys, yamlKey{indent: indent, key: key})
+
+		value := trimmed[valueOffset:]
+		kind, err := checkYAMLValue(value)
+		if err != nil {
+			return nil, false, fmt.Errorf("line %d: %w", number, err)
+		}
+		op := matchYAMLOp(ops, keys)
+		if op == nil {
+			switch kind {
+			case yamlBlockScalar:
+				copyIndent = indent
+			case yamlScalar:
+				scalarIndent = indent
+			}
+			out.WriteString(line)
+			continue
+		}
+
+		// The aliases of an anchored value would be left dangling.
+		if strings.HasPrefix(value, "&") {
+			return nil, false, fmt.Errorf("line %d: the anchored value of %q can't be changed", number, key)
+		}
+		// The lines of the value, if any, are removed with the value.
+		modified = true
+		skipIndent = indent
+		if !op.remove {
+			valueStart := len(content) - len(trimmed) + valueOffset
+			out.WriteString(strings.TrimRight(content[:valueStart], " "))
+			out.WriteString(" ")
+			out.WriteString(strconv.Quote(op.set))
+			out.WriteString(ending)
+			continue
+		}
+		if item {
+			return nil, false, fmt.Errorf("line %d: the first key of a sequence item can't be removed", number)
+		}
+		keys = keys[:len(keys)-1]
+	}
+	out.WriteString(pending.String())
+	if !modified {
+		return body, false, nil
+	}
+	return []byte(out.String()), true, nil
+}
+
+// String describes the operations in the debug dump.
+func (ops parsedYAMLOps) String() string {
+	descriptions := make([]string, len(ops))
+	for i, op := range ops {
+		descriptions[i] = fmt.Sprintf("set %s to %q", strings.Join(op.path, "."), op.set)
+		if op.remove {
+			descriptions[i] = "remove " + strings.Join(op.path, ".")
+		}
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+func (ops parsedYAMLOps) option() string { return "yamlOps" }
+
+// changesBody implements the transformer interface.
+func (ops parsedYAMLOps) changesBody() bool { return true }
+
+// matchesContentType implements the contentTypeMatcher interface.
+func (ops parsedYAMLOps) matchesContentType(contentType string) bool {
+	return isYAMLResponse(contentType)
+}
+
+// transform implements the bodyTransformer interface, as rewriteBody for a YAML body: the yamlOps are applied
+// once the body has been rewritten. A body which can't be parsed fails the yaml stage.
+func (ops parsedYAMLOps) transform(rw *responseWriter, body []byte) ([]byte, bool, bool, []int, error) {
+	r, response, req := rw.middleware, rw.response, rw.request
+	rewritten, modified, complete, replaced := body, false, true, []int(nil)
+	if len(response.rewrites) > 0 {
+		var err error
+		if rewritten, modified, complete, replaced, err = r.rewriteBody(response, body, req); err != nil {
+			return body, false, true, nil, err
+		}
+	}
+
+	result, changed, err := applyYAMLOps(rewritten, ops)
+	if err != nil {
+		return body, false, true, nil, &stageError{stage: stageYAML, err: fmt.Errorf("unable to parse the YAML body: %w", err)}
+	}
+	return result, modified || changed, complete, replaced, nil
+}
@@ -0,0 +1,274 @@
+package traefik_responsebodyrewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApplyYAMLOps(t *testing.T) {
+	const body = `# Service configuration.
+service:
+  name: orders   # The name.
+  endpoint: http://orders.internal.local:8080
+  debug: true
+  notes: |
+    endpoint: http://orders.internal.local:8080
+  servers:
+    - name: a
+      endpoint: http://a.internal.local
+    - name: b
+      endpoint: "http://b.internal.local"
+
+  secrets:
+    token: abc
+    # The key.
+    key: def
+other:
+  endpoint: http://other.internal.local
+`
+
+	tests := []struct {
+		desc    string
+		ops     []YAMLOp
+		body    string
+		expBody string
+		expErr  string
+	}{
+		{
+			desc: "set",
+			ops:  []YAMLOp{{Path: "service.endpoint", Set: "https://orders.example.com"}},
+			body: body,
+			expBody: `# Service configuration.
+service:
+  name: orders   # The name.
+  endpoint: "https://orders.example.com"
+  debug: true
+  notes: |
+    endpoint: http://orders.internal.local:8080
+  servers:
+    - name: a
+      endpoint: http://a.internal.local
+    - name: b
+      endpoint: "http://b.internal.local"
+
+  secrets:
+    token: abc
+    # The key.
+    key: def
+other:
+  endpoint: http://other.internal.local
+`,
+		},
+		{
+			desc: "set in sequence",
+			ops:  []YAMLOp{{Path: "service.servers.endpoint", Set: "https://example.com"}},
+			body: body,
+			expBody: `# Service configuration.
+service:
+  name: orders   # The name.
+  endpoint: http://orders.internal.local:8080
+  debug: true
+  notes: |
+    endpoint: http://orders.internal.local:8080
+  servers:
+    - name: a
+      endpoint: "https://example.com"
+    - name: b
+      endpoint: "https://example.com"
+
+  secrets:
+    token: abc
+    # The key.
+    key: def
+other:
+  endpoint: http://other.internal.local
+`,
+		},
+		{
+			desc: "remove and wildcard",
+			ops:  []YAMLOp{{Path: "service.secrets", Remove: true}, {Path: "*.notes", Remove: true}, {Path: "other.endpoint", Set: `say "hi"`}},
+			body: body,
+			expBody: `# Service configuration.
+service:
+  name: orders   # The name.
+  endpoint: http://orders.internal.local:8080
+  debug: true
+  servers:
+    - name: a
+      endpoint: http://a.internal.local
+    - name: b
+      endpoint: "http://b.internal.local"
+
+other:
+  endpoint: "say \"hi\""
+`,
+		},
+		{
+			desc:    "set nested value",
+			ops:     []YAMLOp{{Path: "service.secrets", Set: "redacted"}},
+			body:    "service:\n  secrets:\n    token: abc\n  name: orders\n",
+			expBody: "service:\n  secrets: \"redacted\"\n  name: orders\n",
+		},
+		{
+			desc:    "sequence at the key indentation",
+			ops:     []YAMLOp{{Path: "servers.endpoint", Set: "x"}},
+			body:    "servers:\n- endpoint: a\n  name: a\n- name: b\n  endpoint: b\r\n",
+			expBody: "servers:\n- endpoint: \"x\"\n  name: a\n- name: b\n  endpoint: \"x\"\r\n",
+		},
+		{
+			desc:    "documents",
+			ops:     []YAMLOp{{Path: "endpoint", Set: "x"}},
+			body:    "endpoint: a\n---\nendpoint: b\n",
+			expBody: "endpoint: \"x\"\n---\nendpoint: \"x\"\n",
+		},
+		{
+			desc:    "no match",
+			ops:     []YAMLOp{{Path: "service.missing", Set: "x"}},
+			body:    body,
+			expBody: body,
+		},
+		{
+			desc:   "multi-line plain scalar",
+			ops:    []YAMLOp{{Path: "endpoint", Set: "x"}},
+			body:   "notes: a long\n  note\nendpoint: a\n",
+			expErr: "line 2: multi-line plain scalars are not supported",
+		},
+		{
+			desc:   "multi-line flow collection",
+			ops:    []YAMLOp{{Path: "endpoint", Set: "x"}},
+			body:   "list: [a,\n  b]\nendpoint: a\n",
+			expErr: "line 1: flow collections must be on a single line",
+		},
+		{
+			desc:   "anchored value",
+			ops:    []YAMLOp{{Path: "endpoint", Set: "x"}},
+			body:   "endpoint: &e a\nother: *e\n",
+			expErr: `line 1: the anchored value of "endpoint" can't be changed`,
+		},
+		{
+			desc:   "first key of a sequence item",
+			ops:    []YAMLOp{{Path: "servers.name", Remove: true}},
+			body:   "servers:\n  - name: a\n    endpoint: a\n",
+			expErr: "line 2: the first key of a sequence item can't be removed",
+		},
+		{
+			desc:   "not YAML",
+			ops:    []YAMLOp{{Path: "endpoint", Set: "x"}},
+			body:   "<html></html>",
+			expErr: `line 1: "<html></html>" is not a key of a block mapping`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			ops, err := parseYAMLOps(test.ops)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, modified, err := applyYAMLOps([]byte(test.body), ops)
+			if test.expErr != "" {
+				if err == nil || err.Error() != test.expErr {
+					t.Errorf("got error %v, want %q", err, test.expErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != test.expBody {
+				t.Errorf("got body %q, want %q", out, test.expBody)
+			}
+			if modified != (test.expBody != test.body) {
+				t.Errorf("got modified %t, want %t", modified, test.expBody != test.body)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_yamlOps(t *testing.T) {
+	tests := []struct {
+		desc        string
+		response    Response
+		contentType string
+		body        string
+		expBody     string
+		expErr      string
+	}{
+		{
+			desc:        "ops after the rewrites",
+			response:    Response{Rewrites: []Rewrite{{Regex: "internal.local", Replacement: "example.com"}}},
+			contentType: "application/yaml",
+			body:        "endpoint: http://orders.internal.local\nhost: internal.local\n",
+			expBody:     "endpoint: \"https://orders.example.com\"\nhost: example.com\n",
+		},
+		{
+			desc:        "ops only",
+			contentType: "text/yaml; charset=utf-8",
+			body:        "endpoint: http://orders.internal.local\n",
+			expBody:     "endpoint: \"https://orders.example.com\"\n",
+		},
+		{
+			desc:        "parse failure",
+			response:    Response{Rewrites: []Rewrite{{Regex: "internal.local", Replacement: "example.com"}}},
+			contentType: "application/yaml",
+			body:        "endpoint: http://orders.internal.local\nnotes: [a,\n  b]\n",
+			expBody:     "endpoint: http://orders.internal.local\nnotes: [a,\n  b]\n",
+		},
+		{
+			desc:        "other content type",
+			contentType: "application/json",
+			body:        `{"endpoint": "http://orders.internal.local"}`,
+			expBody:     `{"endpoint": "http://orders.internal.local"}`,
+		},
+		{
+			desc:     "invalid path",
+			response: Response{YAMLOps: []YAMLOp{{Path: "service..endpoint", Set: "x"}}},
+			expErr:   `responses[0]: yamlOps[0]: invalid path "service..endpoint": empty key`,
+		},
+		{
+			desc:     "set and remove",
+			response: Response{YAMLOps: []YAMLOp{{Path: "endpoint", Set: "x", Remove: true}}},
+			expErr:   "responses[0]: yamlOps[0]: set and remove can't both be set",
+		},
+		{
+			desc:     "streaming mode",
+			response: Response{Stream: true, Rewrites: []Rewrite{{Regex: "a", Replacement: "b"}}},
+			expErr:   "responses[0]: yamlOps can't be used with stream, jsonPaths, rewriteFirstBytes or rewriteMultipart",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			response := test.response
+			response.Status = "200"
+			if response.YAMLOps == nil {
+				response.YAMLOps = []YAMLOp{{Path: "endpoint", Set: "https://orders.example.com"}}
+			}
+			handler, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", test.contentType)
+				_, _ = rw.Write([]byte(test.body))
+			}), &Config{Responses: []Response{response}}, "rewriteBody")
+			if test.expErr != "" {
+				if err == nil || err.Error() != test.expErr {
+					t.Errorf("got error %v, want %q", err, test.expErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if body := recorder.Body.String(); body != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_yamlOpsFailure(t *testing.T) {
+	testModeFailure(t, Response{Status: "200", YAMLOps: []YAMLOp{{Path: "endpoint", Set: "https://orders.example.com"}}},
+		"application/yaml", "endpoint: http://orders.internal.local\nnotes: [a,\n  b]\n", stageYAML)
+}